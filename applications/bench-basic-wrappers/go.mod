@@ -1,20 +1,34 @@
 module github.com/weids-dev/benchains/applications/bench-basic-wrappers
 
-go 1.22.1
+go 1.23.5
 
 require (
-	github.com/hyperledger/fabric-gateway v1.5.0
-	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.3
-	google.golang.org/grpc v1.62.1
+	github.com/hyperledger/fabric-gateway v1.7.1 // indirect
+	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.5 // indirect
+	google.golang.org/grpc v1.69.4 // indirect
 )
 
 require (
-	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/miekg/pkcs11 v1.1.1 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
-	golang.org/x/net v0.22.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20240304212257-790db918fca8 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
+	golang.org/x/crypto v0.39.0 // indirect
+	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
+	google.golang.org/protobuf v1.36.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+require (
+	bench-server v0.0.0
+	bench-zk v0.0.0
+)
+
+// The REST API is the one of bench-server, over its Layer 1 backend; the gateway connection code
+// and typed contract clients are shared with the ZK operator, and bench-zk in turn needs the
+// circuits module of this repository
+replace (
+	bench-server => ../bench-server
+	bench-zk => ../bench-zk
+	github.com/weids-dev/benchains/circuits => ../../circuits
 )
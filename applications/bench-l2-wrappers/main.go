@@ -1,59 +1,28 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
-	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
 	"os"
-	"os/exec"
-	"path"
 	"strconv"
 	"time"
 
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/logging"
+
 	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/status"
 
 	"net/http"
 	"strings"
 )
 
-type PeerConfig struct {
-	MSPID         string
-	CryptoPath    string
-	CertPath      string
-	KeyPath       string
-	TLSCertPath   string
-	PeerEndpoint  string
-	GatewayPeer   string
-	ChannelName   string
-	ChaincodeName string
-}
-
-// TransactionData holds the transaction ID and its corresponding writes
-type TransactionData struct {
-	TxID   string
-	Writes []map[string]interface{}
-}
-
-// UserState
-
-// Write represents a simplified write structure
-type Write struct {
-	Key   string
-	Value string
-}
-
 // computeHash computes the SHA-256 hash of the input data
 func computeHash(data []byte) []byte {
 	hash := sha256.Sum256(data)
@@ -61,7 +30,7 @@ func computeHash(data []byte) []byte {
 }
 
 // buildMerkleTree builds a Merkle tree from the given transactions and returns the Merkle root
-func buildMerkleTree(transactions []TransactionData) string {
+func buildMerkleTree(transactions []blocks.Transaction) string {
 	if len(transactions) == 0 {
 		return ""
 	}
@@ -96,14 +65,6 @@ type Item struct {
 	Value int    `json:"value"` // Value represents the item's worth or power.
 }
 
-// Player represents a game player with a unique ID, balance, and inventory of items.
-// It encapsulates the player's state within the game.
-type Player struct {
-	ID      string  `json:"id"`      // ID is the player's unique identifier.
-	Balance float64 `json:"balance"` // Balance tracks the currency the player has.
-	Items   []Item  `json:"items"`   // Items hold the collection of items owned by the player.
-}
-
 // BankTransaction represents a transaction from the bank to buy in-game currency.
 type BankTransaction struct {
 	UserID        string  `json:"userID"`
@@ -114,21 +75,76 @@ type BankTransaction struct {
 var now = time.Now()
 var playerId = fmt.Sprintf("player%d", now.Unix()*1e3+int64(now.Nanosecond())/1e6)
 
-// TODO: change the rate as the time goes
-const rate string = "0.313"
+// parseAmount parses a decimal amount of USD or BEN, e.g. "3" or "0.313", into the fixed-point
+// integer with three decimal places the chaincode stores.
+func parseAmount(s string) (int64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	return int64(math.Round(f * 1000)), nil
+}
 
-func plasmaHandler(w http.ResponseWriter, r *http.Request, contract *client.Contract) {
-	if r.Method != http.MethodPut {
-		if r.Method == http.MethodGet {
-			queryAllMerkleRoots(contract)
-			return
+// parseIDs parses the numeric player and transaction IDs of a request path.
+func parseIDs(parts ...string) ([]int64, error) {
+	ids := make([]int64, len(parts))
+	for i, part := range parts {
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q: %w", part, err)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// writeContractError answers a request whose transaction failed: 503 if it may succeed when
+// retried, 422 if the chaincode rejected it, 409 if it was invalidated on commit and 502 if it
+// could not be ordered or its outcome is unknown.
+func writeContractError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	log := slog.Default()
+	var contractErr *gateway.ContractError
+	if errors.As(err, &contractErr) {
+		log = log.With(logging.Tx(contractErr.TransactionID))
+		switch {
+		case contractErr.Retryable():
+			code = http.StatusServiceUnavailable
+		case errors.Is(err, gateway.ErrEndorse), errors.Is(err, gateway.ErrEvaluate):
+			code = http.StatusUnprocessableEntity
+		case errors.Is(err, gateway.ErrCommit):
+			code = http.StatusConflict
+		default:
+			code = http.StatusBadGateway
 		}
+	}
+	log.Warn("Transaction failed", "status", code, "err", err)
+	http.Error(w, err.Error(), code)
+}
+
+// writeJSON answers a request with v as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "err", err)
+	}
+}
+
+func plasmaHandler(w http.ResponseWriter, r *http.Request, plasma *gateway.PlasmaClient) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 		return
 	}
+
+	roots, err := plasma.QueryAllMerkleRoots()
+	if err != nil {
+		writeContractError(w, err)
+		return
+	}
+	writeJSON(w, roots)
 }
 
-func depositHandler(w http.ResponseWriter, r *http.Request, contract *client.Contract) {
+func depositHandler(w http.ResponseWriter, r *http.Request, currency *gateway.CurrencyClient) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 		return
@@ -140,36 +156,63 @@ func depositHandler(w http.ResponseWriter, r *http.Request, contract *client.Con
 		http.Error(w, "Invalid URL format", http.StatusBadRequest)
 		return
 	}
-	transactionId := parts[2]
-	USD := parts[3]
-	playerId := parts[4]
+	ids, err := parseIDs(parts[2], parts[4])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	usd, err := parseAmount(parts[3])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transactionId, playerId := ids[0], ids[1]
 
-	log.Printf("Depositing for player %s with txID %s and amount %s USD\n", playerId, transactionId, USD)
-	recordBankTransaction(contract, playerId, USD, transactionId)
-	log.Printf("Finish depositing for player %s with txID %s and amount %s USD\n", playerId, transactionId, USD)
+	log := slog.With("player", playerId, "bankTx", transactionId)
+	log.Debug("Depositing", "usd", parts[3])
+	if err := currency.RecordBankTransaction(playerId, usd, transactionId); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	log.Info("Deposited", "usd", parts[3])
 }
 
-func exchangeHandler(w http.ResponseWriter, r *http.Request, contract *client.Contract) {
+func exchangeHandler(w http.ResponseWriter, r *http.Request, currency *gateway.CurrencyClient) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Extract txID and playerID from URL
+	// Extract txID, BEN and playerID from URL; a negative amount sells BEN
 	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) != 4 {
+	if len(parts) != 5 {
 		http.Error(w, "Invalid URL format", http.StatusBadRequest)
 		return
 	}
-	transactionId := parts[2]
-	playerId := parts[3]
+	ids, err := parseIDs(parts[2], parts[4])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ben, err := parseAmount(parts[3])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transactionId, playerId := ids[0], ids[1]
 
-	log.Printf("Exchanging in-game currency for player %s with txID %s\n", playerId, transactionId)
-	exchangeInGameCurrency(contract, playerId, transactionId, rate)
-	log.Printf("finish exchanging in-game currency for player %s with txID %s and rate %s\n", playerId, transactionId, rate)
+	log := slog.With("player", playerId, "bankTx", transactionId)
+	log.Debug("Exchanging", "ben", parts[3])
+	if err := currency.ExchangeInGameCurrency(playerId, ben, r.URL.Query().Get("signature")); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	log.Info("Exchanged", "ben", parts[3])
 }
 
-func bankExchangeHandler(w http.ResponseWriter, r *http.Request, contract *client.Contract) {
+// bankExchangeHandler deposits USD and buys as many BEN, which is the whole deposit at the
+// exchange rate of 1.0 InitLedger sets.
+func bankExchangeHandler(w http.ResponseWriter, r *http.Request, currency *gateway.CurrencyClient) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 		return
@@ -181,24 +224,44 @@ func bankExchangeHandler(w http.ResponseWriter, r *http.Request, contract *clien
 		http.Error(w, "Invalid URL format", http.StatusBadRequest)
 		return
 	}
-	transactionId := parts[2]
-	USD := parts[3]
-	playerId := parts[4]
+	ids, err := parseIDs(parts[2], parts[4])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	usd, err := parseAmount(parts[3])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transactionId, playerId := ids[0], ids[1]
 
-	log.Printf("Depositing for player %s with txID %s and amount %s USD\n", playerId, transactionId, USD)
-	recordBankTransaction(contract, playerId, USD, transactionId)
-	log.Printf("Finish depositing for player %s with txID %s and amount %s USD\n", playerId, transactionId, USD)
+	log := slog.With("player", playerId, "bankTx", transactionId)
+	log.Debug("Depositing", "usd", parts[3])
+	if err := currency.RecordBankTransaction(playerId, usd, transactionId); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	log.Info("Deposited", "usd", parts[3])
 
-	log.Printf("Exchanging in-game currency for player %s with txID %s\n", playerId, transactionId)
-	exchangeInGameCurrency(contract, playerId, transactionId, rate)
-	log.Printf("finish exchanging in-game currency for player %s with txID %s and rate %s\n", playerId, transactionId, rate)
+	log.Debug("Exchanging", "ben", parts[3])
+	if err := currency.ExchangeInGameCurrency(playerId, usd, r.URL.Query().Get("signature")); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	log.Info("Exchanged", "ben", parts[3])
 }
 
-func createPlayerHandler(w http.ResponseWriter, r *http.Request, contract *client.Contract) {
+func createPlayerHandler(w http.ResponseWriter, r *http.Request, currency *gateway.CurrencyClient) {
 	if r.Method != http.MethodPut {
 		if r.Method == http.MethodGet {
-			getAllPlayers(contract)
-			getPlayersNum(contract)
+			players, err := currency.GetAllPlayers()
+			if err != nil {
+				writeContractError(w, err)
+				return
+			}
+			slog.Debug("Listed players", "count", len(players))
+			writeJSON(w, players)
 			return
 		}
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
@@ -210,172 +273,143 @@ func createPlayerHandler(w http.ResponseWriter, r *http.Request, contract *clien
 		http.Error(w, "Invalid URL format", http.StatusBadRequest)
 		return
 	}
-	playerId := parts[2]
+	ids, err := parseIDs(parts[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	playerId := ids[0]
 
-	log.Printf("Creating player with ID: %s\n", playerId)
-	createPlayer(contract, playerId)
-	log.Printf("PUT request processed for playerId: %s", playerId)
+	slog.Debug("Creating player", "player", playerId)
+	if err := currency.CreatePlayer(playerId, r.URL.Query().Get("pubKey"), r.URL.Query().Get("signature")); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	slog.Info("Created player", "player", playerId)
 }
 
-var debug = true // Set this to true to enable logging
+// Environment variables setting the lowest level logged (debug, info, warn or error) and the log
+// format (text or json).
+const (
+	envLogLevel  = "BENCH_L2_LOG_LEVEL"
+	envLogFormat = "BENCH_L2_LOG_FORMAT"
+)
+
+// checkpointFile records the last block whose Merkle root was committed to the root chain
+const checkpointFile = "bench-l2-checkpoint.json"
 
 func main() {
-	if debug {
-		log.SetOutput(os.Stdout)
-	} else {
-		log.SetOutput(io.Discard)
-	}
-
-	plasmaChainConfig := PeerConfig{
-		MSPID:         "org02MSP",
-		CryptoPath:    "../../networks/fabric/certs/chains/peerOrganizations/org02.chains",
-		CertPath:      "../../networks/fabric/certs/chains/peerOrganizations/org02.chains/users/User1@org02.chains/msp/signcerts/User1@org02.chains-cert.pem",
-		KeyPath:       "../../networks/fabric/certs/chains/peerOrganizations/org02.chains/users/User1@org02.chains/msp/keystore/",
-		TLSCertPath:   "../../networks/fabric/certs/chains/peerOrganizations/org02.chains/peers/peer1.org02.chains/tls/ca.crt",
-		PeerEndpoint:  "localhost:6002",
-		GatewayPeer:   "peer1.org02.chains",
-		ChannelName:   "chains02",
-		ChaincodeName: "pasic",
+	if err := logging.Setup(os.Getenv(envLogLevel), os.Getenv(envLogFormat)); err != nil {
+		panic(err)
 	}
 
-	rootChainConfig := PeerConfig{
-		MSPID:         "org01MSP",
-		CryptoPath:    "../../networks/fabric/certs/chains/peerOrganizations/org01.chains",
-		CertPath:      "../../networks/fabric/certs/chains/peerOrganizations/org01.chains/users/User1@org01.chains/msp/signcerts/User1@org01.chains-cert.pem",
-		KeyPath:       "../../networks/fabric/certs/chains/peerOrganizations/org01.chains/users/User1@org01.chains/msp/keystore/",
-		TLSCertPath:   "../../networks/fabric/certs/chains/peerOrganizations/org01.chains/peers/peer1.org01.chains/tls/ca.crt",
-		PeerEndpoint:  "localhost:6001",
-		GatewayPeer:   "peer1.org01.chains",
-		ChannelName:   "chains",
-		ChaincodeName: "basic",
-	}
+	plasmaChainConfig := chainConfig("BENCH_L2_PLASMA", "org02", "localhost:6002", "chains02", "pasic")
+	rootChainConfig := chainConfig("BENCH_L2_ROOT", "org01", "localhost:6001", "chains", "basic")
 
 	// Establish connection with plasma chain
-	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	plasma_clientConnection := newGrpcConnection(plasmaChainConfig)
-	defer plasma_clientConnection.Close()
-
-	plasma_id := newIdentity(plasmaChainConfig)
-	plasma_sign := newSign(plasmaChainConfig)
-
-	// Create a Gateway connection for a specific client identity
-	plasma_gw, err := client.Connect(
-		plasma_id,
-		client.WithSign(plasma_sign),
-		client.WithClientConnection(plasma_clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(1*time.Minute),
-		client.WithEndorseTimeout(1*time.Minute),
-		client.WithSubmitTimeout(1*time.Minute),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	plasma_gw, err := gateway.NewGateway(plasmaChainConfig)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("failed to connect to the plasma chain: %w", err))
 	}
 	defer plasma_gw.Close()
 
-	plasma_network := plasma_gw.GetNetwork(plasmaChainConfig.ChannelName)
-	plasma_contract := plasma_network.GetContract(plasmaChainConfig.ChaincodeName)
+	plasma_network := plasma_gw.Network
+	plasma_currency := plasma_gw.Currency()
 	syscontract := plasma_network.GetContract("qscc") // system chaincode
 
-	initLedger(plasma_contract)
-	getAllPlayers(plasma_contract)
+	// InitLedger fails once the chain is initialized, e.g. when the agent is restarted
+	if err := plasma_currency.InitLedger(); err != nil {
+		slog.Info("Plasma chain not initialized (already done?)", "err", err)
+	}
+	logPlayers(plasma_currency)
 
 	// Establish connection with main chain
-	root_clientConnection := newGrpcConnection(rootChainConfig)
-	defer root_clientConnection.Close()
-
-	root_id := newIdentity(rootChainConfig)
-	root_sign := newSign(rootChainConfig)
-
-	// Create a Gateway connection for a specific client identity
-	root_gw, err := client.Connect(
-		root_id,
-		client.WithSign(root_sign),
-		client.WithClientConnection(root_clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(1*time.Minute),
-		client.WithEndorseTimeout(1*time.Minute),
-		client.WithSubmitTimeout(1*time.Minute),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	root_gw, err := gateway.NewGateway(rootChainConfig)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("failed to connect to the root chain: %w", err))
 	}
 	defer root_gw.Close()
 
-	root_network := root_gw.GetNetwork(rootChainConfig.ChannelName)
-	root_contract := root_network.GetContract(rootChainConfig.ChaincodeName)
+	root_currency := root_gw.Currency()
+	root_plasma := root_gw.Plasma()
 
-	initLedger2(root_contract)
-	getAllPlayers(root_contract)
+	if err := root_currency.InitLedger(); err != nil {
+		slog.Info("Root chain currency not initialized (already done?)", "err", err)
+	}
+	if err := root_plasma.InitLedger(); err != nil {
+		slog.Info("Root chain PlasmaContract not initialized", "err", err)
+	}
+	logPlayers(root_currency)
 
 	/*
-	   Check and Commit Periodically
+	   Check and Commit Every Block
 	*/
 
-	newestCommittedBlockNumber := uint64(1) // Initially, no blocks committed
-	// Periodic loop to check the newest block and fetch new blocks
-	ticker := time.NewTicker(5 * time.Second) // 5 seconds interval
-	defer ticker.Stop()
+	// Stream committed blocks instead of polling, resuming after the last block a previous run checkpointed
+	checkpointer, err := client.NewFileCheckpointer(checkpointFile)
+	if err != nil {
+		panic(fmt.Errorf("failed to open checkpoint file: %w", err))
+	}
+	defer checkpointer.Close()
 
-	go func() {
-		for range ticker.C {
-			// Step 1: Get the newest block number
-			newestBlockNumber, err := getNewestBlockNumber(syscontract, "chains02")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
+	go func() {
+		for {
+			// Block 1 is covered by the initial root, so a fresh run starts at block 2
+			streamCtx, streamCancel := context.WithCancel(ctx)
+			events, err := plasma_network.BlockEvents(streamCtx, client.WithStartBlock(2), client.WithCheckpoint(checkpointer))
 			if err != nil {
-				log.Println("Error getting newest block number:", err)
+				streamCancel()
+				slog.Error("Failed to start block events", "err", err)
+				time.Sleep(5 * time.Second)
 				continue
 			}
 
-			fmt.Printf("Newest block number: %d\n", newestBlockNumber)
-			fmt.Printf("Newest committed block number: %d\n", newestCommittedBlockNumber)
-
-			// Step 2: Check if there are new blocks to process
-			if newestBlockNumber > newestCommittedBlockNumber {
-				fmt.Printf("Found new blocks to commit: %d to %d    || ", newestCommittedBlockNumber+1, newestBlockNumber)
-
-				// Step 3: Process all blocks between newestCommittedBlockNumber + 1 and newestBlockNumber
-				for blockNumber := newestCommittedBlockNumber + 1; blockNumber <= newestBlockNumber; blockNumber++ {
-					snum := strconv.FormatUint(blockNumber, 10)
+			for block := range events {
+				blockNumber := block.GetHeader().GetNumber()
+				log := slog.With(logging.Block(blockNumber))
 
-					blockBytes := getBlockByNumber(syscontract, "chains02", snum)
-					block, err := decodeBlock(blockBytes)
-					if err != nil {
-						fmt.Println("Error decoding block:", err)
-						continue
-					}
-
-					transactions, err := extractTransactions(block)
-					if err != nil {
-						fmt.Println("Error extracting transactions:", err)
-						continue
-					}
-
-					// Output the extracted transactions
-					for _, tx := range transactions {
-						log.Printf("TxID: %s\n", tx.TxID)
-						for _, write := range tx.Writes {
-							log.Printf("Write: %+v\n", write)
-						}
-					}
-
-					fmt.Printf("Number of transactions in this block: %d   || ", len(transactions))
+				// A block that cannot be decoded is not skipped: the next stream delivers it again from the checkpoint
+				transactions, err := blocks.Transactions(block)
+				if err != nil {
+					log.Error("Failed to extract transactions", "err", err)
+					break
+				}
 
-					// Compute the Merkle root of the transactions
-					merkleRoot := buildMerkleTree(transactions)
+				// Only transactions Fabric validated changed the world state
+				valid, skipped := blocks.Valid(transactions)
+				if n := len(transactions) - len(valid); n > 0 {
+					log.Warn("Skipped invalid transactions", "count", n, "codes", blocks.FormatSkipped(skipped))
+				}
+				transactions = valid
+				logTransactions(log, transactions)
 
-					// Commit the Merkle root to the root chain
-					go commitMerkleRoot(root_contract, snum, merkleRoot)
+				// Compute the Merkle root of the transactions
+				merkleRoot := buildMerkleTree(transactions)
 
-					fmt.Printf("Committed Merkle root for block %d: %s\n", blockNumber, merkleRoot)
+				// Commit the Merkle root to the root chain before checkpointing, so a restart never skips a block
+				// A block that fails is delivered again by the next stream, which resumes from the checkpoint
+				if err := root_plasma.CommitMerkleRoot(blockNumber, merkleRoot); err != nil {
+					log.Error("Failed to commit Merkle root", "err", err)
+					break
 				}
+				// Without the checkpoint the next stream would commit this block again, so stop the agent
+				if err := checkpointer.CheckpointBlock(blockNumber); err != nil {
+					log.Error("Failed to save checkpoint", "err", err)
+					os.Exit(1)
+				}
+
+				log.Info("Committed Merkle root", "transactions", len(transactions), "root", merkleRoot)
+			}
+			streamCancel()
 
-				// Step 4: Update newest committed block number after processing
-				newestCommittedBlockNumber = newestBlockNumber
-				// queryAllMerkleRoots(root_contract)
+			if ctx.Err() != nil {
+				return
 			}
+			slog.Warn("Block event stream closed, reconnecting from checkpoint")
+			time.Sleep(5 * time.Second)
 		}
 	}()
 
@@ -386,647 +420,134 @@ func main() {
 	time.Sleep(10 * time.Second)
 
 	// All those will be written to the ledger
-	go createPlayer(plasma_contract, "AWANG01")
-	go createPlayer(plasma_contract, "AWANG02")
-	go createPlayer(plasma_contract, "AWANG03")
-	go createPlayer(plasma_contract, "AWANG04")
-	go createPlayer(plasma_contract, "AWANG05")
-	go createPlayer(plasma_contract, "AWANG06")
-	go createPlayer(plasma_contract, "AWANG07")
-	go createPlayer(plasma_contract, "AWANG08")
+	// Eight players deposit a few USD each and exchange them for as many BEN
+	deposits := []string{"1", "2", "3", "3", "3", "3", "3", "8"}
+	for i := range deposits {
+		go func(id int64) {
+			if err := plasma_currency.CreatePlayer(id, "", ""); err != nil {
+				slog.Error("Failed to create player", "player", id, "err", err)
+			}
+		}(int64(101 + i))
+	}
 
 	time.Sleep(10 * time.Second)
 
-	go recordBankTransaction(plasma_contract, "AWANG01", "1", "TXXAWANG01")
-	go recordBankTransaction(plasma_contract, "AWANG02", "2", "TXXAWANG02")
-	go recordBankTransaction(plasma_contract, "AWANG03", "3", "TXXAWANG03")
-	go recordBankTransaction(plasma_contract, "AWANG04", "3", "TXXAWANG04")
-	go recordBankTransaction(plasma_contract, "AWANG05", "3", "TXXAWANG05")
-	go recordBankTransaction(plasma_contract, "AWANG06", "3", "TXXAWANG06")
-	go recordBankTransaction(plasma_contract, "AWANG07", "3", "TXXAWANG07")
-	go recordBankTransaction(plasma_contract, "AWANG08", "8", "TXXAWANG08")
+	for i, usd := range deposits {
+		amount, _ := parseAmount(usd)
+		go func(id int64) {
+			if err := plasma_currency.RecordBankTransaction(id, amount, id); err != nil {
+				slog.Error("Failed to deposit", "player", id, "err", err)
+			}
+		}(int64(101 + i))
+	}
 
 	time.Sleep(10 * time.Second)
 
-	go exchangeInGameCurrency(plasma_contract, "AWANG01", "TXXAWANG01", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG02", "TXXAWANG02", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG03", "TXXAWANG03", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG04", "TXXAWANG04", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG05", "TXXAWANG05", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG06", "TXXAWANG06", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG07", "TXXAWANG07", rate)
-	go exchangeInGameCurrency(plasma_contract, "AWANG08", "TXXAWANG08", rate)
+	for i, usd := range deposits {
+		amount, _ := parseAmount(usd)
+		go func(id int64) {
+			if err := plasma_currency.ExchangeInGameCurrency(id, amount, ""); err != nil {
+				slog.Error("Failed to exchange", "player", id, "err", err)
+			}
+		}(int64(101 + i))
+	}
 
 	time.Sleep(5 * time.Second)
 
-	newestBlockNumber, err := getNewestBlockNumber(syscontract, "chains02")
+	newestBlockNumber, err := blocks.NewestBlockNumber(syscontract, "chains02")
 	if err != nil {
-		fmt.Println("Error getting newest block number:", err)
+		slog.Error("Failed to get newest block number", "err", err)
 		return
 	}
 
-	log.Println("Newest Block Number:", newestBlockNumber)
-	snum := strconv.FormatUint(newestBlockNumber, 10)
-
-	blockBytes := getBlockByNumber(syscontract, "chains02", snum)
-	block, err := decodeBlock(blockBytes)
+	slog.Info("Newest block", logging.Block(newestBlockNumber))
+	block, err := blocks.GetBlock(syscontract, "chains02", newestBlockNumber)
 	if err != nil {
-		panic(fmt.Errorf("failed to decode block: %w", err))
+		slog.Error("Failed to get block", logging.Block(newestBlockNumber), "err", err)
+		return
 	}
 
 	// fmt.Printf("%s\n", block)
 
-	transactions, err := extractTransactions(block)
+	transactions, err := blocks.Transactions(block)
 
 	if err != nil {
-		fmt.Println("Error extracting transactions:", err)
+		slog.Error("Failed to extract transactions", logging.Block(newestBlockNumber), "err", err)
 		return
 	}
 
 	// fmt.Println(transactions)
 
-	// Output the extracted transactions
-	for _, tx := range transactions {
-		log.Printf("TxID: %s\n", tx.TxID)
-		for _, write := range tx.Writes {
-			log.Printf("Write: %+v\n", write)
-		}
-	}
+	logTransactions(slog.With(logging.Block(newestBlockNumber)), transactions)
 
 	/*
 	   Test Functions End
 	*/
 
 	http.HandleFunc("/player/", func(w http.ResponseWriter, r *http.Request) {
-		createPlayerHandler(w, r, plasma_contract)
+		createPlayerHandler(w, r, plasma_currency)
 	})
 	http.HandleFunc("/bank/", func(w http.ResponseWriter, r *http.Request) {
-		depositHandler(w, r, plasma_contract)
+		depositHandler(w, r, plasma_currency)
 	})
 	http.HandleFunc("/exchange/", func(w http.ResponseWriter, r *http.Request) {
-		exchangeHandler(w, r, plasma_contract)
+		exchangeHandler(w, r, plasma_currency)
 	})
 	http.HandleFunc("/bexchange/", func(w http.ResponseWriter, r *http.Request) {
-		bankExchangeHandler(w, r, plasma_contract)
+		bankExchangeHandler(w, r, plasma_currency)
 	})
 	http.HandleFunc("/plasma/", func(w http.ResponseWriter, r *http.Request) {
-		plasmaHandler(w, r, root_contract)
+		plasmaHandler(w, r, root_plasma)
 	})
 
+	slog.Info("Server is listening", "port", 10809)
 	if err := http.ListenAndServe(":10809", nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-
-	fmt.Println("Server is listening on port 10809")
-}
-
-func newGrpcConnection(config PeerConfig) *grpc.ClientConn {
-	certificate, err := loadCertificate(config.TLSCertPath)
-	if err != nil {
-		panic(err)
+		slog.Error("Failed to start server", "err", err)
+		os.Exit(1)
 	}
-
-	certPool := x509.NewCertPool()
-	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, config.GatewayPeer)
-
-	connection, err := grpc.Dial(config.PeerEndpoint, grpc.WithTransportCredentials(transportCredentials))
-	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
-	}
-
-	return connection
 }
 
-func newIdentity(config PeerConfig) *identity.X509Identity {
-	certificate, err := loadCertificate(config.CertPath)
-	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(config.MSPID, certificate)
-	if err != nil {
-		panic(err)
+// chainConfig returns the connection to one chain of the default network as User1 of org.
+// Every field can be overridden from the environment variables under prefix (see
+// gateway.Chain.ApplyEnv); a connection profile or wallet named there replaces the default
+// peer or identity respectively.
+func chainConfig(prefix, org, peerEndpoint, channelName, chaincodeName string) gateway.Chain {
+	cryptoPath := "../../networks/fabric/certs/chains/peerOrganizations/" + org + ".chains"
+	defaults := gateway.Chain{
+		MspID:         org + "MSP",
+		CryptoPath:    cryptoPath,
+		CertPath:      cryptoPath + "/users/User1@" + org + ".chains/msp/signcerts/User1@" + org + ".chains-cert.pem",
+		KeyPath:       cryptoPath + "/users/User1@" + org + ".chains/msp/keystore/",
+		TLSCertPath:   cryptoPath + "/peers/peer1." + org + ".chains/tls/ca.crt",
+		PeerEndpoint:  peerEndpoint,
+		GatewayPeer:   "peer1." + org + ".chains",
+		ChannelName:   channelName,
+		ChaincodeName: chaincodeName,
 	}
 
-	return id
+	var chain gateway.Chain
+	chain.ApplyEnv(prefix)
+	return chain.Inherit(defaults)
 }
 
-func loadCertificate(filename string) (*x509.Certificate, error) {
-	certificatePEM, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: %w", err)
-	}
-	return identity.CertificateFromPEM(certificatePEM)
-}
-
-func newSign(config PeerConfig) identity.Sign {
-	files, err := os.ReadDir(config.KeyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
-	}
-	privateKeyPEM, err := os.ReadFile(path.Join(config.KeyPath, files[0].Name()))
-
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
-	}
-
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+// logPlayers logs every player of the currency contract.
+func logPlayers(currency *gateway.CurrencyClient) {
+	players, err := currency.GetAllPlayers()
 	if err != nil {
-		panic(err)
-	}
-
-	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	return sign
-}
-
-func commitMerkleRoot(contract *client.Contract, blockNumber, merkleRoot string) {
-	log.Printf("\n--> Submit Transaction: CommitMerkleRoot \n")
-
-	_, err := contract.SubmitTransaction("PlasmaContract:CommitMerkleRoot", blockNumber, merkleRoot)
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// This type of transaction would typically only be run once by an application the first time it was started after its
-// initial deployment. A new version of the chaincode deployed later would likely not need to run an "init" function.
-//
-// SubmitTransaction will submit a transaction to the ledger and return its result only after it is committed to the ledger.
-// The transaction function will be evaluated on endorsing peers and then submitted to the ordering service to be committed to the ledger.
-func initLedger(contract *client.Contract) {
-	log.Printf("\n--> Submit Transaction: InitLedger, function creates the initial set of players on the ledger \n")
-
-	_, err := contract.SubmitTransaction("CurrencyContract:InitLedger")
-
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// TODO: Seperate pasic and basic contract
-func initLedger2(contract *client.Contract) {
-	log.Printf("\n--> Submit Transaction: InitLedger, function creates the initial set of players on the ledger \n")
-
-	_, err := contract.SubmitTransaction("CurrencyContract:InitLedger")
-
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-
-	log.Printf("\n--> Submit Transaction: InitLedger on PlasmaContract \n")
-
-	_, err = contract.SubmitTransaction("PlasmaContract:InitLedger")
-
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-func queryAllMerkleRoots(contract *client.Contract) {
-	result, err := contract.EvaluateTransaction("PlasmaContract:QueryAllMerkleRoots")
-	if err != nil {
-		fmt.Printf("Failed to evaluate transaction: %v\n", err)
+		slog.Error("Failed to get players", "err", err)
 		return
 	}
-
-	fmt.Printf("All committed Merkle roots:\n%s\n", string(result))
-}
-
-// Evaluate a transaction to query ledger state.
-func getAllPlayers(contract *client.Contract) {
-	log.Println("\n--> Evaluate Transaction: GetAllPlayers, function returns all the current players on the ledger")
-
-	evaluateResult, err := contract.EvaluateTransaction("CurrencyContract:GetAllPlayers")
-	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
-	}
-	result := formatJSON(evaluateResult)
-
-	fmt.Printf("*** Records: %s\n", result)
-}
-
-// Function to decode block data using configtxlator
-func decodeBlock(blockData []byte) (string, error) {
-	// Prepare command to decode block data using configtxlator
-	cmd := exec.Command("configtxlator", "proto_decode", "--type", "common.Block")
-	cmd.Stdin = bytes.NewReader(blockData)
-
-	// Run the command and capture output
-	stdout, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to execute configtxlator command: %w", err)
-	}
-
-	return string(stdout), nil
-}
-
-// Extract transactions from the decoded block
-func extractTransactions(decodedBlock string) ([]TransactionData, error) {
-	// Define a struct to hold the decoded block data
-	var blockData map[string]interface{}
-	err := json.Unmarshal([]byte(decodedBlock), &blockData)
-	fmt.Printf("*** Decoded Block: %s\n", decodedBlock)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse decoded block data: %w", err)
-	}
-
-	// Navigate the JSON structure to extract transactions
-	data, ok := blockData["data"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("failed to find data field in block")
-	}
-
-	dataArray, ok := data["data"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("failed to find data array in block data")
-	}
-
-	var transactions []TransactionData
-	for _, item := range dataArray {
-		envelope, ok := item.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("failed to parse transaction envelope")
-		}
-
-		payload, ok := envelope["payload"].(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("failed to find payload in transaction envelope")
-		}
-
-		channelHeader, ok := payload["header"].(map[string]interface{})["channel_header"].(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("failed to find channel header in transaction payload")
-		}
-
-		transactionID, ok := channelHeader["tx_id"].(string)
-		if !ok {
-			return nil, fmt.Errorf("failed to find transaction ID")
-		}
-
-		// Extract writes from the transaction payload
-		writes, err := extractWrites(payload)
-
-		_, err = extractTransaction(payload) // test
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to extract writes: %w", err)
-		}
-
-		transactions = append(transactions, TransactionData{
-			TxID:   transactionID,
-			Writes: writes,
-		})
-	}
-
-	return transactions, nil
-}
-
-// Extract writes from the transaction payload
-func extractWrites(payload map[string]interface{}) ([]map[string]interface{}, error) {
-	// Navigate to the 'data' field under 'payload'
-	data, ok := payload["data"].(map[string]interface{})
-
-	// fmt.Printf("*** Payload: %s\n", payload)
-
-	if !ok {
-		return nil, fmt.Errorf("failed to find data field in payload")
-	}
-
-	// Traverse further into the 'actions' field to find the writes
-	actions, ok := data["actions"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("failed to find actions in transaction payload")
-	}
-
-	var writes []map[string]interface{}
-	for _, action := range actions {
-		actionData, ok := action.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		// Navigate to 'payload' and then to 'chaincode_proposal_payload'
-		chaincodeActionPayload, ok := actionData["payload"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		// Extract 'action' field to get the writes in the 'rwset'
-		chaincodeAction, ok := chaincodeActionPayload["action"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		proposalResponsePayload, ok := chaincodeAction["proposal_response_payload"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		extension, ok := proposalResponsePayload["extension"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		results, ok := extension["results"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		nsRwset, ok := results["ns_rwset"].([]interface{})
-		if !ok {
-			continue
-		}
-
-		// Extract writes for each namespace read-write set (nsRwset)
-		for _, rw := range nsRwset {
-			rwset, ok := rw.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			// Check for the 'rwset' field that contains the 'writes'
-			rwsetData, ok := rwset["rwset"].(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			writeSet, ok := rwsetData["writes"].([]interface{})
-			if !ok {
-				continue
-			}
-
-			// Convert each write to a map and append to the list of writes
-			for _, write := range writeSet {
-				writeMap, ok := write.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				writes = append(writes, writeMap)
-			}
-		}
-	}
-
-	return writes, nil
+	slog.Info("Players", "count", len(players))
+	slog.Debug("Players", "players", players)
 }
 
-// Extract transaction from the transaction payload
-func extractTransaction(payload map[string]interface{}) ([]map[string]interface{}, error) {
-	// Navigate to the 'data' field under 'payload'
-	data, ok := payload["data"].(map[string]interface{})
-
-	// fmt.Printf("*** Payload: %s\n", payload)
-
-	if !ok {
-		return nil, fmt.Errorf("failed to find data field in payload")
-	}
-
-	// Traverse further into the 'actions' field to find the writes
-	actions, ok := data["actions"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("failed to find actions in transaction payload")
-	}
-
-	for _, action := range actions {
-		actionData, ok := action.(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 1")
-		}
-
-		// Navigate to 'payload' and then to 'chaincode_proposal_payload'
-		chaincodeActionPayload, ok := actionData["payload"].(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 2")
-		}
-
-		chaincodeProposalPayload, ok := chaincodeActionPayload["chaincode_proposal_payload"].(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 4")
-		}
-
-		input, ok := chaincodeProposalPayload["input"].(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 5")
-		}
-
-		spec, ok := input["chaincode_spec"].(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 6")
-		}
-
-		inputArgs, ok := spec["input"].(map[string]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 7")
-		}
-
-		args, ok := inputArgs["args"].([]interface{})
-		if !ok {
-			fmt.Println("Error: Input Args 8")
-		}
-
-		fmt.Printf("Input Args: %s\n", args)
-	}
-
-	return nil, nil
-}
-
-func getNewestBlockNumber(contract *client.Contract, channelName string) (uint64, error) {
-	log.Println("\n--> Evaluate Transaction: getChainInfo from system chaincode qscc GetChainInfo")
-
-	// Call QSCC to get the chain info of the specified channel
-	evaluateResult, err := contract.EvaluateTransaction("GetChainInfo", channelName)
-	if err != nil {
-		return 0, fmt.Errorf("failed to evaluate transaction: %w", err)
-	}
-
-	// Decode the returned protobuf data to JSON
-	chainInfoJSON, err := decodeChainInfo(evaluateResult)
-	if err != nil {
-		return 0, fmt.Errorf("failed to decode chain info: %w", err)
-	}
-
-	// Extract the height and compute the newest block number
-	newestBlockNumber, err := extractNewestBlockNumber(chainInfoJSON)
-	if err != nil {
-		return 0, fmt.Errorf("failed to extract newest block number: %w", err)
-	}
-
-	return newestBlockNumber, nil
-}
-
-func decodeChainInfo(chainInfoData []byte) (string, error) {
-	// Prepare command to decode chain info data using configtxlator
-	cmd := exec.Command("configtxlator", "proto_decode", "--type", "common.BlockchainInfo")
-	cmd.Stdin = bytes.NewReader(chainInfoData)
-
-	// Run the command and capture output
-	stdout, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to execute configtxlator command: %w", err)
-	}
-
-	return string(stdout), nil
-}
-
-func extractNewestBlockNumber(decodedChainInfo string) (uint64, error) {
-	// Define a struct to hold the decoded chain info data
-	var chainInfo map[string]interface{}
-	err := json.Unmarshal([]byte(decodedChainInfo), &chainInfo)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse decoded chain info data: %w", err)
-	}
-
-	log.Println("\n ChainInfo: ", chainInfo)
-
-	// Get the blockchain height
-	height, ok := chainInfo["height"].(string) // Block height is stored as a string in JSON
-	if !ok {
-		return 0, fmt.Errorf("failed to find height in chain info")
-	}
-
-	// Convert the height to uint64
-	var heightUint uint64
-	fmt.Sscanf(height, "%d", &heightUint)
-
-	// Newest block number is height - 1
-	if heightUint == 0 {
-		return 0, fmt.Errorf("chain height is zero, no blocks in the chain")
-	}
-
-	newestBlockNumber := heightUint - 1
-
-	return newestBlockNumber, nil
-}
-
-func getBlockByNumber(contract *client.Contract, channelName string, number string) []byte {
-	log.Println("\n--> Evaluate Transaction: getBlock from system chaincode qscc GetBlockByNumber")
-
-	evaluateResult, err := contract.EvaluateTransaction("GetBlockByNumber", channelName, number)
-	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
-	}
-
-	return evaluateResult
-}
-
-// getPlayersNum evaluates a transaction to query ledger state and prints the number of players
-func getPlayersNum(contract *client.Contract) {
-	log.Println("\n--> Evaluate Transaction: getPlayersNum, function returns the number of current players on the ledger")
-
-	evaluateResult, err := contract.EvaluateTransaction("CurrencyContract:GetAllPlayers")
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
-	}
-
-	// Unmarshal the JSON bytes into a slice of player structs
-	var players []*Player
-	if err := json.Unmarshal(evaluateResult, &players); err != nil {
-		panic(fmt.Errorf("failed to unmarshal JSON: %w", err))
-	}
-
-	// Now you can accurately get the number of players
-	log.Printf("*** Number of Records: %d\n", len(players))
-}
-
-// createPlayer directly create a player with all attr initialized default
-func createPlayer(contract *client.Contract, playerId string) {
-	log.Printf("\n--> Submit Transaction: CreatePlayer \n")
-
-	_, err := contract.SubmitTransaction("CurrencyContract:CreatePlayer", playerId)
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// recordBankTransaction records a new bank transaction to the ledger
-func recordBankTransaction(contract *client.Contract, userID, amountUSDStr, transactionID string) {
-	log.Printf("\n--> Submit Transaction: RecordBankTransaction \n")
-
-	_, err := contract.SubmitTransaction("CurrencyContract:RecordBankTransaction", userID, amountUSDStr, transactionID)
-
-	if err != nil {
-		// errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// exchangeInGameCurrency let users to exchange their deposited USD to in-game currency
-func exchangeInGameCurrency(contract *client.Contract, userID, transactionID, exchangeRateStr string) {
-	log.Printf("\n--> Submit Transaction: ExchangeInGameCurrency \n")
-
-	_, err := contract.SubmitTransaction("CurrencyContract:ExchangeInGameCurrency", userID, transactionID, exchangeRateStr)
-
-	if err != nil {
-		// errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// Submit transaction, passing in the wrong number of arguments ,expected to throw an error containing details of any error responses from the smart contract.
-func errorHandling(contract *client.Contract, err error) {
-	switch err := err.(type) {
-	case *client.EndorseError:
-		fmt.Printf("Endorse error for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-	case *client.SubmitError:
-		fmt.Printf("Submit error for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-	case *client.CommitStatusError:
-		if errors.Is(err, context.DeadlineExceeded) {
-			fmt.Printf("Timeout waiting for transaction %s commit status: %s", err.TransactionID, err)
-		} else {
-			fmt.Printf("Error obtaining commit status for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-		}
-	case *client.CommitError:
-		fmt.Printf("Transaction %s failed to commit with status %d: %s\n", err.TransactionID, int32(err.Code), err)
-	default:
-		panic(fmt.Errorf("unexpected error type %T: %w", err, err))
-	}
-
-	// Any error that originates from a peer or orderer node external to the gateway will have its details
-	// embedded within the gRPC status error. The following code shows how to extract that.
-	statusErr := status.Convert(err)
-
-	details := statusErr.Details()
-	if len(details) > 0 {
-		fmt.Println("Error Details:")
-
-		for _, detail := range details {
-			switch detail := detail.(type) {
-			case *gateway.ErrorDetail:
-				fmt.Printf("- address: %s, mspId: %s, message: %s\n", detail.Address, detail.MspId, detail.Message)
-			}
+// logTransactions logs the ID and writes of every transaction at debug level.
+func logTransactions(log *slog.Logger, transactions []blocks.Transaction) {
+	for _, tx := range transactions {
+		writes := make([]string, 0, len(tx.Writes))
+		for _, write := range tx.Writes {
+			writes = append(writes, fmt.Sprintf("%s/%q=%s", write.Namespace, write.Key, write.Value))
 		}
+		log.Debug("Transaction", logging.Tx(tx.TxID), "function", tx.Function, "writes", writes)
 	}
 }
-
-// Format JSON data
-func formatJSON(data []byte) string {
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
-		panic(fmt.Errorf("failed to parse JSON: %w", err))
-	}
-	return prettyJSON.String()
-}
@@ -0,0 +1,322 @@
+package api
+
+// Version 1 of the REST API over a Backend, served by bench-server over every backend and by
+// bench-basic-wrappers over Layer 1. Requests and responses are JSON; amounts are decimal numbers
+// with up to three decimal places. openapi.yaml describes the API and is served at /v1/openapi.yaml.
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"bench-zk/gateway"
+	"bench-zk/logging"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Player is a player of the API, with its balances in BEN and USD and its registered key.
+type Player struct {
+	ID     int64   `json:"id"`
+	BEN    float64 `json:"ben"`
+	USD    float64 `json:"usd"`
+	PubKey string  `json:"pubKey,omitempty"`
+}
+
+// CreatePlayerRequest is the body of POST /v1/players.
+type CreatePlayerRequest struct {
+	ID        *int64 `json:"id"`
+	PubKey    string `json:"pubKey,omitempty"`    // Key signing the player's rollup state changes
+	Signature string `json:"signature,omitempty"` // Player's signature of its creation
+}
+
+// Deposit is the body of POST /v1/deposits and of its response: USD paid in by bank transfer
+// TransactionID, credited to the USD balance of the player.
+type Deposit struct {
+	Player        *int64   `json:"player"`
+	TransactionID *int64   `json:"transactionId"`
+	USD           *float64 `json:"usd"`
+}
+
+// Exchange is the body of POST /v1/exchanges and of its response: BEN bought by the player with
+// its USD balance at the current rate, or sold if negative.
+type Exchange struct {
+	Player    *int64   `json:"player"`
+	BEN       *float64 `json:"ben"`
+	Signature string   `json:"signature,omitempty"` // Player's signature of the change
+}
+
+// Transfer is the body of POST /v1/transfers and of its response: BEN moved between two players.
+type Transfer struct {
+	From      *int64   `json:"from"`
+	To        *int64   `json:"to"`
+	Amount    *float64 `json:"amount"`
+	Signature string   `json:"signature,omitempty"` // Sender's signature of the transfer
+}
+
+// Status is the body of GET /v1/status: the backend serving the API and, for the backends
+// committed to Layer 1 by an operator, the last block committed.
+type Status struct {
+	Backend        string  `json:"backend"`
+	CommittedBlock *uint64 `json:"committedBlock,omitempty"`
+}
+
+// Error is the body of every error response. A failed transaction carries its ID, the validation
+// code of an invalid commit and the messages of the peers involved.
+type Error struct {
+	Error          string   `json:"error"`
+	TransactionID  string   `json:"transactionId,omitempty"`
+	ValidationCode string   `json:"validationCode,omitempty"`
+	Details        []string `json:"details,omitempty"`
+}
+
+// server serves the REST API over backend, named name.
+type server struct {
+	name    string
+	backend Backend
+}
+
+// New returns the handler of the REST API over backend, named name. GET /v1/players is only
+// served if backend is a Lister.
+func New(name string, backend Backend) http.Handler {
+	a := &server{name, backend}
+	mux := http.NewServeMux()
+	if _, ok := backend.(Lister); ok {
+		mux.HandleFunc("GET /v1/players", a.listPlayers)
+	}
+	mux.HandleFunc("POST /v1/players", a.createPlayer)
+	mux.HandleFunc("GET /v1/players/{id}", a.getPlayer)
+	mux.HandleFunc("POST /v1/deposits", a.deposit)
+	mux.HandleFunc("POST /v1/exchanges", a.exchange)
+	mux.HandleFunc("POST /v1/transfers", a.transfer)
+	mux.HandleFunc("GET /v1/status", a.status)
+	mux.HandleFunc("GET /v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	return mux
+}
+
+func (a *server) listPlayers(w http.ResponseWriter, r *http.Request) {
+	players, err := a.backend.(Lister).Players()
+	if err != nil {
+		writeContractError(w, err)
+		return
+	}
+	out := make([]Player, len(players))
+	for i, p := range players {
+		out[i] = toPlayer(p)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *server) createPlayer(w http.ResponseWriter, r *http.Request) {
+	var req CreatePlayerRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.ID == nil {
+		writeError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+
+	if err := a.backend.CreatePlayer(*req.ID, req.PubKey, req.Signature); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	slog.Debug("Created player", "player", *req.ID)
+	w.Header().Set("Location", "/v1/players/"+strconv.FormatInt(*req.ID, 10))
+	writeJSON(w, http.StatusCreated, Player{ID: *req.ID, PubKey: req.PubKey})
+}
+
+func (a *server) getPlayer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid player ID %q", r.PathValue("id")))
+		return
+	}
+
+	player, err := a.backend.Query(id)
+	if errors.Is(err, ErrNoPlayer) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeContractError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPlayer(*player))
+}
+
+func (a *server) deposit(w http.ResponseWriter, r *http.Request) {
+	var req Deposit
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Player == nil || req.TransactionID == nil || req.USD == nil {
+		writeError(w, http.StatusBadRequest, "player, transactionId and usd are required")
+		return
+	}
+	usd, err := toFixed(*req.USD)
+	if err == nil && usd <= 0 {
+		err = errors.New("usd must be positive")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.backend.Deposit(*req.Player, *req.TransactionID, usd); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	slog.Debug("Deposited", "player", *req.Player, "bankTx", *req.TransactionID, "usd", *req.USD)
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (a *server) exchange(w http.ResponseWriter, r *http.Request) {
+	var req Exchange
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Player == nil || req.BEN == nil {
+		writeError(w, http.StatusBadRequest, "player and ben are required")
+		return
+	}
+	ben, err := toFixed(*req.BEN)
+	if err == nil && ben == 0 {
+		err = errors.New("ben must not be zero")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.backend.Exchange(*req.Player, ben, req.Signature); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	slog.Debug("Exchanged", "player", *req.Player, "ben", *req.BEN)
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (a *server) transfer(w http.ResponseWriter, r *http.Request) {
+	var req Transfer
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.From == nil || req.To == nil || req.Amount == nil {
+		writeError(w, http.StatusBadRequest, "from, to and amount are required")
+		return
+	}
+	amount, err := toFixed(*req.Amount)
+	if err == nil && amount <= 0 {
+		err = errors.New("amount must be positive")
+	}
+	if err == nil && *req.From == *req.To {
+		err = errors.New("from and to must be different players")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.backend.Transfer(*req.From, *req.To, amount, req.Signature); err != nil {
+		writeContractError(w, err)
+		return
+	}
+	slog.Debug("Transferred", "from", *req.From, "to", *req.To, "amount", *req.Amount)
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (a *server) status(w http.ResponseWriter, r *http.Request) {
+	status := Status{Backend: a.name}
+	if committer, ok := a.backend.(Committer); ok {
+		block, err := committer.Committed()
+		if err != nil {
+			writeContractError(w, err)
+			return
+		}
+		status.CommittedBlock = &block
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// toPlayer converts the fixed-point balances of p to decimal amounts.
+func toPlayer(p gateway.Player) Player {
+	return Player{ID: p.ID, BEN: float64(p.Balance) / 1000, USD: float64(p.UsdBalance) / 1000, PubKey: p.PubKey}
+}
+
+// toFixed converts a decimal amount to the fixed-point integer with three decimal places the
+// chaincode stores.
+func toFixed(amount float64) (int64, error) {
+	fixed := math.Round(amount * 1000)
+	if math.Abs(amount*1000-fixed) > 1e-6 {
+		return 0, fmt.Errorf("amount %v has more than three decimal places", amount)
+	}
+	if math.Abs(fixed) >= math.MaxInt64 {
+		return 0, fmt.Errorf("amount %v is out of range", amount)
+	}
+	return int64(fixed), nil
+}
+
+// readJSON decodes the body of r into v, answering 400 and returning false if it is not a JSON
+// object of the fields of v.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON answers a request with code and v as JSON.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "err", err)
+	}
+}
+
+// writeError answers a request with code and message.
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, Error{Error: message})
+}
+
+// writeContractError answers a request whose transaction failed: 503 if it may succeed when
+// retried, 422 if the chaincode or the endorsing peers rejected it (EndorseError), 409 if it was
+// invalidated on commit (CommitError) and 502 if it could not be ordered or its outcome is unknown.
+func writeContractError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	body := Error{Error: err.Error()}
+	log := slog.Default()
+	var contractErr *gateway.ContractError
+	if errors.As(err, &contractErr) {
+		body.TransactionID, body.Details = contractErr.TransactionID, contractErr.Details
+		log = log.With(logging.Tx(contractErr.TransactionID))
+		switch {
+		case contractErr.Retryable():
+			code = http.StatusServiceUnavailable
+		case errors.Is(err, gateway.ErrEndorse), errors.Is(err, gateway.ErrEvaluate):
+			code = http.StatusUnprocessableEntity
+		case errors.Is(err, gateway.ErrCommit):
+			code = http.StatusConflict
+		default:
+			code = http.StatusBadGateway
+		}
+		if errors.Is(err, gateway.ErrCommit) {
+			body.ValidationCode = contractErr.ValidationCode.String()
+		}
+	}
+	log.Warn("Transaction failed", "status", code, "err", err)
+	writeJSON(w, code, body)
+}
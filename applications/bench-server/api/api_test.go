@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bench-zk/gateway"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeInvoker answers every transaction with the result of its name, or fails it with err.
+type fakeInvoker struct {
+	results map[string]string
+	err     error
+	calls   []string // Transaction and arguments of each call
+}
+
+func (f *fakeInvoker) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return f.EvaluateTransaction(name, args...)
+}
+
+func (f *fakeInvoker) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, strings.Join(append([]string{name}, args...), " "))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(f.results[name]), nil
+}
+
+// The backends as bench-server names them
+const (
+	backendL1     = "l1"
+	backendPlasma = "plasma"
+	backendZK     = "zk"
+)
+
+// serve sends a request with body to the API over the backend name, transacting on l1 or l2 as
+// bench-server does, and returns the response.
+func serve(t *testing.T, name string, l1, l2 gateway.Invoker, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var backend Backend
+	switch name {
+	case backendL1:
+		backend = NewChainBackend(gateway.NewCurrencyClient(l1))
+	case backendPlasma:
+		backend = NewPlasmaBackend(gateway.NewCurrencyClient(l2), gateway.NewPlasmaClient(l1))
+	case backendZK:
+		backend = NewZKBackend(gateway.NewCurrencyClient(l2), gateway.NewZKClient(l1))
+	default:
+		t.Fatalf("unknown backend %q", name)
+	}
+	rec := httptest.NewRecorder()
+	New(name, backend).ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestAPI(t *testing.T) {
+	for _, name := range []string{backendL1, backendPlasma, backendZK} {
+		l1, l2 := &fakeInvoker{}, &fakeInvoker{}
+		chain, other := l2, l1
+		if name == backendL1 {
+			chain, other = l1, l2
+		}
+
+		requests := []struct{ target, body string }{
+			{"/v1/players", `{"id":4,"pubKey":"a2V5","signature":"c2ln"}`},
+			{"/v1/deposits", `{"player":4,"transactionId":7,"usd":3.25}`},
+			{"/v1/exchanges", `{"player":4,"ben":-1.5,"signature":"c2ln"}`},
+			{"/v1/transfers", `{"from":4,"to":5,"amount":0.5,"signature":"c2ln"}`},
+		}
+		for _, req := range requests {
+			if rec := serve(t, name, l1, l2, http.MethodPost, req.target, req.body); rec.Code != http.StatusCreated {
+				t.Errorf("%s: POST %s: %d %s", name, req.target, rec.Code, rec.Body)
+			}
+		}
+		want := []string{
+			"CurrencyContract:CreatePlayer 4 a2V5 c2ln",
+			"CurrencyContract:RecordBankTransaction 4 3250 7",
+			"CurrencyContract:ExchangeInGameCurrency 4 -1500 c2ln",
+			"CurrencyContract:Transfer 4 5 500 c2ln",
+		}
+		if strings.Join(chain.calls, "\n") != strings.Join(want, "\n") || len(other.calls) != 0 {
+			t.Errorf("%s: transactions %q and %q, expected %q on the chain of the backend", name, chain.calls, other.calls, want)
+		}
+
+		chain.results = map[string]string{
+			"CurrencyContract:PlayerExists": "true",
+			"CurrencyContract:GetPlayer":    `{"id":4,"balance":2500,"usdBalance":500}`,
+		}
+		rec := serve(t, name, l1, l2, http.MethodGet, "/v1/players/4", "")
+		var player Player
+		if err := json.NewDecoder(rec.Body).Decode(&player); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: GET /v1/players/4: %d, %v", name, rec.Code, err)
+		}
+		if player != (Player{ID: 4, BEN: 2.5, USD: 0.5}) {
+			t.Errorf("%s: unexpected player %+v", name, player)
+		}
+
+		chain.results["CurrencyContract:GetAllPlayers"] = `[{"id":4,"balance":2500,"usdBalance":500},{"id":5,"balance":0,"usdBalance":1}]`
+		rec = serve(t, name, l1, l2, http.MethodGet, "/v1/players", "")
+		var players []Player
+		if err := json.NewDecoder(rec.Body).Decode(&players); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: GET /v1/players: %d, %v", name, rec.Code, err)
+		}
+		if len(players) != 2 || players[0] != (Player{ID: 4, BEN: 2.5, USD: 0.5}) || players[1] != (Player{ID: 5, USD: 0.001}) {
+			t.Errorf("%s: unexpected players %+v", name, players)
+		}
+
+		chain.results["CurrencyContract:PlayerExists"] = "false"
+		if rec := serve(t, name, l1, l2, http.MethodGet, "/v1/players/9", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: GET of a missing player: %d", name, rec.Code)
+		}
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	l1 := &fakeInvoker{results: map[string]string{
+		"PlasmaContract:QueryAllMerkleRoots": `[{"BlockNumber":"12","MerkleRoot":"a"},{"BlockNumber":"9","MerkleRoot":"b"}]`,
+		"ZKContract:QueryAllStateRoots":      `[{"BlockNumber":"7","StateRoot":"b"},{"BlockNumber":"1","StateRoot":"a"}]`,
+	}}
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{backendL1, `{"backend":"l1"}`},
+		{backendPlasma, `{"backend":"plasma","committedBlock":12}`},
+		{backendZK, `{"backend":"zk","committedBlock":7}`},
+	} {
+		rec := serve(t, tc.name, l1, &fakeInvoker{}, http.MethodGet, "/v1/status", "")
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != tc.want {
+			t.Errorf("%s: GET /v1/status: %d %s, expected %s", tc.name, rec.Code, rec.Body, tc.want)
+		}
+	}
+
+	// A Layer 1 ledger that is not initialized is an error, not block 0
+	notInitialized := &fakeInvoker{err: status.Error(codes.Aborted, "latest block number not initialized")}
+	if rec := serve(t, backendZK, notInitialized, &fakeInvoker{}, http.MethodGet, "/v1/status", ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("GET /v1/status without state roots: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAPIBadRequests(t *testing.T) {
+	for _, tc := range []struct{ target, body string }{
+		{"/v1/players", `{}`},
+		{"/v1/players", `{"id":"four"}`},
+		{"/v1/deposits", `{"player":4,"transactionId":7}`},
+		{"/v1/deposits", `{"player":4,"transactionId":7,"usd":-1}`},
+		{"/v1/deposits", `{"player":4,"transactionId":7,"usd":1.0001}`},
+		{"/v1/exchanges", `{"player":4,"ben":0}`},
+		{"/v1/exchanges", `{"player":4,"ben":1,"rate":2}`},
+		{"/v1/transfers", `{"from":4,"to":5}`},
+		{"/v1/transfers", `{"from":4,"to":5,"amount":-1}`},
+		{"/v1/transfers", `{"from":4,"to":4,"amount":1}`},
+	} {
+		inv := &fakeInvoker{}
+		rec := serve(t, backendZK, inv, inv, http.MethodPost, tc.target, tc.body)
+		var body Error
+		if rec.Code != http.StatusBadRequest || json.NewDecoder(rec.Body).Decode(&body) != nil || body.Error == "" {
+			t.Errorf("POST %s %s: %d, expected 400 with an error", tc.target, tc.body, rec.Code)
+		}
+		if len(inv.calls) != 0 {
+			t.Errorf("POST %s %s called %q", tc.target, tc.body, inv.calls)
+		}
+	}
+	if rec := serve(t, backendL1, &fakeInvoker{}, nil, http.MethodDelete, "/v1/players", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /v1/players: %d", rec.Code)
+	}
+}
+
+func TestAPIContractErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err            error
+		code           int
+		validationCode string
+	}{
+		{status.Error(codes.Aborted, "insufficient BEN balance"), http.StatusUnprocessableEntity, ""},
+		{status.Error(codes.Unavailable, "no peers"), http.StatusServiceUnavailable, ""},
+		{&gateway.ContractError{Kind: gateway.ErrCommit, TransactionID: "tx1", ValidationCode: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, http.StatusConflict, "ENDORSEMENT_POLICY_FAILURE"},
+		{&gateway.ContractError{Kind: gateway.ErrCommit, TransactionID: "tx1", ValidationCode: peer.TxValidationCode_MVCC_READ_CONFLICT}, http.StatusServiceUnavailable, "MVCC_READ_CONFLICT"},
+		{&gateway.ContractError{Kind: gateway.ErrSubmit, TransactionID: "tx1", Code: codes.Internal}, http.StatusBadGateway, ""},
+	} {
+		inv := &fakeInvoker{err: tc.err}
+		rec := serve(t, backendPlasma, inv, inv, http.MethodPost, "/v1/transfers", `{"from":4,"to":5,"amount":1}`)
+		var body Error
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.code || body.ValidationCode != tc.validationCode {
+			t.Errorf("%v: %d %+v, expected %d", tc.err, rec.Code, body, tc.code)
+		}
+		if _, ok := tc.err.(*gateway.ContractError); ok && body.TransactionID != "tx1" {
+			t.Errorf("%v: transaction ID %q missing", tc.err, body.TransactionID)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+// The backends the API fronts. Every solution keeps the game state in CurrencyContract; what
+// differs is the chain it runs on and how that chain reaches Layer 1. Amounts are the fixed-point
+// integers with three decimal places the chaincode stores.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"bench-zk/gateway"
+)
+
+// ErrNoPlayer is returned by Backend.Query for a player that does not exist.
+var ErrNoPlayer = errors.New("player does not exist")
+
+// Backend is one scaling solution under benchmark. Its methods return once the transaction is
+// committed on the chain the backend runs on; failed transactions return a *gateway.ContractError.
+type Backend interface {
+	// CreatePlayer creates player id with empty balances, registering its base64 public key
+	// pubKey if it is not empty. signature is the player's signature of its creation, which the
+	// rollup requires.
+	CreatePlayer(id int64, pubKey, signature string) error
+	// Deposit credits usd to the USD balance of player for the bank transfer transactionID.
+	Deposit(player, transactionID, usd int64) error
+	// Exchange buys ben BEN with the USD balance of player, or sells them if negative. signature
+	// is the player's signature of the change, which the rollup requires.
+	Exchange(player, ben int64, signature string) error
+	// Transfer moves amount BEN from player from to player to. signature is the sender's
+	// signature of the transfer, which the rollup requires.
+	Transfer(from, to, amount int64, signature string) error
+	// Query returns player id, or an error wrapping ErrNoPlayer if it does not exist.
+	Query(id int64) (*gateway.Player, error)
+}
+
+// Lister is implemented by the backends that can list every player.
+type Lister interface {
+	// Players returns every player.
+	Players() ([]gateway.Player, error)
+}
+
+// Committer is implemented by the backends whose chain is committed to Layer 1 by an operator.
+type Committer interface {
+	// Committed returns the last block of the backend's chain whose commitment is on Layer 1.
+	Committed() (uint64, error)
+}
+
+// ChainBackend runs every request as a CurrencyContract transaction on one chain. On its own it
+// is the backend of Layer 1.
+type ChainBackend struct {
+	currency *gateway.CurrencyClient
+}
+
+// NewChainBackend returns the backend running its requests on the chain of currency.
+func NewChainBackend(currency *gateway.CurrencyClient) ChainBackend {
+	return ChainBackend{currency}
+}
+
+func (b ChainBackend) CreatePlayer(id int64, pubKey, signature string) error {
+	return b.currency.CreatePlayer(id, pubKey, signature)
+}
+
+func (b ChainBackend) Deposit(player, transactionID, usd int64) error {
+	return b.currency.RecordBankTransaction(player, usd, transactionID)
+}
+
+func (b ChainBackend) Exchange(player, ben int64, signature string) error {
+	return b.currency.ExchangeInGameCurrency(player, ben, signature)
+}
+
+func (b ChainBackend) Transfer(from, to, amount int64, signature string) error {
+	return b.currency.Transfer(from, to, amount, signature)
+}
+
+func (b ChainBackend) Query(id int64) (*gateway.Player, error) {
+	exists, err := b.currency.PlayerExists(id)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("player %d: %w", id, ErrNoPlayer)
+	}
+	return b.currency.GetPlayer(id)
+}
+
+func (b ChainBackend) Players() ([]gateway.Player, error) { return b.currency.GetAllPlayers() }
+
+// PlasmaBackend transacts on the Plasma chain, whose blocks bench-l2-wrappers commits to the
+// PlasmaContract of Layer 1 as Merkle roots.
+type PlasmaBackend struct {
+	ChainBackend
+	plasma *gateway.PlasmaClient
+}
+
+// NewPlasmaBackend returns the backend transacting through currency on the Plasma chain, with
+// the Merkle roots of plasma on Layer 1.
+func NewPlasmaBackend(currency *gateway.CurrencyClient, plasma *gateway.PlasmaClient) PlasmaBackend {
+	return PlasmaBackend{ChainBackend{currency}, plasma}
+}
+
+// Committed returns the last block whose Merkle root is on Layer 1.
+func (b PlasmaBackend) Committed() (uint64, error) {
+	roots, err := b.plasma.QueryAllMerkleRoots()
+	if err != nil {
+		return 0, err
+	}
+	blocks := make([]string, len(roots))
+	for i, root := range roots {
+		blocks[i] = root.BlockNumber
+	}
+	return lastBlock(blocks)
+}
+
+// ZKBackend transacts on the rollup chain, whose blocks the bench-zk operator proves and commits
+// to the ZKContract of Layer 1 as state roots.
+type ZKBackend struct {
+	ChainBackend
+	zk *gateway.ZKClient
+}
+
+// NewZKBackend returns the backend transacting through currency on the rollup chain, with the
+// state roots of zk on Layer 1.
+func NewZKBackend(currency *gateway.CurrencyClient, zk *gateway.ZKClient) ZKBackend {
+	return ZKBackend{ChainBackend{currency}, zk}
+}
+
+// Committed returns the last block whose state root is on Layer 1.
+func (b ZKBackend) Committed() (uint64, error) {
+	roots, err := b.zk.QueryAllStateRoots()
+	if err != nil {
+		return 0, err
+	}
+	blocks := make([]string, len(roots))
+	for i, root := range roots {
+		blocks[i] = root.BlockNumber
+	}
+	return lastBlock(blocks)
+}
+
+// lastBlock returns the highest of the block numbers of the roots committed to Layer 1, whatever
+// order the contract returns them in.
+func lastBlock(blocks []string) (uint64, error) {
+	var last uint64
+	for _, block := range blocks {
+		n, err := strconv.ParseUint(block, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid block number %q of a committed root: %w", block, err)
+		}
+		last = max(last, n)
+	}
+	return last, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"bench-server/api"
+	"bench-zk/gateway"
+)
+
+// newBackend returns the backend named name: the l1 backend transacts on l1, the Plasma and
+// ZK-rollup backends on l2 with their commitments read from l1.
+func newBackend(name string, l1, l2 gateway.Invoker) (api.Backend, error) {
+	switch name {
+	case BackendL1:
+		return api.NewChainBackend(gateway.NewCurrencyClient(l1)), nil
+	case BackendPlasma:
+		return api.NewPlasmaBackend(gateway.NewCurrencyClient(l2), gateway.NewPlasmaClient(l1)), nil
+	case BackendZK:
+		return api.NewZKBackend(gateway.NewCurrencyClient(l2), gateway.NewZKClient(l1)), nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+// openBackend connects to the chains of the configured backend and initializes the currency of
+// the chain it transacts on. closer closes the connections.
+func openBackend(cfg *Config) (backend api.Backend, closer func(), err error) {
+	l1, err := gateway.NewGateway(cfg.L1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to l1: %w", err)
+	}
+	chain, closer := l1, l1.Close
+	if cfg.Backend != BackendL1 {
+		if chain, err = gateway.NewGateway(cfg.L2); err != nil {
+			l1.Close()
+			return nil, nil, fmt.Errorf("failed to connect to l2: %w", err)
+		}
+		closer = func() { chain.Close(); l1.Close() }
+	}
+	if backend, err = newBackend(cfg.Backend, l1, chain); err != nil {
+		closer()
+		return nil, nil, err
+	}
+
+	// InitLedger sets the exchange rate, and fails once the chain is initialized, e.g. when the
+	// server is restarted
+	if err := chain.Currency().InitLedger(); err != nil {
+		slog.Info("Currency not initialized (already done?)", "err", err)
+	}
+	return backend, closer, nil
+}
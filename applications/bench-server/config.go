@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bench-zk/gateway"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Backends the server can front.
+const (
+	BackendL1     = "l1"     // CurrencyContract on Layer 1 itself
+	BackendPlasma = "plasma" // CurrencyContract on the Plasma chain, committed by bench-l2-wrappers
+	BackendZK     = "zk"     // CurrencyContract on the rollup chain, proven by bench-zk
+)
+
+// Defaults of the optional fields.
+const (
+	DefaultBackend   = BackendL1
+	DefaultAddress   = ":10808"
+	DefaultLogLevel  = "info"
+	DefaultLogFormat = "text"
+)
+
+// Config is the server configuration, loaded from a YAML or JSON file.
+// L1 is the root chain, L2 the chain the Plasma and ZK-rollup operators commit to L1.
+type Config struct {
+	Backend   string        `yaml:"backend" json:"backend"`     // Backend serving the requests: "l1", "plasma" or "zk"
+	Address   string        `yaml:"address" json:"address"`     // Address the REST API is served on
+	L1        gateway.Chain `yaml:"l1" json:"l1"`               // Chain of the l1 backend, and of the commitments of the others
+	L2        gateway.Chain `yaml:"l2" json:"l2"`               // Chain of the plasma and zk backends
+	LogLevel  string        `yaml:"logLevel" json:"logLevel"`   // Lowest level logged: "debug", "info", "warn" or "error"
+	LogFormat string        `yaml:"logFormat" json:"logFormat"` // Log format: "text" or "json"
+}
+
+// Environment variable prefixes overriding the fields of each chain, e.g.
+// BENCH_SERVER_L1_PEER_ENDPOINT (see gateway.Chain.ApplyEnv).
+const (
+	EnvL1 = "BENCH_SERVER_L1"
+	EnvL2 = "BENCH_SERVER_L2"
+)
+
+// LoadConfig reads the configuration file at path. Files ending in ".json" are decoded as JSON,
+// anything else as YAML. As for the bench-zk operator, relative paths are resolved against the
+// directory of the file, then the environment overrides chain fields and connection profiles and
+// wallets fill the chain fields still empty.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if cfg.Backend == "" {
+		cfg.Backend = DefaultBackend
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultAddress
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = DefaultLogLevel
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = DefaultLogFormat
+	}
+
+	base := filepath.Dir(path)
+	cfg.L1 = cfg.L1.RelativeTo(base)
+	cfg.L2 = cfg.L2.RelativeTo(base)
+	cfg.L1.ApplyEnv(EnvL1)
+	cfg.L2.ApplyEnv(EnvL2)
+	if cfg.L1, err = cfg.L1.Resolve(); err != nil {
+		return nil, fmt.Errorf("failed to resolve l1: %w", err)
+	}
+	if cfg.L2, err = cfg.L2.Resolve(); err != nil {
+		return nil, fmt.Errorf("failed to resolve l2: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks that the backend is known.
+func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendL1, BackendPlasma, BackendZK:
+		return nil
+	}
+	return fmt.Errorf("config: unknown backend %q, expected %q, %q or %q", c.Backend, BackendL1, BackendPlasma, BackendZK)
+}
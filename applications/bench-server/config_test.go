@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "server.yaml", `
+backend: zk
+l1:
+  certPath: certs/cert.pem
+  tlsCertPath: /etc/fabric/ca.crt
+  channelName: chains
+l2:
+  channelName: chains02
+`)
+	t.Setenv(EnvL2+"_CHAINCODE_NAME", "pasic2")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if cfg.Backend != BackendZK || cfg.Address != DefaultAddress || cfg.LogLevel != DefaultLogLevel || cfg.LogFormat != DefaultLogFormat {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+	if want := filepath.Join(filepath.Dir(path), "certs/cert.pem"); cfg.L1.CertPath != want || cfg.L1.TLSCertPath != "/etc/fabric/ca.crt" {
+		t.Errorf("Paths not resolved against the configuration file: %+v", cfg.L1)
+	}
+	if cfg.L2.ChannelName != "chains02" || cfg.L2.ChaincodeName != "pasic2" {
+		t.Errorf("Unexpected l2: %+v", cfg.L2)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "server.json", `{"address": ":9000", "l1": {"channelName": "chains"}}`))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Backend != DefaultBackend || cfg.Address != ":9000" || cfg.L1.ChannelName != "chains" {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+func TestValidateBackend(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "server.yaml", "backend: sidechain\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted an unknown backend")
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg, err := LoadConfig("config.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load the shipped configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.L1.ChaincodeName != "basic" || cfg.L2.ChaincodeName != "pasic" {
+		t.Errorf("Unexpected chains: %+v / %+v", cfg.L1, cfg.L2)
+	}
+}
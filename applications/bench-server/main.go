@@ -0,0 +1,57 @@
+package main
+
+// bench-server serves one REST API over the Layer 1 chain, the Plasma chain or the ZK rollup,
+// selected by its configuration, so that the same workload benchmarks every solution.
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"bench-server/api"
+	"bench-zk/logging"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to the server configuration (YAML or JSON)")
+	backend := flag.String("backend", "", `backend serving the API, overriding the configuration: "l1", "plasma" or "zk"`)
+	flag.Parse()
+
+	if err := run(*configPath, *backend); err != nil {
+		slog.Error("Server failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the API with the configuration at configPath, over backend if it is not empty.
+func run(configPath, backend string) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if backend != "" {
+		cfg.Backend = backend
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
+	}
+
+	b, closer, err := openBackend(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	server := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           api.New(cfg.Backend, b),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("Server is listening", "address", cfg.Address, "backend", cfg.Backend)
+	return server.ListenAndServe()
+}
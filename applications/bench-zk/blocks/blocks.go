@@ -0,0 +1,281 @@
+// blocks/blocks.go
+
+// Package blocks decodes Layer 2 blocks: the chain info and blocks qscc returns, and the
+// endorser transactions a block holds with their validation codes and key writes. The rollup
+// operator and the Plasma operator of bench-l2-wrappers both read Layer 2 through it.
+package blocks
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bench-zk/gateway"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// Transaction is an endorser transaction decoded from a Layer 2 block.
+type Transaction struct {
+	TxID           string
+	ValidationCode peer.TxValidationCode // Validation result recorded by the committing peer
+	ChaincodeName  string
+	Function       string   // Invoked function, e.g. "CurrencyContract:CreatePlayer"
+	Args           []string // Arguments following the function name
+	Writes         []Write  // Key writes of the transaction's read-write set
+}
+
+// Write is a single key write from a transaction's read-write set.
+type Write struct {
+	Namespace string // Chaincode that wrote the key
+	Key       string
+	Value     []byte
+	IsDelete  bool
+}
+
+// NewestBlockNumber returns the newest block of channelName from qscc GetChainInfo, evaluated
+// through qscc.
+func NewestBlockNumber(qscc gateway.Invoker, channelName string) (uint64, error) {
+	slog.Debug("Evaluating GetChainInfo of system chaincode qscc", "channel", channelName)
+
+	evaluateResult, err := qscc.EvaluateTransaction("GetChainInfo", channelName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate transaction: %w", err)
+	}
+	chainInfo, err := DecodeChainInfo(evaluateResult)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode chain info: %w", err)
+	}
+	return Newest(chainInfo)
+}
+
+// GetBlock returns block number of channelName from qscc GetBlockByNumber, evaluated through qscc.
+func GetBlock(qscc gateway.Invoker, channelName string, number uint64) (*common.Block, error) {
+	slog.Debug("Evaluating GetBlockByNumber of system chaincode qscc", "channel", channelName, "number", number)
+
+	evaluateResult, err := qscc.EvaluateTransaction("GetBlockByNumber", channelName, strconv.FormatUint(number, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %w", err)
+	}
+	return DecodeBlock(evaluateResult)
+}
+
+// DecodeChainInfo unmarshals the common.BlockchainInfo returned by qscc GetChainInfo.
+func DecodeChainInfo(chainInfoData []byte) (*common.BlockchainInfo, error) {
+	chainInfo := &common.BlockchainInfo{}
+	if err := proto.Unmarshal(chainInfoData, chainInfo); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal chain info: %w", err)
+	}
+	return chainInfo, nil
+}
+
+// Newest returns the number of the newest block of a chain, its height - 1.
+func Newest(chainInfo *common.BlockchainInfo) (uint64, error) {
+	height := chainInfo.GetHeight()
+	if height == 0 {
+		return 0, fmt.Errorf("chain height is zero, no blocks in the chain")
+	}
+	return height - 1, nil
+}
+
+// DecodeBlock unmarshals the common.Block returned by qscc GetBlockByNumber.
+func DecodeBlock(blockData []byte) (*common.Block, error) {
+	block := &common.Block{}
+	if err := proto.Unmarshal(blockData, block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block: %w", err)
+	}
+	return block, nil
+}
+
+// Time returns when block was created, which Fabric block headers do not record: the
+// timestamp of its first envelope, set by the client that proposed the transaction shortly
+// before the block was cut. ok is false for a block without a timestamp.
+func Time(block *common.Block) (at time.Time, ok bool) {
+	data := block.GetData().GetData()
+	if len(data) == 0 {
+		return time.Time{}, false
+	}
+	envelope := &common.Envelope{}
+	payload := &common.Payload{}
+	channelHeader := &common.ChannelHeader{}
+	if proto.Unmarshal(data[0], envelope) != nil ||
+		proto.Unmarshal(envelope.GetPayload(), payload) != nil ||
+		proto.Unmarshal(payload.GetHeader().GetChannelHeader(), channelHeader) != nil ||
+		channelHeader.GetTimestamp() == nil {
+		return time.Time{}, false
+	}
+	return channelHeader.GetTimestamp().AsTime(), true
+}
+
+// Transactions returns the endorser transactions of block in block order.
+// Config and other non-endorser envelopes carry no chaincode invocation and are left out.
+func Transactions(block *common.Block) ([]Transaction, error) {
+	// The committing peer records one validation code per envelope in the transactions filter
+	var filter []byte
+	if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	var transactions []Transaction
+	for i, envelopeBytes := range block.GetData().GetData() {
+		envelope := &common.Envelope{}
+		if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal envelope %d: %w", i, err)
+		}
+
+		payload := &common.Payload{}
+		if err := proto.Unmarshal(envelope.GetPayload(), payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload of envelope %d: %w", i, err)
+		}
+
+		channelHeader := &common.ChannelHeader{}
+		if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), channelHeader); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal channel header of envelope %d: %w", i, err)
+		}
+
+		if common.HeaderType(channelHeader.GetType()) != common.HeaderType_ENDORSER_TRANSACTION {
+			continue
+		}
+
+		transaction := Transaction{
+			TxID:           channelHeader.GetTxId(),
+			ValidationCode: peer.TxValidationCode_NOT_VALIDATED,
+		}
+		if i < len(filter) {
+			transaction.ValidationCode = peer.TxValidationCode(filter[i])
+		}
+
+		if err := extractInvocation(payload.GetData(), &transaction); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %s: %w", transaction.TxID, err)
+		}
+
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+// extractInvocation fills in the chaincode name, function and args of an endorser transaction payload.
+func extractInvocation(data []byte, transaction *Transaction) error {
+	tx := &peer.Transaction{}
+	if err := proto.Unmarshal(data, tx); err != nil {
+		return fmt.Errorf("failed to unmarshal transaction: %w", err)
+	}
+
+	// Transactions submitted through the gateway carry exactly one action
+	actions := tx.GetActions()
+	if len(actions) == 0 {
+		return fmt.Errorf("transaction has no actions")
+	}
+
+	actionPayload := &peer.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(actions[0].GetPayload(), actionPayload); err != nil {
+		return fmt.Errorf("failed to unmarshal chaincode action payload: %w", err)
+	}
+
+	proposalPayload := &peer.ChaincodeProposalPayload{}
+	if err := proto.Unmarshal(actionPayload.GetChaincodeProposalPayload(), proposalPayload); err != nil {
+		return fmt.Errorf("failed to unmarshal chaincode proposal payload: %w", err)
+	}
+
+	invocationSpec := &peer.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(proposalPayload.GetInput(), invocationSpec); err != nil {
+		return fmt.Errorf("failed to unmarshal chaincode invocation spec: %w", err)
+	}
+
+	spec := invocationSpec.GetChaincodeSpec()
+	transaction.ChaincodeName = spec.GetChaincodeId().GetName()
+
+	args := spec.GetInput().GetArgs()
+	if len(args) > 0 {
+		transaction.Function = string(args[0])
+		for _, arg := range args[1:] {
+			transaction.Args = append(transaction.Args, string(arg))
+		}
+	}
+
+	writes, err := extractWrites(actionPayload.GetAction())
+	if err != nil {
+		return err
+	}
+	transaction.Writes = writes
+	return nil
+}
+
+// extractWrites returns the key writes from the read-write set of an endorsed action.
+func extractWrites(action *peer.ChaincodeEndorsedAction) ([]Write, error) {
+	if action == nil {
+		return nil, nil
+	}
+
+	responsePayload := &peer.ProposalResponsePayload{}
+	if err := proto.Unmarshal(action.GetProposalResponsePayload(), responsePayload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proposal response payload: %w", err)
+	}
+
+	chaincodeAction := &peer.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.GetExtension(), chaincodeAction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal chaincode action: %w", err)
+	}
+
+	txRwSet := &rwset.TxReadWriteSet{}
+	if err := proto.Unmarshal(chaincodeAction.GetResults(), txRwSet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal read-write set: %w", err)
+	}
+
+	var writes []Write
+	for _, nsRwSet := range txRwSet.GetNsRwset() {
+		kvRwSet := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsRwSet.GetRwset(), kvRwSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rwset of namespace %s: %w", nsRwSet.GetNamespace(), err)
+		}
+
+		for _, write := range kvRwSet.GetWrites() {
+			writes = append(writes, Write{
+				Namespace: nsRwSet.GetNamespace(),
+				Key:       write.GetKey(),
+				Value:     write.GetValue(),
+				IsDelete:  write.GetIsDelete(),
+			})
+		}
+	}
+	return writes, nil
+}
+
+// Valid returns the transactions the committing peer marked VALID, in block order,
+// together with the number of skipped transactions per validation code. Invalid transactions
+// (MVCC conflicts, endorsement policy failures, ...) never changed the world state on Layer 2,
+// so applying them would make the operator's state drift from it.
+func Valid(transactions []Transaction) ([]Transaction, map[peer.TxValidationCode]int) {
+	var valid []Transaction
+	skipped := make(map[peer.TxValidationCode]int)
+	for _, tx := range transactions {
+		if tx.ValidationCode != peer.TxValidationCode_VALID {
+			skipped[tx.ValidationCode]++
+			continue
+		}
+		valid = append(valid, tx)
+	}
+	return valid, skipped
+}
+
+// FormatSkipped renders skip counts as "ENDORSEMENT_POLICY_FAILURE=1, MVCC_READ_CONFLICT=2", ordered by code.
+func FormatSkipped(skipped map[peer.TxValidationCode]int) string {
+	codes := make([]peer.TxValidationCode, 0, len(skipped))
+	for code := range skipped {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%s=%d", code, skipped[code]))
+	}
+	return strings.Join(parts, ", ")
+}
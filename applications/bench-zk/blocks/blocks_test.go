@@ -0,0 +1,154 @@
+// blocks/blocks_test.go
+
+// The tests build their blocks with package blockstest, which imports package blocks, so they
+// live in package blocks_test.
+package blocks_test
+
+import (
+	"testing"
+	"time"
+
+	"bench-zk/blocks"
+	"bench-zk/blocks/blockstest"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+func TestDecodeChainInfo(t *testing.T) {
+	data := blockstest.MustMarshal(t, &common.BlockchainInfo{Height: 42})
+
+	chainInfo, err := blocks.DecodeChainInfo(data)
+	if err != nil {
+		t.Fatalf("DecodeChainInfo failed: %v", err)
+	}
+	newest, err := blocks.Newest(chainInfo)
+	if err != nil {
+		t.Fatalf("Newest failed: %v", err)
+	}
+	if newest != 41 {
+		t.Errorf("expected newest block 41, got %d", newest)
+	}
+
+	if _, err := blocks.Newest(&common.BlockchainInfo{}); err == nil {
+		t.Errorf("expected an error for an empty chain")
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	metadata := make([][]byte, len(common.BlockMetadataIndex_name))
+	metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{
+		byte(peer.TxValidationCode_VALID),
+		byte(peer.TxValidationCode_MVCC_READ_CONFLICT),
+		byte(peer.TxValidationCode_VALID),
+	}
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 7},
+		Data: &common.BlockData{Data: [][]byte{
+			blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "tx1", "pasic",
+				[]blocks.Write{{Key: "\x00PLAYER\x004\x00", Value: []byte(`{"id":4,"balance":0,"usdBalance":0}`)}},
+				"CurrencyContract:CreatePlayer", "4"),
+			blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "tx2", "pasic", nil, "CurrencyContract:ExchangeInGameCurrency", "4", "50000"),
+			blockstest.Envelope(t, common.HeaderType_CONFIG, "", "", nil),
+		}},
+		Metadata: &common.BlockMetadata{Metadata: metadata},
+	}
+
+	decoded, err := blocks.DecodeBlock(blockstest.MustMarshal(t, block))
+	if err != nil {
+		t.Fatalf("DecodeBlock failed: %v", err)
+	}
+	if decoded.GetHeader().GetNumber() != 7 {
+		t.Errorf("expected block number 7, got %d", decoded.GetHeader().GetNumber())
+	}
+
+	transactions, err := blocks.Transactions(decoded)
+	if err != nil {
+		t.Fatalf("Transactions failed: %v", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("expected 2 endorser transactions, got %d", len(transactions))
+	}
+
+	tx := transactions[0]
+	if tx.TxID != "tx1" || tx.ChaincodeName != "pasic" || tx.Function != "CurrencyContract:CreatePlayer" {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+	if len(tx.Args) != 1 || tx.Args[0] != "4" {
+		t.Errorf("unexpected args: %v", tx.Args)
+	}
+	if tx.ValidationCode != peer.TxValidationCode_VALID {
+		t.Errorf("expected VALID, got %v", tx.ValidationCode)
+	}
+	if len(tx.Writes) != 1 || tx.Writes[0].Namespace != "pasic" || tx.Writes[0].Key != "\x00PLAYER\x004\x00" {
+		t.Errorf("unexpected writes: %+v", tx.Writes)
+	}
+
+	tx = transactions[1]
+	if len(tx.Args) != 2 || tx.Args[1] != "50000" {
+		t.Errorf("unexpected args: %v", tx.Args)
+	}
+	if tx.ValidationCode != peer.TxValidationCode_MVCC_READ_CONFLICT {
+		t.Errorf("expected MVCC_READ_CONFLICT, got %v", tx.ValidationCode)
+	}
+}
+
+func TestTransactionsWithoutFilter(t *testing.T) {
+	block := &common.Block{
+		Data: &common.BlockData{Data: [][]byte{
+			blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "tx1", "pasic", nil, "CurrencyContract:GetAllPlayers"),
+		}},
+	}
+
+	transactions, err := blocks.Transactions(block)
+	if err != nil {
+		t.Fatalf("Transactions failed: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].ValidationCode != peer.TxValidationCode_NOT_VALIDATED {
+		t.Errorf("expected one NOT_VALIDATED transaction, got %+v", transactions)
+	}
+	if len(transactions[0].Args) != 0 {
+		t.Errorf("expected no args, got %v", transactions[0].Args)
+	}
+}
+
+func TestDecodeBlockInvalid(t *testing.T) {
+	if _, err := blocks.DecodeBlock([]byte{0xff, 0xff, 0xff}); err == nil {
+		t.Errorf("expected an error for malformed block bytes")
+	}
+}
+
+func TestValid(t *testing.T) {
+	transactions := []blocks.Transaction{
+		{TxID: "tx1", ValidationCode: peer.TxValidationCode_VALID},
+		{TxID: "tx2", ValidationCode: peer.TxValidationCode_MVCC_READ_CONFLICT},
+		{TxID: "tx3", ValidationCode: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE},
+		{TxID: "tx4", ValidationCode: peer.TxValidationCode_VALID},
+		{TxID: "tx5", ValidationCode: peer.TxValidationCode_MVCC_READ_CONFLICT},
+	}
+
+	valid, skipped := blocks.Valid(transactions)
+	if len(valid) != 2 || valid[0].TxID != "tx1" || valid[1].TxID != "tx4" {
+		t.Fatalf("expected tx1 and tx4 to be valid, got %+v", valid)
+	}
+	if skipped[peer.TxValidationCode_MVCC_READ_CONFLICT] != 2 || skipped[peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE] != 1 {
+		t.Errorf("unexpected skip counts: %v", skipped)
+	}
+
+	want := "ENDORSEMENT_POLICY_FAILURE=1, MVCC_READ_CONFLICT=2"
+	if got := blocks.FormatSkipped(skipped); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestTime(t *testing.T) {
+	created := time.Unix(1000, 0)
+	block := blockstest.TimedBlock(t, 4, created)
+
+	if at, ok := blocks.Time(block); !ok || !at.Equal(created) {
+		t.Errorf("Time() = %v, %v, want %v", at, ok, created)
+	}
+	if _, ok := blocks.Time(blockstest.Block(5, peer.TxValidationCode_VALID)); ok {
+		t.Error("Time found a timestamp in an empty block")
+	}
+}
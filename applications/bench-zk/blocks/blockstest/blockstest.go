@@ -0,0 +1,96 @@
+// blocks/blockstest/blockstest.go
+
+// Package blockstest builds the Layer 2 blocks that tests feed to package blocks and to the
+// operators reading Layer 2 through it.
+package blockstest
+
+import (
+	"testing"
+	"time"
+
+	"bench-zk/blocks"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MustMarshal marshals m or fails the test.
+func MustMarshal(tb testing.TB, m proto.Message) []byte {
+	tb.Helper()
+	data, err := proto.Marshal(m)
+	if err != nil {
+		tb.Fatalf("failed to marshal %T: %v", m, err)
+	}
+	return data
+}
+
+// Envelope builds an envelope invoking chaincode with args and producing writes,
+// as the gateway would submit it.
+func Envelope(tb testing.TB, headerType common.HeaderType, txID, chaincode string, writes []blocks.Write, args ...string) []byte {
+	var input [][]byte
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	invocationSpec := &peer.ChaincodeInvocationSpec{
+		ChaincodeSpec: &peer.ChaincodeSpec{
+			ChaincodeId: &peer.ChaincodeID{Name: chaincode},
+			Input:       &peer.ChaincodeInput{Args: input},
+		},
+	}
+	proposalPayload := &peer.ChaincodeProposalPayload{Input: MustMarshal(tb, invocationSpec)}
+	kvWrites := []*kvrwset.KVWrite{}
+	for _, write := range writes {
+		kvWrites = append(kvWrites, &kvrwset.KVWrite{Key: write.Key, Value: write.Value, IsDelete: write.IsDelete})
+	}
+	results := &rwset.TxReadWriteSet{
+		DataModel: rwset.TxReadWriteSet_KV,
+		NsRwset: []*rwset.NsReadWriteSet{
+			{Namespace: chaincode, Rwset: MustMarshal(tb, &kvrwset.KVRWSet{Writes: kvWrites})},
+		},
+	}
+	responsePayload := &peer.ProposalResponsePayload{
+		Extension: MustMarshal(tb, &peer.ChaincodeAction{Results: MustMarshal(tb, results)}),
+	}
+	actionPayload := &peer.ChaincodeActionPayload{
+		ChaincodeProposalPayload: MustMarshal(tb, proposalPayload),
+		Action:                   &peer.ChaincodeEndorsedAction{ProposalResponsePayload: MustMarshal(tb, responsePayload)},
+	}
+	tx := &peer.Transaction{
+		Actions: []*peer.TransactionAction{{Payload: MustMarshal(tb, actionPayload)}},
+	}
+
+	channelHeader := &common.ChannelHeader{Type: int32(headerType), TxId: txID, ChannelId: "chains02"}
+	payload := &common.Payload{
+		Header: &common.Header{ChannelHeader: MustMarshal(tb, channelHeader)},
+		Data:   MustMarshal(tb, tx),
+	}
+	return MustMarshal(tb, &common.Envelope{Payload: MustMarshal(tb, payload)})
+}
+
+// Block builds block number n holding envelopes, with every transaction marked code.
+func Block(n uint64, code peer.TxValidationCode, envelopes ...[]byte) *common.Block {
+	metadata := make([][]byte, len(common.BlockMetadataIndex_name))
+	filter := make([]byte, len(envelopes))
+	for i := range filter {
+		filter[i] = byte(code)
+	}
+	metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+
+	return &common.Block{
+		Header:   &common.BlockHeader{Number: n},
+		Data:     &common.BlockData{Data: envelopes},
+		Metadata: &common.BlockMetadata{Metadata: metadata},
+	}
+}
+
+// TimedBlock builds block number n holding one transaction proposed at.
+func TimedBlock(tb testing.TB, n uint64, at time.Time) *common.Block {
+	channelHeader := &common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), Timestamp: timestamppb.New(at)}
+	payload := &common.Payload{Header: &common.Header{ChannelHeader: MustMarshal(tb, channelHeader)}}
+	return Block(n, peer.TxValidationCode_VALID, MustMarshal(tb, &common.Envelope{Payload: MustMarshal(tb, payload)}))
+}
@@ -5,7 +5,6 @@ package circuit
 // Implementing ZK-SNARKs Circuit using gnark library for ZK-Rollups
 
 import (
-	// "fmt"
 	// "math/big"
 
 	// ---------------------------
@@ -13,12 +12,10 @@ import (
 	// ---------------------------
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/hash/mimc"
-	// ---------------------------
-	//  GNARK-CRYPTO libraries
-	// ---------------------------
 )
 
-// Adjustable constants for ProofMerkleCircuit
+// Adjustable constants for the experimental circuits; the rollup circuits live in the shared
+// rollup package
 const (
 	MD = 4 // Merkle Circuit
 
@@ -26,8 +23,6 @@ const (
 	N = 1024 // Number of leaves
 	B = 32   // Batch size
 
-	D2 = 10 // ProofMerkleCircuit: Number of Leaves would be 2^10 = 1024
-	B2 = 32 // Number of transactions in the batch
 )
 
 // DepositCircuit enforces that:
@@ -119,97 +114,6 @@ func computeMerkleRoot(api frontend.API, leaves [N]frontend.Variable) frontend.V
 	return currentLevel[0] // Root
 }
 
-// ProofMerkleCircuit verifies a batch of transactions updating a Merkle tree sequentially.
-type ProofMerkleCircuit struct {
-	// Public inputs
-	OldRoot frontend.Variable `gnark:"oldRoot,public"`
-	NewRoot frontend.Variable `gnark:"newRoot,public"`
-
-	// Private inputs: B2 transactions
-	Transactions [B2]struct {
-		OldName    frontend.Variable     `gnark:"oldName"`
-		OldBalance frontend.Variable     `gnark:"oldBalance"`
-		NewName    frontend.Variable     `gnark:"newName"`
-		BenChange  frontend.Variable     `gnark:"benChange"` // Changed from NewBalance
-		Siblings   [D2]frontend.Variable `gnark:"siblings"`
-		PathBits   [D2]frontend.Variable `gnark:"pathBits"`
-	}
-}
-
-// Define implements the circuit constraints.
-func (c *ProofMerkleCircuit) Define(api frontend.API) error {
-	var previousNewRoot frontend.Variable
-
-	for k := 0; k < B2; k++ {
-		tx := c.Transactions[k]
-
-		// Compute old leaf hash: H_old_k = MiMC(OldName, OldBalance)
-		mimcOld, err := mimc.NewMiMC(api)
-		if err != nil {
-			return err
-		}
-		mimcOld.Write(tx.OldName, tx.OldBalance)
-		H_old_k := mimcOld.Sum()
-
-		// Compute old root from H_old_k and Merkle proof
-		currentHash := H_old_k
-		for i := 0; i < D2; i++ {
-			left := api.Select(tx.PathBits[i], currentHash, tx.Siblings[i])
-			right := api.Select(tx.PathBits[i], tx.Siblings[i], currentHash)
-			mimcLevel, err := mimc.NewMiMC(api)
-			if err != nil {
-				return err
-			}
-			mimcLevel.Write(left, right)
-			currentHash = mimcLevel.Sum()
-		}
-		ComputedOldRoot_k := currentHash
-
-		// Calculate NewBalance inside the circuit by adding OldBalance and BenChange
-		NewBalance := api.Add(tx.OldBalance, tx.BenChange)
-
-		// Compute new leaf hash: H_new_k = MiMC(NewName, NewBalance)
-		mimcNew, err := mimc.NewMiMC(api)
-		if err != nil {
-			return err
-		}
-		mimcNew.Write(tx.NewName, NewBalance)
-		H_new_k := mimcNew.Sum()
-
-		// Compute new root from H_new_k and the same Merkle proof
-		currentHash = H_new_k
-		for i := 0; i < D2; i++ {
-			left := api.Select(tx.PathBits[i], currentHash, tx.Siblings[i])
-			right := api.Select(tx.PathBits[i], tx.Siblings[i], currentHash)
-			mimcLevel, err := mimc.NewMiMC(api)
-			if err != nil {
-				return err
-			}
-			mimcLevel.Write(left, right)
-			currentHash = mimcLevel.Sum()
-		}
-		ComputedNewRoot_k := currentHash
-
-		// Chain the roots: old root matches previous new root (or OldRoot for k=0)
-		if k == 0 {
-			api.AssertIsEqual(ComputedOldRoot_k, c.OldRoot)
-		} else {
-			api.AssertIsEqual(ComputedOldRoot_k, previousNewRoot)
-		}
-		previousNewRoot = ComputedNewRoot_k
-
-		// Ensure PathBits are boolean (0 or 1)
-		for i := 0; i < D2; i++ {
-			api.AssertIsBoolean(tx.PathBits[i])
-		}
-	}
-
-	// Verify the final root matches NewRoot
-	api.AssertIsEqual(previousNewRoot, c.NewRoot)
-
-	return nil
-}
-
 // Define implements the circuit constraints.
 func (c *BatchMerkleCircuit) Define(api frontend.API) error {
 	// Step 1: Compute initial leaf hashes and Merkle root
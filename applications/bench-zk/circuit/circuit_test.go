@@ -19,7 +19,7 @@ import (
 	//  GNARK-CRYPTO libraries
 	// ---------------------------
 	"github.com/consensys/gnark-crypto/ecc"
-	// "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	// "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
 
 	"bench-zk/merkle"
 	"bench-zk/utils"
@@ -31,7 +31,7 @@ func TestDepositCircuit(t *testing.T) {
 	// a) Construct the circuit constraints shape
 	//----------------------------------------------------------------
 	var circuit DepositCircuit
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	ccs, err := frontend.Compile(ecc.BLS12_377.ScalarField(), r1cs.NewBuilder, &circuit)
 	if err != nil {
 		t.Fatalf("Failed to compile circuit: %v", err)
 	}
@@ -74,7 +74,7 @@ func TestDepositCircuit(t *testing.T) {
 
 	// e) Full witness
 	//----------------------------------------------------------------
-	fullWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	fullWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField())
 	if err != nil {
 		t.Fatalf("Failed to create full witness: %v", err)
 	}
@@ -94,7 +94,7 @@ func TestDepositCircuit(t *testing.T) {
 	//----------------------------------------------------------------
 	// g) Verify the proof with public inputs only
 	//----------------------------------------------------------------
-	publicWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	publicWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		t.Fatalf("Failed to create public witness: %v", err)
 	}
@@ -150,7 +150,7 @@ func TestUserStateCircuit(t *testing.T) {
 
 	// Step 7: Compile the circuit
 	var circuit UserStateCircuit
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	ccs, err := frontend.Compile(ecc.BLS12_377.ScalarField(), r1cs.NewBuilder, &circuit)
 	if err != nil {
 		t.Fatalf("Failed to compile circuit: %v", err)
 	}
@@ -162,7 +162,7 @@ func TestUserStateCircuit(t *testing.T) {
 	}
 
 	// Step 9: Create full witness and generate proof for user A
-	fullWitnessA, err := frontend.NewWitness(&assignmentA, ecc.BN254.ScalarField())
+	fullWitnessA, err := frontend.NewWitness(&assignmentA, ecc.BLS12_377.ScalarField())
 	if err != nil {
 		t.Fatalf("Failed to create full witness for user A: %v", err)
 	}
@@ -174,7 +174,7 @@ func TestUserStateCircuit(t *testing.T) {
 	proofTime := time.Since(start)
 
 	// Step 10: Create public witness and verify proof for user A
-	publicWitnessA, err := frontend.NewWitness(&assignmentA, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	publicWitnessA, err := frontend.NewWitness(&assignmentA, ecc.BLS12_377.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		t.Fatalf("Failed to create public witness for user A: %v", err)
 	}
@@ -205,7 +205,7 @@ func TestUserStateCircuit(t *testing.T) {
 	}
 
 	// Step 12: Create full witness and generate proof for user B
-	fullWitnessB, err := frontend.NewWitness(&assignmentB, ecc.BN254.ScalarField())
+	fullWitnessB, err := frontend.NewWitness(&assignmentB, ecc.BLS12_377.ScalarField())
 	if err != nil {
 		t.Fatalf("Failed to create full witness for user B: %v", err)
 	}
@@ -215,7 +215,7 @@ func TestUserStateCircuit(t *testing.T) {
 	}
 
 	// Step 13: Create public witness and verify proof for user B
-	publicWitnessB, err := frontend.NewWitness(&assignmentB, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	publicWitnessB, err := frontend.NewWitness(&assignmentB, ecc.BLS12_377.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		t.Fatalf("Failed to create public witness for user B: %v", err)
 	}
@@ -289,7 +289,7 @@ func TestMerkleCircuit(t *testing.T) {
 
 	// Step 8: Compile the circuit
 	var circuit MerkleCircuit
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	ccs, err := frontend.Compile(ecc.BLS12_377.ScalarField(), r1cs.NewBuilder, &circuit)
 	if err != nil {
 		t.Fatalf("Failed to compile circuit: %v", err)
 	}
@@ -301,7 +301,7 @@ func TestMerkleCircuit(t *testing.T) {
 	}
 
 	// Step 10: Create full witness and generate proof
-	fullWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	fullWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField())
 	if err != nil {
 		t.Fatalf("Failed to create full witness: %v", err)
 	}
@@ -313,7 +313,7 @@ func TestMerkleCircuit(t *testing.T) {
 	proofTime := time.Since(start)
 
 	// Step 11: Create public witness and verify proof
-	publicWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	publicWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		t.Fatalf("Failed to create public witness: %v", err)
 	}
@@ -380,7 +380,7 @@ func TestBatchMerkleCircuit(t *testing.T) {
 	// Step 6: Compile the circuit
 	start = time.Now()
 	var circuit BatchMerkleCircuit
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	ccs, err := frontend.Compile(ecc.BLS12_377.ScalarField(), r1cs.NewBuilder, &circuit)
 	if err != nil {
 		t.Fatalf("Failed to compile circuit: %v", err)
 	}
@@ -392,7 +392,7 @@ func TestBatchMerkleCircuit(t *testing.T) {
 	}
 
 	// Step 8: Generate proof
-	fullWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	fullWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField())
 	if err != nil {
 		t.Fatalf("Failed to create full witness: %v", err)
 	}
@@ -406,7 +406,7 @@ func TestBatchMerkleCircuit(t *testing.T) {
 	proofTime := time.Since(start)
 
 	// Step 9: Verify proof
-	publicWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	publicWitness, err := frontend.NewWitness(&assignment, ecc.BLS12_377.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		t.Fatalf("Failed to create public witness: %v", err)
 	}
@@ -420,126 +420,3 @@ func TestBatchMerkleCircuit(t *testing.T) {
 	t.Logf("Successfully verified BatchMerkleCircuit proof for %v transactions!", B)
 	t.Logf("BatchMerkleCircuit: Leaves=%d, Batch Size=%d, Proof Generation Time=%v, Verification Time=%v, Preparation Time=%v, Compile Time=%v", N, B, proofTime, verifyTime, prepareTime, compileTime)
 }
-
-// TestProofMerkleCircuit tests the ProofMerkleCircuit with adjustable D2 and B2.
-func TestProofMerkleCircuit(t *testing.T) {
-	const N2 = 1 << D2 // Number of leaves, e.g., 1024 for D2=10
-
-	// Step 1: Initialize users
-	var users [N2]merkle.UserState
-	for i := 0; i < N2; i++ {
-		users[i] = merkle.UserState{
-			Name: big.NewInt(int64(i + 1)), // Names: 1 to N2
-			Ben:  big.NewInt(100),          // Initial balance: 100
-		}
-	}
-
-	// Step 2: Compute initial Merkle root
-	oldRoot := merkle.BuildMerkleStates(users[:])
-
-	// Step 3: Generate B2 transactions with proofs
-	start := time.Now()
-	type Transaction struct {
-		LeafIndex     int
-		DepositAmount *big.Int
-		OldState      merkle.UserState
-		NewState      merkle.UserState
-		Proof         *merkle.MProof
-	}
-	var transactions [B2]Transaction
-	currentUsers := make([]merkle.UserState, N2)
-	copy(currentUsers, users[:])
-
-	for k := 0; k < B2; k++ {
-		leafIndex := rand.Intn(N2)
-		oldState := currentUsers[leafIndex]
-		H_old := merkle.HashUserState(oldState)
-		proof, err := merkle.GenerateMerkleProof(currentUsers, H_old)
-		if err != nil {
-			t.Fatalf("Failed to generate Merkle proof for transaction %d: %v", k, err)
-		}
-		depositAmount := big.NewInt(int64(rand.Intn(11))) // Random deposit: 0-10
-		newState := merkle.UserState{
-			Name: oldState.Name,
-			Ben:  new(big.Int).Add(oldState.Ben, depositAmount),
-		}
-		transactions[k] = Transaction{
-			LeafIndex:     leafIndex,
-			DepositAmount: depositAmount,
-			OldState:      oldState,
-			NewState:      newState,
-			Proof:         proof,
-		}
-		currentUsers[leafIndex] = newState // Update state for next iteration
-	}
-
-	// Step 4: Compute final Merkle root
-	newRoot := merkle.BuildMerkleStates(currentUsers)
-
-	// Step 5: Assign circuit values
-	var assignment ProofMerkleCircuit
-	assignment.OldRoot = oldRoot
-	assignment.NewRoot = newRoot
-	for k := 0; k < B2; k++ {
-		tx := transactions[k]
-		assignment.Transactions[k].OldName = tx.OldState.Name
-		assignment.Transactions[k].OldBalance = tx.OldState.Ben
-		assignment.Transactions[k].NewName = tx.NewState.Name
-		assignment.Transactions[k].BenChange = tx.DepositAmount // Use DepositAmount as BenChange instead of NewBalance
-		for i := 0; i < D2; i++ {
-			assignment.Transactions[k].Siblings[i] = tx.Proof.Siblings[i]
-			if tx.Proof.PathBits[i] {
-				assignment.Transactions[k].PathBits[i] = big.NewInt(1)
-			} else {
-				assignment.Transactions[k].PathBits[i] = big.NewInt(0)
-			}
-		}
-	}
-	prepareTime := time.Since(start)
-
-	start = time.Now()
-	// Step 6: Compile the circuit
-	var circuit ProofMerkleCircuit
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
-	if err != nil {
-		t.Fatalf("Failed to compile circuit: %v", err)
-	}
-	compileTime := time.Since(start)
-
-	start = time.Now()
-	// Step 7: Setup proving/verifying keys
-	pk, vk, err := groth16.Setup(ccs)
-	if err != nil {
-		t.Fatalf("Failed to setup keys: %v", err)
-	}
-	setupTime := time.Since(start)
-
-	start = time.Now()
-	// Step 8: Generate proof and measure time
-	fullWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		t.Fatalf("Failed to create full witness: %v", err)
-	}
-	witnessTime := time.Since(start)
-
-	start = time.Now()
-	proof, err := groth16.Prove(ccs, pk, fullWitness)
-	if err != nil {
-		t.Fatalf("Failed to generate proof: %v", err)
-	}
-	proofTime := time.Since(start)
-
-	// Step 9: Verify proof
-	publicWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
-	if err != nil {
-		t.Fatalf("Failed to create public witness: %v", err)
-	}
-	start = time.Now()
-	err = groth16.Verify(proof, vk, publicWitness)
-	if err != nil {
-		t.Fatalf("Failed to verify proof: %v", err)
-	}
-	verifyTime := time.Since(start)
-
-	t.Logf("ProofMerkleCircuit: Depth=%d, Leaves=%d, Batch Size=%d, Proof Generation Time=%v, Verification Time=%v, Preparation Time=%v, Compile Time=%v, Witness Time=%v, Setup Time=%v", D2, N2, B2, proofTime, verifyTime, prepareTime, compileTime, witnessTime, setupTime)
-}
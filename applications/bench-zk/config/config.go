@@ -0,0 +1,184 @@
+// config/config.go
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bench-zk/gateway"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default locations (relative to the configuration file) of the operator's local files.
+const (
+	DefaultKeyDir         = "keys"
+	DefaultStatePath      = "operator-state.json"
+	DefaultCheckpointPath = "operator-checkpoint.json"
+	DefaultProverWorkers  = 2
+	DefaultProofBackend   = "groth16"
+	DefaultHasher         = "mimc"
+	DefaultPoolPolicy     = "round-robin"
+	DefaultLogLevel       = "info"
+	DefaultLogFormat      = "text"
+)
+
+// Config is the operator configuration, loaded from a YAML or JSON file.
+// L1 is the root chain hosting ZKContract, L2 is the rollup chain hosting CurrencyContract.
+type Config struct {
+	L1             gateway.Chain   `yaml:"l1" json:"l1"`
+	L2             gateway.Chain   `yaml:"l2" json:"l2"`
+	L1Peers        []gateway.Chain `yaml:"l1Peers" json:"l1Peers"`               // Further Layer 1 gateways sharing the ZKContract calls, empty fields taken from l1
+	PoolPolicy     string          `yaml:"poolPolicy" json:"poolPolicy"`         // Selection among l1 and l1Peers: "round-robin" or "least-loaded"
+	KeyDir         string          `yaml:"keyDir" json:"keyDir"`                 // Directory holding the compiled circuit and keys of each proof backend
+	StatePath      string          `yaml:"statePath" json:"statePath"`           // File holding the operator's rollup state snapshot
+	CheckpointPath string          `yaml:"checkpointPath" json:"checkpointPath"` // File holding the last Layer 2 block the operator committed
+	ProverWorkers  int             `yaml:"proverWorkers" json:"proverWorkers"`   // Number of blocks proven concurrently
+	ProofBackend   string          `yaml:"proofBackend" json:"proofBackend"`     // Proof system: "groth16" or "plonk"
+	Aggregate      int             `yaml:"aggregate" json:"aggregate"`           // Most batch proofs of a block folded into one proof on Layer 1; 0 to disable
+	SRSPath        string          `yaml:"srsPath" json:"srsPath"`               // KZG SRS over BLS12-377 the plonk setup uses, e.g. converted from a ceremony (see prover.LoadSRS)
+	Hasher         string          `yaml:"hasher" json:"hasher"`                 // Hash function of the rollup state: "mimc" or "poseidon2"
+	LatencyPath    string          `yaml:"latencyPath" json:"latencyPath"`       // File the latency of each step of the Layer 1 transactions is written to, as CSV or JSON; empty to disable
+	MetricsAddress string          `yaml:"metricsAddress" json:"metricsAddress"` // Address the operator serves Prometheus metrics on at /metrics, e.g. ":9464"; empty to disable
+	LogLevel       string          `yaml:"logLevel" json:"logLevel"`             // Lowest level logged: "debug", "info", "warn" or "error"
+	LogFormat      string          `yaml:"logFormat" json:"logFormat"`           // Log format: "text" or "json"
+}
+
+// Environment variable prefixes overriding the fields of each chain, e.g. BENCH_ZK_L1_PEER_ENDPOINT
+// (see gateway.Chain.ApplyEnv).
+const (
+	EnvL1 = "BENCH_ZK_L1"
+	EnvL2 = "BENCH_ZK_L2"
+)
+
+// Load reads the configuration file at path. Files ending in ".json" are decoded as JSON,
+// anything else as YAML. Relative file paths inside the configuration are resolved against
+// the directory of the configuration file, so the operator can be started from anywhere.
+// The environment then overrides chain fields, and connection profiles and wallets fill the
+// chain fields still empty.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if cfg.KeyDir == "" {
+		cfg.KeyDir = DefaultKeyDir
+	}
+	if cfg.StatePath == "" {
+		cfg.StatePath = DefaultStatePath
+	}
+	if cfg.CheckpointPath == "" {
+		cfg.CheckpointPath = DefaultCheckpointPath
+	}
+	if cfg.ProverWorkers <= 0 {
+		cfg.ProverWorkers = DefaultProverWorkers
+	}
+	if cfg.ProofBackend == "" {
+		cfg.ProofBackend = DefaultProofBackend
+	}
+	if cfg.Hasher == "" {
+		cfg.Hasher = DefaultHasher
+	}
+	if cfg.PoolPolicy == "" {
+		cfg.PoolPolicy = DefaultPoolPolicy
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = DefaultLogLevel
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = DefaultLogFormat
+	}
+
+	base := filepath.Dir(path)
+	cfg.KeyDir = resolve(base, cfg.KeyDir)
+	cfg.SRSPath = resolve(base, cfg.SRSPath)
+	cfg.StatePath = resolve(base, cfg.StatePath)
+	cfg.CheckpointPath = resolve(base, cfg.CheckpointPath)
+	cfg.LatencyPath = resolve(base, cfg.LatencyPath)
+	cfg.L1 = cfg.L1.RelativeTo(base)
+	cfg.L2 = cfg.L2.RelativeTo(base)
+
+	cfg.L1.ApplyEnv(EnvL1)
+	cfg.L2.ApplyEnv(EnvL2)
+	if cfg.L1, err = cfg.L1.Resolve(); err != nil {
+		return nil, fmt.Errorf("failed to resolve l1: %w", err)
+	}
+	if cfg.L2, err = cfg.L2.Resolve(); err != nil {
+		return nil, fmt.Errorf("failed to resolve l2: %w", err)
+	}
+	for i := range cfg.L1Peers {
+		if cfg.L1Peers[i], err = cfg.L1Peers[i].RelativeTo(base).Inherit(cfg.L1).Resolve(); err != nil {
+			return nil, fmt.Errorf("failed to resolve l1Peers[%d]: %w", i, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks that both chains carry every field needed to open a gateway connection.
+func (c *Config) Validate() error {
+	if err := validateChain("l1", c.L1); err != nil {
+		return err
+	}
+	for i, peer := range c.L1Peers {
+		if err := validateChain(fmt.Sprintf("l1Peers[%d]", i), peer); err != nil {
+			return err
+		}
+	}
+	if _, err := gateway.ParsePolicy(c.PoolPolicy); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if c.Aggregate < 0 || c.Aggregate == 1 {
+		return fmt.Errorf("config: aggregate must be 0 (disabled) or at least 2, got %d", c.Aggregate)
+	}
+	// Only Groth16 batch proofs can be verified inside the aggregation circuit
+	if c.Aggregate > 0 && c.ProofBackend != DefaultProofBackend {
+		return fmt.Errorf("config: aggregate requires proofBackend %s, got %s", DefaultProofBackend, c.ProofBackend)
+	}
+	return validateChain("l2", c.L2)
+}
+
+func validateChain(name string, chain gateway.Chain) error {
+	required := []struct {
+		field string
+		value string
+	}{
+		{"mspId", chain.MspID},
+		{"certPath (or wallet)", chain.CertPath + chain.CertPEM},
+		{"keyPath (or wallet)", chain.KeyPath + chain.KeyPEM},
+		{"tlsCertPath (or profile)", chain.TLSCertPath + chain.TLSCertPEM},
+		{"peerEndpoint", chain.PeerEndpoint},
+		{"gatewayPeer", chain.GatewayPeer},
+		{"channelName", chain.ChannelName},
+		{"chaincodeName", chain.ChaincodeName},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("config: %s.%s is required", name, r.field)
+		}
+	}
+	return nil
+}
+
+// resolve makes a relative path relative to base. Empty and absolute paths are kept as is.
+func resolve(base, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
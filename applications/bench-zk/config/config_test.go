@@ -0,0 +1,275 @@
+// config/config_test.go
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testYAML = `
+l1:
+  mspId: org01MSP
+  cryptoPath: certs/org01.chains
+  certPath: certs/org01.chains/users/User1@org01.chains/msp/signcerts/cert.pem
+  keyPath: certs/org01.chains/users/User1@org01.chains/msp/keystore/
+  tlsCertPath: /etc/fabric/org01/ca.crt
+  peerEndpoint: localhost:6001
+  gatewayPeer: peer1.org01.chains
+  channelName: chains
+  chaincodeName: basic
+l2:
+  mspId: org02MSP
+  certPath: cert.pem
+  keyPath: keystore/
+  tlsCertPath: ca.crt
+  peerEndpoint: localhost:6002
+  gatewayPeer: peer1.org02.chains
+  channelName: chains02
+  chaincodeName: pasic
+keyDir: zk-keys
+srsPath: srs/bls12-377.srs
+`
+
+const testJSON = `{
+  "l1": {"mspId": "org01MSP", "certPath": "a.pem", "keyPath": "k/", "tlsCertPath": "ca.crt",
+         "peerEndpoint": "localhost:6001", "gatewayPeer": "peer1.org01.chains",
+         "channelName": "chains", "chaincodeName": "basic"},
+  "l2": {"mspId": "org02MSP", "certPath": "b.pem", "keyPath": "k/", "tlsCertPath": "ca.crt",
+         "peerEndpoint": "localhost:6002", "gatewayPeer": "peer1.org02.chains",
+         "channelName": "chains02", "chaincodeName": "pasic"},
+  "statePath": "/var/lib/bench-zk/state.json",
+  "proofBackend": "plonk",
+  "hasher": "poseidon2"
+}`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeFile(t, "operator.yaml", testYAML)
+	dir := filepath.Dir(path)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	if cfg.L1.MspID != "org01MSP" || cfg.L2.ChaincodeName != "pasic" {
+		t.Errorf("Unexpected chain fields: %+v / %+v", cfg.L1, cfg.L2)
+	}
+	if want := filepath.Join(dir, "certs/org01.chains/users/User1@org01.chains/msp/signcerts/cert.pem"); cfg.L1.CertPath != want {
+		t.Errorf("CertPath = %s, want %s", cfg.L1.CertPath, want)
+	}
+	if cfg.L1.TLSCertPath != "/etc/fabric/org01/ca.crt" {
+		t.Errorf("Absolute TLSCertPath was rewritten to %s", cfg.L1.TLSCertPath)
+	}
+	if want := filepath.Join(dir, "zk-keys"); cfg.KeyDir != want {
+		t.Errorf("KeyDir = %s, want %s", cfg.KeyDir, want)
+	}
+	if want := filepath.Join(dir, "srs/bls12-377.srs"); cfg.SRSPath != want {
+		t.Errorf("SRSPath = %s, want %s", cfg.SRSPath, want)
+	}
+	if want := filepath.Join(dir, DefaultStatePath); cfg.StatePath != want {
+		t.Errorf("StatePath = %s, want %s", cfg.StatePath, want)
+	}
+	if want := filepath.Join(dir, DefaultCheckpointPath); cfg.CheckpointPath != want {
+		t.Errorf("CheckpointPath = %s, want %s", cfg.CheckpointPath, want)
+	}
+	if cfg.ProverWorkers != DefaultProverWorkers {
+		t.Errorf("ProverWorkers = %d, want %d", cfg.ProverWorkers, DefaultProverWorkers)
+	}
+	if cfg.ProofBackend != DefaultProofBackend {
+		t.Errorf("ProofBackend = %s, want %s", cfg.ProofBackend, DefaultProofBackend)
+	}
+	if cfg.Hasher != DefaultHasher {
+		t.Errorf("Hasher = %s, want %s", cfg.Hasher, DefaultHasher)
+	}
+	if cfg.LogLevel != DefaultLogLevel || cfg.LogFormat != DefaultLogFormat {
+		t.Errorf("LogLevel, LogFormat = %s, %s, want %s, %s", cfg.LogLevel, cfg.LogFormat, DefaultLogLevel, DefaultLogFormat)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := writeFile(t, "operator.json", testJSON)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if cfg.L2.PeerEndpoint != "localhost:6002" {
+		t.Errorf("L2 PeerEndpoint = %s", cfg.L2.PeerEndpoint)
+	}
+	if cfg.StatePath != "/var/lib/bench-zk/state.json" {
+		t.Errorf("StatePath = %s", cfg.StatePath)
+	}
+	if cfg.ProofBackend != "plonk" {
+		t.Errorf("ProofBackend = %s", cfg.ProofBackend)
+	}
+	if cfg.Hasher != "poseidon2" {
+		t.Errorf("Hasher = %s", cfg.Hasher)
+	}
+	if want := filepath.Join(filepath.Dir(path), DefaultKeyDir); cfg.KeyDir != want {
+		t.Errorf("KeyDir = %s, want %s", cfg.KeyDir, want)
+	}
+}
+
+func TestValidateMissingField(t *testing.T) {
+	path := writeFile(t, "operator.yaml", "l1:\n  mspId: org01MSP\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Expected Validate to fail for an incomplete config")
+	}
+}
+
+// TestValidateAggregate checks that aggregation folds at least two Groth16 batch proofs.
+func TestValidateAggregate(t *testing.T) {
+	cfg, err := Load(writeFile(t, "operator.yaml", testYAML))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for _, tc := range []struct {
+		aggregate int
+		backend   string
+		valid     bool
+	}{
+		{0, "plonk", true},
+		{2, "groth16", true},
+		{1, "groth16", false},
+		{-1, "groth16", false},
+		{4, "plonk", false},
+	} {
+		cfg.Aggregate, cfg.ProofBackend = tc.aggregate, tc.backend
+		if err := cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("aggregate %d with %s: Validate returned %v", tc.aggregate, tc.backend, err)
+		}
+	}
+}
+
+const testProfileYAML = `
+l1:
+  profile: connection-org01.yaml
+  wallet: wallet
+  channelName: chains
+  chaincodeName: basic
+l2:
+  mspId: org02MSP
+  certPath: cert.pem
+  keyPath: keystore/priv_sk
+  tlsCertPath: ca.crt
+  peerEndpoint: localhost:6002
+  gatewayPeer: peer1.org02.chains
+  channelName: chains02
+  chaincodeName: pasic
+`
+
+const testConnectionProfile = `
+name: org01
+client:
+  organization: org01
+organizations:
+  Org01:
+    mspid: org01MSP
+    peers:
+    - peer1.org01.chains
+peers:
+  peer1.org01.chains:
+    url: grpcs://localhost:6001
+    tlsCACerts:
+      path: tls/ca.crt
+`
+
+func TestLoadProfileWalletAndEnv(t *testing.T) {
+	path := writeFile(t, "operator.yaml", testProfileYAML)
+	dir := filepath.Dir(path)
+	if err := os.WriteFile(filepath.Join(dir, "connection-org01.yaml"), []byte(testConnectionProfile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "wallet"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	identity := `{"credentials":{"certificate":"CERT","privateKey":"KEY"},"mspId":"org01MSP","type":"X.509","version":1}`
+	if err := os.WriteFile(filepath.Join(dir, "wallet", "User1.id"), []byte(identity), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvL2+"_PEER_ENDPOINT", "peer1.org02.chains:7051")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if cfg.L1.MspID != "org01MSP" || cfg.L1.PeerEndpoint != "localhost:6001" || cfg.L1.CertPEM != "CERT" {
+		t.Errorf("L1 not filled from the profile and wallet: %+v", cfg.L1)
+	}
+	if want := filepath.Join(dir, "tls/ca.crt"); cfg.L1.TLSCertPath != want {
+		t.Errorf("TLSCertPath = %s, want %s", cfg.L1.TLSCertPath, want)
+	}
+	if cfg.L2.PeerEndpoint != "peer1.org02.chains:7051" {
+		t.Errorf("L2 PeerEndpoint = %s, want the environment override", cfg.L2.PeerEndpoint)
+	}
+
+	t.Setenv(EnvL1+"_PROFILE", filepath.Join(dir, "missing.yaml"))
+	if _, err := Load(path); err == nil {
+		t.Error("Load accepted a missing connection profile")
+	}
+}
+
+func TestLoadL1Peers(t *testing.T) {
+	yaml := testYAML + `
+l1Peers:
+  - peerEndpoint: localhost:6003
+    gatewayPeer: peer1.org03.chains
+    tlsCertPath: certs/org03.chains/ca.crt
+  - peerEndpoint: localhost:6004
+    gatewayPeer: peer1.org04.chains
+poolPolicy: least-loaded
+`
+	path := writeFile(t, "operator.yaml", yaml)
+	dir := filepath.Dir(path)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(cfg.L1Peers) != 2 || cfg.PoolPolicy != "least-loaded" {
+		t.Fatalf("Unexpected pool: %+v, %s", cfg.L1Peers, cfg.PoolPolicy)
+	}
+	peer := cfg.L1Peers[0]
+	if peer.PeerEndpoint != "localhost:6003" || peer.ChannelName != "chains" || peer.CertPath != cfg.L1.CertPath || peer.MspID != "org01MSP" {
+		t.Errorf("l1Peers[0] did not inherit from l1: %+v", peer)
+	}
+	if want := filepath.Join(dir, "certs/org03.chains/ca.crt"); peer.TLSCertPath != want {
+		t.Errorf("l1Peers[0] TLSCertPath = %s, want %s", peer.TLSCertPath, want)
+	}
+	if cfg.L1Peers[1].TLSCertPath != cfg.L1.TLSCertPath {
+		t.Errorf("l1Peers[1] TLSCertPath = %s, want the one of l1", cfg.L1Peers[1].TLSCertPath)
+	}
+
+	cfg.PoolPolicy = "random"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted an unknown pool policy")
+	}
+}
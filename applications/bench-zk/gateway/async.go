@@ -0,0 +1,160 @@
+// gateway/async.go
+
+package gateway
+
+// Asynchronous submission: a transaction is endorsed and handed to the orderer, and the caller
+// waits for its commit separately, so that a benchmark can keep several transactions in flight
+// and see how long each step of the flow took.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// TxTiming is the timeline of one transaction submitted through SubmitAsync. A zero time means
+// the transaction did not get that far.
+type TxTiming struct {
+	Transaction    string // Qualified name, e.g. "ZKContract:CommitProof"
+	TransactionID  string
+	Start          time.Time // Before the proposal was created and signed
+	Endorsed       time.Time // Endorsed transaction returned by the gateway
+	Submitted      time.Time // Accepted by the orderer
+	Committed      time.Time // Commit status received, whether valid or not
+	BlockNumber    uint64
+	ValidationCode peer.TxValidationCode
+	Err            error // Why the transaction failed, nil if it committed as valid
+}
+
+// Duration returns how long stage took, and false if the transaction did not complete it.
+func (t *TxTiming) Duration(stage string) (time.Duration, bool) {
+	var from, to time.Time
+	switch stage {
+	case StageEndorse:
+		from, to = t.Start, t.Endorsed
+	case StageSubmit:
+		from, to = t.Endorsed, t.Submitted
+	case StageCommit:
+		from, to = t.Submitted, t.Committed
+	case StageTotal:
+		from, to = t.Start, t.Committed
+	}
+	if from.IsZero() || to.IsZero() {
+		return 0, false
+	}
+	return to.Sub(from), true
+}
+
+// FailedStage returns the stage the transaction stopped in, StageCommit for a transaction
+// committed as invalid, and "" if it has not failed.
+func (t *TxTiming) FailedStage() string {
+	switch {
+	case t.Err == nil:
+		return ""
+	case t.Endorsed.IsZero():
+		return StageEndorse
+	case t.Submitted.IsZero():
+		return StageSubmit
+	}
+	return StageCommit
+}
+
+// Pending is a transaction accepted by the orderer whose commit has not been waited for yet.
+type Pending struct {
+	Result []byte   // Result returned by the chaincode at endorsement
+	Timing TxTiming // Complete once Wait returned
+
+	commit *client.Commit
+	off    *offline
+	sink   *Metrics
+	once   sync.Once
+	err    error
+}
+
+// SubmitAsync endorses transaction name of contract and submits it to the orderer like
+// client.Contract.SubmitAsync, timing each step, and returns as soon as the orderer accepted it.
+// A transaction that fails on the way is recorded in sink, which may be nil, and its error is
+// returned as is from the fabric-gateway client.
+func SubmitAsync(contract *client.Contract, sink *Metrics, name string, args ...string) (*Pending, error) {
+	return submitAsync(contract, nil, sink, name, args)
+}
+
+// submitAsync is SubmitAsync signing each step through off, or inline if off is nil.
+func submitAsync(contract *client.Contract, off *offline, sink *Metrics, name string, args []string) (*Pending, error) {
+	t := TxTiming{Transaction: name, Start: time.Now()}
+	fail := func(err error) (*Pending, error) {
+		t.Err = err
+		sink.Record(&t)
+		return nil, err
+	}
+
+	proposal, err := contract.NewProposal(name, client.WithArguments(args...))
+	if err != nil {
+		return fail(err)
+	}
+	t.TransactionID = proposal.TransactionID()
+	if proposal, err = off.signProposal(proposal); err != nil {
+		return fail(signError(ErrEndorse, &t, err))
+	}
+	transaction, err := proposal.Endorse()
+	if err != nil {
+		return fail(err)
+	}
+	t.Endorsed = time.Now()
+	if transaction, err = off.signTransaction(transaction); err != nil {
+		return fail(signError(ErrSubmit, &t, err))
+	}
+	commit, err := transaction.Submit()
+	if err != nil {
+		return fail(err)
+	}
+	t.Submitted = time.Now()
+
+	return &Pending{Result: transaction.Result(), Timing: t, commit: commit, off: off, sink: sink}, nil
+}
+
+// TransactionID returns the ID of the pending transaction.
+func (p *Pending) TransactionID() string {
+	return p.Timing.TransactionID
+}
+
+// Wait blocks until the transaction is committed, within the commit status timeout of the
+// gateway, and records its timing. A transaction committed as invalid fails with a ContractError
+// of kind ErrCommit. Calling Wait again returns the same outcome.
+func (p *Pending) Wait() error {
+	return p.wait(func() (*client.Status, error) { return p.commit.Status() })
+}
+
+// WaitWithContext is Wait with the timeout of ctx instead of the gateway's. If ctx ends first
+// the transaction may still commit, but it is recorded as failed.
+func (p *Pending) WaitWithContext(ctx context.Context) error {
+	return p.wait(func() (*client.Status, error) { return p.commit.StatusWithContext(ctx) })
+}
+
+func (p *Pending) wait(status func() (*client.Status, error)) error {
+	p.once.Do(func() {
+		var s *client.Status
+		commit, err := p.off.signCommit(p.commit)
+		if err != nil {
+			err = signError(ErrCommitStatus, &p.Timing, err)
+		} else {
+			p.commit = commit
+			s, err = status()
+		}
+		if err != nil {
+			p.err = err
+		} else {
+			p.Timing.Committed = time.Now()
+			p.Timing.BlockNumber, p.Timing.ValidationCode = s.BlockNumber, s.Code
+			if !s.Successful {
+				p.err = &ContractError{Kind: ErrCommit, Transaction: p.Timing.Transaction, TransactionID: s.TransactionID, ValidationCode: s.Code}
+			}
+		}
+		p.Timing.Err = p.err
+		p.sink.Record(&p.Timing)
+	})
+	return p.err
+}
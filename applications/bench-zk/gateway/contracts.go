@@ -0,0 +1,345 @@
+// gateway/contracts.go
+
+package gateway
+
+// Typed clients of the contracts of chaincodes/wrappers. Every method converts its arguments to
+// the strings the gateway expects, decodes the result into Go values and returns a *ContractError
+// on failure, instead of each application passing strings around and panicking.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Contract names registered by chaincodes/wrappers.
+const (
+	CurrencyContractName = "CurrencyContract"
+	PlasmaContractName   = "PlasmaContract"
+	ZKContractName       = "ZKContract"
+)
+
+// MerkleRoot is one Merkle root committed to PlasmaContract.
+type MerkleRoot struct {
+	BlockNumber string `json:"BlockNumber"`
+	MerkleRoot  string `json:"MerkleRoot"`
+}
+
+// StateRoot is one state root committed to ZKContract.
+type StateRoot struct {
+	BlockNumber string `json:"BlockNumber"`
+	StateRoot   string `json:"StateRoot"`
+}
+
+// ProofChain is the chain of proofs ZKContract stores for a block split into several batches:
+// Proofs[i] moves the state from Roots[i] to Roots[i+1] and is verified under CircuitIDs[i].
+// Roots and proofs are base64.
+type ProofChain struct {
+	Roots      []string `json:"roots"`
+	Proofs     []string `json:"proofs"`
+	CircuitIDs []string `json:"circuitIds"`
+}
+
+// AggregatedProof is the single proof ZKContract stores for a block whose batch proofs were
+// aggregated: Proof moves the state through Roots and is verified under CircuitID.
+// Roots and the proof are base64.
+type AggregatedProof struct {
+	Roots     []string `json:"roots"`
+	Proof     string   `json:"proof"`
+	CircuitID string   `json:"circuitId"`
+}
+
+// contract calls the transactions of one contract through an Invoker and classifies their errors.
+type contract struct {
+	inv  Invoker
+	name string
+}
+
+func (c contract) submit(tx string, args ...string) ([]byte, error) {
+	name := c.name + ":" + tx
+	result, err := c.inv.SubmitTransaction(name, args...)
+	if err != nil {
+		return nil, classify(name, false, err)
+	}
+	return result, nil
+}
+
+func (c contract) evaluate(tx string, args ...string) ([]byte, error) {
+	name := c.name + ":" + tx
+	result, err := c.inv.EvaluateTransaction(name, args...)
+	if err != nil {
+		return nil, classify(name, true, err)
+	}
+	return result, nil
+}
+
+// evaluateString evaluates a transaction returning a string.
+func (c contract) evaluateString(tx string, args ...string) (string, error) {
+	result, err := c.evaluate(tx, args...)
+	return string(result), err
+}
+
+// evaluateJSON evaluates a transaction and decodes its JSON result into v. An empty result, which
+// contractapi returns for a nil slice or pointer, leaves v unchanged.
+func (c contract) evaluateJSON(v interface{}, tx string, args ...string) error {
+	result, err := c.evaluate(tx, args...)
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result, v); err != nil {
+		return decodeError(c.name+":"+tx, fmt.Errorf("failed to unmarshal %T: %w", v, err))
+	}
+	return nil
+}
+
+func formatInt(i int64) string    { return strconv.FormatInt(i, 10) }
+func formatBlock(b uint64) string { return strconv.FormatUint(b, 10) }
+func marshalStrings(s []string) string {
+	if s == nil {
+		s = []string{}
+	}
+	data, _ := json.Marshal(s) // Marshalling strings cannot fail
+	return string(data)
+}
+
+// CurrencyClient is a typed client of CurrencyContract, the in-game currency of Layer 2.
+// Amounts have three decimal places.
+type CurrencyClient struct{ c contract }
+
+// NewCurrencyClient returns a client of the CurrencyContract of the chaincode behind inv.
+func NewCurrencyClient(inv Invoker) *CurrencyClient {
+	return &CurrencyClient{contract{inv, CurrencyContractName}}
+}
+
+// InitLedger creates players 1 to 3, without public keys, and sets the exchange rate to 1.0.
+func (c *CurrencyClient) InitLedger() error {
+	_, err := c.c.submit("InitLedger")
+	return err
+}
+
+// CreatePlayer creates player id with empty balances, registering pubKey, the player's public
+// key encoded by rollup.EncodePublicKey. The ZK rollup requires one, and signature, the player's
+// signature of rollup.Message(id, 0, 0) encoded by rollup.EncodeSignature; other chains accept "".
+func (c *CurrencyClient) CreatePlayer(id int64, pubKey, signature string) error {
+	_, err := c.c.submit("CreatePlayer", formatInt(id), pubKey, signature)
+	return err
+}
+
+// EnableRollup marks the chain as rolled up by the ZK rollup: from then on players must
+// register a public key, and players without one cannot get BEN. It fails if such a player
+// already holds BEN.
+func (c *CurrencyClient) EnableRollup() error {
+	_, err := c.c.submit("EnableRollup")
+	return err
+}
+
+// RollupEnabled reports whether EnableRollup was called.
+func (c *CurrencyClient) RollupEnabled() (bool, error) {
+	var enabled bool
+	err := c.c.evaluateJSON(&enabled, "RollupEnabled")
+	return enabled, err
+}
+
+// PlayerExists reports whether player id exists.
+func (c *CurrencyClient) PlayerExists(id int64) (bool, error) {
+	var exists bool
+	err := c.c.evaluateJSON(&exists, "PlayerExists", formatInt(id))
+	return exists, err
+}
+
+// GetPlayer returns player id.
+func (c *CurrencyClient) GetPlayer(id int64) (*Player, error) {
+	var player Player
+	if err := c.c.evaluateJSON(&player, "GetPlayer", formatInt(id)); err != nil {
+		return nil, err
+	}
+	return &player, nil
+}
+
+// GetAllPlayers returns every player on the ledger.
+func (c *CurrencyClient) GetAllPlayers() ([]Player, error) {
+	var players []Player
+	err := c.c.evaluateJSON(&players, "GetAllPlayers")
+	return players, err
+}
+
+// RecordBankTransaction credits amountUSD to the USD balance of userID for the bank transfer
+// transactionID.
+func (c *CurrencyClient) RecordBankTransaction(userID, amountUSD, transactionID int64) error {
+	_, err := c.c.submit("RecordBankTransaction", formatInt(userID), formatInt(amountUSD), formatInt(transactionID))
+	return err
+}
+
+// ExchangeInGameCurrency changes the BEN balance of userID by benAmountChange, paying or
+// refunding the USD equivalent at the current exchange rate. The ZK rollup requires signature,
+// the player's signature of rollup.Message(userID, benAmountChange, nonce) at its current nonce;
+// other chains accept "".
+func (c *CurrencyClient) ExchangeInGameCurrency(userID, benAmountChange int64, signature string) error {
+	_, err := c.c.submit("ExchangeInGameCurrency", formatInt(userID), formatInt(benAmountChange), signature)
+	return err
+}
+
+// Transfer moves amount BEN from player fromID to player toID. The ZK rollup requires signature,
+// the sender's signature of rollup.TransferMessage(fromID, toID, amount, nonce) at its current
+// nonce; other chains accept "".
+func (c *CurrencyClient) Transfer(fromID, toID, amount int64, signature string) error {
+	_, err := c.c.submit("Transfer", formatInt(fromID), formatInt(toID), formatInt(amount), signature)
+	return err
+}
+
+// SetExchangeRate sets the USD to BEN exchange rate, e.g. 2000 for 2.0.
+func (c *CurrencyClient) SetExchangeRate(rate int64) error {
+	_, err := c.c.submit("SetExchangeRate", formatInt(rate))
+	return err
+}
+
+// PlasmaClient is a typed client of PlasmaContract, the Merkle root commitments of the Plasma
+// root chain.
+type PlasmaClient struct{ c contract }
+
+// NewPlasmaClient returns a client of the PlasmaContract of the chaincode behind inv.
+func NewPlasmaClient(inv Invoker) *PlasmaClient {
+	return &PlasmaClient{contract{inv, PlasmaContractName}}
+}
+
+// InitLedger commits a zero Merkle root for block 0.
+func (c *PlasmaClient) InitLedger() error {
+	_, err := c.c.submit("InitLedger")
+	return err
+}
+
+// CommitMerkleRoot commits the Merkle root of a Layer 2 block.
+func (c *PlasmaClient) CommitMerkleRoot(blockNumber uint64, merkleRoot string) error {
+	_, err := c.c.submit("CommitMerkleRoot", formatBlock(blockNumber), merkleRoot)
+	return err
+}
+
+// QueryMerkleRoot returns the Merkle root committed for a Layer 2 block.
+func (c *PlasmaClient) QueryMerkleRoot(blockNumber uint64) (string, error) {
+	return c.c.evaluateString("QueryMerkleRoot", formatBlock(blockNumber))
+}
+
+// QueryAllMerkleRoots returns every committed Merkle root.
+func (c *PlasmaClient) QueryAllMerkleRoots() ([]MerkleRoot, error) {
+	var roots []MerkleRoot
+	err := c.c.evaluateJSON(&roots, "QueryAllMerkleRoots")
+	return roots, err
+}
+
+// ZKClient is a typed client of ZKContract, the verifier of the rollup's state transitions on
+// Layer 1. Roots, proofs and verifying keys are base64.
+type ZKClient struct{ c contract }
+
+// NewZKClient returns a client of the ZKContract of the chaincode behind inv.
+func NewZKClient(inv Invoker) *ZKClient {
+	return &ZKClient{contract{inv, ZKContractName}}
+}
+
+// InitLedger registers the verifier of circuitID, binds the rollup to it and records
+// initialRoot as the root of block 1. It fails once ZKContract is initialized.
+func (c *ZKClient) InitLedger(circuitID, backend, verifyingKey, fingerprint, initialRoot string) error {
+	_, err := c.c.submit("InitLedger", circuitID, backend, verifyingKey, fingerprint, initialRoot)
+	return err
+}
+
+// RegisterVerifier registers the verifier of another circuitID, under which proofs can then be
+// committed. Only the MSP that initialized ZKContract may call it.
+func (c *ZKClient) RegisterVerifier(circuitID, backend, verifyingKey, fingerprint string) error {
+	_, err := c.c.submit("RegisterVerifier", circuitID, backend, verifyingKey, fingerprint)
+	return err
+}
+
+// QueryCircuitID returns the circuit ID of the verifier ZKContract was initialized with.
+func (c *ZKClient) QueryCircuitID() (string, error) {
+	return c.c.evaluateString("QueryCircuitId")
+}
+
+// QueryVerifierBackend returns the proof system of the verifier of circuitID.
+func (c *ZKClient) QueryVerifierBackend(circuitID string) (string, error) {
+	return c.c.evaluateString("QueryVerifierBackend", circuitID)
+}
+
+// QueryVerifierFingerprint returns the fingerprint of the constraint system the verifier of
+// circuitID was set up for.
+func (c *ZKClient) QueryVerifierFingerprint(circuitID string) (string, error) {
+	return c.c.evaluateString("QueryVerifierFingerprint", circuitID)
+}
+
+// CommitNoChange commits stateRoot, which must be the current root, for a block without state changes.
+func (c *ZKClient) CommitNoChange(blockNumber uint64, stateRoot string) error {
+	_, err := c.c.submit("CommitNoChange", formatBlock(blockNumber), stateRoot)
+	return err
+}
+
+// CommitProof commits newRoot for a block with a proof of the transition from oldRoot, verified
+// with the verifier of circuitID.
+func (c *ZKClient) CommitProof(blockNumber uint64, circuitID, oldRoot, newRoot, proof string) error {
+	_, err := c.c.submit("CommitProof", formatBlock(blockNumber), circuitID, oldRoot, newRoot, proof)
+	return err
+}
+
+// CommitProofChain commits the last root of chain for a block whose transitions needed several proofs.
+func (c *ZKClient) CommitProofChain(blockNumber uint64, chain ProofChain) error {
+	_, err := c.c.submit("CommitProofChain", formatBlock(blockNumber), marshalStrings(chain.Roots), marshalStrings(chain.Proofs), marshalStrings(chain.CircuitIDs))
+	return err
+}
+
+// CommitAggregatedProof commits the last root of proof.Roots for a block whose batch proofs were
+// aggregated into one.
+func (c *ZKClient) CommitAggregatedProof(blockNumber uint64, proof AggregatedProof) error {
+	_, err := c.c.submit("CommitAggregatedProof", formatBlock(blockNumber), proof.CircuitID, marshalStrings(proof.Roots), proof.Proof)
+	return err
+}
+
+// QueryStateRoot returns the state root committed for a block.
+func (c *ZKClient) QueryStateRoot(blockNumber uint64) (string, error) {
+	return c.c.evaluateString("QueryStateRoot", formatBlock(blockNumber))
+}
+
+// QueryProof returns the proof committed for a block by CommitProof.
+func (c *ZKClient) QueryProof(blockNumber uint64) (string, error) {
+	return c.c.evaluateString("QueryProof", formatBlock(blockNumber))
+}
+
+// QueryProofCircuitID returns the circuit ID the proof committed for a block by CommitProof was
+// verified under.
+func (c *ZKClient) QueryProofCircuitID(blockNumber uint64) (string, error) {
+	return c.c.evaluateString("QueryProofCircuitId", formatBlock(blockNumber))
+}
+
+// QueryProofChain returns the proof chain committed for a block by CommitProofChain.
+func (c *ZKClient) QueryProofChain(blockNumber uint64) (*ProofChain, error) {
+	var chain ProofChain
+	if err := c.c.evaluateJSON(&chain, "QueryProofChain", formatBlock(blockNumber)); err != nil {
+		return nil, err
+	}
+	return &chain, nil
+}
+
+// QueryAggregatedProof returns the aggregated proof committed for a block by CommitAggregatedProof.
+func (c *ZKClient) QueryAggregatedProof(blockNumber uint64) (*AggregatedProof, error) {
+	var proof AggregatedProof
+	if err := c.c.evaluateJSON(&proof, "QueryAggregatedProof", formatBlock(blockNumber)); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// QueryAllStateRoots returns every committed state root, in block order.
+func (c *ZKClient) QueryAllStateRoots() ([]StateRoot, error) {
+	var roots []StateRoot
+	err := c.c.evaluateJSON(&roots, "QueryAllStateRoots")
+	return roots, err
+}
+
+// Currency returns a client of the CurrencyContract of g's chaincode.
+func (g *Gateway) Currency() *CurrencyClient { return NewCurrencyClient(g) }
+
+// Plasma returns a client of the PlasmaContract of g's chaincode.
+func (g *Gateway) Plasma() *PlasmaClient { return NewPlasmaClient(g) }
+
+// ZK returns a client of the ZKContract of g's chaincode.
+func (g *Gateway) ZK() *ZKClient { return NewZKClient(g) }
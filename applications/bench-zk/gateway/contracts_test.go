@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc/codes"
+)
+
+// chaincodeMetadata is the metadata of chaincodes/wrappers, regenerated by its TestMetadata.
+const chaincodeMetadata = "../../../chaincodes/wrappers/metadata.json"
+
+var specs = []ContractSpec{CurrencySpec, PlasmaSpec, ZKSpec}
+
+func TestCheckMetadata(t *testing.T) {
+	metadata, err := os.ReadFile(chaincodeMetadata)
+	if err != nil {
+		t.Fatalf("Failed to read the chaincode metadata: %v", err)
+	}
+	if err := CheckMetadata(metadata, specs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every transaction of the chaincode has a typed client
+	var md contractMetadata
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		covered := map[string]bool{}
+		for _, tx := range spec.Transactions {
+			covered[tx.Name] = true
+		}
+		for _, tx := range md.Contracts[spec.Name].Transactions {
+			if !covered[tx.Name] {
+				t.Errorf("%s:%s has no typed client", spec.Name, tx.Name)
+			}
+		}
+	}
+
+	// The string signature bench-zk used to call ExchangeInGameCurrency with is rejected
+	stale := ContractSpec{CurrencyContractName, []TxSpec{{"ExchangeInGameCurrency", []string{"string", "string", "string"}, ""}}}
+	if err := CheckMetadata(metadata, stale); err == nil || !strings.Contains(err.Error(), "ExchangeInGameCurrency takes (integer, integer, string)") {
+		t.Errorf("Expected a signature mismatch, got %v", err)
+	}
+	if err := CheckMetadata(metadata, ContractSpec{Name: "TokenContract"}); err == nil {
+		t.Error("CheckMetadata accepted a contract that is not deployed")
+	}
+}
+
+// recorder is an Invoker recording the transactions called and the number of their arguments.
+type recorder map[string]int
+
+func (r recorder) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	r[name] = len(args)
+	return nil, nil
+}
+
+func (r recorder) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	r[name] = len(args)
+	return nil, nil
+}
+
+// TestClientsMatchSpecs calls every method of the typed clients and checks that the transactions
+// they call are those of the specs, with as many arguments.
+func TestClientsMatchSpecs(t *testing.T) {
+	calls := recorder{}
+	for _, c := range []interface{}{NewCurrencyClient(calls), NewPlasmaClient(calls), NewZKClient(calls)} {
+		v := reflect.ValueOf(c)
+		for i := 0; i < v.NumMethod(); i++ {
+			m := v.Method(i)
+			args := make([]reflect.Value, m.Type().NumIn())
+			for k := range args {
+				args[k] = reflect.Zero(m.Type().In(k))
+			}
+			m.Call(args)
+		}
+	}
+
+	want := recorder{}
+	for _, spec := range specs {
+		for _, tx := range spec.Transactions {
+			want[spec.Name+":"+tx.Name] = len(tx.Params)
+		}
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Clients call %v, specs declare %v", calls, want)
+	}
+}
+
+func TestContractErrors(t *testing.T) {
+	certPEM, keyPEM := testCredentials(t)
+	gw, err := NewGateway(startFakePeer(t, "peer1", certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("NewGateway failed: %v", err)
+	}
+	defer gw.Close()
+
+	// The fake peer fails every endorsement with Unavailable
+	err = gw.Currency().CreatePlayer(4, "", "")
+	var contractErr *ContractError
+	if !errors.As(err, &contractErr) || !errors.Is(err, ErrEndorse) {
+		t.Fatalf("Expected an endorsement ContractError, got %v", err)
+	}
+	if contractErr.Transaction != "CurrencyContract:CreatePlayer" || contractErr.TransactionID == "" || contractErr.Code != codes.Unavailable || !contractErr.Retryable() {
+		t.Errorf("Unexpected error: %+v", contractErr)
+	}
+	var endorseErr *client.EndorseError
+	if !errors.As(err, &endorseErr) {
+		t.Error("ContractError does not unwrap to the client's EndorseError")
+	}
+
+	// ... and answers every evaluation with its name, which is not a JSON boolean
+	if _, err := gw.Currency().PlayerExists(4); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected a decode error, got %v", err)
+	}
+	if root, err := gw.ZK().QueryStateRoot(2); err != nil || root != "peer1" {
+		t.Errorf("QueryStateRoot = %q, %v", root, err)
+	}
+}
@@ -0,0 +1,141 @@
+// gateway/errors.go
+
+package gateway
+
+// Classification of the errors returned by the fabric-gateway client, so that callers can tell
+// a transaction the chaincode rejected from one that may still commit without switching on the
+// client's error types and digging the peers' messages out of the gRPC status themselves.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	gatewaypb "github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of ContractError, matched with errors.Is.
+var (
+	ErrEndorse      = errors.New("endorsement failed")               // Rejected by the chaincode or the endorsing peers; nothing was ordered
+	ErrSubmit       = errors.New("submission to the orderer failed") // Endorsed, but not accepted by the orderer
+	ErrCommitStatus = errors.New("commit status unknown")            // Ordered, but the outcome could not be obtained; it may still commit
+	ErrCommit       = errors.New("transaction invalidated")          // Committed to a block as invalid, e.g. on an MVCC read conflict
+	ErrEvaluate     = errors.New("evaluation failed")                // Query rejected by the chaincode or the peer
+	ErrDecode       = errors.New("unexpected result")                // The transaction succeeded but its result could not be decoded
+)
+
+// ContractError is a failed call of a contract transaction. It unwraps to both its Kind and the
+// error of the fabric-gateway client, so errors.Is(err, ErrEndorse) and
+// errors.As(err, &endorseErr) both work.
+type ContractError struct {
+	Kind           error                 // ErrEndorse, ErrSubmit, ErrCommitStatus, ErrCommit, ErrEvaluate or ErrDecode
+	Transaction    string                // Qualified name, e.g. "CurrencyContract:CreatePlayer"
+	TransactionID  string                // Empty for evaluations
+	Code           codes.Code            // gRPC status of the call; OK for ErrCommit and ErrDecode
+	ValidationCode peer.TxValidationCode // Why the transaction was invalidated, for ErrCommit
+	Details        []string              // Messages of the peers and orderers involved, e.g. the chaincode's error
+	Err            error
+}
+
+func (e *ContractError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %v", e.Transaction, e.Kind)
+	switch {
+	case e.Kind == ErrCommit:
+		fmt.Fprintf(&b, " (%s)", e.ValidationCode)
+	case e.Code != codes.OK:
+		fmt.Fprintf(&b, " (%s)", e.Code)
+	}
+	if len(e.Details) > 0 {
+		b.WriteString(": " + strings.Join(e.Details, "; "))
+	} else if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *ContractError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Retryable reports whether submitting the transaction again is safe and may succeed: it was
+// never ordered and failed for a reason other than the chaincode rejecting it, or it was
+// invalidated by a conflicting transaction of the same block.
+func (e *ContractError) Retryable() bool {
+	switch e.Kind {
+	case ErrEndorse, ErrEvaluate:
+		return e.Code == codes.Unavailable || e.Code == codes.DeadlineExceeded || e.Code == codes.ResourceExhausted
+	case ErrCommit:
+		return e.ValidationCode == peer.TxValidationCode_MVCC_READ_CONFLICT || e.ValidationCode == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a query the chaincode rejected because what it reads is not
+// on the ledger, which the contracts of chaincodes/wrappers report as "<what> not found".
+func IsNotFound(err error) bool {
+	var e *ContractError
+	return errors.As(err, &e) && e.Kind == ErrEvaluate && !e.Retryable() && strings.Contains(e.Error(), "not found")
+}
+
+// classify wraps an error returned by the fabric-gateway client for transaction into a
+// ContractError. Errors of other origins, e.g. a Pool with every member down, keep their gRPC
+// status and are classified as failed endorsements or evaluations, since nothing was ordered.
+func classify(transaction string, evaluate bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	// Invalid commits reported by Gateway.SubmitTransaction are classified already
+	var classified *ContractError
+	if errors.As(err, &classified) {
+		return err
+	}
+	e := &ContractError{Transaction: transaction, Code: status.Code(err), Err: err}
+
+	var (
+		endorseErr      *client.EndorseError
+		submitErr       *client.SubmitError
+		commitStatusErr *client.CommitStatusError
+		commitErr       *client.CommitError
+	)
+	switch {
+	case errors.As(err, &endorseErr):
+		e.Kind, e.TransactionID = ErrEndorse, endorseErr.TransactionID
+	case errors.As(err, &submitErr):
+		e.Kind, e.TransactionID = ErrSubmit, submitErr.TransactionID
+	case errors.As(err, &commitStatusErr):
+		e.Kind, e.TransactionID = ErrCommitStatus, commitStatusErr.TransactionID
+		if errors.Is(err, context.DeadlineExceeded) {
+			e.Code = codes.DeadlineExceeded
+		}
+	case errors.As(err, &commitErr):
+		e.Kind, e.TransactionID = ErrCommit, commitErr.TransactionID
+		e.Code, e.ValidationCode = codes.OK, commitErr.Code
+	case evaluate:
+		e.Kind = ErrEvaluate
+	default:
+		e.Kind = ErrEndorse
+	}
+
+	// Errors that originate from a peer or orderer behind the gateway carry their messages as
+	// details of the gRPC status
+	for _, detail := range status.Convert(err).Details() {
+		if d, ok := detail.(*gatewaypb.ErrorDetail); ok {
+			e.Details = append(e.Details, fmt.Sprintf("%s (%s): %s", d.Address, d.MspId, d.Message))
+		}
+	}
+	return e
+}
+
+// decodeError reports a result of transaction that does not have the expected form.
+func decodeError(transaction string, err error) error {
+	return &ContractError{Kind: ErrDecode, Transaction: transaction, Err: err}
+}
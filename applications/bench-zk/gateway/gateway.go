@@ -1,28 +1,34 @@
 package gateway
 
 import (
-	"bytes"
-	"context"
 	"crypto/x509"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/status"
 )
 
 type Player struct {
 	ID         int64 `json:"id"`         // ID is the player's unique identifier.
 	Balance    int64 `json:"balance"`    // Balance tracks the BEN currency (3 decimal places)
 	UsdBalance int64 `json:"usdBalance"` // UsdBalance tracks USD available for exchange
+
+	// PubKey is the EdDSA public key registered by the player (see rollup.EncodePublicKey),
+	// empty for players created without one.
+	PubKey string `json:"pubKey,omitempty"`
+	// Nonce counts the BEN changes the player signed since the ZK rollup was enabled; the next
+	// change is signed with it.
+	Nonce int64 `json:"nonce,omitempty"`
+	// Signature is the player's signature of its last signed BEN change (see
+	// rollup.EncodeSignature), which the operator hands to the rollup circuits.
+	Signature string `json:"signature,omitempty"`
 }
 
 // Gateway encapsulates all the resources needed to interact with the Fabric network.
@@ -33,24 +39,47 @@ type Gateway struct {
 	Contract         *client.Contract
 	ChaincodeName    string
 	ChannelName      string
+	Metrics          *Metrics // Sink of the timings of the transactions submitted through g, if set
 }
 
+// Chain holds everything needed to connect to one Fabric channel as one client identity.
+// The tags let it be filled directly from the operator's YAML/JSON configuration file.
+// Instead of the individual fields, the peer can come from a connection profile (Profile, Peer)
+// and the identity from a wallet (Wallet, Identity); see Resolve.
 type Chain struct {
-	MspID         string
-	CryptoPath    string
-	CertPath      string
-	KeyPath       string
-	TLSCertPath   string
-	PeerEndpoint  string
-	GatewayPeer   string
-	ChannelName   string
-	ChaincodeName string
+	MspID         string `yaml:"mspId" json:"mspId"`
+	CryptoPath    string `yaml:"cryptoPath" json:"cryptoPath"`
+	CertPath      string `yaml:"certPath" json:"certPath"`
+	KeyPath       string `yaml:"keyPath" json:"keyPath"` // Private key file, or keystore directory holding exactly one key
+	TLSCertPath   string `yaml:"tlsCertPath" json:"tlsCertPath"`
+	PeerEndpoint  string `yaml:"peerEndpoint" json:"peerEndpoint"`
+	GatewayPeer   string `yaml:"gatewayPeer" json:"gatewayPeer"`
+	ChannelName   string `yaml:"channelName" json:"channelName"`
+	ChaincodeName string `yaml:"chaincodeName" json:"chaincodeName"`
+
+	Profile  string `yaml:"profile" json:"profile"`   // Connection profile providing mspId, peerEndpoint, gatewayPeer and the TLS certificate
+	Peer     string `yaml:"peer" json:"peer"`         // Peer of the profile to connect to, the first peer of the client organization by default
+	Wallet   string `yaml:"wallet" json:"wallet"`     // Wallet directory providing mspId, the certificate and the private key
+	Identity string `yaml:"identity" json:"identity"` // Label of the wallet identity, optional if the wallet holds only one
+
+	// PEM contents filled by Resolve from the profile and the wallet, used instead of the paths
+	TLSCertPEM string `yaml:"-" json:"-"`
+	CertPEM    string `yaml:"-" json:"-"`
+	KeyPEM     string `yaml:"-" json:"-"`
 }
 
 // NewGateway initializes a new Gateway instance, similar to what your main() function was doing.
 func NewGateway(chain Chain) (*Gateway, error) {
+	chain, err := chain.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
 	// 1. Setup gRPC connection
-	clientConnection := newGrpcConnection(chain)
+	clientConnection, err := newGrpcConnection(chain)
+	if err != nil {
+		return nil, err
+	}
 
 	// 2. Create Identity and Sign using the Chain struct
 	id, sign, err := newIdentityAndSign(chain)
@@ -100,161 +129,63 @@ func (g *Gateway) Close() {
 	}
 }
 
-// InitLedger invokes the "InitLedger" transaction.
-func (g *Gateway) InitLedger() error {
-	fmt.Printf("\n--> Submit Transaction: InitLedger\n")
-
-	_, err := g.Contract.SubmitTransaction("InitLedger")
-	if err != nil {
-		// errorHandling(g.contract, err) // Or define a similar function
-		return fmt.Errorf("failed to submit InitLedger transaction: %w", err)
-	}
-
-	fmt.Println("*** Transaction committed successfully")
-	return nil
-}
-
-// GetAllPlayers queries the ledger for all players.
-func (g *Gateway) GetAllPlayers() (string, error) {
-	fmt.Println("\n--> Evaluate Transaction: GetAllPlayers")
-
-	evaluateResult, err := g.Contract.EvaluateTransaction("GetAllPlayers")
-	if err != nil {
-		return "", fmt.Errorf("failed to evaluate GetAllPlayers: %w", err)
+// SubmitTransaction submits a transaction and waits for its commit like
+// client.Contract.SubmitTransaction. With Metrics set it goes through SubmitAsync, so that the
+// time of each step is recorded; a transaction committed as invalid then fails with a
+// ContractError of kind ErrCommit rather than a client.CommitError.
+func (g *Gateway) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	if g.Metrics == nil {
+		return g.Contract.SubmitTransaction(name, args...)
 	}
-
-	result := formatJSON(evaluateResult)
-	fmt.Printf("*** Records: %s\n", result)
-	return result, nil
-}
-
-// GetPlayersNum queries the ledger and prints the number of players.
-func (g *Gateway) GetPlayersNum() (int, error) {
-	fmt.Println("\n--> Evaluate Transaction: GetAllPlayers to get number of players")
-
-	evaluateResult, err := g.Contract.EvaluateTransaction("GetAllPlayers")
+	pending, err := g.SubmitAsync(name, args...)
 	if err != nil {
-		return 0, fmt.Errorf("failed to evaluate transaction: %w", err)
+		return nil, err
 	}
-
-	var players []*Player
-	if err := json.Unmarshal(evaluateResult, &players); err != nil {
-		return 0, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	if err := pending.Wait(); err != nil {
+		return nil, err
 	}
-
-	fmt.Printf("*** Number of Records: %d\n", len(players))
-	return len(players), nil
+	return pending.Result, nil
 }
 
-// CreatePlayer submits a transaction to create a new player with a given ID.
-func (g *Gateway) CreatePlayer(playerID string) error {
-	fmt.Printf("\n--> Submit Transaction: CreatePlayer\n")
-
-	_, err := g.Contract.SubmitTransaction("CreatePlayer", playerID)
-	if err != nil {
-		return fmt.Errorf("failed to submit CreatePlayer transaction: %w", err)
-	}
-
-	fmt.Println("*** Transaction committed successfully")
-	return nil
+// EvaluateTransaction evaluates a transaction like client.Contract.EvaluateTransaction.
+func (g *Gateway) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return g.Contract.EvaluateTransaction(name, args...)
 }
 
-// RecordBankTransaction records a new bank transaction on the ledger.
-func (g *Gateway) RecordBankTransaction(userID, amountUSDStr, transactionID string) error {
-	fmt.Printf("\n--> Submit Transaction: RecordBankTransaction\n")
-
-	_, err := g.Contract.SubmitTransaction("RecordBankTransaction", userID, amountUSDStr, transactionID)
-	if err != nil {
-		return fmt.Errorf("failed to submit RecordBankTransaction: %w", err)
-	}
-
-	fmt.Println("*** Transaction committed successfully")
-	return nil
-}
-
-// ExchangeInGameCurrency lets users exchange deposited USD to in-game currency.
-func (g *Gateway) ExchangeInGameCurrency(userID, transactionID, exchangeRateStr string) error {
-	fmt.Printf("\n--> Submit Transaction: ExchangeInGameCurrency\n")
-
-	_, err := g.Contract.SubmitTransaction("ExchangeInGameCurrency", userID, transactionID, exchangeRateStr)
-	if err != nil {
-		return fmt.Errorf("failed to submit ExchangeInGameCurrency: %w", err)
-	}
-
-	fmt.Println("*** Transaction committed successfully")
-	return nil
+// SubmitAsync submits a transaction without waiting for its commit, recording its timing in
+// g.Metrics; see the package function SubmitAsync.
+func (g *Gateway) SubmitAsync(name string, args ...string) (*Pending, error) {
+	return SubmitAsync(g.Contract, g.Metrics, name, args...)
 }
 
 // -------------------------------------------------------------
 // Helper functions below
 // -------------------------------------------------------------
 // newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection(chain Chain) *grpc.ClientConn {
-	certificate, err := loadCertificate(chain.TLSCertPath)
+func newGrpcConnection(chain Chain) (*grpc.ClientConn, error) {
+	if chain.PeerEndpoint == "" {
+		return nil, errors.New("no peer endpoint configured")
+	}
+	certificate, err := loadCertificate(chain.TLSCertPath, chain.TLSCertPEM)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to load TLS certificate of %s: %w", chain.PeerEndpoint, err)
 	}
 
 	certPool := x509.NewCertPool()
 	certPool.AddCert(certificate)
 	transportCredentials := credentials.NewClientTLSFromCert(certPool, chain.GatewayPeer)
 
-	connection, err := grpc.Dial(chain.PeerEndpoint, grpc.WithTransportCredentials(transportCredentials))
+	connection, err := grpc.NewClient(chain.PeerEndpoint, grpc.WithTransportCredentials(transportCredentials))
 	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+		return nil, fmt.Errorf("failed to create gRPC connection to %s: %w", chain.PeerEndpoint, err)
 	}
 
-	return connection
-}
-
-// Format JSON data
-func formatJSON(data []byte) string {
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
-		panic(fmt.Errorf("failed to parse JSON: %w", err))
-	}
-	return prettyJSON.String()
-}
-
-// Submit transaction, passing in the wrong number of arguments ,expected to throw an error containing details of any error responses from the smart contract.
-func errorHandling(err error) {
-	switch err := err.(type) {
-	case *client.EndorseError:
-		fmt.Printf("Endorse error for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-	case *client.SubmitError:
-		fmt.Printf("Submit error for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-	case *client.CommitStatusError:
-		if errors.Is(err, context.DeadlineExceeded) {
-			fmt.Printf("Timeout waiting for transaction %s commit status: %s", err.TransactionID, err)
-		} else {
-			fmt.Printf("Error obtaining commit status for transaction %s with gRPC status %v: %s\n", err.TransactionID, status.Code(err), err)
-		}
-	case *client.CommitError:
-		fmt.Printf("Transaction %s failed to commit with status %d: %s\n", err.TransactionID, int32(err.Code), err)
-	default:
-		panic(fmt.Errorf("unexpected error type %T: %w", err, err))
-	}
-
-	// Any error that originates from a peer or orderer node external to the gateway will have its details
-	// embedded within the gRPC status error. The following code shows how to extract that.
-	statusErr := status.Convert(err)
-
-	details := statusErr.Details()
-	if len(details) > 0 {
-		fmt.Println("Error Details:")
-
-		for _, detail := range details {
-			switch detail := detail.(type) {
-			case *gateway.ErrorDetail:
-				fmt.Printf("- address: %s, mspId: %s, message: %s\n", detail.Address, detail.MspId, detail.Message)
-			}
-		}
-	}
+	return connection, nil
 }
 
 // newIdentityAndSign creates both identity and signature using the provided Chain configuration.
 func newIdentityAndSign(chain Chain) (*identity.X509Identity, identity.Sign, error) {
-	certificate, err := loadCertificate(chain.CertPath)
+	certificate, err := loadCertificate(chain.CertPath, chain.CertPEM)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to load certificate: %w", err)
 	}
@@ -264,9 +195,12 @@ func newIdentityAndSign(chain Chain) (*identity.X509Identity, identity.Sign, err
 		return nil, nil, fmt.Errorf("failed to create identity: %w", err)
 	}
 
-	privateKeyPEM, err := loadPrivateKey(chain.KeyPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load private key: %w", err)
+	privateKeyPEM := []byte(chain.KeyPEM)
+	if chain.KeyPEM == "" {
+		privateKeyPEM, err = loadPrivateKey(chain.KeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load private key: %w", err)
+		}
 	}
 
 	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
@@ -282,14 +216,41 @@ func newIdentityAndSign(chain Chain) (*identity.X509Identity, identity.Sign, err
 	return id, sign, nil
 }
 
-// loadPrivateKey reads the private key from the specified directory.
+// loadPrivateKey reads the private key at keyPath, either the key file itself or a keystore
+// directory holding exactly one file. A directory holding several keys is rejected rather than
+// guessing, since signing with the wrong key only fails later at endorsement.
 func loadPrivateKey(keyPath string) ([]byte, error) {
-	files, err := os.ReadDir(keyPath)
+	if keyPath == "" {
+		return nil, errors.New("no private key configured")
+	}
+	info, err := os.Stat(keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read private key directory: %w", err)
+		return nil, fmt.Errorf("failed to read private key: %w", err)
 	}
 
-	privateKeyPEM, err := os.ReadFile(path.Join(keyPath, files[0].Name()))
+	keyFile := keyPath
+	if info.IsDir() {
+		entries, err := os.ReadDir(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key directory: %w", err)
+		}
+		var files []string
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, e.Name())
+			}
+		}
+		switch len(files) {
+		case 0:
+			return nil, fmt.Errorf("private key directory %s is empty", keyPath)
+		case 1:
+			keyFile = path.Join(keyPath, files[0])
+		default:
+			return nil, fmt.Errorf("private key directory %s holds %d files (%s); set keyPath to the key file", keyPath, len(files), strings.Join(files, ", "))
+		}
+	}
+
+	privateKeyPEM, err := os.ReadFile(keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
@@ -297,10 +258,17 @@ func loadPrivateKey(keyPath string) ([]byte, error) {
 	return privateKeyPEM, nil
 }
 
-func loadCertificate(filename string) (*x509.Certificate, error) {
-	certificatePEM, err := os.ReadFile(filename)
+// loadCertificate parses certificatePEM, or the certificate file at filename if it is empty.
+func loadCertificate(filename, certificatePEM string) (*x509.Certificate, error) {
+	if certificatePEM != "" {
+		return identity.CertificateFromPEM([]byte(certificatePEM))
+	}
+	if filename == "" {
+		return nil, errors.New("no certificate configured")
+	}
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read certificate file: %w", err)
 	}
-	return identity.CertificateFromPEM(certificatePEM)
+	return identity.CertificateFromPEM(data)
 }
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -29,86 +30,106 @@ func TestMain(m *testing.M) {
 	var err error
 	gw, err = NewGateway(chainConfig)
 	if err != nil {
-		return
+		// Without the network only the offline tests run
+		fmt.Println("Skipping network tests:", err)
+	} else {
+		defer gw.Close()
 	}
-	defer gw.Close()
 
 	// Run the tests
 	m.Run()
 }
 
+// requireNetwork skips t unless TestMain connected to the network.
+func requireNetwork(t *testing.T) {
+	t.Helper()
+	if gw == nil {
+		t.Skip("network not available")
+	}
+}
+
 func TestInit(t *testing.T) {
-	if err := gw.InitLedger(); err != nil {
+	requireNetwork(t)
+	currency := gw.Currency()
+	if err := currency.InitLedger(); err != nil {
 		t.Fatalf("InitLedger failed: %v\n", err)
 	}
 
-	allPlayers, err := gw.GetAllPlayers()
+	allPlayers, err := currency.GetAllPlayers()
 	if err != nil {
 		t.Fatalf("GetAllPlayers failed: %v\n", err)
 	}
-	fmt.Println("All Players:", allPlayers)
+	fmt.Printf("All Players: %+v\n", allPlayers)
 }
 
 func TestGetPlayersNum(t *testing.T) {
+	requireNetwork(t)
+	currency := gw.Currency()
 	// Create 5 players
-	for i := 4; i <= 8; i++ {
-		playerID := fmt.Sprintf("player%d", i)
-		if err := gw.CreatePlayer(playerID); err != nil {
-			t.Fatalf("CreatePlayer failed for %s: %v\n", playerID, err)
+	for id := int64(4); id <= 8; id++ {
+		if err := currency.CreatePlayer(id, "", ""); err != nil {
+			t.Fatalf("CreatePlayer failed for %d: %v\n", id, err)
 		}
 	}
-	// Test GetPlayersNum
-	num, err := gw.GetPlayersNum()
+	// Count the players
+	players, err := currency.GetAllPlayers()
 	if err != nil {
-		t.Fatalf("GetPlayersNum failed: %v\n", err)
+		t.Fatalf("GetAllPlayers failed: %v\n", err)
 	}
-	fmt.Printf("Number of players: %d\n", num)
+	fmt.Printf("Number of players: %d\n", len(players))
 
 	// Expected number of players
 	expectedNum := 8
-	if num != expectedNum {
-		t.Errorf("Expected %d players, got %d", expectedNum, num)
+	if len(players) != expectedNum {
+		t.Errorf("Expected %d players, got %d", expectedNum, len(players))
+	}
+
+	// Creating a player twice is rejected by the chaincode, before ordering
+	if err := currency.CreatePlayer(4, "", ""); !errors.Is(err, ErrEndorse) {
+		t.Errorf("Expected an endorsement error for an existing player, got %v", err)
 	}
 }
 
 func TestRecordBankTransaction(t *testing.T) {
-	userID := "player4"
-	amountUSDStr := "1000"
-	transactionID := "txn001"
-
-	// Call RecordBankTransaction
-	err := gw.RecordBankTransaction(userID, amountUSDStr, transactionID)
+	requireNetwork(t)
+	currency := gw.Currency()
+	before, err := currency.GetPlayer(4)
 	if err != nil {
+		t.Fatalf("GetPlayer failed: %v\n", err)
+	}
+
+	// Deposit 1.000 USD
+	if err := currency.RecordBankTransaction(4, 1000, 1); err != nil {
 		t.Fatalf("RecordBankTransaction failed: %v\n", err)
 	}
 
-	// Optionally, you can verify if the transaction was correctly recorded in the ledger.
-	// This might involve querying the ledger to check if the transaction exists, depending on the functionality.
-	fmt.Println("Bank transaction recorded successfully.")
-	allPlayers, err := gw.GetAllPlayers()
+	after, err := currency.GetPlayer(4)
 	if err != nil {
-		t.Fatalf("GetAllPlayers failed: %v\n", err)
+		t.Fatalf("GetPlayer failed: %v\n", err)
+	}
+	if after.UsdBalance != before.UsdBalance+1000 {
+		t.Errorf("Expected USD balance %d, got %d", before.UsdBalance+1000, after.UsdBalance)
 	}
-	fmt.Println("All Players:", allPlayers)
 }
 
 func TestExchangeInGameCurrency(t *testing.T) {
-	userID := "player4"
-	transactionID := "txn001"
-	exchangeRateStr := "0.313" // Example exchange rate for USD to in-game currency
-
-	// Call ExchangeInGameCurrency
-	err := gw.ExchangeInGameCurrency(userID, transactionID, exchangeRateStr)
+	requireNetwork(t)
+	currency := gw.Currency()
+	before, err := currency.GetPlayer(4)
 	if err != nil {
+		t.Fatalf("GetPlayer failed: %v\n", err)
+	}
+
+	// Buy 0.313 BEN with the deposited USD
+	if err := currency.ExchangeInGameCurrency(4, 313, ""); err != nil {
 		t.Fatalf("ExchangeInGameCurrency failed: %v\n", err)
 	}
 
-	// Optionally, you can verify if the currency exchange was correctly processed.
-	// This might involve checking if the user's in-game currency balance has been updated, for example.
-	fmt.Println("In-game currency exchange completed successfully.")
-	allPlayers, err := gw.GetAllPlayers()
+	after, err := currency.GetPlayer(4)
 	if err != nil {
-		t.Fatalf("GetAllPlayers failed: %v\n", err)
+		t.Fatalf("GetPlayer failed: %v\n", err)
+	}
+	if after.Balance != before.Balance+313 {
+		t.Errorf("Expected BEN balance %d, got %d", before.Balance+313, after.Balance)
 	}
-	fmt.Println("All Players:", allPlayers)
 }
@@ -0,0 +1,236 @@
+// gateway/metadata.go
+
+package gateway
+
+// Signatures of the typed clients, checked against the metadata contractapi publishes for the
+// deployed chaincode (org.hyperledger.fabric:GetMetadata), so that a client built against another
+// version of chaincodes/wrappers fails at startup rather than on its first transaction.
+// chaincodes/wrappers/metadata.json holds the metadata of the chaincode in this repository.
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// GetMetadataTransaction is the system transaction of contractapi returning the contract metadata.
+const GetMetadataTransaction = "org.hyperledger.fabric:GetMetadata"
+
+// TxSpec is the signature of a contract transaction in the terms of the contract metadata.
+// Parameter and return types are "integer", "string", "boolean", the name of a component schema
+// such as "Player", or "[]" followed by one of those; Returns is empty for no result.
+type TxSpec struct {
+	Name    string
+	Params  []string
+	Returns string
+}
+
+// ContractSpec is the contract a typed client calls.
+type ContractSpec struct {
+	Name         string
+	Transactions []TxSpec
+}
+
+// Specs of the typed clients.
+var (
+	CurrencySpec = ContractSpec{CurrencyContractName, []TxSpec{
+		{"InitLedger", nil, ""},
+		{"CreatePlayer", []string{"integer", "string", "string"}, ""},
+		{"EnableRollup", nil, ""},
+		{"RollupEnabled", nil, "boolean"},
+		{"PlayerExists", []string{"integer"}, "boolean"},
+		{"GetPlayer", []string{"integer"}, "Player"},
+		{"GetAllPlayers", nil, "[]Player"},
+		{"RecordBankTransaction", []string{"integer", "integer", "integer"}, ""},
+		{"ExchangeInGameCurrency", []string{"integer", "integer", "string"}, ""},
+		{"SetExchangeRate", []string{"integer"}, ""},
+		{"Transfer", []string{"integer", "integer", "integer", "string"}, ""},
+	}}
+	PlasmaSpec = ContractSpec{PlasmaContractName, []TxSpec{
+		{"InitLedger", nil, ""},
+		{"CommitMerkleRoot", []string{"string", "string"}, ""},
+		{"QueryMerkleRoot", []string{"string"}, "string"},
+		{"QueryAllMerkleRoots", nil, "string"},
+	}}
+	ZKSpec = ContractSpec{ZKContractName, []TxSpec{
+		{"InitLedger", []string{"string", "string", "string", "string", "string"}, ""},
+		{"RegisterVerifier", []string{"string", "string", "string", "string"}, ""},
+		{"QueryCircuitId", nil, "string"},
+		{"QueryVerifierBackend", []string{"string"}, "string"},
+		{"QueryVerifierFingerprint", []string{"string"}, "string"},
+		{"CommitNoChange", []string{"string", "string"}, ""},
+		{"CommitProof", []string{"string", "string", "string", "string", "string"}, ""},
+		{"CommitProofChain", []string{"string", "string", "string", "string"}, ""},
+		{"CommitAggregatedProof", []string{"string", "string", "string", "string"}, ""},
+		{"QueryStateRoot", []string{"string"}, "string"},
+		{"QueryProof", []string{"string"}, "string"},
+		{"QueryProofCircuitId", []string{"string"}, "string"},
+		{"QueryProofChain", []string{"string"}, "string"},
+		{"QueryAggregatedProof", []string{"string"}, "string"},
+		{"QueryAllStateRoots", nil, "string"},
+	}}
+)
+
+// schemaTypes are the Go types the clients decode component schemas into. Their JSON fields must
+// be the properties of the schema.
+var schemaTypes = map[string]reflect.Type{
+	"Player": reflect.TypeOf(Player{}),
+}
+
+// metadataSchema is the subset of JSON schema contractapi uses to describe values.
+type metadataSchema struct {
+	Type       string                    `json:"type"`
+	Ref        string                    `json:"$ref"`
+	Items      *metadataSchema           `json:"items"`
+	Properties map[string]metadataSchema `json:"properties"`
+}
+
+// String returns the type in the notation of TxSpec.
+func (s *metadataSchema) String() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Ref != "":
+		return path.Base(s.Ref)
+	case s.Type == "array":
+		return "[]" + s.Items.String()
+	}
+	return s.Type
+}
+
+// contractMetadata is the subset of the contract metadata CheckMetadata needs.
+type contractMetadata struct {
+	Contracts map[string]struct {
+		Transactions []struct {
+			Name       string `json:"name"`
+			Parameters []struct {
+				Schema metadataSchema `json:"schema"`
+			} `json:"parameters"`
+			Returns *metadataSchema `json:"returns"`
+		} `json:"transactions"`
+	} `json:"contracts"`
+	Components struct {
+		Schemas map[string]metadataSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// FetchMetadata returns the contract metadata of the chaincode behind inv.
+func FetchMetadata(inv Invoker) ([]byte, error) {
+	metadata, err := inv.EvaluateTransaction(GetMetadataTransaction)
+	if err != nil {
+		return nil, classify(GetMetadataTransaction, true, err)
+	}
+	return metadata, nil
+}
+
+// CheckContracts fetches the contract metadata of the chaincode behind inv and checks specs against it.
+func CheckContracts(inv Invoker, specs ...ContractSpec) error {
+	metadata, err := FetchMetadata(inv)
+	if err != nil {
+		return err
+	}
+	return CheckMetadata(metadata, specs...)
+}
+
+// CheckMetadata checks that every transaction of specs exists in the contract metadata with the
+// same parameter and return types, and that the component schemas they use have the fields the
+// clients decode. Transactions of the chaincode the specs do not use are ignored.
+func CheckMetadata(metadata []byte, specs ...ContractSpec) error {
+	var md contractMetadata
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		return fmt.Errorf("failed to parse contract metadata: %w", err)
+	}
+
+	var problems []string
+	schemas := map[string]bool{}
+	for _, spec := range specs {
+		contract, ok := md.Contracts[spec.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("contract %s is not deployed", spec.Name))
+			continue
+		}
+		for _, want := range spec.Transactions {
+			name := spec.Name + ":" + want.Name
+			found := false
+			for _, tx := range contract.Transactions {
+				if tx.Name != want.Name {
+					continue
+				}
+				found = true
+				params := make([]string, len(tx.Parameters))
+				for i, p := range tx.Parameters {
+					params[i] = p.Schema.String()
+				}
+				if got := strings.Join(params, ", "); got != strings.Join(want.Params, ", ") {
+					problems = append(problems, fmt.Sprintf("%s takes (%s), the client passes (%s)", name, got, strings.Join(want.Params, ", ")))
+				}
+				if got := tx.Returns.String(); got != want.Returns {
+					problems = append(problems, fmt.Sprintf("%s returns %q, the client expects %q", name, got, want.Returns))
+				}
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s is not a transaction of the chaincode", name))
+			}
+			if _, ok := schemaTypes[strings.TrimPrefix(want.Returns, "[]")]; ok {
+				schemas[strings.TrimPrefix(want.Returns, "[]")] = true
+			}
+		}
+	}
+
+	for name := range schemas {
+		schema, ok := md.Components.Schemas[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("schema %s is not in the contract metadata", name))
+			continue
+		}
+		if got, want := schemaFields(schema), jsonFields(schemaTypes[name]); got != want {
+			problems = append(problems, fmt.Sprintf("schema %s has fields (%s), the client decodes (%s)", name, got, want))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("contract metadata does not match the client:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// schemaFields lists the properties of a component schema as "name type", sorted.
+func schemaFields(s metadataSchema) string {
+	var fields []string
+	for name, p := range s.Properties {
+		fields = append(fields, name+" "+p.String())
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
+
+// jsonFields lists the JSON fields of struct type t as "name type" in the notation of TxSpec, sorted.
+func jsonFields(t reflect.Type) string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		var typ string
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			typ = "integer"
+		case reflect.Bool:
+			typ = "boolean"
+		case reflect.String:
+			typ = "string"
+		default:
+			typ = f.Type.String()
+		}
+		fields = append(fields, name+" "+typ)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
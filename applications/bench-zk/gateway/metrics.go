@@ -0,0 +1,310 @@
+// gateway/metrics.go
+
+package gateway
+
+// In-memory latency histograms of submitted transactions, broken down by the steps of the
+// transaction flow, so that benchmark reports can tell endorsement, ordering and commit time
+// apart instead of only giving end-to-end numbers. They can be exported as JSON or CSV.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stages of a submitted transaction, as timed by SubmitAsync.
+const (
+	StageEndorse = "endorse" // From creating the proposal until the endorsed transaction is returned
+	StageSubmit  = "submit"  // From sending the endorsed transaction until the orderer accepted it
+	StageCommit  = "commit"  // From acceptance by the orderer until the commit status is received
+	StageTotal   = "total"   // From creating the proposal until the commit status is received
+)
+
+// stages lists the stages in the order they are reported.
+var stages = []string{StageEndorse, StageSubmit, StageCommit, StageTotal}
+
+// Buckets of a Histogram: bucket i holds the durations up to histogramBase * 2^(i/4), so
+// neighbouring bounds are 19% apart and the last one is about 88 seconds. Longer durations go
+// to an overflow bucket.
+const (
+	histogramBase    = 100 * time.Microsecond
+	histogramBuckets = 80
+)
+
+// bucketBound returns the upper bound of bucket i.
+func bucketBound(i int) time.Duration {
+	return time.Duration(float64(histogramBase) * math.Exp2(float64(i)/4))
+}
+
+// bucketIndex returns the bucket d falls in, histogramBuckets for the overflow bucket.
+func bucketIndex(d time.Duration) int {
+	if d <= histogramBase {
+		return 0
+	}
+	i := int(math.Ceil(4 * math.Log2(float64(d)/float64(histogramBase))))
+	if i > histogramBuckets {
+		return histogramBuckets
+	}
+	return i
+}
+
+// Histogram is a log-scale histogram of durations with exact count, sum, min and max. It is
+// not safe for concurrent use; Metrics serializes access to its histograms.
+type Histogram struct {
+	counts [histogramBuckets + 1]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// Observe adds d to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+	h.counts[bucketIndex(d)]++
+}
+
+// Count returns the number of durations observed.
+func (h *Histogram) Count() uint64 { return h.count }
+
+// Mean returns the mean of the durations observed.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Quantile returns an upper estimate of the q-quantile (0 <= q <= 1) of the durations observed:
+// the upper bound of the bucket holding it, within [min, max].
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i == histogramBuckets {
+				return h.max
+			}
+			return min(max(bucketBound(i), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Bucket is one non-empty bucket of a Histogram.
+type Bucket struct {
+	UpperBoundMs float64 `json:"upperBoundMs"` // 0 for the overflow bucket
+	Count        uint64  `json:"count"`
+}
+
+// buckets returns the non-empty buckets of h.
+func (h *Histogram) buckets() []Bucket {
+	var buckets []Bucket
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		b := Bucket{Count: c}
+		if i < histogramBuckets {
+			b.UpperBoundMs = ms(bucketBound(i))
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// LatencySummary summarizes the latency of one stage of one transaction, in milliseconds.
+type LatencySummary struct {
+	Transaction string   `json:"transaction"`
+	Stage       string   `json:"stage"`
+	Count       uint64   `json:"count"`  // Transactions that completed the stage
+	Errors      uint64   `json:"errors"` // Transactions that failed in the stage
+	MinMs       float64  `json:"minMs"`
+	MeanMs      float64  `json:"meanMs"`
+	P50Ms       float64  `json:"p50Ms"`
+	P90Ms       float64  `json:"p90Ms"`
+	P99Ms       float64  `json:"p99Ms"`
+	MaxMs       float64  `json:"maxMs"`
+	Buckets     []Bucket `json:"buckets,omitempty"`
+}
+
+// seriesKey identifies the histogram of one stage of one transaction.
+type seriesKey struct {
+	transaction string
+	stage       string
+}
+
+// series is the histogram of one stage of one transaction and the failures in that stage.
+type series struct {
+	hist   Histogram
+	errors uint64
+}
+
+// Metrics is a sink of transaction timings, safe for concurrent use. A nil *Metrics discards
+// what it is given.
+type Metrics struct {
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+// NewMetrics returns an empty sink.
+func NewMetrics() *Metrics {
+	return &Metrics{series: map[seriesKey]*series{}}
+}
+
+// Record adds the duration of every stage t completed to the histograms of its transaction, and
+// counts the stage it failed in, if any.
+func (m *Metrics) Record(t *TxTiming) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stage := range stages {
+		if d, ok := t.Duration(stage); ok {
+			m.get(t.Transaction, stage).hist.Observe(d)
+		}
+	}
+	if t.Err != nil {
+		m.get(t.Transaction, t.FailedStage()).errors++
+		m.get(t.Transaction, StageTotal).errors++
+	}
+}
+
+// get returns the series of stage of transaction, creating it if needed. m.mu must be held.
+func (m *Metrics) get(transaction, stage string) *series {
+	key := seriesKey{transaction, stage}
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Summaries returns the latency of every stage of every transaction recorded, by transaction
+// name and then in the order of the transaction flow.
+func (m *Metrics) Summaries() []LatencySummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order := map[string]int{}
+	for i, stage := range stages {
+		order[stage] = i
+	}
+	keys := make([]seriesKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].transaction != keys[j].transaction {
+			return keys[i].transaction < keys[j].transaction
+		}
+		return order[keys[i].stage] < order[keys[j].stage]
+	})
+
+	summaries := make([]LatencySummary, len(keys))
+	for i, key := range keys {
+		s := m.series[key]
+		summaries[i] = LatencySummary{
+			Transaction: key.transaction,
+			Stage:       key.stage,
+			Count:       s.hist.Count(),
+			Errors:      s.errors,
+			MinMs:       ms(s.hist.min),
+			MeanMs:      ms(s.hist.Mean()),
+			P50Ms:       ms(s.hist.Quantile(0.5)),
+			P90Ms:       ms(s.hist.Quantile(0.9)),
+			P99Ms:       ms(s.hist.Quantile(0.99)),
+			MaxMs:       ms(s.hist.max),
+			Buckets:     s.hist.buckets(),
+		}
+	}
+	return summaries
+}
+
+// WriteJSON writes the summaries, with their histogram buckets, as a JSON array.
+func (m *Metrics) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m.Summaries()); err != nil {
+		return fmt.Errorf("failed to write latency metrics: %w", err)
+	}
+	return nil
+}
+
+// csvHeader is the first row written by WriteCSV.
+var csvHeader = []string{"transaction", "stage", "count", "errors", "min_ms", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms"}
+
+// WriteCSV writes the summaries as CSV, one row per stage of each transaction, without the
+// histogram buckets.
+func (m *Metrics) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("failed to write latency metrics: %w", err)
+	}
+	for _, s := range m.Summaries() {
+		record := []string{
+			s.Transaction,
+			s.Stage,
+			strconv.FormatUint(s.Count, 10),
+			strconv.FormatUint(s.Errors, 10),
+		}
+		for _, v := range []float64{s.MinMs, s.MeanMs, s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs} {
+			record = append(record, strconv.FormatFloat(v, 'f', 3, 64))
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write latency metrics: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write latency metrics: %w", err)
+	}
+	return nil
+}
+
+// WriteFile writes the summaries to path, as CSV if it ends in ".csv" and as JSON otherwise.
+func (m *Metrics) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create latency metrics file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = m.WriteCSV(f)
+	} else {
+		err = m.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close latency metrics file: %w", cerr)
+	}
+	return err
+}
+
+// ms converts d to milliseconds with microsecond precision.
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,155 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Error("Empty histogram has non-zero statistics")
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if h.Count() != 1000 || h.min != time.Millisecond || h.max != time.Second {
+		t.Errorf("Count %d, min %v, max %v", h.Count(), h.min, h.max)
+	}
+	if h.Mean() != 500500*time.Microsecond {
+		t.Errorf("Mean = %v", h.Mean())
+	}
+	// Quantiles are bucket bounds, at most one bucket width above the exact value
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 1} {
+		exact := time.Duration(q*1000) * time.Millisecond
+		if got := h.Quantile(q); got < exact || float64(got) > float64(exact)*1.19 {
+			t.Errorf("Quantile(%v) = %v, exact %v", q, got, exact)
+		}
+	}
+
+	// Durations beyond the last bucket are kept exactly as the maximum
+	h.Observe(5 * time.Minute)
+	if got := h.Quantile(1); got != 5*time.Minute {
+		t.Errorf("Quantile(1) = %v after an overflow", got)
+	}
+	if b := h.buckets(); b[len(b)-1] != (Bucket{0, 1}) {
+		t.Errorf("Last bucket = %+v, want the overflow bucket", b[len(b)-1])
+	}
+}
+
+// timing returns the timing of a transaction whose stages took the given durations; a negative
+// duration stops it before that stage.
+func timing(name string, endorse, submit, commit time.Duration, err error) *TxTiming {
+	start := time.Now()
+	t := &TxTiming{Transaction: name, Start: start, Err: err}
+	if endorse < 0 {
+		return t
+	}
+	t.Endorsed = start.Add(endorse)
+	if submit < 0 {
+		return t
+	}
+	t.Submitted = t.Endorsed.Add(submit)
+	if commit < 0 {
+		return t
+	}
+	t.Committed = t.Submitted.Add(commit)
+	return t
+}
+
+func TestMetrics(t *testing.T) {
+	var nilMetrics *Metrics
+	nilMetrics.Record(timing("Tx", 1, 1, 1, nil)) // Discarded
+
+	m := NewMetrics()
+	m.Record(timing("ZKContract:CommitProof", 10*time.Millisecond, 2*time.Millisecond, 2*time.Second, nil))
+	m.Record(timing("ZKContract:CommitProof", 30*time.Millisecond, 4*time.Millisecond, 2*time.Second,
+		&ContractError{Kind: ErrCommit, ValidationCode: peer.TxValidationCode_MVCC_READ_CONFLICT}))
+	m.Record(timing("ZKContract:CommitProof", -1, -1, -1, errors.New("endorsement failed")))
+	m.Record(timing("PlasmaContract:CommitMerkleRoot", 5*time.Millisecond, time.Millisecond, time.Second, nil))
+
+	type row struct {
+		tx, stage     string
+		count, errors uint64
+		minMs, maxMs  float64
+	}
+	var got []row
+	for _, s := range m.Summaries() {
+		got = append(got, row{s.Transaction, s.Stage, s.Count, s.Errors, s.MinMs, s.MaxMs})
+	}
+	want := []row{
+		{"PlasmaContract:CommitMerkleRoot", StageEndorse, 1, 0, 5, 5},
+		{"PlasmaContract:CommitMerkleRoot", StageSubmit, 1, 0, 1, 1},
+		{"PlasmaContract:CommitMerkleRoot", StageCommit, 1, 0, 1000, 1000},
+		{"PlasmaContract:CommitMerkleRoot", StageTotal, 1, 0, 1006, 1006},
+		{"ZKContract:CommitProof", StageEndorse, 2, 1, 10, 30},
+		{"ZKContract:CommitProof", StageSubmit, 2, 0, 2, 4},
+		{"ZKContract:CommitProof", StageCommit, 2, 1, 2000, 2000},
+		{"ZKContract:CommitProof", StageTotal, 2, 2, 2012, 2034},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summaries:\n got %v\nwant %v", got, want)
+	}
+
+	var csvOut bytes.Buffer
+	if err := m.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatalf("WriteCSV wrote invalid CSV: %v", err)
+	}
+	if len(records) != len(want)+1 || strings.Join(records[0], ",") != "transaction,stage,count,errors,min_ms,mean_ms,p50_ms,p90_ms,p99_ms,max_ms" {
+		t.Errorf("Unexpected CSV header or length: %v", records)
+	}
+	if strings.Join(records[3], ",") != "PlasmaContract:CommitMerkleRoot,commit,1,0,1000.000,1000.000,1000.000,1000.000,1000.000,1000.000" {
+		t.Errorf("Unexpected CSV row: %v", records[3])
+	}
+
+	var jsonOut bytes.Buffer
+	if err := m.WriteJSON(&jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []LatencySummary
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, m.Summaries()) {
+		t.Error("JSON does not round-trip to the summaries")
+	}
+}
+
+func TestSubmitAsyncMetrics(t *testing.T) {
+	certPEM, keyPEM := testCredentials(t)
+	gw, err := NewGateway(startFakePeer(t, "peer1", certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("NewGateway failed: %v", err)
+	}
+	defer gw.Close()
+	gw.Metrics = NewMetrics()
+
+	// The fake peer fails every endorsement, which is classified as before and recorded
+	if _, err := gw.SubmitAsync("CurrencyContract:CreatePlayer", "4"); err == nil {
+		t.Fatal("SubmitAsync succeeded against the fake peer")
+	}
+	if err := gw.Currency().CreatePlayer(4, "", ""); !errors.Is(err, ErrEndorse) {
+		t.Fatalf("Expected an endorsement error, got %v", err)
+	}
+	summaries := gw.Metrics.Summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("Expected the endorse and total series, got %+v", summaries)
+	}
+	for _, s := range summaries {
+		if s.Transaction != "CurrencyContract:CreatePlayer" || s.Count != 0 || s.Errors != 2 {
+			t.Errorf("Unexpected summary %+v", s)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// gateway/offline.go
+
+package gateway
+
+// The offline signing flow of fabric-gateway: the client builds each proposal, transaction and
+// commit status request, has its digest signed by a Signer, and sends the signed message. One
+// gRPC connection then serves any number of identities, which is how benchmarks emulate many
+// distinct users, and the keys can stay in a signing device.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+)
+
+// offline signs the steps of a transaction with signer, through the gateway of its identity.
+// A nil *offline leaves them to the fabric-gateway client, which signs inline.
+type offline struct {
+	gw     *client.Gateway
+	signer Signer
+}
+
+func (o *offline) signProposal(proposal *client.Proposal) (*client.Proposal, error) {
+	if o == nil {
+		return proposal, nil
+	}
+	bytes, err := proposal.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize proposal: %w", err)
+	}
+	signature, err := o.signer.Sign(proposal.Digest())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign proposal: %w", err)
+	}
+	return o.gw.NewSignedProposal(bytes, signature)
+}
+
+func (o *offline) signTransaction(transaction *client.Transaction) (*client.Transaction, error) {
+	if o == nil {
+		return transaction, nil
+	}
+	bytes, err := transaction.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+	signature, err := o.signer.Sign(transaction.Digest())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return o.gw.NewSignedTransaction(bytes, signature)
+}
+
+func (o *offline) signCommit(commit *client.Commit) (*client.Commit, error) {
+	if o == nil {
+		return commit, nil
+	}
+	bytes, err := commit.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize commit status request: %w", err)
+	}
+	signature, err := o.signer.Sign(commit.Digest())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign commit status request: %w", err)
+	}
+	return o.gw.NewSignedCommit(bytes, signature)
+}
+
+// signError reports that a step of the transaction of t could not be signed; kind is the step
+// that did not run.
+func signError(kind error, t *TxTiming, err error) error {
+	return &ContractError{Kind: kind, Transaction: t.Transaction, TransactionID: t.TransactionID, Err: err}
+}
+
+// OfflineClient submits and evaluates transactions of one chaincode on behalf of any number of
+// identities, whose signatures come from the Signer of each call.
+type OfflineClient struct {
+	Metrics *Metrics // Sink of the timings of the transactions submitted, if set
+
+	conn      *grpc.ClientConn
+	channel   string
+	chaincode string
+
+	mu       sync.Mutex
+	gateways map[string]*client.Gateway // By MSP ID and credentials of the identity
+}
+
+// NewOfflineClient connects to the gateway peer of chain. The identity fields of chain are not
+// used; every call names its signer.
+func NewOfflineClient(chain Chain) (*OfflineClient, error) {
+	chain, err := chain.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newGrpcConnection(chain)
+	if err != nil {
+		return nil, err
+	}
+	return &OfflineClient{
+		conn:      conn,
+		channel:   chain.ChannelName,
+		chaincode: chain.ChaincodeName,
+		gateways:  map[string]*client.Gateway{},
+	}, nil
+}
+
+// contract returns the contract and offline signing of signer, connecting its identity on first use.
+func (o *OfflineClient) contract(signer Signer) (*client.Contract, *offline, error) {
+	id := signer.Identity()
+	key := id.MspID() + "\x00" + string(id.Credentials())
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	gw, ok := o.gateways[key]
+	if !ok {
+		var err error
+		gw, err = client.Connect(
+			id,
+			client.WithClientConnection(o.conn),
+			client.WithEvaluateTimeout(1*time.Minute),
+			client.WithEndorseTimeout(1*time.Minute),
+			client.WithSubmitTimeout(1*time.Minute),
+			client.WithCommitStatusTimeout(1*time.Minute),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to gateway: %w", err)
+		}
+		o.gateways[key] = gw
+	}
+	return gw.GetNetwork(o.channel).GetContract(o.chaincode), &offline{gw, signer}, nil
+}
+
+// SubmitAsync submits transaction name as signer and returns once the orderer accepted it; see
+// the package function SubmitAsync. Errors are those of the fabric-gateway client, or a
+// ContractError if a step could not be signed.
+func (o *OfflineClient) SubmitAsync(signer Signer, name string, args ...string) (*Pending, error) {
+	contract, off, err := o.contract(signer)
+	if err != nil {
+		return nil, err
+	}
+	return submitAsync(contract, off, o.Metrics, name, args)
+}
+
+// SubmitTransaction submits transaction name as signer and waits for its commit.
+func (o *OfflineClient) SubmitTransaction(signer Signer, name string, args ...string) ([]byte, error) {
+	pending, err := o.SubmitAsync(signer, name, args...)
+	if err != nil {
+		return nil, classify(name, false, err)
+	}
+	if err := pending.Wait(); err != nil {
+		return nil, classify(name, false, err)
+	}
+	return pending.Result, nil
+}
+
+// EvaluateTransaction evaluates transaction name as signer.
+func (o *OfflineClient) EvaluateTransaction(signer Signer, name string, args ...string) ([]byte, error) {
+	contract, off, err := o.contract(signer)
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := contract.NewProposal(name, client.WithArguments(args...))
+	if err != nil {
+		return nil, classify(name, true, err)
+	}
+	if proposal, err = off.signProposal(proposal); err != nil {
+		return nil, &ContractError{Kind: ErrEvaluate, Transaction: name, Err: err}
+	}
+	result, err := proposal.Evaluate()
+	if err != nil {
+		return nil, classify(name, true, err)
+	}
+	return result, nil
+}
+
+// As returns an Invoker calling as signer, to use the typed clients on behalf of one identity,
+// e.g. NewCurrencyClient(o.As(pool.Next())).
+func (o *OfflineClient) As(signer Signer) Invoker {
+	return &signedInvoker{o, signer}
+}
+
+// signedInvoker is an Invoker calling an OfflineClient as one signer.
+type signedInvoker struct {
+	client *OfflineClient
+	signer Signer
+}
+
+func (s *signedInvoker) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return s.client.SubmitTransaction(s.signer, name, args...)
+}
+
+func (s *signedInvoker) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return s.client.EvaluateTransaction(s.signer, name, args...)
+}
+
+// Call is one transaction of a batch, submitted on behalf of Signer.
+type Call struct {
+	Signer Signer
+	Name   string // Qualified name, e.g. "CurrencyContract:CreatePlayer"
+	Args   []string
+}
+
+// CallResult is the outcome of a Call.
+type CallResult struct {
+	Result []byte
+	Timing TxTiming // Set once the orderer accepted the transaction; earlier failures are only in Metrics
+	Err    error    // A ContractError, or the error of the context if the call was never started
+}
+
+// SubmitBatch submits calls keeping up to inFlight of them between proposal and commit, and
+// returns their outcomes in the order of calls. No call is started once ctx is done; those
+// already started are still waited for.
+func (o *OfflineClient) SubmitBatch(ctx context.Context, calls []Call, inFlight int) []CallResult {
+	if inFlight < 1 {
+		inFlight = 1
+	}
+	results := make([]CallResult, len(calls))
+	slots := make(chan struct{}, inFlight)
+	var wg sync.WaitGroup
+
+	for i, call := range calls {
+		// A free slot must not win over a done context
+		if ctx.Err() == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			for j := i; j < len(calls); j++ {
+				results[j].Err = ctx.Err()
+			}
+			break
+		}
+		wg.Add(1)
+		go func(res *CallResult, call Call) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			pending, err := o.SubmitAsync(call.Signer, call.Name, call.Args...)
+			if err != nil {
+				res.Err = classify(call.Name, false, err)
+				return
+			}
+			err = pending.Wait()
+			res.Result, res.Timing, res.Err = pending.Result, pending.Timing, classify(call.Name, false, err)
+		}(&results[i], call)
+	}
+	wg.Wait()
+	return results
+}
+
+// Close releases the gateways of every identity and the connection.
+func (o *OfflineClient) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, gw := range o.gateways {
+		gw.Close()
+	}
+	o.gateways = map[string]*client.Gateway{}
+	o.conn.Close()
+}
@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// testOrg writes the CA of a cryptogen organization org01.chains to a temporary directory and
+// returns the organization directory.
+func testOrg(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Country: []string{"US"}, CommonName: "ca.org01.chains"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orgDir := t.TempDir()
+	caDir := path.Join(orgDir, "ca")
+	if err := os.Mkdir(caDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(caDir, "ca.org01.chains-cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(caDir, "priv_sk"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return orgDir
+}
+
+// certificateOf returns the certificate of an X.509 signer.
+func certificateOf(t *testing.T, s Signer) *x509.Certificate {
+	t.Helper()
+	cert, err := identity.CertificateFromPEM(s.Identity().Credentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIssueIdentityPool(t *testing.T) {
+	orgDir := testOrg(t)
+	pool, err := IssueIdentityPool("org01MSP", orgDir, 3)
+	if err != nil {
+		t.Fatalf("IssueIdentityPool failed: %v", err)
+	}
+	caCert, _, err := loadCA(path.Join(orgDir, "ca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	for i, want := range []string{"user1@org01.chains", "user2@org01.chains", "user3@org01.chains"} {
+		s := pool.Next()
+		cert := certificateOf(t, s)
+		if s.Identity().MspID() != "org01MSP" || cert.Subject.CommonName != want || len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "client" {
+			t.Errorf("Identity %d: %s %v", i, s.Identity().MspID(), cert.Subject)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("Identity %d is not issued by the CA: %v", i, err)
+		}
+	}
+	if pool.Next() != pool.Get(0) || pool.Get(4) != pool.Get(1) || pool.Len() != 3 {
+		t.Error("Pool does not hand out its identities in turn")
+	}
+
+	if _, err := IssueIdentityPool("org01MSP", t.TempDir(), 1); err == nil {
+		t.Error("IssueIdentityPool accepted a directory without a CA")
+	}
+	if _, err := NewIdentityPool(); err == nil {
+		t.Error("NewIdentityPool accepted no signers")
+	}
+}
+
+func TestOfflineSigning(t *testing.T) {
+	certPEM, keyPEM := testCredentials(t)
+	fake := &fakePeer{name: "peer1"}
+	oc, err := NewOfflineClient(serveFakePeer(t, fake, certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("NewOfflineClient failed: %v", err)
+	}
+	defer oc.Close()
+	pool, err := IssueIdentityPool("org01MSP", testOrg(t), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsm := NewHSMSigner(pool.Get(1), 1, time.Millisecond)
+
+	// The proposal is signed by the HSM with the key of the second user
+	result, err := oc.EvaluateTransaction(hsm, "CurrencyContract:PlayerExists", "4")
+	if err != nil || string(result) != "peer1" {
+		t.Fatalf("EvaluateTransaction = %q, %v", result, err)
+	}
+	proposal := fake.last.Load()
+	digest := sha256.Sum256(proposal.ProposalBytes)
+	if !ecdsa.VerifyASN1(certificateOf(t, pool.Get(1)).PublicKey.(*ecdsa.PublicKey), digest[:], proposal.Signature) {
+		t.Error("Proposal is not signed by the identity of the call")
+	}
+	if hsm.Signatures() != 1 {
+		t.Errorf("HSM made %d signatures, expected 1", hsm.Signatures())
+	}
+
+	// Submissions fail at the fake peer's endorsement, after signing the proposal
+	err = NewCurrencyClient(oc.As(hsm)).CreatePlayer(4, "", "")
+	var contractErr *ContractError
+	if !errors.As(err, &contractErr) || !errors.Is(err, ErrEndorse) || !contractErr.Retryable() {
+		t.Errorf("Expected a retryable endorsement error, got %v", err)
+	}
+	if hsm.Signatures() != 2 {
+		t.Errorf("HSM made %d signatures, expected 2", hsm.Signatures())
+	}
+}
+
+func TestSubmitBatch(t *testing.T) {
+	certPEM, keyPEM := testCredentials(t)
+	fake := &fakePeer{name: "peer1", delay: 20 * time.Millisecond}
+	oc, err := NewOfflineClient(serveFakePeer(t, fake, certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("NewOfflineClient failed: %v", err)
+	}
+	defer oc.Close()
+	oc.Metrics = NewMetrics()
+	pool, err := IssueIdentityPool("org01MSP", testOrg(t), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make([]Call, 12)
+	for i := range calls {
+		calls[i] = Call{pool.Next(), "CurrencyContract:CreatePlayer", []string{formatInt(int64(i)), ""}}
+	}
+	results := oc.SubmitBatch(context.Background(), calls, 3)
+	for i, res := range results {
+		if !errors.Is(res.Err, ErrEndorse) {
+			t.Errorf("Call %d: expected an endorsement error, got %v", i, res.Err)
+		}
+	}
+	if n := fake.maxInFlight.Load(); n < 2 || n > 3 {
+		t.Errorf("%d proposals in flight at once, expected up to 3", n)
+	}
+	if s := oc.Metrics.Summaries(); len(s) == 0 || s[0].Stage != StageEndorse || s[0].Errors != 12 {
+		t.Errorf("Unexpected metrics %+v", s)
+	}
+
+	// Nothing is started once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, res := range oc.SubmitBatch(ctx, calls, 3) {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("Call %d: expected the context error, got %v", i, res.Err)
+		}
+	}
+}
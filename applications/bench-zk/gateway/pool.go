@@ -0,0 +1,296 @@
+// gateway/pool.go
+
+package gateway
+
+// A pool of gateway connections to the same channel and chaincode through several peers, possibly
+// of several organizations, so that one peer going down does not stop the client and the load of
+// a benchmark is not funnelled through a single gateway peer.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultHealthInterval is how often a Pool checks the connections of its members.
+const DefaultHealthInterval = 5 * time.Second
+
+// Invoker submits and evaluates transactions of one chaincode. It is implemented by
+// *client.Contract, *Gateway and *Pool, so callers can use any of them.
+type Invoker interface {
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
+var (
+	_ Invoker = (*client.Contract)(nil)
+	_ Invoker = (*Gateway)(nil)
+	_ Invoker = (*Pool)(nil)
+)
+
+// Policy selects the member of a Pool that handles a call.
+type Policy int
+
+const (
+	RoundRobin  Policy = iota // Members in turn
+	LeastLoaded               // Member with the fewest calls in flight, in turn among ties
+)
+
+// ParsePolicy parses "round-robin" or "least-loaded". The empty string is RoundRobin.
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "round-robin":
+		return RoundRobin, nil
+	case "least-loaded":
+		return LeastLoaded, nil
+	default:
+		return 0, fmt.Errorf("unknown pool policy %q, want round-robin or least-loaded", s)
+	}
+}
+
+// String returns the name ParsePolicy accepts.
+func (p Policy) String() string {
+	if p == LeastLoaded {
+		return "least-loaded"
+	}
+	return "round-robin"
+}
+
+// Pool spreads the transactions of one chaincode over the gateways of several peers.
+//
+// A member whose peer answers with gRPC status Unavailable is marked unhealthy and the call is
+// retried on the next member; the background health check marks it healthy again once its
+// connection is back. Evaluations are always retried. Submissions are only retried when
+// endorsement failed, since a transaction that reached the orderer may still commit, and
+// submitting it again would apply it twice.
+type Pool struct {
+	ChannelName   string
+	ChaincodeName string
+
+	members []*poolMember
+	policy  Policy
+	next    atomic.Uint64 // Round-robin position
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// poolMember is one gateway of a Pool with its load and health.
+type poolMember struct {
+	gw       *Gateway
+	endpoint string
+	inFlight atomic.Int64
+	healthy  atomic.Bool
+	calls    atomic.Uint64
+	failures atomic.Uint64
+}
+
+// MemberStats is a snapshot of the load and health of one member of a Pool.
+type MemberStats struct {
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"inFlight"`
+	Calls    uint64 `json:"calls"`
+	Failures uint64 `json:"failures"` // Calls that failed with Unavailable
+}
+
+// NewPool connects to every chain, which must all name the same channel and chaincode, and
+// checks the health of the connections every healthInterval (DefaultHealthInterval if zero).
+func NewPool(chains []Chain, policy Policy, healthInterval time.Duration) (*Pool, error) {
+	if len(chains) == 0 {
+		return nil, errors.New("gateway pool needs at least one chain")
+	}
+	for _, c := range chains[1:] {
+		if c.ChannelName != chains[0].ChannelName || c.ChaincodeName != chains[0].ChaincodeName {
+			return nil, fmt.Errorf("gateway pool mixes %s/%s and %s/%s", chains[0].ChannelName, chains[0].ChaincodeName, c.ChannelName, c.ChaincodeName)
+		}
+	}
+
+	gateways := make([]*Gateway, 0, len(chains))
+	for i, c := range chains {
+		gw, err := NewGateway(c)
+		if err != nil {
+			for _, g := range gateways {
+				g.Close()
+			}
+			return nil, fmt.Errorf("failed to connect pool member %d (%s): %w", i, c.PeerEndpoint, err)
+		}
+		gateways = append(gateways, gw)
+	}
+	return newPool(gateways, policy, healthInterval), nil
+}
+
+// newPool builds a pool over connected gateways and starts its health check.
+func newPool(gateways []*Gateway, policy Policy, healthInterval time.Duration) *Pool {
+	if healthInterval <= 0 {
+		healthInterval = DefaultHealthInterval
+	}
+	p := &Pool{
+		ChannelName:   gateways[0].ChannelName,
+		ChaincodeName: gateways[0].ChaincodeName,
+		policy:        policy,
+		stop:          make(chan struct{}),
+	}
+	for _, gw := range gateways {
+		m := &poolMember{gw: gw, endpoint: gw.ClientConnection.Target()}
+		m.healthy.Store(true)
+		p.members = append(p.members, m)
+	}
+
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkHealth()
+			}
+		}
+	}()
+	return p
+}
+
+// Primary returns the gateway of the first chain, e.g. to follow block events.
+func (p *Pool) Primary() *Gateway {
+	return p.members[0].gw
+}
+
+// SubmitTransaction submits a transaction through one member, retrying on the others while
+// endorsement fails with Unavailable.
+func (p *Pool) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return p.call(func(c Invoker) ([]byte, error) {
+		return c.SubmitTransaction(name, args...)
+	}, func(err error) bool {
+		var endorseErr *client.EndorseError
+		return errors.As(err, &endorseErr)
+	})
+}
+
+// EvaluateTransaction evaluates a transaction through one member, retrying on the others while
+// it fails with Unavailable.
+func (p *Pool) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return p.call(func(c Invoker) ([]byte, error) {
+		return c.EvaluateTransaction(name, args...)
+	}, func(error) bool { return true })
+}
+
+// call runs fn on members chosen by the policy until it succeeds, fails with another status
+// than Unavailable, fails in a way safe reports as not retryable, or every member was tried.
+func (p *Pool) call(fn func(Invoker) ([]byte, error), safe func(error) bool) ([]byte, error) {
+	tried := make([]bool, len(p.members))
+	var failed []string
+	for {
+		m := p.pick(tried)
+		m.inFlight.Add(1)
+		m.calls.Add(1)
+		result, err := fn(m.gw)
+		m.inFlight.Add(-1)
+		if err == nil || status.Code(err) != codes.Unavailable {
+			return result, err
+		}
+
+		m.failures.Add(1)
+		m.healthy.Store(false)
+		if !safe(err) {
+			return nil, err
+		}
+		failed = append(failed, m.endpoint)
+		if len(failed) == len(p.members) {
+			return nil, fmt.Errorf("all %d gateway peers unavailable (%s): %w", len(p.members), strings.Join(failed, ", "), err)
+		}
+	}
+}
+
+// SetMetrics makes every member record the timings of the transactions it submits in m. It
+// must be called before the pool is used.
+func (p *Pool) SetMetrics(m *Metrics) {
+	for _, member := range p.members {
+		member.gw.Metrics = m
+	}
+}
+
+// pick returns the member the policy selects among those not tried yet, preferring healthy ones.
+// The rotation runs over the candidates rather than over all members, so the turns of an
+// unhealthy member are spread over the others instead of all going to its successor.
+func (p *Pool) pick(tried []bool) *poolMember {
+	var candidates []int
+	for _, wantHealthy := range []bool{true, false} {
+		for i, m := range p.members {
+			if !tried[i] && m.healthy.Load() == wantHealthy {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		panic("gateway pool: every member already tried")
+	}
+
+	start := int((p.next.Add(1) - 1) % uint64(len(candidates)))
+	best := candidates[start]
+	if p.policy == LeastLoaded {
+		for k := range candidates {
+			i := candidates[(start+k)%len(candidates)]
+			if p.members[i].inFlight.Load() < p.members[best].inFlight.Load() {
+				best = i
+			}
+		}
+	}
+	tried[best] = true
+	return p.members[best]
+}
+
+// checkHealth updates the health of every member from the state of its gRPC connection. An
+// idle connection is asked to reconnect, so that a member marked unhealthy comes back as soon
+// as its peer does.
+func (p *Pool) checkHealth() {
+	for _, m := range p.members {
+		conn := m.gw.ClientConnection
+		switch state := conn.GetState(); state {
+		case connectivity.Ready:
+			m.healthy.Store(true)
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			m.healthy.Store(false)
+		}
+	}
+}
+
+// Stats returns the load and health of every member, in the order of the chains.
+func (p *Pool) Stats() []MemberStats {
+	stats := make([]MemberStats, len(p.members))
+	for i, m := range p.members {
+		stats[i] = MemberStats{
+			Endpoint: m.endpoint,
+			Healthy:  m.healthy.Load(),
+			InFlight: m.inFlight.Load(),
+			Calls:    m.calls.Load(),
+			Failures: m.failures.Load(),
+		}
+	}
+	return stats
+}
+
+// Close stops the health check and closes every member.
+func (p *Pool) Close() {
+	close(p.stop)
+	p.done.Wait()
+	for _, m := range p.members {
+		m.gw.Close()
+	}
+}
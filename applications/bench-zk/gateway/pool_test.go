@@ -0,0 +1,220 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gatewaypb "github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// fakePeer is a gateway peer answering every evaluation with its name and failing every
+// endorsement with Unavailable, after delay. It keeps the last proposal it received and the
+// largest number of endorsements it handled at once.
+type fakePeer struct {
+	gatewaypb.UnimplementedGatewayServer
+	name  string
+	delay time.Duration
+
+	last        atomic.Pointer[peer.SignedProposal]
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (f *fakePeer) Evaluate(_ context.Context, req *gatewaypb.EvaluateRequest) (*gatewaypb.EvaluateResponse, error) {
+	f.last.Store(req.ProposedTransaction)
+	return &gatewaypb.EvaluateResponse{Result: &peer.Response{Status: 200, Payload: []byte(f.name)}}, nil
+}
+
+func (f *fakePeer) Endorse(_ context.Context, req *gatewaypb.EndorseRequest) (*gatewaypb.EndorseResponse, error) {
+	f.last.Store(req.ProposedTransaction)
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for max := f.maxInFlight.Load(); n > max && !f.maxInFlight.CompareAndSwap(max, n); max = f.maxInFlight.Load() {
+	}
+	time.Sleep(f.delay)
+	return nil, status.Error(codes.Unavailable, "no endorsers available")
+}
+
+// testCredentials returns a self-signed certificate for localhost and its key, in PEM, used
+// both as the TLS certificate of the fake peers and as the client identity.
+func testCredentials(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+// startFakePeer serves a fakePeer on a local port and returns its chain.
+func startFakePeer(t *testing.T, name, certPEM, keyPEM string) Chain {
+	t.Helper()
+	return serveFakePeer(t, &fakePeer{name: name}, certPEM, keyPEM)
+}
+
+// serveFakePeer serves f on a local port and returns its chain.
+func serveFakePeer(t *testing.T, f *fakePeer, certPEM, keyPEM string) Chain {
+	t.Helper()
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	gatewaypb.RegisterGatewayServer(server, f)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return testChain(lis.Addr().String(), certPEM, keyPEM)
+}
+
+func testChain(endpoint, certPEM, keyPEM string) Chain {
+	return Chain{
+		MspID:         "org01MSP",
+		CertPEM:       certPEM,
+		KeyPEM:        keyPEM,
+		TLSCertPEM:    certPEM,
+		PeerEndpoint:  endpoint,
+		GatewayPeer:   "localhost",
+		ChannelName:   "chains",
+		ChaincodeName: "basic",
+	}
+}
+
+// downEndpoint returns a local address nothing listens on.
+func downEndpoint(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestPoolFailover(t *testing.T) {
+	certPEM, keyPEM := testCredentials(t)
+	chains := []Chain{
+		testChain(downEndpoint(t), certPEM, keyPEM),
+		startFakePeer(t, "peer1", certPEM, keyPEM),
+		startFakePeer(t, "peer2", certPEM, keyPEM),
+	}
+	pool, err := NewPool(chains, RoundRobin, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPool failed: %v", err)
+	}
+	defer pool.Close()
+
+	served := map[string]int{}
+	for i := 0; i < 6; i++ {
+		result, err := pool.EvaluateTransaction("GetAllPlayers")
+		if err != nil {
+			t.Fatalf("EvaluateTransaction %d failed: %v", i, err)
+		}
+		served[string(result)]++
+	}
+	if served["peer1"] != 3 || served["peer2"] != 3 {
+		t.Errorf("Load not spread over the live peers: %v", served)
+	}
+
+	stats := pool.Stats()
+	if stats[0].Healthy || stats[0].Failures != 1 {
+		t.Errorf("Down member not marked unhealthy after one failure: %+v", stats[0])
+	}
+	if !stats[1].Healthy || !stats[2].Healthy {
+		t.Errorf("Live members marked unhealthy: %+v", stats)
+	}
+
+	// Endorsement failing with Unavailable everywhere is retried on every member, then reported
+	_, err = pool.SubmitTransaction("CreatePlayer", "1")
+	if err == nil || !strings.Contains(err.Error(), "all 3 gateway peers unavailable") {
+		t.Errorf("Expected every member to be tried, got %v", err)
+	}
+}
+
+func TestPoolPick(t *testing.T) {
+	pool := &Pool{policy: LeastLoaded}
+	for i := 0; i < 3; i++ {
+		m := &poolMember{}
+		m.healthy.Store(true)
+		pool.members = append(pool.members, m)
+	}
+	pool.members[0].inFlight.Store(4)
+	pool.members[1].inFlight.Store(2)
+	pool.members[2].inFlight.Store(3)
+
+	tried := make([]bool, 3)
+	if m := pool.pick(tried); m != pool.members[1] {
+		t.Errorf("LeastLoaded did not pick the member with the fewest calls in flight")
+	}
+	if m := pool.pick(tried); m != pool.members[2] {
+		t.Errorf("LeastLoaded did not skip the member already tried")
+	}
+
+	// Unhealthy members are only picked when no healthy one is left
+	pool.policy = RoundRobin
+	pool.members[0].healthy.Store(false)
+	tried = make([]bool, 3)
+	var order []*poolMember
+	for range pool.members {
+		order = append(order, pool.pick(tried))
+	}
+	if order[2] != pool.members[0] {
+		t.Errorf("Unhealthy member picked before the healthy ones")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"", "round-robin", "least-loaded"} {
+		p, err := ParsePolicy(s)
+		if err != nil {
+			t.Errorf("ParsePolicy(%q) failed: %v", s, err)
+		}
+		if s != "" && p.String() != s {
+			t.Errorf("ParsePolicy(%q).String() = %s", s, p)
+		}
+	}
+	if _, err := ParsePolicy("random"); err == nil {
+		t.Error("ParsePolicy accepted an unknown policy")
+	}
+}
@@ -0,0 +1,319 @@
+// gateway/profile.go
+
+package gateway
+
+// Loading of Chain fields from the files Fabric tooling already produces: common connection
+// profiles (clients/caliper-zk/ccp-template.yaml) and wallet directories, plus environment
+// overrides so the same configuration file works on every machine.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a Fabric common connection profile, as written by clients/caliper-zk/ccp-generate.sh.
+// Only the parts needed to reach a gateway peer are decoded.
+type Profile struct {
+	Name   string `yaml:"name" json:"name"`
+	Client struct {
+		Organization string `yaml:"organization" json:"organization"`
+	} `yaml:"client" json:"client"`
+	Organizations map[string]ProfileOrg  `yaml:"organizations" json:"organizations"`
+	Peers         map[string]ProfilePeer `yaml:"peers" json:"peers"`
+
+	dir string // Directory of the profile file, against which tlsCACerts.path is resolved
+}
+
+// ProfileOrg is an organization of a connection profile.
+type ProfileOrg struct {
+	MSPID string   `yaml:"mspid" json:"mspid"`
+	Peers []string `yaml:"peers" json:"peers"`
+}
+
+// ProfilePeer is a peer of a connection profile.
+type ProfilePeer struct {
+	URL        string `yaml:"url" json:"url"` // grpcs://host:port
+	TLSCACerts struct {
+		Pem  string `yaml:"pem" json:"pem"`
+		Path string `yaml:"path" json:"path"`
+	} `yaml:"tlsCACerts" json:"tlsCACerts"`
+	GRPCOptions map[string]interface{} `yaml:"grpcOptions" json:"grpcOptions"`
+}
+
+// LoadProfile reads the connection profile at path. Files ending in ".json" are decoded as
+// JSON, anything else as YAML.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connection profile: %w", err)
+	}
+
+	var p Profile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &p)
+	} else {
+		err = yaml.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection profile %s: %w", path, err)
+	}
+	p.dir = filepath.Dir(path)
+	return &p, nil
+}
+
+// Chain returns the connection fields of the named peer: its endpoint, the host name its TLS
+// certificate was issued for, its TLS CA certificate and the MSP ID of the client organization.
+// An empty peer selects the first peer of the client organization. Channel, chaincode and
+// client identity are not part of a connection profile and are left empty.
+func (p *Profile) Chain(peer string) (Chain, error) {
+	orgName, org, err := p.clientOrg()
+	if err != nil {
+		return Chain{}, err
+	}
+	if peer == "" {
+		if len(org.Peers) == 0 {
+			return Chain{}, fmt.Errorf("organization %s of connection profile %s lists no peers", orgName, p.Name)
+		}
+		peer = org.Peers[0]
+	}
+	pp, ok := p.Peers[peer]
+	if !ok {
+		return Chain{}, fmt.Errorf("peer %s is not in connection profile %s", peer, p.Name)
+	}
+
+	endpoint, ok := strings.CutPrefix(pp.URL, "grpcs://")
+	if !ok || endpoint == "" {
+		return Chain{}, fmt.Errorf("peer %s of connection profile %s has url %q, want grpcs://host:port", peer, p.Name, pp.URL)
+	}
+
+	chain := Chain{MspID: org.MSPID, PeerEndpoint: endpoint, GatewayPeer: peer}
+	for _, option := range []string{"ssl-target-name-override", "hostnameOverride"} {
+		if name, ok := pp.GRPCOptions[option].(string); ok && name != "" {
+			chain.GatewayPeer = name
+			break
+		}
+	}
+	switch {
+	case pp.TLSCACerts.Pem != "":
+		chain.TLSCertPEM = pp.TLSCACerts.Pem
+	case pp.TLSCACerts.Path != "":
+		chain.TLSCertPath = resolvePath(p.dir, pp.TLSCACerts.Path)
+	default:
+		return Chain{}, fmt.Errorf("peer %s of connection profile %s has no tlsCACerts", peer, p.Name)
+	}
+	return chain, nil
+}
+
+// clientOrg returns the organization named by client.organization. The lookup ignores case,
+// since ccp-template.yaml names the client "org02" and the organization "Org02". A profile with
+// a single organization needs no client section.
+func (p *Profile) clientOrg() (string, ProfileOrg, error) {
+	if name := p.Client.Organization; name != "" {
+		for orgName, org := range p.Organizations {
+			if strings.EqualFold(orgName, name) {
+				return orgName, org, nil
+			}
+		}
+		return "", ProfileOrg{}, fmt.Errorf("client organization %s is not in connection profile %s", name, p.Name)
+	}
+	if len(p.Organizations) == 1 {
+		for orgName, org := range p.Organizations {
+			return orgName, org, nil
+		}
+	}
+	return "", ProfileOrg{}, fmt.Errorf("connection profile %s has %d organizations and no client.organization", p.Name, len(p.Organizations))
+}
+
+// WalletIdentity is an X.509 identity stored in a wallet directory, one "<label>.id" JSON file
+// per identity, the format used by the Fabric SDKs and Caliper.
+type WalletIdentity struct {
+	Credentials struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"privateKey"`
+	} `json:"credentials"`
+	MspID   string `json:"mspId"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+// walletSuffix is the file name suffix of wallet identities.
+const walletSuffix = ".id"
+
+// LoadWalletIdentity reads the identity labelled label from the wallet directory dir. An empty
+// label is accepted when the wallet holds a single identity.
+func LoadWalletIdentity(dir, label string) (*WalletIdentity, error) {
+	if label == "" {
+		labels, err := walletLabels(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(labels) != 1 {
+			return nil, fmt.Errorf("wallet %s holds %d identities (%s); choose one with identity", dir, len(labels), strings.Join(labels, ", "))
+		}
+		label = labels[0]
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, label+walletSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet identity %s: %w", label, err)
+	}
+	var id WalletIdentity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet identity %s: %w", label, err)
+	}
+	if id.Type != "" && id.Type != "X.509" {
+		return nil, fmt.Errorf("wallet identity %s has type %s, only X.509 is supported", label, id.Type)
+	}
+	if id.MspID == "" || id.Credentials.Certificate == "" || id.Credentials.PrivateKey == "" {
+		return nil, fmt.Errorf("wallet identity %s is missing its mspId, certificate or private key", label)
+	}
+	return &id, nil
+}
+
+// walletLabels lists the labels of the identities in the wallet directory dir.
+func walletLabels(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet directory: %w", err)
+	}
+	var labels []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), walletSuffix) {
+			labels = append(labels, strings.TrimSuffix(e.Name(), walletSuffix))
+		}
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// Resolve returns a copy of c with the fields left empty filled from its connection profile and
+// wallet. Fields set explicitly, in the configuration file or the environment, take precedence.
+func (c Chain) Resolve() (Chain, error) {
+	if c.Profile != "" {
+		p, err := LoadProfile(c.Profile)
+		if err != nil {
+			return c, err
+		}
+		pc, err := p.Chain(c.Peer)
+		if err != nil {
+			return c, err
+		}
+		fill(&c.MspID, pc.MspID)
+		fill(&c.PeerEndpoint, pc.PeerEndpoint)
+		fill(&c.GatewayPeer, pc.GatewayPeer)
+		if c.TLSCertPath == "" {
+			fill(&c.TLSCertPEM, pc.TLSCertPEM)
+			fill(&c.TLSCertPath, pc.TLSCertPath)
+		}
+	}
+
+	if c.Wallet != "" {
+		id, err := LoadWalletIdentity(c.Wallet, c.Identity)
+		if err != nil {
+			return c, err
+		}
+		if c.MspID != "" && c.MspID != id.MspID {
+			return c, fmt.Errorf("wallet identity of %s belongs to %s, not %s", c.Wallet, id.MspID, c.MspID)
+		}
+		c.MspID = id.MspID
+		if c.CertPath == "" {
+			fill(&c.CertPEM, id.Credentials.Certificate)
+		}
+		if c.KeyPath == "" {
+			fill(&c.KeyPEM, id.Credentials.PrivateKey)
+		}
+	}
+	return c, nil
+}
+
+// Inherit returns a copy of c with the fields it leaves empty taken from base: the channel and
+// chaincode, the peer unless c names a connection profile, and the identity unless c names a
+// wallet. It describes another peer of the same channel, e.g. a member of a Pool, by difference.
+func (c Chain) Inherit(base Chain) Chain {
+	fill(&c.ChannelName, base.ChannelName)
+	fill(&c.ChaincodeName, base.ChaincodeName)
+	if c.Profile == "" {
+		fill(&c.PeerEndpoint, base.PeerEndpoint)
+		fill(&c.GatewayPeer, base.GatewayPeer)
+		if c.TLSCertPath == "" {
+			fill(&c.TLSCertPEM, base.TLSCertPEM)
+			fill(&c.TLSCertPath, base.TLSCertPath)
+		}
+	}
+	if c.Wallet == "" {
+		fill(&c.CryptoPath, base.CryptoPath)
+		if c.CertPath == "" {
+			fill(&c.CertPEM, base.CertPEM)
+			fill(&c.CertPath, base.CertPath)
+		}
+		if c.KeyPath == "" {
+			fill(&c.KeyPEM, base.KeyPEM)
+			fill(&c.KeyPath, base.KeyPath)
+		}
+	}
+	if c.Profile == "" && c.Wallet == "" {
+		fill(&c.MspID, base.MspID)
+	}
+	return c
+}
+
+// RelativeTo returns a copy of c with its relative file paths made relative to dir, e.g. the
+// directory of the configuration file c was read from. Empty and absolute paths are kept as is.
+func (c Chain) RelativeTo(dir string) Chain {
+	for _, p := range []*string{&c.CryptoPath, &c.CertPath, &c.KeyPath, &c.TLSCertPath, &c.Profile, &c.Wallet} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return c
+}
+
+// fill sets *field to value unless it is already set.
+func fill(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// ApplyEnv overrides the fields of c from the environment variables prefix_MSP_ID,
+// prefix_CERT_PATH, prefix_KEY_PATH, prefix_TLS_CERT_PATH, prefix_PEER_ENDPOINT,
+// prefix_GATEWAY_PEER, prefix_CHANNEL_NAME, prefix_CHAINCODE_NAME, prefix_PROFILE, prefix_PEER,
+// prefix_WALLET and prefix_IDENTITY that are set and not empty.
+func (c *Chain) ApplyEnv(prefix string) {
+	overrides := []struct {
+		name  string
+		field *string
+	}{
+		{"MSP_ID", &c.MspID},
+		{"CERT_PATH", &c.CertPath},
+		{"KEY_PATH", &c.KeyPath},
+		{"TLS_CERT_PATH", &c.TLSCertPath},
+		{"PEER_ENDPOINT", &c.PeerEndpoint},
+		{"GATEWAY_PEER", &c.GatewayPeer},
+		{"CHANNEL_NAME", &c.ChannelName},
+		{"CHAINCODE_NAME", &c.ChaincodeName},
+		{"PROFILE", &c.Profile},
+		{"PEER", &c.Peer},
+		{"WALLET", &c.Wallet},
+		{"IDENTITY", &c.Identity},
+	}
+	for _, o := range overrides {
+		if v := os.Getenv(prefix + "_" + o.name); v != "" {
+			*o.field = v
+		}
+	}
+}
+
+// resolvePath makes a relative path relative to base. Empty and absolute paths are kept as is.
+func resolvePath(base, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
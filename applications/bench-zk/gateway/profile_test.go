@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testProfile is ccp-template.yaml as ccp-generate.sh fills it for org02.
+const testProfile = `---
+name: org02
+version: 1.0.0
+client:
+  organization: org02
+organizations:
+  Org02:
+    mspid: org02MSP
+    peers:
+    - peer1.org02.chains
+peers:
+  peer1.org02.chains:
+    url: grpcs://localhost:6002
+    tlsCACerts:
+      pem: |
+          -----BEGIN CERTIFICATE-----
+          MIIB
+          -----END CERTIFICATE-----
+    grpcOptions:
+      ssl-target-name-override: peer1.org02.chains
+      hostnameOverride: peer1.org02.chains
+  peer2.org02.chains:
+    url: grpcs://localhost:7002
+    tlsCACerts:
+      path: tls/ca.crt
+`
+
+const testWalletIdentity = `{"credentials":{"certificate":"CERT","privateKey":"KEY"},"mspId":"org02MSP","type":"X.509","version":1}`
+
+func writeTestFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestProfileChain(t *testing.T) {
+	dir := t.TempDir()
+	p, err := LoadProfile(writeTestFile(t, filepath.Join(dir, "connection-org02.yaml"), testProfile))
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+
+	chain, err := p.Chain("")
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	if chain.MspID != "org02MSP" || chain.PeerEndpoint != "localhost:6002" || chain.GatewayPeer != "peer1.org02.chains" {
+		t.Errorf("Unexpected chain: %+v", chain)
+	}
+	if !strings.HasPrefix(chain.TLSCertPEM, "-----BEGIN CERTIFICATE-----\nMIIB\n") {
+		t.Errorf("TLSCertPEM = %q", chain.TLSCertPEM)
+	}
+
+	chain, err = p.Chain("peer2.org02.chains")
+	if err != nil {
+		t.Fatalf("Chain(peer2) failed: %v", err)
+	}
+	if chain.GatewayPeer != "peer2.org02.chains" || chain.TLSCertPath != filepath.Join(dir, "tls/ca.crt") {
+		t.Errorf("Unexpected chain for peer2: %+v", chain)
+	}
+
+	if _, err := p.Chain("peer9.org02.chains"); err == nil {
+		t.Error("Chain accepted an unknown peer")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	profile := writeTestFile(t, filepath.Join(dir, "connection-org02.yaml"), testProfile)
+	wallet := filepath.Join(dir, "wallet")
+	writeTestFile(t, filepath.Join(wallet, "user1.id"), testWalletIdentity)
+
+	chain, err := Chain{Profile: profile, Wallet: wallet, PeerEndpoint: "peer1:443", ChannelName: "chains02"}.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if chain.PeerEndpoint != "peer1:443" {
+		t.Errorf("Explicit PeerEndpoint was overridden by the profile: %s", chain.PeerEndpoint)
+	}
+	if chain.MspID != "org02MSP" || chain.CertPEM != "CERT" || chain.KeyPEM != "KEY" || chain.TLSCertPEM == "" {
+		t.Errorf("Unexpected chain: %+v", chain)
+	}
+
+	// A second identity makes the label mandatory
+	writeTestFile(t, filepath.Join(wallet, "admin.id"), testWalletIdentity)
+	if _, err := (Chain{Wallet: wallet}).Resolve(); err == nil || !strings.Contains(err.Error(), "admin, user1") {
+		t.Errorf("Expected an ambiguous wallet error, got %v", err)
+	}
+	if _, err := (Chain{Wallet: wallet, Identity: "admin"}).Resolve(); err != nil {
+		t.Errorf("Resolve with identity failed: %v", err)
+	}
+	if _, err := (Chain{Wallet: wallet, Identity: "admin", MspID: "org01MSP"}).Resolve(); err == nil {
+		t.Error("Resolve accepted an identity of another organization")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("TEST_CHAIN_PEER_ENDPOINT", "peer0:7051")
+	t.Setenv("TEST_CHAIN_KEY_PATH", "/keys/priv_sk")
+	t.Setenv("TEST_CHAIN_CHANNEL_NAME", "")
+
+	chain := Chain{PeerEndpoint: "localhost:6001", ChannelName: "chains"}
+	chain.ApplyEnv("TEST_CHAIN")
+	if chain.PeerEndpoint != "peer0:7051" || chain.KeyPath != "/keys/priv_sk" || chain.ChannelName != "chains" {
+		t.Errorf("Unexpected chain: %+v", chain)
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	keystore := t.TempDir()
+	if _, err := loadPrivateKey(keystore); err == nil {
+		t.Error("loadPrivateKey accepted an empty directory")
+	}
+
+	key := writeTestFile(t, filepath.Join(keystore, "a_sk"), "A")
+	if pem, err := loadPrivateKey(keystore); err != nil || string(pem) != "A" {
+		t.Errorf("loadPrivateKey(dir) = %q, %v", pem, err)
+	}
+
+	writeTestFile(t, filepath.Join(keystore, "b_sk"), "B")
+	if _, err := loadPrivateKey(keystore); err == nil {
+		t.Error("loadPrivateKey guessed among several keys")
+	}
+	if pem, err := loadPrivateKey(key); err != nil || string(pem) != "A" {
+		t.Errorf("loadPrivateKey(file) = %q, %v", pem, err)
+	}
+
+	if _, err := loadPrivateKey(filepath.Join(keystore, "missing")); err == nil {
+		t.Error("loadPrivateKey accepted a missing file")
+	}
+}
+
+func TestNewGrpcConnectionMissingCertificate(t *testing.T) {
+	_, err := newGrpcConnection(Chain{PeerEndpoint: "localhost:6001", TLSCertPath: filepath.Join(t.TempDir(), "ca.crt")})
+	if err == nil || !strings.Contains(err.Error(), "ca.crt") {
+		t.Errorf("Expected an error naming the missing certificate, got %v", err)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	base := Chain{
+		MspID: "org01MSP", CertPath: "cert.pem", KeyPath: "keystore", TLSCertPath: "ca.crt",
+		PeerEndpoint: "localhost:6001", GatewayPeer: "peer1.org01.chains", ChannelName: "chains", ChaincodeName: "basic",
+	}
+
+	peer := Chain{PeerEndpoint: "localhost:6002", GatewayPeer: "peer2.org01.chains"}.Inherit(base)
+	if peer.PeerEndpoint != "localhost:6002" || peer.TLSCertPath != "ca.crt" || peer.CertPath != "cert.pem" || peer.MspID != "org01MSP" || peer.ChaincodeName != "basic" {
+		t.Errorf("Unexpected peer of the same organization: %+v", peer)
+	}
+
+	other := Chain{Profile: "connection-org03.yaml", Wallet: "wallet-org03"}.Inherit(base)
+	if other.PeerEndpoint != "" || other.CertPath != "" || other.MspID != "" || other.ChannelName != "chains" {
+		t.Errorf("Profile and wallet members must only inherit the channel and chaincode: %+v", other)
+	}
+}
+
+func TestRelativeTo(t *testing.T) {
+	chain := Chain{CertPath: "certs/cert.pem", KeyPath: "/keys/key.pem", Wallet: "wallet", PeerEndpoint: "localhost:6001"}.RelativeTo("conf")
+	want := Chain{CertPath: filepath.Join("conf", "certs/cert.pem"), KeyPath: "/keys/key.pem", Wallet: filepath.Join("conf", "wallet"), PeerEndpoint: "localhost:6001"}
+	if chain != want {
+		t.Errorf("RelativeTo: %+v, expected %+v", chain, want)
+	}
+}
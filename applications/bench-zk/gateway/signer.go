@@ -0,0 +1,235 @@
+// gateway/signer.go
+
+package gateway
+
+// Signers for the offline signing flow of OfflineClient, where the client only builds proposals
+// and the signatures come from elsewhere: a key held in process, an emulated hardware security
+// module, or a pool of user identities issued from the organization's CA so that a benchmark can
+// act as thousands of distinct users.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// Signer signs the proposals, transactions and commit status requests of one client identity.
+type Signer interface {
+	Identity() identity.Identity
+	Sign(digest []byte) ([]byte, error)
+}
+
+// keySigner signs with a private key held in process.
+type keySigner struct {
+	id   identity.Identity
+	sign identity.Sign
+}
+
+func (s *keySigner) Identity() identity.Identity        { return s.id }
+func (s *keySigner) Sign(digest []byte) ([]byte, error) { return s.sign(digest) }
+
+// NewKeySigner returns a Signer signing as id with privateKey, an ECDSA or Ed25519 key.
+func NewKeySigner(id identity.Identity, privateKey crypto.PrivateKey) (Signer, error) {
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signature function: %w", err)
+	}
+	return &keySigner{id, sign}, nil
+}
+
+// LoadSigner returns a Signer for the identity of chain, the one NewGateway signs with.
+func LoadSigner(chain Chain) (Signer, error) {
+	chain, err := chain.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	id, sign, err := newIdentityAndSign(chain)
+	if err != nil {
+		return nil, err
+	}
+	return &keySigner{id, sign}, nil
+}
+
+// HSMSigner stands in for a hardware security module: it signs through a limited number of
+// sessions, each signature taking at least a fixed latency, so that benchmarks can see how a
+// signing device bounds the throughput of a client.
+type HSMSigner struct {
+	signer     Signer
+	latency    time.Duration
+	sessions   chan struct{}
+	signatures atomic.Uint64
+}
+
+// NewHSMSigner returns an HSMSigner signing with signer through at most sessions concurrent
+// sessions, each signature taking latency.
+func NewHSMSigner(signer Signer, sessions int, latency time.Duration) *HSMSigner {
+	if sessions < 1 {
+		sessions = 1
+	}
+	return &HSMSigner{signer: signer, latency: latency, sessions: make(chan struct{}, sessions)}
+}
+
+func (h *HSMSigner) Identity() identity.Identity { return h.signer.Identity() }
+
+// Sign waits for a free session and signs digest in it.
+func (h *HSMSigner) Sign(digest []byte) ([]byte, error) {
+	h.sessions <- struct{}{}
+	defer func() { <-h.sessions }()
+	start := time.Now()
+	signature, err := h.signer.Sign(digest)
+	time.Sleep(h.latency - time.Since(start))
+	h.signatures.Add(1)
+	return signature, err
+}
+
+// Signatures returns the number of signatures made.
+func (h *HSMSigner) Signatures() uint64 { return h.signatures.Load() }
+
+// IdentityPool is a fixed set of signers handed out in turn, safe for concurrent use.
+type IdentityPool struct {
+	signers []Signer
+	next    atomic.Uint64
+}
+
+// NewIdentityPool returns a pool of signers, which must not be empty.
+func NewIdentityPool(signers ...Signer) (*IdentityPool, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("identity pool needs at least one signer")
+	}
+	return &IdentityPool{signers: signers}, nil
+}
+
+// IssueIdentityPool returns a pool of n user identities of mspID, issued with fresh keys by the
+// CA of a cryptogen organization directory (peerOrganizations/<domain>). The identities are
+// named user<i>@<domain> and carry the "client" organizational unit, so peers of an MSP with
+// NodeOUs accept them like the users cryptogen generated.
+func IssueIdentityPool(mspID, orgDir string, n int) (*IdentityPool, error) {
+	caCert, caKey, err := loadCA(path.Join(orgDir, "ca"))
+	if err != nil {
+		return nil, err
+	}
+	domain := strings.TrimPrefix(caCert.Subject.CommonName, "ca.")
+
+	signers := make([]Signer, n)
+	for i := range signers {
+		if signers[i], err = issueIdentity(mspID, fmt.Sprintf("user%d@%s", i+1, domain), caCert, caKey); err != nil {
+			return nil, err
+		}
+	}
+	return NewIdentityPool(signers...)
+}
+
+// Len returns the number of identities in the pool.
+func (p *IdentityPool) Len() int { return len(p.signers) }
+
+// Get returns the i-th identity, modulo the size of the pool.
+func (p *IdentityPool) Get(i int) Signer { return p.signers[i%len(p.signers)] }
+
+// Next returns the identities in turn.
+func (p *IdentityPool) Next() Signer {
+	return p.signers[(p.next.Add(1)-1)%uint64(len(p.signers))]
+}
+
+// loadCA reads the certificate and private key of a cryptogen CA directory, which holds the
+// certificate as <name>-cert.pem and the key as priv_sk.
+func loadCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	certFiles, err := filePaths(dir, "-cert.pem")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA directory: %w", err)
+	}
+	if len(certFiles) != 1 {
+		return nil, nil, fmt.Errorf("CA directory %s holds %d certificates, expected one", dir, len(certFiles))
+	}
+	cert, err := loadCertificate(certFiles[0], "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	keyPEM, err := loadPrivateKey(path.Join(dir, "priv_sk"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("CA key of type %T cannot sign certificates", key)
+	}
+	return cert, signer, nil
+}
+
+// filePaths lists the files of dir whose name ends in suffix.
+func filePaths(dir, suffix string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), suffix) {
+			paths = append(paths, path.Join(dir, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// issueIdentity creates a P-256 key and a client certificate for name signed by the CA, laid out
+// like the user certificates of cryptogen.
+func issueIdentity(mspID, name string, caCert *x509.Certificate, caKey crypto.Signer) (Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	publicKey, err := key.PublicKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	ski := sha256.Sum256(publicKey.Bytes()) // Key identifier as cryptogen computes it
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:            caCert.Subject.Country,
+			Province:           caCert.Subject.Province,
+			Locality:           caCert.Subject.Locality,
+			OrganizationalUnit: []string{"client"},
+			CommonName:         name,
+		},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              caCert.NotAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          ski[:],
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", name, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for %s: %w", name, err)
+	}
+	id, err := identity.NewX509Identity(mspID, cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity: %w", err)
+	}
+	return NewKeySigner(id, key)
+}
@@ -0,0 +1,77 @@
+// logging/logging.go
+
+package logging
+
+// Structured logging of the operator and its tools, on top of log/slog. Messages about a Layer 2
+// block or transaction carry it in the Block and Tx attributes, so the lines of one block can be
+// followed through the pipeline, e.g. with `jq 'select(.block == 42)'` on the JSON format.
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats.
+const (
+	FormatText = "text" // key=value pairs, the default
+	FormatJSON = "json" // One JSON object per line
+)
+
+// Attribute keys correlating messages with the block or transaction they are about.
+const (
+	KeyBlock = "block"
+	KeyTx    = "tx"
+)
+
+// Block returns the attribute of Layer 2 block number.
+func Block(number uint64) slog.Attr {
+	return slog.Uint64(KeyBlock, number)
+}
+
+// Tx returns the attribute of transaction id.
+func Tx(id string) slog.Attr {
+	return slog.String(KeyTx, id)
+}
+
+// ParseLevel parses a level name: "debug", "info", "warn" or "error", in any case. An empty name
+// is "info".
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q: expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// New returns a logger writing records of level and above to w in format, FormatText if empty.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q: expected %s or %s", format, FormatText, FormatJSON)
+}
+
+// Setup makes a logger writing to standard error the default one, which the log package also
+// writes through.
+func Setup(level, format string) error {
+	logger, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,59 @@
+// logging/logging_test.go
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		if level, err := ParseLevel(name); err != nil || level != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", name, level, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatJSON)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("Committed proof", Block(42), Tx("abc"))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Committed proof" || record[KeyBlock] != float64(42) || record[KeyTx] != "abc" {
+		t.Errorf("Unexpected record %v", record)
+	}
+
+	buf.Reset()
+	if logger, err = New(&buf, "debug", ""); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Debug("Processing transaction", Block(7))
+	if line := buf.String(); !strings.Contains(line, "level=DEBUG") || !strings.Contains(line, "block=7") {
+		t.Errorf("Unexpected text record %q", line)
+	}
+
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Error("New accepted an unknown format")
+	}
+}
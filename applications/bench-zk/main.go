@@ -2,6 +2,467 @@
 
 package main
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bench-zk/config"
+	"bench-zk/gateway"
+	"bench-zk/logging"
+	"bench-zk/prover"
+	"bench-zk/sweep"
+	"bench-zk/wrappers"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/kzg"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+)
+
+const usage = `Usage: bench-zk <command> [flags]
+
+Commands:
+  setup          compile the rollup circuit and write the keys of the proof backend
+  init-l1        install the verifying key and the genesis root in ZKContract
+  operate        run the operator until interrupted
+  status         list the state roots committed on Layer 1
+  verify-block   verify the commitment of one block: verify-block [flags] <block>
+  sweep          measure the rollup circuit over a grid of tree depths and batch sizes
+
+Run 'bench-zk <command> -h' for the flags of a command.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "setup":
+		err = runSetup(args)
+	case "init-l1":
+		err = runInitL1(args)
+	case "operate":
+		err = runOperate(args)
+	case "status":
+		err = runStatus(args)
+	case "verify-block":
+		err = runVerifyBlock(args)
+	case "sweep":
+		err = runSweep(args)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		slog.Error("Command failed", "command", os.Args[1], "err", err)
+		os.Exit(1)
+	}
+}
+
+// logFlags are the flags overriding the logging configuration.
+type logFlags struct {
+	level  *string
+	format *string
+}
+
+func addLogFlags(fs *flag.FlagSet) logFlags {
+	return logFlags{
+		level:  fs.String("log-level", "", "lowest level logged: debug, info, warn or error (overrides logLevel)"),
+		format: fs.String("log-format", "", "log format: text or json (overrides logFormat)"),
+	}
+}
+
+// setup installs the default logger with level and format, unless the flags override them.
+func (f logFlags) setup(level, format string) error {
+	if *f.level != "" {
+		level = *f.level
+	}
+	if *f.format != "" {
+		format = *f.format
+	}
+	return logging.Setup(level, format)
+}
+
+// parseFlags parses the flags shared by all commands, loads the configuration file and sets up
+// logging.
+func parseFlags(name string, args []string) (*config.Config, *flag.FlagSet, error) {
+	return parseFlagsWith(name, args, nil)
+}
+
+// parseFlagsWith is parseFlags for a command that defines flags of its own on fs with define.
+func parseFlagsWith(name string, args []string, define func(fs *flag.FlagSet)) (*config.Config, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if define != nil {
+		define(fs)
+	}
+	configPath := fs.String("config", "config.yaml", "path to the operator configuration (YAML or JSON)")
+	logs := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := logs.setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs, nil
+}
+
+// openWrappers connects to both chains with the keys from the configured key directory.
+func openWrappers(cfg *config.Config) (*wrappers.Wrappers, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	h, err := hasher.ByName(cfg.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wrappers.NewWrappersWithKeys(cfg.L1, cfg.L2, cfg.KeyDir, cfg.ProofBackend, h)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Aggregate > 0 {
+		if w.Aggregator, err = wrappers.LoadAggregator(cfg.KeyDir, h, cfg.Aggregate); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	if len(cfg.L1Peers) > 0 {
+		policy, err := gateway.ParsePolicy(cfg.PoolPolicy)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if w.L1Pool, err = gateway.NewPool(append([]gateway.Chain{cfg.L1}, cfg.L1Peers...), policy, gateway.DefaultHealthInterval); err != nil {
+			w.Close()
+			return nil, err
+		}
+		slog.Info("Spreading Layer 1 calls over gateway peers", "peers", len(cfg.L1Peers)+1, "policy", policy)
+	}
+	if err := w.CheckContracts(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// openL1 connects to Layer 1 only, which is all the read-only commands need.
+func openL1(cfg *config.Config) (*gateway.Gateway, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return gateway.NewGateway(cfg.L1)
+}
+
+func runSetup(args []string) error {
+	var unsafeSRS *bool
+	cfg, _, err := parseFlagsWith("setup", args, func(fs *flag.FlagSet) {
+		unsafeSRS = fs.Bool("unsafe-srs", false, "set plonk up with an SRS of known secret when srsPath is empty (benchmarks only: anyone can forge proofs)")
+	})
+	if err != nil {
+		return err
+	}
+
+	h, err := hasher.ByName(cfg.Hasher)
+	if err != nil {
+		return err
+	}
+	if cfg.Aggregate > 0 && cfg.ProofBackend != prover.Groth16 {
+		return fmt.Errorf("aggregate requires proofBackend %s, got %s", prover.Groth16, cfg.ProofBackend)
+	}
+
+	// Groth16 runs its own setup; PLONK needs a KZG SRS, which must come from a ceremony unless
+	// the keys are explicitly meant for benchmarks
+	var srs *kzg.SRS
+	if cfg.ProofBackend == prover.Plonk {
+		switch {
+		case cfg.SRSPath != "":
+			slog.Info("Loading KZG SRS", "path", cfg.SRSPath)
+			if srs, err = prover.LoadSRS(cfg.SRSPath); err != nil {
+				return err
+			}
+		case *unsafeSRS:
+			slog.Warn("Generating the KZG SRS with a known secret: anyone can forge proofs against these keys, only use them for benchmarks")
+		default:
+			return fmt.Errorf("the plonk backend needs a KZG SRS: set srsPath to the SRS of a ceremony, or pass -unsafe-srs for benchmarks")
+		}
+	}
+
+	slog.Info("Compiling circuit and running setup", "hasher", h.Name(), "backend", cfg.ProofBackend, "keyDir", cfg.KeyDir)
+	if err := wrappers.SetupKeys(cfg.KeyDir, cfg.ProofBackend, h, srs); err != nil {
+		return err
+	}
+	if cfg.Aggregate > 0 {
+		slog.Info("Compiling aggregation circuit and running setup", "slots", cfg.Aggregate)
+		if err := wrappers.SetupAggregator(cfg.KeyDir, h, cfg.Aggregate); err != nil {
+			return err
+		}
+	}
+	slog.Info("Keys written", "keyDir", cfg.KeyDir)
+	return nil
+}
+
+func runInitL1(args []string) error {
+	cfg, _, err := parseFlags("init-l1", args)
+	if err != nil {
+		return err
+	}
+
+	w, err := openWrappers(cfg)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.CheckCircuit(false); err != nil {
+		return err
+	}
+	if err := w.InitL1(); err != nil {
+		return err
+	}
+	if err := w.SaveState(cfg.StatePath); err != nil {
+		return err
+	}
+	// A checkpoint from a previous deployment would make operate skip blocks
+	if err := os.Remove(cfg.CheckpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale checkpoint: %w", err)
+	}
+	slog.Info("Genesis root committed", logging.Block(uint64(w.LatestRoot)), "root", w.LatestRootHash, "state", cfg.StatePath)
+	return nil
+}
+
+func runOperate(args []string) error {
+	cfg, _, err := parseFlags("operate", args)
+	if err != nil {
+		return err
+	}
+
+	w, err := openWrappers(cfg)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.LoadState(cfg.StatePath); err != nil {
+		return err
+	}
+	if err := w.CheckCircuit(true); err != nil {
+		return err
+	}
+	w.StatePath = cfg.StatePath
+	w.CheckpointPath = cfg.CheckpointPath
+	w.ProverWorkers = cfg.ProverWorkers
+	if cfg.LatencyPath != "" {
+		latency := gateway.NewMetrics()
+		w.Gw1.Metrics = latency
+		if w.L1Pool != nil {
+			w.L1Pool.SetMetrics(latency)
+		}
+		defer func() {
+			if err := latency.WriteFile(cfg.LatencyPath); err != nil {
+				slog.Error("Failed to write the Layer 1 latency", "err", err)
+				return
+			}
+			slog.Info("Layer 1 latency written", "path", cfg.LatencyPath)
+		}()
+	}
+	if cfg.MetricsAddress != "" {
+		w.Metrics = wrappers.NewMetrics()
+		server, err := serveMetrics(cfg.MetricsAddress, w.Metrics)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+	}
+	slog.Info("Resuming operator", logging.Block(uint64(w.LatestRoot)), "root", w.LatestRootHash)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return w.Operate(ctx)
+}
+
+// serveMetrics serves the operator metrics on /metrics at address until the server is closed.
+func serveMetrics(address string, m *wrappers.Metrics) (*http.Server, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "err", err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", lis.Addr()))
+	return server, nil
+}
+
+func runStatus(args []string) error {
+	cfg, _, err := parseFlags("status", args)
+	if err != nil {
+		return err
+	}
+
+	gw, err := openL1(cfg)
+	if err != nil {
+		return err
+	}
+	defer gw.Close()
+
+	roots, err := wrappers.QueryCommittedRoots(gw)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Block Number | State Root")
+	fmt.Println("-------------|------------")
+	for _, root := range roots {
+		fmt.Printf("%12s | %s\n", root.BlockNumber, root.StateRoot)
+	}
+	return nil
+}
+
+func runVerifyBlock(args []string) error {
+	cfg, fs, err := parseFlags("verify-block", args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one block number, got %d arguments", fs.NArg())
+	}
+	blockNumber, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block number %q: %w", fs.Arg(0), err)
+	}
+
+	h, err := hasher.ByName(cfg.Hasher)
+	if err != nil {
+		return err
+	}
+	verifiers, err := wrappers.LoadVerifiers(cfg.KeyDir, cfg.ProofBackend, h, cfg.Aggregate)
+	if err != nil {
+		return err
+	}
+
+	gw, err := openL1(cfg)
+	if err != nil {
+		return err
+	}
+	defer gw.Close()
+
+	res, err := wrappers.VerifyBlock(gw, verifiers, blockNumber)
+	if err != nil {
+		return err
+	}
+
+	if res.Aggregated > 0 {
+		fmt.Printf("Block %d: aggregated proof of %d slots verified for %s -> %s\n", res.BlockNumber, res.Aggregated, res.OldRoot, res.NewRoot)
+	} else if res.Proofs > 1 {
+		fmt.Printf("Block %d: chain of %d proofs verified for %s -> %s\n", res.BlockNumber, res.Proofs, res.OldRoot, res.NewRoot)
+	} else if res.HasProof {
+		fmt.Printf("Block %d: proof verified for %s -> %s\n", res.BlockNumber, res.OldRoot, res.NewRoot)
+	} else {
+		fmt.Printf("Block %d: committed without state change, root %s\n", res.BlockNumber, res.NewRoot)
+	}
+	if res.HasProof {
+		fmt.Printf("Backend %s: %d proof bytes, verified in %v\n", res.Backend, res.ProofBytes, res.VerifyTime)
+	}
+	return nil
+}
+
+// runSweep does not read the configuration file: it only compiles and proves locally.
+func runSweep(args []string) (err error) {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	depths := fs.String("depths", "4,8,10", "comma-separated Merkle tree depths")
+	batches := fs.String("batches", "1,8,32", "comma-separated batch sizes")
+	backend := fs.String("backend", prover.Groth16, "proof backend: groth16 or plonk")
+	hasherName := fs.String("hasher", "mimc", "hash function: mimc or poseidon2")
+	out := fs.String("out", "sweep.csv", "CSV file to write the results to")
+	logs := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logs.setup(config.DefaultLogLevel, config.DefaultLogFormat); err != nil {
+		return err
+	}
+
+	h, err := hasher.ByName(*hasherName)
+	if err != nil {
+		return err
+	}
+	ds, err := parseInts(*depths)
+	if err != nil {
+		return fmt.Errorf("invalid -depths: %w", err)
+	}
+	bs, err := parseInts(*batches)
+	if err != nil {
+		return fmt.Errorf("invalid -batches: %w", err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", *out, err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to close %s: %w", *out, cerr)
+		}
+	}()
+	w, err := sweep.NewWriter(f)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range sweep.Grid(ds, bs) {
+		slog.Info("Compiling, setting up and proving", "depth", p.Depth, "batch", p.Batch, "backend", *backend, "hasher", h.Name())
+		res, err := sweep.Run(p, *backend, h)
+		if err != nil {
+			return fmt.Errorf("D=%d B=%d: %w", p.Depth, p.Batch, err)
+		}
+		slog.Info("Point measured", "depth", p.Depth, "batch", p.Batch, "constraints", res.Constraints,
+			"setup", res.Setup, "prove", res.Prove, "proofBytes", res.ProofBytes, "peakHeapMB", res.PeakHeapMB)
+		if err := w.Write(res); err != nil {
+			return err
+		}
+	}
+	slog.Info("Results written", "path", *out)
+	return nil
+}
 
+// parseInts parses a comma-separated list of positive integers.
+func parseInts(list string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if v < 1 {
+			return nil, fmt.Errorf("%d is not positive", v)
+		}
+		values = append(values, v)
+	}
+	return values, nil
 }
@@ -7,10 +7,9 @@ import (
 	"fmt"
 	"math/big"
 
-	"bench-zk/utils"
+	"github.com/weids-dev/benchains/circuits/hasher"
 
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
-	gcHash "github.com/consensys/gnark-crypto/hash"
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
 )
 
 // MProof represents the Merkle proof for a specific leaf in the Merkle tree.
@@ -24,9 +23,44 @@ type MProof struct {
 // When exiting, user can withdraw all BEN stored on zk-rollups contract chain,
 // but he/she cannot move any unused deposits out.
 // (Which means in our current implementation, only final UserState is on-chain)
+//
+// Leaves of the rollup state (ProofMerkleCircuit) are accounts: they additionally bind the
+// EdDSA public key allowed to sign the user's state changes and a nonce counting them.
+// A UserState without a Nonce is a plain (Name, Ben) leaf, as used by the simpler circuits.
 type UserState struct {
-	Name *big.Int
-	Ben  *big.Int
+	Name    *big.Int
+	Ben     *big.Int
+	PubKeyX *big.Int `json:",omitempty"` // Key registered by the player, (0, 0) for a free slot
+	PubKeyY *big.Int `json:",omitempty"`
+	Nonce   *big.Int `json:",omitempty"` // Number of state changes applied to the account
+}
+
+// NewAccount returns an account leaf holding ben for name, with no public key bound: a free
+// slot, which the circuit only lets hold a zero balance.
+func NewAccount(name, ben *big.Int) UserState {
+	return NewRegisteredAccount(name, ben, big.NewInt(0), big.NewInt(0))
+}
+
+// NewRegisteredAccount returns an account leaf holding ben for name, bound to the public key
+// (pubKeyX, pubKeyY) the player registered.
+func NewRegisteredAccount(name, ben, pubKeyX, pubKeyY *big.Int) UserState {
+	return UserState{
+		Name:    name,
+		Ben:     ben,
+		PubKeyX: pubKeyX,
+		PubKeyY: pubKeyY,
+		Nonce:   big.NewInt(0),
+	}
+}
+
+// IsRegistered reports whether the account leaf user is bound to a public key.
+func (user UserState) IsRegistered() bool {
+	return user.PubKeyX.Sign() != 0 || user.PubKeyY.Sign() != 0
+}
+
+// IsAccount reports whether user is an account leaf rather than a plain (Name, Ben) leaf.
+func (user UserState) IsAccount() bool {
+	return user.Nonce != nil
 }
 
 // TransactionData holds the transaction ID and its corresponding args
@@ -69,54 +103,45 @@ func Base64ToBigInt(encoded string) (*big.Int, error) {
 // --------------------------------------------------------------------------------
 // Helper function: HashUserState
 //
-// Hashes a single user state (Name + Balance) into a field element using MiMC_BN254
+// Hashes a single user state (Name + Balance) into a field element using MiMC_BLS12_377
 // user can prove that the possess the same state by re-computing their claimed states
 // and producing the same hash that in the state Merkle tree (Merkle proof).
+// Account leaves hash (Name, Balance, PubKeyX, PubKeyY, Nonce) instead.
 // --------------------------------------------------------------------------------
 func HashUserState(user UserState) *big.Int {
-	hasher := gcHash.MIMC_BN254.New()
-
-	// Convert Name to fr.Element and then to bytes
-	var nameFr fr.Element
-	nameFr.SetBigInt(user.Name)
-	nameBytes := nameFr.Bytes()
-	_, _ = hasher.Write(nameBytes[:])
-
-	// Convert Ben to fr.Element and then to bytes
-	var benFr fr.Element
-	benFr.SetBigInt(user.Ben)
-	benBytes := benFr.Bytes()
-	_, _ = hasher.Write(benBytes[:])
+	return HashUserStateWith(hasher.MiMC, user)
+}
 
-	digest := hasher.Sum(nil)
-	var outFr fr.Element
-	outFr.SetBytes(digest)
-	res := new(big.Int)
-	outFr.BigInt(res)
-	return res
+// HashUserStateWith hashes a single user state like HashUserState, using h.
+func HashUserStateWith(h *hasher.Hasher, user UserState) *big.Int {
+	fields := []*big.Int{user.Name, user.Ben}
+	if user.IsAccount() {
+		fields = append(fields, user.PubKeyX, user.PubKeyY, user.Nonce)
+	}
+	return h.Hash(fields...)
 }
 
 // HashTransactionData hashes a single transaction (TxID + all Args)
-// into a field element using MiMC_BN254.
+// into a field element using MiMC_BLS12_377.
 //
 // The order is:
 //  1. TxID (as bytes) first,
 //  2. Then each argument (in sequence).
 func HashTransactionData(tx TransactionData) *big.Int {
-	hasher := gcHash.MIMC_BN254.New()
+	txHasher := hasher.MiMC.New()
 
 	// 1) Write TxID bytes
 	txIDBytes := []byte(tx.TxID)
-	_, _ = hasher.Write(txIDBytes)
+	_, _ = txHasher.Write(txIDBytes)
 
 	// 2) Write each argument's bytes
 	for _, arg := range tx.Args {
 		argBytes := []byte(arg)
-		_, _ = hasher.Write(argBytes)
+		_, _ = txHasher.Write(argBytes)
 	}
 
 	// 3) Compute the hash
-	digest := hasher.Sum(nil)
+	digest := txHasher.Sum(nil)
 
 	// 4) Convert to fr.Element => big.Int
 	var outFr fr.Element
@@ -165,7 +190,7 @@ func BuildMerkleTransactions(txs []TransactionData) *big.Int {
 				nextLevel = append(nextLevel, leaves[i])
 			} else {
 				// parent = MiMC(leaves[i], leaves[i+1])
-				parent := utils.ComputeMiMC(leaves[i], leaves[i+1])
+				parent := hasher.MiMC.Hash(leaves[i], leaves[i+1])
 				nextLevel = append(nextLevel, parent)
 			}
 		}
@@ -184,10 +209,16 @@ func BuildMerkleTransactions(txs []TransactionData) *big.Int {
 // Then pairwise hash to get parent, etc. Returns the Merkle root as *big.Int.
 // --------------------------------------------------------------------------------
 func BuildMerkleStates(users []UserState) *big.Int {
+	return BuildMerkleStatesWith(hasher.MiMC, users)
+}
+
+// BuildMerkleStatesWith builds the Merkle tree of users like BuildMerkleStates, hashing leaves
+// and nodes with h.
+func BuildMerkleStatesWith(h *hasher.Hasher, users []UserState) *big.Int {
 	// 1) Hash each user into a leaf
 	var leaves []*big.Int
 	for _, u := range users {
-		leaf := HashUserState(u)
+		leaf := HashUserStateWith(h, u)
 		leaves = append(leaves, leaf)
 	}
 
@@ -205,7 +236,7 @@ func BuildMerkleStates(users []UserState) *big.Int {
 			if i+1 == len(leaves) {
 				nextLevel = append(nextLevel, leaves[i])
 			} else {
-				parent := utils.ComputeMiMC(leaves[i], leaves[i+1])
+				parent := h.Hash(leaves[i], leaves[i+1])
 				nextLevel = append(nextLevel, parent)
 			}
 		}
@@ -250,17 +281,17 @@ func GenerateMerkleProof(users []UserState, leaf *big.Int) (*MProof, error) {
 			if leaves[i].String() == leaf.String() {
 				proof.PathBits = append(proof.PathBits, true) // Left to right
 				proof.Siblings = append(proof.Siblings, leaves[i+1])
-				newleaf := utils.ComputeMiMC(leaves[i], leaves[i+1])
+				newleaf := hasher.MiMC.Hash(leaves[i], leaves[i+1])
 				nextLevel = append(nextLevel, newleaf)
 				leaf = newleaf
 			} else if leaves[i+1].String() == leaf.String() {
 				proof.PathBits = append(proof.PathBits, false) // Right to left
 				proof.Siblings = append(proof.Siblings, leaves[i])
-				newleaf := utils.ComputeMiMC(leaves[i], leaves[i+1])
+				newleaf := hasher.MiMC.Hash(leaves[i], leaves[i+1])
 				nextLevel = append(nextLevel, newleaf)
 				leaf = newleaf
 			} else {
-				nextLevel = append(nextLevel, utils.ComputeMiMC(leaves[i], leaves[i+1]))
+				nextLevel = append(nextLevel, hasher.MiMC.Hash(leaves[i], leaves[i+1]))
 			}
 		}
 		leaves = nextLevel
@@ -270,8 +301,63 @@ func GenerateMerkleProof(users []UserState, leaf *big.Int) (*MProof, error) {
 	return proof, nil
 }
 
+// GenerateMerkleProofAt generates a Merkle proof for the leaf at the given index.
+// Unlike GenerateMerkleProof it does not search for the leaf by hash, so it stays
+// unambiguous when several users hash to the same leaf (e.g. identical dummy users).
+func GenerateMerkleProofAt(users []UserState, index int) (*MProof, error) {
+	return GenerateMerkleProofAtWith(hasher.MiMC, users, index)
+}
+
+// GenerateMerkleProofAtWith generates a Merkle proof like GenerateMerkleProofAt, in the tree
+// built by BuildMerkleStatesWith with h.
+func GenerateMerkleProofAtWith(h *hasher.Hasher, users []UserState, index int) (*MProof, error) {
+	if index < 0 || index >= len(users) {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, len(users))
+	}
+
+	// 1) Hash each user into a leaf
+	var leaves []*big.Int
+	for _, u := range users {
+		leaves = append(leaves, HashUserStateWith(h, u))
+	}
+
+	proof := &MProof{
+		PathBits: []bool{},
+		Siblings: []*big.Int{},
+	}
+
+	// 2) Traverse the tree from leaf to root, following the index
+	for len(leaves) > 1 {
+		var nextLevel []*big.Int
+		for i := 0; i < len(leaves); i += 2 {
+			// if odd number of leaves, carry the last leaf over
+			if i+1 == len(leaves) {
+				nextLevel = append(nextLevel, leaves[i])
+				continue
+			}
+			if i == index {
+				proof.PathBits = append(proof.PathBits, true) // Left to right
+				proof.Siblings = append(proof.Siblings, leaves[i+1])
+			} else if i+1 == index {
+				proof.PathBits = append(proof.PathBits, false) // Right to left
+				proof.Siblings = append(proof.Siblings, leaves[i])
+			}
+			nextLevel = append(nextLevel, h.Hash(leaves[i], leaves[i+1]))
+		}
+		leaves = nextLevel
+		index /= 2
+	}
+
+	return proof, nil
+}
+
 // VerifyMerkleProof verifies that the provided proof is valid for the given root and leaf.
 func VerifyMerkleProof(root *big.Int, leaf *big.Int, proof *MProof) bool {
+	return VerifyMerkleProofWith(hasher.MiMC, root, leaf, proof)
+}
+
+// VerifyMerkleProofWith verifies a Merkle proof like VerifyMerkleProof, hashing nodes with h.
+func VerifyMerkleProofWith(h *hasher.Hasher, root *big.Int, leaf *big.Int, proof *MProof) bool {
 	// Start with the leaf hash
 	currentHash := leaf
 
@@ -279,10 +365,10 @@ func VerifyMerkleProof(root *big.Int, leaf *big.Int, proof *MProof) bool {
 	for i, sibling := range proof.Siblings {
 		if proof.PathBits[i] {
 			// Left to right: hash(currentHash || sibling)
-			currentHash = utils.ComputeMiMC(currentHash, sibling)
+			currentHash = h.Hash(currentHash, sibling)
 		} else {
 			// Right to left: hash(sibling || currentHash)
-			currentHash = utils.ComputeMiMC(sibling, currentHash)
+			currentHash = h.Hash(sibling, currentHash)
 		}
 	}
 
@@ -293,18 +379,23 @@ func VerifyMerkleProof(root *big.Int, leaf *big.Int, proof *MProof) bool {
 // UpdateMerkleRoot computes the new Merkle root and proof for an updated user state,
 // given the previous proof for that user's state for the previous root.
 func UpdateMerkleRoot(prevProof *MProof, newUserState UserState) *big.Int {
+	return UpdateMerkleRootWith(hasher.MiMC, prevProof, newUserState)
+}
+
+// UpdateMerkleRootWith computes the new Merkle root like UpdateMerkleRoot, hashing with h.
+func UpdateMerkleRootWith(h *hasher.Hasher, prevProof *MProof, newUserState UserState) *big.Int {
 	// Compute the new leaf hash from the updated user state
-	newLeafHash := HashUserState(newUserState)
+	newLeafHash := HashUserStateWith(h, newUserState)
 	currentHash := new(big.Int).Set(newLeafHash)
 
 	// Recompute the root by hashing up the path using the previous proof's siblings
 	for i, sibling := range prevProof.Siblings {
 		if prevProof.PathBits[i] {
 			// Leaf was on the left: hash(currentHash, sibling)
-			currentHash = utils.ComputeMiMC(currentHash, sibling)
+			currentHash = h.Hash(currentHash, sibling)
 		} else {
 			// Leaf was on the right: hash(sibling, currentHash)
-			currentHash = utils.ComputeMiMC(sibling, currentHash)
+			currentHash = h.Hash(sibling, currentHash)
 		}
 	}
 
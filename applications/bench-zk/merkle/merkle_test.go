@@ -10,30 +10,30 @@ import (
 func TestMerkleProof(t *testing.T) {
 	// Sample user states for testing
 	users := []UserState{
-		{new(big.Int).SetBytes([]byte("Alice")), big.NewInt(100)},
-		{new(big.Int).SetBytes([]byte("Bob")), big.NewInt(340)},
-		{new(big.Int).SetBytes([]byte("Charlie")), big.NewInt(500)},
-		{new(big.Int).SetBytes([]byte("David")), big.NewInt(750)},
-		{new(big.Int).SetBytes([]byte("Eva")), big.NewInt(200)},
-		{new(big.Int).SetBytes([]byte("Frank")), big.NewInt(900)},
-		{new(big.Int).SetBytes([]byte("Grace")), big.NewInt(50)},
-		{new(big.Int).SetBytes([]byte("Hannah")), big.NewInt(1200)},
-		{new(big.Int).SetBytes([]byte("Isaac")), big.NewInt(180)},
-		{new(big.Int).SetBytes([]byte("Jack")), big.NewInt(350)},
-		{new(big.Int).SetBytes([]byte("Kathy")), big.NewInt(450)},
-		{new(big.Int).SetBytes([]byte("Leo")), big.NewInt(600)},
-		{new(big.Int).SetBytes([]byte("Mona")), big.NewInt(800)},
-		{new(big.Int).SetBytes([]byte("Nina")), big.NewInt(150)},
-		{new(big.Int).SetBytes([]byte("Oscar")), big.NewInt(1100)},
-		{new(big.Int).SetBytes([]byte("Paul")), big.NewInt(950)},
-		{new(big.Int).SetBytes([]byte("Quinn")), big.NewInt(300)},
-		{new(big.Int).SetBytes([]byte("Rita")), big.NewInt(400)},
-		{new(big.Int).SetBytes([]byte("Steve")), big.NewInt(550)},
-		{new(big.Int).SetBytes([]byte("Tina")), big.NewInt(50)},
-		{new(big.Int).SetBytes([]byte("Victor")), big.NewInt(720)},
-		{new(big.Int).SetBytes([]byte("Wendy")), big.NewInt(670)},
-		{new(big.Int).SetBytes([]byte("Xander")), big.NewInt(90)},
-		{new(big.Int).SetBytes([]byte("Yara")), big.NewInt(1000)},
+		{Name: new(big.Int).SetBytes([]byte("Alice")), Ben: big.NewInt(100)},
+		{Name: new(big.Int).SetBytes([]byte("Bob")), Ben: big.NewInt(340)},
+		{Name: new(big.Int).SetBytes([]byte("Charlie")), Ben: big.NewInt(500)},
+		{Name: new(big.Int).SetBytes([]byte("David")), Ben: big.NewInt(750)},
+		{Name: new(big.Int).SetBytes([]byte("Eva")), Ben: big.NewInt(200)},
+		{Name: new(big.Int).SetBytes([]byte("Frank")), Ben: big.NewInt(900)},
+		{Name: new(big.Int).SetBytes([]byte("Grace")), Ben: big.NewInt(50)},
+		{Name: new(big.Int).SetBytes([]byte("Hannah")), Ben: big.NewInt(1200)},
+		{Name: new(big.Int).SetBytes([]byte("Isaac")), Ben: big.NewInt(180)},
+		{Name: new(big.Int).SetBytes([]byte("Jack")), Ben: big.NewInt(350)},
+		{Name: new(big.Int).SetBytes([]byte("Kathy")), Ben: big.NewInt(450)},
+		{Name: new(big.Int).SetBytes([]byte("Leo")), Ben: big.NewInt(600)},
+		{Name: new(big.Int).SetBytes([]byte("Mona")), Ben: big.NewInt(800)},
+		{Name: new(big.Int).SetBytes([]byte("Nina")), Ben: big.NewInt(150)},
+		{Name: new(big.Int).SetBytes([]byte("Oscar")), Ben: big.NewInt(1100)},
+		{Name: new(big.Int).SetBytes([]byte("Paul")), Ben: big.NewInt(950)},
+		{Name: new(big.Int).SetBytes([]byte("Quinn")), Ben: big.NewInt(300)},
+		{Name: new(big.Int).SetBytes([]byte("Rita")), Ben: big.NewInt(400)},
+		{Name: new(big.Int).SetBytes([]byte("Steve")), Ben: big.NewInt(550)},
+		{Name: new(big.Int).SetBytes([]byte("Tina")), Ben: big.NewInt(50)},
+		{Name: new(big.Int).SetBytes([]byte("Victor")), Ben: big.NewInt(720)},
+		{Name: new(big.Int).SetBytes([]byte("Wendy")), Ben: big.NewInt(670)},
+		{Name: new(big.Int).SetBytes([]byte("Xander")), Ben: big.NewInt(90)},
+		{Name: new(big.Int).SetBytes([]byte("Yara")), Ben: big.NewInt(1000)},
 	}
 
 	// Build the Merkle tree and retrieve the root
@@ -69,30 +69,30 @@ func TestMerkleProof(t *testing.T) {
 func TestMerkleUpdate(t *testing.T) {
 	// Initialize a list of users (same as TestMerkleProof for consistency)
 	users := []UserState{
-		{new(big.Int).SetBytes([]byte("Alice")), big.NewInt(100)},
-		{new(big.Int).SetBytes([]byte("Bob")), big.NewInt(340)},
-		{new(big.Int).SetBytes([]byte("Charlie")), big.NewInt(500)},
-		{new(big.Int).SetBytes([]byte("David")), big.NewInt(750)},
-		{new(big.Int).SetBytes([]byte("Eva")), big.NewInt(200)},
-		{new(big.Int).SetBytes([]byte("Frank")), big.NewInt(900)},
-		{new(big.Int).SetBytes([]byte("Grace")), big.NewInt(50)},
-		{new(big.Int).SetBytes([]byte("Hannah")), big.NewInt(1200)},
-		{new(big.Int).SetBytes([]byte("Isaac")), big.NewInt(180)},
-		{new(big.Int).SetBytes([]byte("Jack")), big.NewInt(350)},
-		{new(big.Int).SetBytes([]byte("Kathy")), big.NewInt(450)},
-		{new(big.Int).SetBytes([]byte("Leo")), big.NewInt(600)},
-		{new(big.Int).SetBytes([]byte("Mona")), big.NewInt(800)},
-		{new(big.Int).SetBytes([]byte("Nina")), big.NewInt(150)},
-		{new(big.Int).SetBytes([]byte("Oscar")), big.NewInt(1100)},
-		{new(big.Int).SetBytes([]byte("Paul")), big.NewInt(950)},
-		{new(big.Int).SetBytes([]byte("Quinn")), big.NewInt(300)},
-		{new(big.Int).SetBytes([]byte("Rita")), big.NewInt(400)},
-		{new(big.Int).SetBytes([]byte("Steve")), big.NewInt(550)},
-		{new(big.Int).SetBytes([]byte("Tina")), big.NewInt(50)},
-		{new(big.Int).SetBytes([]byte("Victor")), big.NewInt(720)},
-		{new(big.Int).SetBytes([]byte("Wendy")), big.NewInt(670)},
-		{new(big.Int).SetBytes([]byte("Xander")), big.NewInt(90)},
-		{new(big.Int).SetBytes([]byte("Yara")), big.NewInt(1000)},
+		{Name: new(big.Int).SetBytes([]byte("Alice")), Ben: big.NewInt(100)},
+		{Name: new(big.Int).SetBytes([]byte("Bob")), Ben: big.NewInt(340)},
+		{Name: new(big.Int).SetBytes([]byte("Charlie")), Ben: big.NewInt(500)},
+		{Name: new(big.Int).SetBytes([]byte("David")), Ben: big.NewInt(750)},
+		{Name: new(big.Int).SetBytes([]byte("Eva")), Ben: big.NewInt(200)},
+		{Name: new(big.Int).SetBytes([]byte("Frank")), Ben: big.NewInt(900)},
+		{Name: new(big.Int).SetBytes([]byte("Grace")), Ben: big.NewInt(50)},
+		{Name: new(big.Int).SetBytes([]byte("Hannah")), Ben: big.NewInt(1200)},
+		{Name: new(big.Int).SetBytes([]byte("Isaac")), Ben: big.NewInt(180)},
+		{Name: new(big.Int).SetBytes([]byte("Jack")), Ben: big.NewInt(350)},
+		{Name: new(big.Int).SetBytes([]byte("Kathy")), Ben: big.NewInt(450)},
+		{Name: new(big.Int).SetBytes([]byte("Leo")), Ben: big.NewInt(600)},
+		{Name: new(big.Int).SetBytes([]byte("Mona")), Ben: big.NewInt(800)},
+		{Name: new(big.Int).SetBytes([]byte("Nina")), Ben: big.NewInt(150)},
+		{Name: new(big.Int).SetBytes([]byte("Oscar")), Ben: big.NewInt(1100)},
+		{Name: new(big.Int).SetBytes([]byte("Paul")), Ben: big.NewInt(950)},
+		{Name: new(big.Int).SetBytes([]byte("Quinn")), Ben: big.NewInt(300)},
+		{Name: new(big.Int).SetBytes([]byte("Rita")), Ben: big.NewInt(400)},
+		{Name: new(big.Int).SetBytes([]byte("Steve")), Ben: big.NewInt(550)},
+		{Name: new(big.Int).SetBytes([]byte("Tina")), Ben: big.NewInt(50)},
+		{Name: new(big.Int).SetBytes([]byte("Victor")), Ben: big.NewInt(720)},
+		{Name: new(big.Int).SetBytes([]byte("Wendy")), Ben: big.NewInt(670)},
+		{Name: new(big.Int).SetBytes([]byte("Xander")), Ben: big.NewInt(90)},
+		{Name: new(big.Int).SetBytes([]byte("Yara")), Ben: big.NewInt(1000)},
 	}
 
 	// Build the initial Merkle tree
@@ -146,3 +146,54 @@ func TestMerkleUpdate(t *testing.T) {
 		t.Fatalf("Computed new root %v does not match actual new root %v", newRoot, actualNewRoot)
 	}
 }
+
+func TestMerkleProofAtDuplicateLeaves(t *testing.T) {
+	// Identical users hash to the same leaf, so only an index can tell them apart
+	users := make([]UserState, 8)
+	for i := range users {
+		users[i] = UserState{Name: big.NewInt(7), Ben: big.NewInt(0)}
+	}
+	root := BuildMerkleStates(users)
+
+	for index := range users {
+		proof, err := GenerateMerkleProofAt(users, index)
+		if err != nil {
+			t.Fatalf("GenerateMerkleProofAt(%d) failed: %v", index, err)
+		}
+		if !VerifyMerkleProof(root, HashUserState(users[index]), proof) {
+			t.Fatalf("proof for leaf %d does not verify", index)
+		}
+
+		// Updating through the proof must touch exactly this leaf
+		updated := append([]UserState(nil), users...)
+		updated[index] = UserState{Name: big.NewInt(7), Ben: big.NewInt(42)}
+		if got, want := UpdateMerkleRoot(proof, updated[index]), BuildMerkleStates(updated); got.Cmp(want) != 0 {
+			t.Fatalf("updating leaf %d through its proof gave root %s, want %s", index, got, want)
+		}
+	}
+
+	if _, err := GenerateMerkleProofAt(users, len(users)); err == nil {
+		t.Errorf("expected an error for an out-of-range index")
+	}
+}
+
+// TestHashAccountState checks that account leaves commit to the key and nonce of the account.
+func TestHashAccountState(t *testing.T) {
+	account := NewAccount(big.NewInt(7), big.NewInt(100))
+	plain := UserState{Name: big.NewInt(7), Ben: big.NewInt(100)}
+	if HashUserState(account).Cmp(HashUserState(plain)) == 0 {
+		t.Errorf("account leaf hashes like a leaf without key and nonce")
+	}
+
+	bumped := account
+	bumped.Nonce = big.NewInt(1)
+	if HashUserState(bumped).Cmp(HashUserState(account)) == 0 {
+		t.Errorf("leaf hash does not depend on the nonce")
+	}
+
+	rekeyed := account
+	rekeyed.PubKeyX = big.NewInt(1)
+	if HashUserState(rekeyed).Cmp(HashUserState(account)) == 0 {
+		t.Errorf("leaf hash does not depend on the public key")
+	}
+}
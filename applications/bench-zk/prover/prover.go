@@ -0,0 +1,407 @@
+// prover/prover.go
+
+package prover
+
+// Proof systems the operator can prove the rollup circuit with. Both work over rollup.Curve, so
+// the circuit and the public inputs committed to Layer 1 are the same whichever backend is used.
+// The aggregation circuit folding batch proofs is proven with Groth16 over aggregate.Curve.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/kzg"
+	kzgsrs "github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test/unsafekzg"
+
+	"github.com/weids-dev/benchains/circuits/aggregate"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// Supported backends.
+const (
+	Groth16 = "groth16" // R1CS, circuit-specific trusted setup, constant-size proofs
+	Plonk   = "plonk"   // SparseR1CS, universal KZG SRS, larger proofs
+
+	// Groth16BW6761 is Groth16 over aggregate.Curve, for the aggregation circuit only
+	Groth16BW6761 = "groth16-bw6761"
+)
+
+// File names used inside the key directory of a backend.
+const (
+	CircuitFile      = "circuit.cs"
+	ProvingKeyFile   = "proving.key"
+	VerifyingKeyFile = "verifying.key"
+)
+
+// Verifier checks proofs of one compiled circuit.
+type Verifier interface {
+	// Backend returns the name of the proof system: Groth16, Plonk or Groth16BW6761.
+	Backend() string
+	// Verify checks a serialized proof against the public inputs of publicAssignment.
+	Verify(proof []byte, publicAssignment frontend.Circuit) error
+	// VerifyingKey returns the serialized verifying key, as installed on Layer 1.
+	VerifyingKey() ([]byte, error)
+}
+
+// Prover generates proofs of one compiled circuit. It only reads its keys, so several proofs
+// can be generated at once.
+type Prover interface {
+	Verifier
+	// Prove generates a serialized proof for the full assignment of the circuit.
+	Prove(assignment frontend.Circuit) ([]byte, error)
+	// Fingerprint identifies the compiled circuit the keys belong to (see rollup.Fingerprint).
+	Fingerprint() (string, error)
+}
+
+// keyWriter is implemented by both provers, for Setup to write their files.
+type keyWriter interface {
+	keys() (ccs, pk, vk io.WriterTo)
+}
+
+// rawWriter is implemented by the proofs and verifying keys of both backends.
+type rawWriter interface {
+	WriteRawTo(w io.Writer) (int64, error)
+}
+
+// New compiles c for backend and runs its setup in memory. The PLONK SRS is generated with a
+// known secret, which is only acceptable for tests and benchmarks.
+func New(backend string, c frontend.Circuit) (Prover, error) {
+	ccs, err := Compile(backend, c)
+	if err != nil {
+		return nil, err
+	}
+	return FromCompiled(backend, ccs)
+}
+
+// Compile compiles c to the constraint system backend proves: R1CS for Groth16, SparseR1CS
+// for PLONK.
+func Compile(backend string, c frontend.Circuit) (constraint.ConstraintSystem, error) {
+	var builder frontend.NewBuilder
+	switch backend {
+	case Groth16, Groth16BW6761:
+		builder = r1cs.NewBuilder
+	case Plonk:
+		builder = scs.NewBuilder
+	default:
+		return nil, unknownBackend(backend)
+	}
+	ccs, err := frontend.Compile(curve(backend).ScalarField(), builder, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile ZK circuit: %w", err)
+	}
+	return ccs, nil
+}
+
+// FromCompiled runs the setup of backend in memory for a constraint system returned by
+// Compile, as New does.
+func FromCompiled(backend string, ccs constraint.ConstraintSystem) (Prover, error) {
+	return fromCompiled(backend, ccs, nil)
+}
+
+// fromCompiled runs the setup of backend for ccs, with srs for PLONK or, if nil, an SRS
+// generated with a known secret.
+func fromCompiled(backend string, ccs constraint.ConstraintSystem, srs *kzg.SRS) (Prover, error) {
+	switch backend {
+	case Groth16, Groth16BW6761:
+		pk, vk, err := groth16.Setup(ccs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup ZK proving/verifying keys: %w", err)
+		}
+		return &groth16Prover{curve: curve(backend), ccs: ccs, pk: pk, vk: vk}, nil
+	case Plonk:
+		canonical, lagrange, err := plonkSRS(ccs, srs)
+		if err != nil {
+			return nil, err
+		}
+		pk, vk, err := plonk.Setup(ccs, canonical, lagrange)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup ZK proving/verifying keys: %w", err)
+		}
+		return &plonkProver{ccs: ccs, pk: pk, vk: vk}, nil
+	default:
+		return nil, unknownBackend(backend)
+	}
+}
+
+// curve returns the curve backend proves over.
+func curve(backend string) ecc.ID {
+	if backend == Groth16BW6761 {
+		return aggregate.Curve
+	}
+	return rollup.Curve
+}
+
+// unknownBackend reports a backend that is none of the supported ones.
+func unknownBackend(backend string) error {
+	return fmt.Errorf("unknown proof backend %q (expected %q, %q or %q)", backend, Groth16, Plonk, Groth16BW6761)
+}
+
+// plonkSRS returns srs cut to the size of ccs, in canonical and Lagrange form. A nil srs is
+// generated with a known secret instead.
+func plonkSRS(ccs constraint.ConstraintSystem, srs *kzg.SRS) (canonical, lagrange kzgsrs.SRS, err error) {
+	if srs == nil {
+		if canonical, lagrange, err = unsafekzg.NewSRS(ccs); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate KZG SRS: %w", err)
+		}
+		return canonical, lagrange, nil
+	}
+
+	// The sizes unsafekzg.NewSRS generates: the domain of the constraints and public inputs,
+	// plus 3 points for the blinding of the canonical polynomials
+	size := ecc.NextPowerOfTwo(uint64(ccs.GetNbConstraints() + ccs.GetNbPublicVariables()))
+	if uint64(len(srs.Pk.G1)) < size+3 {
+		return nil, nil, fmt.Errorf("KZG SRS of %d points is too small for the circuit, which needs %d", len(srs.Pk.G1), size+3)
+	}
+	lagrangeG1, err := kzg.ToLagrangeG1(srs.Pk.G1[:size])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute the Lagrange form of the KZG SRS: %w", err)
+	}
+	canonical = &kzg.SRS{Pk: kzg.ProvingKey{G1: srs.Pk.G1[:size+3]}, Vk: srs.Vk}
+	lagrange = &kzg.SRS{Pk: kzg.ProvingKey{G1: lagrangeG1}, Vk: srs.Vk}
+	return canonical, lagrange, nil
+}
+
+// LoadSRS reads a KZG SRS over BLS12-377 as gnark-crypto serializes it (kzg.SRS.WriteTo), e.g. the
+// output of a powers-of-tau ceremony converted to that format.
+func LoadSRS(path string) (*kzg.SRS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open KZG SRS: %w", err)
+	}
+	defer f.Close()
+
+	var srs kzg.SRS
+	if _, err := srs.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("failed to read KZG SRS %s: %w", path, err)
+	}
+	return &srs, nil
+}
+
+// Setup compiles c for backend, runs its setup and writes the constraint system, proving key
+// and verifying key to the backend's directory inside keyDir (see Dir). PLONK is set up with
+// srs; a nil srs is generated with a known secret, with which anyone can forge proofs, so it is
+// only acceptable for tests and benchmarks.
+func Setup(backend string, c frontend.Circuit, keyDir string, srs *kzg.SRS) error {
+	ccs, err := Compile(backend, c)
+	if err != nil {
+		return err
+	}
+	p, err := fromCompiled(backend, ccs, srs)
+	if err != nil {
+		return err
+	}
+
+	dir := Dir(keyDir, backend)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	cs, pk, vk := p.(keyWriter).keys()
+	if err := writeTo(filepath.Join(dir, CircuitFile), cs); err != nil {
+		return err
+	}
+	if err := writeTo(filepath.Join(dir, ProvingKeyFile), pk); err != nil {
+		return err
+	}
+	return writeTo(filepath.Join(dir, VerifyingKeyFile), vk)
+}
+
+// Load reads the constraint system and keys written by Setup for backend.
+func Load(backend, keyDir string) (Prover, error) {
+	dir := Dir(keyDir, backend)
+	switch backend {
+	case Groth16, Groth16BW6761:
+		id := curve(backend)
+		ccs := groth16.NewCS(id)
+		pk := groth16.NewProvingKey(id)
+		vk := groth16.NewVerifyingKey(id)
+		if err := readAll(dir, ccs, pk, vk); err != nil {
+			return nil, err
+		}
+		return &groth16Prover{curve: id, ccs: ccs, pk: pk, vk: vk}, nil
+	case Plonk:
+		ccs := plonk.NewCS(rollup.Curve)
+		pk := plonk.NewProvingKey(rollup.Curve)
+		vk := plonk.NewVerifyingKey(rollup.Curve)
+		if err := readAll(dir, ccs, pk, vk); err != nil {
+			return nil, err
+		}
+		return &plonkProver{ccs: ccs, pk: pk, vk: vk}, nil
+	default:
+		return nil, unknownBackend(backend)
+	}
+}
+
+// LoadVerifier reads only the verifying key written by Setup for backend.
+func LoadVerifier(backend, keyDir string) (Verifier, error) {
+	path := filepath.Join(Dir(keyDir, backend), VerifyingKeyFile)
+	switch backend {
+	case Groth16, Groth16BW6761:
+		vk := groth16.NewVerifyingKey(curve(backend))
+		if err := readFrom(path, vk); err != nil {
+			return nil, err
+		}
+		return &groth16Prover{curve: curve(backend), vk: vk}, nil
+	case Plonk:
+		vk := plonk.NewVerifyingKey(rollup.Curve)
+		if err := readFrom(path, vk); err != nil {
+			return nil, err
+		}
+		return &plonkProver{vk: vk}, nil
+	default:
+		return nil, unknownBackend(backend)
+	}
+}
+
+// Dir returns the directory inside keyDir holding the files of backend.
+func Dir(keyDir, backend string) string {
+	return filepath.Join(keyDir, backend)
+}
+
+// groth16Prover proves and verifies with Groth16 over curve.
+type groth16Prover struct {
+	curve ecc.ID
+	ccs   constraint.ConstraintSystem
+	pk    groth16.ProvingKey
+	vk    groth16.VerifyingKey
+}
+
+func (p *groth16Prover) Backend() string {
+	if p.curve == aggregate.Curve {
+		return Groth16BW6761
+	}
+	return Groth16
+}
+
+func (p *groth16Prover) Prove(assignment frontend.Circuit) ([]byte, error) {
+	fullWitness, err := frontend.NewWitness(assignment, p.curve.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create witness: %w", err)
+	}
+	proof, err := groth16.Prove(p.ccs, p.pk, fullWitness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate proof: %w", err)
+	}
+	return serialize(proof)
+}
+
+func (p *groth16Prover) Verify(proofBytes []byte, publicAssignment frontend.Circuit) error {
+	proof := groth16.NewProof(p.curve)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return fmt.Errorf("failed to deserialize proof: %w", err)
+	}
+	publicWitness, err := frontend.NewWitness(publicAssignment, p.curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("failed to create public witness: %w", err)
+	}
+	if err := groth16.Verify(proof, p.vk, publicWitness); err != nil {
+		return fmt.Errorf("proof verification failed: %w", err)
+	}
+	return nil
+}
+
+func (p *groth16Prover) VerifyingKey() ([]byte, error) { return serialize(p.vk) }
+
+func (p *groth16Prover) Fingerprint() (string, error) { return rollup.Fingerprint(p.ccs) }
+
+func (p *groth16Prover) keys() (io.WriterTo, io.WriterTo, io.WriterTo) { return p.ccs, p.pk, p.vk }
+
+// plonkProver proves and verifies with PLONK over a KZG commitment scheme.
+type plonkProver struct {
+	ccs constraint.ConstraintSystem
+	pk  plonk.ProvingKey
+	vk  plonk.VerifyingKey
+}
+
+func (p *plonkProver) Backend() string { return Plonk }
+
+func (p *plonkProver) Prove(assignment frontend.Circuit) ([]byte, error) {
+	fullWitness, err := frontend.NewWitness(assignment, rollup.Curve.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create witness: %w", err)
+	}
+	proof, err := plonk.Prove(p.ccs, p.pk, fullWitness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate proof: %w", err)
+	}
+	return serialize(proof)
+}
+
+func (p *plonkProver) Verify(proofBytes []byte, publicAssignment frontend.Circuit) error {
+	proof := plonk.NewProof(rollup.Curve)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return fmt.Errorf("failed to deserialize proof: %w", err)
+	}
+	publicWitness, err := frontend.NewWitness(publicAssignment, rollup.Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("failed to create public witness: %w", err)
+	}
+	if err := plonk.Verify(proof, p.vk, publicWitness); err != nil {
+		return fmt.Errorf("proof verification failed: %w", err)
+	}
+	return nil
+}
+
+func (p *plonkProver) VerifyingKey() ([]byte, error) { return serialize(p.vk) }
+
+func (p *plonkProver) Fingerprint() (string, error) { return rollup.Fingerprint(p.ccs) }
+
+func (p *plonkProver) keys() (io.WriterTo, io.WriterTo, io.WriterTo) { return p.ccs, p.pk, p.vk }
+
+// serialize writes a proof or verifying key in gnark's raw (uncompressed) encoding, which is
+// faster to read back than the compressed one.
+func serialize(v rawWriter) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := v.WriteRawTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readAll reads the constraint system, proving key and verifying key from dir.
+func readAll(dir string, ccs, pk, vk io.ReaderFrom) error {
+	if err := readFrom(filepath.Join(dir, CircuitFile), ccs); err != nil {
+		return err
+	}
+	if err := readFrom(filepath.Join(dir, ProvingKeyFile), pk); err != nil {
+		return err
+	}
+	return readFrom(filepath.Join(dir, VerifyingKeyFile), vk)
+}
+
+func writeTo(path string, w io.WriterTo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := w.WriteTo(f); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func readFrom(path string, r io.ReaderFrom) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s (run setup first?): %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := r.ReadFrom(f); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// prover/prover_test.go
+
+package prover
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/kzg"
+	"github.com/consensys/gnark/frontend"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// transitionCircuit has the public inputs of the rollup circuits: the state moves from OldRoot
+// to NewRoot = OldRoot + Delta^2.
+type transitionCircuit struct {
+	OldRoot frontend.Variable `gnark:"oldRoot,public"`
+	NewRoot frontend.Variable `gnark:"newRoot,public"`
+	Delta   frontend.Variable `gnark:"delta"`
+}
+
+func (c *transitionCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Add(c.OldRoot, api.Mul(c.Delta, c.Delta)), c.NewRoot)
+	return nil
+}
+
+func TestBackends(t *testing.T) {
+	for _, backend := range []string{Groth16, Plonk} {
+		t.Run(backend, func(t *testing.T) {
+			keyDir := t.TempDir()
+			if err := Setup(backend, &transitionCircuit{}, keyDir, nil); err != nil {
+				t.Fatalf("Setup failed: %v", err)
+			}
+			p, err := Load(backend, keyDir)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if p.Backend() != backend {
+				t.Errorf("Backend() = %q", p.Backend())
+			}
+
+			proof, err := p.Prove(&transitionCircuit{OldRoot: 5, NewRoot: 14, Delta: 3})
+			if err != nil {
+				t.Fatalf("Prove failed: %v", err)
+			}
+
+			v, err := LoadVerifier(backend, keyDir)
+			if err != nil {
+				t.Fatalf("LoadVerifier failed: %v", err)
+			}
+			if err := v.Verify(proof, &transitionCircuit{OldRoot: 5, NewRoot: 14}); err != nil {
+				t.Errorf("valid proof rejected: %v", err)
+			}
+			if err := v.Verify(proof, &transitionCircuit{OldRoot: 5, NewRoot: 15}); err == nil {
+				t.Errorf("proof accepted for other public inputs")
+			}
+			if _, err := v.VerifyingKey(); err != nil {
+				t.Errorf("VerifyingKey failed: %v", err)
+			}
+
+			// The constraint system read back from keyDir is the one a fresh compilation gives
+			loaded, err := p.Fingerprint()
+			if err != nil {
+				t.Fatalf("Fingerprint failed: %v", err)
+			}
+			ccs, err := Compile(backend, &transitionCircuit{})
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			if compiled, err := rollup.Fingerprint(ccs); err != nil || compiled != loaded {
+				t.Errorf("Fingerprint of the loaded circuit %s, of a fresh compilation %s (err %v)", loaded, compiled, err)
+			}
+		})
+	}
+
+	if _, err := New("stark", &transitionCircuit{}); err == nil {
+		t.Errorf("unknown backend accepted")
+	}
+}
+
+// TestSetupWithSRS checks that PLONK is set up with an SRS read by LoadSRS, as a ceremony SRS
+// would be, and that an SRS too small for the circuit is rejected.
+func TestSetupWithSRS(t *testing.T) {
+	writeSRS := func(size uint64) string {
+		srs, err := kzg.NewSRS(size, big.NewInt(42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "srs.bin")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := srs.WriteTo(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	srs, err := LoadSRS(writeSRS(64))
+	if err != nil {
+		t.Fatalf("LoadSRS failed: %v", err)
+	}
+	keyDir := t.TempDir()
+	if err := Setup(Plonk, &transitionCircuit{}, keyDir, srs); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	p, err := Load(Plonk, keyDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	proof, err := p.Prove(&transitionCircuit{OldRoot: 5, NewRoot: 14, Delta: 3})
+	if err != nil {
+		t.Fatalf("Prove failed: %v", err)
+	}
+	if err := p.Verify(proof, &transitionCircuit{OldRoot: 5, NewRoot: 14}); err != nil {
+		t.Errorf("valid proof rejected: %v", err)
+	}
+
+	small, err := LoadSRS(writeSRS(4))
+	if err != nil {
+		t.Fatalf("LoadSRS failed: %v", err)
+	}
+	if err := Setup(Plonk, &transitionCircuit{}, t.TempDir(), small); err == nil {
+		t.Errorf("SRS too small for the circuit accepted")
+	}
+	if _, err := LoadSRS(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("missing SRS accepted")
+	}
+}
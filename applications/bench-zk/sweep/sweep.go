@@ -0,0 +1,263 @@
+// sweep/sweep.go
+
+package sweep
+
+// Parameter sweep of the rollup circuit: ProofMerkleCircuit is compiled, set up and proven for
+// every (depth, batch) point of a grid, to see how its costs scale with the size of the tree
+// and of the batch without editing the circuit.
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"runtime"
+	"strconv"
+	"time"
+
+	"bench-zk/merkle"
+	"bench-zk/prover"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// heapSampleInterval is how often the heap is sampled for the peak memory of a point.
+const heapSampleInterval = 20 * time.Millisecond
+
+// Point is one circuit size: a Merkle tree of 2^Depth leaves and batches of Batch transactions.
+type Point struct {
+	Depth int
+	Batch int
+}
+
+// Grid returns every combination of depths and batches, depth-major.
+func Grid(depths, batches []int) []Point {
+	points := make([]Point, 0, len(depths)*len(batches))
+	for _, d := range depths {
+		for _, b := range batches {
+			points = append(points, Point{Depth: d, Batch: b})
+		}
+	}
+	return points
+}
+
+// Result holds the costs measured for one point.
+type Result struct {
+	Point
+	Backend     string
+	Hasher      string
+	Constraints int
+	Compile     time.Duration
+	Setup       time.Duration
+	Prove       time.Duration
+	Verify      time.Duration
+	ProofBytes  int
+	PeakHeapMB  float64 // Peak heap while compiling, setting up and proving, sampled
+}
+
+// Run compiles ProofMerkleCircuit for p with hasher h, runs the setup of backend and proves
+// and verifies a full batch of signed deposits.
+func Run(p Point, backend string, h *hasher.Hasher) (*Result, error) {
+	if p.Depth < 1 || p.Batch < 1 {
+		return nil, fmt.Errorf("invalid circuit size: depth %d, batch %d", p.Depth, p.Batch)
+	}
+	assignment, err := Assignment(h, p.Depth, p.Batch)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Point: p, Backend: backend, Hasher: h.Name()}
+	heap := watchHeap()
+	defer heap.stop()
+
+	c := rollup.NewProofMerkleCircuit(p.Depth, p.Batch)
+	c.Hasher = h
+	start := time.Now()
+	ccs, err := prover.Compile(backend, c)
+	if err != nil {
+		return nil, err
+	}
+	res.Compile = time.Since(start)
+	res.Constraints = ccs.GetNbConstraints()
+
+	start = time.Now()
+	pr, err := prover.FromCompiled(backend, ccs)
+	if err != nil {
+		return nil, err
+	}
+	res.Setup = time.Since(start)
+
+	start = time.Now()
+	proof, err := pr.Prove(assignment)
+	if err != nil {
+		return nil, err
+	}
+	res.Prove = time.Since(start)
+	res.ProofBytes = len(proof)
+	res.PeakHeapMB = float64(heap.stop()) / (1 << 20)
+
+	start = time.Now()
+	publicAssignment := &rollup.ProofMerkleCircuit{OldRoot: assignment.OldRoot, NewRoot: assignment.NewRoot}
+	if err := pr.Verify(proof, publicAssignment); err != nil {
+		return nil, fmt.Errorf("failed to verify proof: %w", err)
+	}
+	res.Verify = time.Since(start)
+	return res, nil
+}
+
+// Assignment builds a full batch for ProofMerkleCircuit of the given size: batch signed
+// deposits, one per leaf in turn, on a tree of 2^depth registered accounts hashed with h.
+func Assignment(h *hasher.Hasher, depth, batch int) (*rollup.ProofMerkleCircuit, error) {
+	users := make([]merkle.UserState, 1<<depth)
+	keys := make([]*eddsa.PrivateKey, len(users)) // The sweep signs for the players
+	for i := range users {
+		key, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		pubKeyX, pubKeyY := rollup.PublicKey(key)
+		users[i] = merkle.NewRegisteredAccount(big.NewInt(int64(i+1)), big.NewInt(100), pubKeyX, pubKeyY)
+	}
+
+	assignment := rollup.NewProofMerkleCircuit(depth, batch)
+	assignment.OldRoot = merkle.BuildMerkleStatesWith(h, users)
+	for k := range assignment.Transactions {
+		index := k % len(users)
+		oldState := users[index]
+		benChange := big.NewInt(int64(k%10 + 1))
+
+		proof, err := merkle.GenerateMerkleProofAtWith(h, users, index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Merkle proof for transaction %d: %w", k, err)
+		}
+		key := keys[index]
+		pubKeyX, pubKeyY := rollup.PublicKey(key)
+		sig, err := rollup.Sign(key, oldState.Name, benChange, oldState.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign transaction %d: %w", k, err)
+		}
+
+		tx := &assignment.Transactions[k]
+		tx.OldName, tx.OldBalance, tx.NewName, tx.BenChange, tx.Enabled = oldState.Name, oldState.Ben, oldState.Name, benChange, 1
+		for i := range tx.Siblings {
+			tx.Siblings[i] = proof.Siblings[i]
+			if proof.PathBits[i] {
+				tx.PathBits[i] = 1
+			} else {
+				tx.PathBits[i] = 0
+			}
+		}
+		tx.OldPubKeyX, tx.OldPubKeyY, tx.Nonce = oldState.PubKeyX, oldState.PubKeyY, oldState.Nonce
+		tx.PubKey.A.X, tx.PubKey.A.Y = pubKeyX, pubKeyY
+		tx.Signature.R.X, tx.Signature.R.Y, tx.Signature.S = sig.RX, sig.RY, sig.S
+
+		users[index] = merkle.UserState{
+			Name:    oldState.Name,
+			Ben:     new(big.Int).Add(oldState.Ben, benChange),
+			PubKeyX: pubKeyX,
+			PubKeyY: pubKeyY,
+			Nonce:   new(big.Int).Add(oldState.Nonce, big.NewInt(1)),
+		}
+	}
+	assignment.NewRoot = merkle.BuildMerkleStatesWith(h, users)
+	return assignment, nil
+}
+
+// heapWatcher samples the heap in the background and keeps its peak.
+type heapWatcher struct {
+	done chan struct{}
+	peak chan uint64
+}
+
+// watchHeap collects garbage, so that earlier points do not count, and starts sampling.
+func watchHeap() *heapWatcher {
+	runtime.GC()
+	w := &heapWatcher{done: make(chan struct{}), peak: make(chan uint64, 1)}
+	go func() {
+		var stats runtime.MemStats
+		var peak uint64
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+			select {
+			case <-w.done:
+				w.peak <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return w
+}
+
+// stop stops sampling and returns the peak heap in bytes. It can be called more than once.
+func (w *heapWatcher) stop() uint64 {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	peak := <-w.peak
+	w.peak <- peak
+	return peak
+}
+
+// header is the first row written by Writer.
+var header = []string{"depth", "batch", "backend", "hasher", "constraints", "compile_ms", "setup_ms", "prove_ms", "verify_ms", "proof_bytes", "peak_heap_mb"}
+
+// Writer writes results as CSV, one row per point.
+type Writer struct {
+	csv *csv.Writer
+}
+
+// NewWriter writes the CSV header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{csv: csv.NewWriter(w)}
+	if err := cw.write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write appends the row of res and flushes it, so that a long sweep can be followed and an
+// interrupted one keeps the points already measured.
+func (w *Writer) Write(res *Result) error {
+	return w.write([]string{
+		strconv.Itoa(res.Depth),
+		strconv.Itoa(res.Batch),
+		res.Backend,
+		res.Hasher,
+		strconv.Itoa(res.Constraints),
+		millis(res.Compile),
+		millis(res.Setup),
+		millis(res.Prove),
+		millis(res.Verify),
+		strconv.Itoa(res.ProofBytes),
+		strconv.FormatFloat(res.PeakHeapMB, 'f', 1, 64),
+	})
+}
+
+func (w *Writer) write(record []string) error {
+	if err := w.csv.Write(record); err != nil {
+		return fmt.Errorf("failed to write CSV row: %w", err)
+	}
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV row: %w", err)
+	}
+	return nil
+}
+
+// millis formats d in milliseconds with microsecond precision.
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
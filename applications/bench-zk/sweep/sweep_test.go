@@ -0,0 +1,146 @@
+// sweep/sweep_test.go
+
+package sweep
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+
+	"bench-zk/prover"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+func TestGrid(t *testing.T) {
+	got := Grid([]int{2, 4}, []int{1, 8})
+	want := []Point{{2, 1}, {2, 8}, {4, 1}, {4, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Grid = %v, want %v", got, want)
+	}
+}
+
+// TestRun sweeps two tiny circuit sizes and checks the CSV they produce.
+func TestRun(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	var constraints []int
+	for _, p := range Grid([]int{2}, []int{1, 2}) {
+		res, err := Run(p, prover.Groth16, hasher.MiMC)
+		if err != nil {
+			t.Fatalf("Run(%v) failed: %v", p, err)
+		}
+		if res.ProofBytes == 0 || res.Prove <= 0 || res.PeakHeapMB <= 0 {
+			t.Errorf("Run(%v): missing measurements in %+v", p, res)
+		}
+		constraints = append(constraints, res.Constraints)
+		if err := w.Write(res); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if constraints[1] <= constraints[0] {
+		t.Errorf("a batch of 2 has %d constraints, a batch of 1 has %d", constraints[1], constraints[0])
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], header) {
+		t.Fatalf("Unexpected CSV rows: %v", rows)
+	}
+	if rows[2][0] != "2" || rows[2][1] != "2" || rows[2][2] != prover.Groth16 || rows[2][3] != "mimc" {
+		t.Errorf("Unexpected row: %v", rows[2])
+	}
+
+	if _, err := Run(Point{Depth: 0, Batch: 1}, prover.Groth16, hasher.MiMC); err == nil {
+		t.Errorf("depth 0 accepted")
+	}
+}
+
+// BenchmarkProofMerkleCircuitBackends compares the proof systems of package prover on a full
+// ProofMerkleCircuit batch: proving time, proof size and verification time, the latter two
+// being what Layer 1 pays for every committed block.
+func BenchmarkProofMerkleCircuitBackends(b *testing.B) {
+	assignment, err := Assignment(hasher.MiMC, rollup.D2, rollup.B2)
+	if err != nil {
+		b.Fatalf("Failed to build the batch: %v", err)
+	}
+	publicAssignment := rollup.ProofMerkleCircuit{OldRoot: assignment.OldRoot, NewRoot: assignment.NewRoot}
+
+	for _, backend := range []string{prover.Groth16, prover.Plonk} {
+		b.Run(backend, func(b *testing.B) {
+			p, err := prover.New(backend, rollup.NewProofMerkleCircuit(rollup.D2, rollup.B2))
+			if err != nil {
+				b.Fatalf("Failed to setup %s: %v", backend, err)
+			}
+
+			var prove, verify time.Duration
+			var proofBytes int
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				proof, err := p.Prove(assignment)
+				if err != nil {
+					b.Fatalf("Failed to generate proof: %v", err)
+				}
+				prove += time.Since(start)
+
+				start = time.Now()
+				if err := p.Verify(proof, &publicAssignment); err != nil {
+					b.Fatalf("Failed to verify proof: %v", err)
+				}
+				verify += time.Since(start)
+				proofBytes = len(proof)
+			}
+			b.ReportMetric(float64(prove.Milliseconds())/float64(b.N), "prove-ms/op")
+			b.ReportMetric(float64(verify.Microseconds())/1000/float64(b.N), "verify-ms/op")
+			b.ReportMetric(float64(proofBytes), "proof-bytes")
+		})
+	}
+}
+
+// BenchmarkProofMerkleCircuitHashers compares the hashers of package hasher on a full
+// ProofMerkleCircuit batch: constraint count and Groth16 proving time.
+func BenchmarkProofMerkleCircuitHashers(b *testing.B) {
+	for _, h := range hasher.All {
+		b.Run(h.Name(), func(b *testing.B) {
+			ccs, err := frontend.Compile(rollup.Curve.ScalarField(), r1cs.NewBuilder, rollupCircuit(h))
+			if err != nil {
+				b.Fatalf("Failed to compile circuit: %v", err)
+			}
+			p, err := prover.New(prover.Groth16, rollupCircuit(h))
+			if err != nil {
+				b.Fatalf("Failed to setup: %v", err)
+			}
+
+			assignment, err := Assignment(h, rollup.D2, rollup.B2)
+			if err != nil {
+				b.Fatalf("Failed to build the batch: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Prove(assignment); err != nil {
+					b.Fatalf("Failed to generate proof: %v", err)
+				}
+			}
+			b.ReportMetric(float64(ccs.GetNbConstraints()), "constraints")
+		})
+	}
+}
+
+// rollupCircuit returns a ProofMerkleCircuit of the default size built with h.
+func rollupCircuit(h *hasher.Hasher) *rollup.ProofMerkleCircuit {
+	c := rollup.NewProofMerkleCircuit(rollup.D2, rollup.B2)
+	c.Hasher = h
+	return c
+}
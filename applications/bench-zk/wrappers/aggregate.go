@@ -0,0 +1,160 @@
+// wrappers/aggregate.go
+
+package wrappers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strconv"
+
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+	"bench-zk/prover"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+
+	"github.com/weids-dev/benchains/circuits/aggregate"
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// aggregateCircuit names the aggregation circuit in the circuit IDs of ZKContract's verifier
+// registry, followed by its number of slots.
+const aggregateCircuit = "AggregationCircuit"
+
+// Index of each rollup circuit among the inner circuits of the aggregation circuit.
+const (
+	rollupCircuitIndex = iota
+	transferCircuitIndex
+)
+
+// Aggregator folds the Groth16 batch proofs of a block into one proof of the aggregation circuit
+// (see package aggregate), so that Layer 1 verifies one proof however many batches the block
+// was split into.
+type Aggregator struct {
+	Slots  int           // Most batch proofs folded into one proof
+	Prover prover.Prover // Proves the aggregation circuit of Slots slots with prover.Groth16BW6761
+}
+
+// AggregationCircuitID returns the ID under which ZKContract verifies aggregated proofs of slots
+// proofs of the rollup circuits built with h.
+func AggregationCircuitID(h *hasher.Hasher, slots int) string {
+	return namedCircuitID(aggregateCircuit+"/"+strconv.Itoa(slots), h, prover.Groth16BW6761)
+}
+
+// aggregationCircuitID returns the circuit ID the operator's aggregated proofs are verified
+// under on Layer 1.
+func (w *Wrappers) aggregationCircuitID() string {
+	return AggregationCircuitID(w.stateHasher(), w.Aggregator.Slots)
+}
+
+// aggregateKeyDir returns the directory inside keyDir holding the keys of the aggregation
+// circuit of slots slots, for the rollup circuits built with h.
+func aggregateKeyDir(keyDir string, h *hasher.Hasher, slots int) string {
+	return filepath.Join(circuitKeyDir(keyDir, h), "aggregate", strconv.Itoa(slots))
+}
+
+// SetupAggregator compiles the aggregation circuit of slots slots for the Groth16 keys written
+// by SetupKeys for h, runs its setup and writes its keys to keyDir. The inner verifying keys are
+// compiled into the circuit, so it must be set up again whenever SetupKeys is re-run.
+func SetupAggregator(keyDir string, h *hasher.Hasher, slots int) error {
+	inner := make([]prover.Verifier, 2)
+	var err error
+	if inner[rollupCircuitIndex], err = prover.LoadVerifier(prover.Groth16, circuitKeyDir(keyDir, h)); err != nil {
+		return err
+	}
+	if inner[transferCircuitIndex], err = prover.LoadVerifier(prover.Groth16, transferKeyDir(keyDir, h)); err != nil {
+		return err
+	}
+	c, err := newAggregationCircuit(inner, slots)
+	if err != nil {
+		return err
+	}
+	return prover.Setup(prover.Groth16BW6761, c, aggregateKeyDir(keyDir, h, slots), nil)
+}
+
+// LoadAggregator reads the aggregation circuit and keys written by SetupAggregator.
+func LoadAggregator(keyDir string, h *hasher.Hasher, slots int) (*Aggregator, error) {
+	p, err := prover.Load(prover.Groth16BW6761, aggregateKeyDir(keyDir, h, slots))
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregator{Slots: slots, Prover: p}, nil
+}
+
+// newAggregationCircuit returns the aggregation circuit of slots slots verifying proofs of the
+// inner circuits, indexed by rollupCircuitIndex and transferCircuitIndex.
+func newAggregationCircuit(inner []prover.Verifier, slots int) (*aggregate.Circuit, error) {
+	vks := make([]groth16.VerifyingKey, len(inner))
+	for i, v := range inner {
+		if v.Backend() != prover.Groth16 {
+			return nil, fmt.Errorf("only %s proofs can be aggregated, not %s", prover.Groth16, v.Backend())
+		}
+		raw, err := v.VerifyingKey()
+		if err != nil {
+			return nil, err
+		}
+		vks[i] = groth16.NewVerifyingKey(rollup.Curve)
+		if _, err := vks[i].ReadFrom(bytes.NewReader(raw)); err != nil {
+			return nil, fmt.Errorf("failed to read the verifying key of inner circuit %d: %w", i, err)
+		}
+	}
+	return aggregate.NewCircuit(vks, slots)
+}
+
+// aggregate proves with one proof that the batches of a block, proven by proofs, chain the state
+// from the old root of the block to its new root, and verifies it locally.
+func (w *Wrappers) aggregate(batches []proofBatch, proofs [][]byte) (*gateway.AggregatedProof, error) {
+	inner := make([]aggregate.Batch, len(batches))
+	for i, batch := range batches {
+		proof := groth16.NewProof(rollup.Curve)
+		if _, err := proof.ReadFrom(bytes.NewReader(proofs[i])); err != nil {
+			return nil, fmt.Errorf("failed to read the proof of batch %d: %w", i, err)
+		}
+		index := rollupCircuitIndex
+		if batch.transfer {
+			index = transferCircuitIndex
+		}
+		inner[i] = aggregate.Batch{Circuit: index, OldRoot: batch.oldRoot, NewRoot: batch.newRoot, Proof: proof}
+	}
+
+	slots := w.Aggregator.Slots
+	assignment, err := aggregate.Assign(inner, slots)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := w.proveAssignment(w.Aggregator.Prover, assignment)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := aggregate.Roots(inner, slots)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyAggregatedProof(w.Aggregator.Prover, proof, roots); err != nil {
+		return nil, err
+	}
+
+	encodedRoots := make([]string, len(roots))
+	for i, root := range roots {
+		encodedRoots[i] = merkle.MerkleRootToBase64(root)
+	}
+	return &gateway.AggregatedProof{
+		Roots:     encodedRoots,
+		Proof:     base64.StdEncoding.EncodeToString(proof),
+		CircuitID: w.aggregationCircuitID(),
+	}, nil
+}
+
+// verifyAggregatedProof checks a serialized aggregated proof chaining the state through roots.
+func verifyAggregatedProof(v prover.Verifier, proofBytes []byte, roots []*big.Int) error {
+	publicAssignment := aggregate.Circuit{Roots: make([]frontend.Variable, len(roots))}
+	for i, root := range roots {
+		publicAssignment.Roots[i] = root
+	}
+	return v.Verify(proofBytes, &publicAssignment)
+}
@@ -0,0 +1,284 @@
+// wrappers/aggregate_test.go
+package wrappers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+	"bench-zk/prover"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// aggregationRollup is the operator state of an aggregation test, proving with rollup circuits
+// of one transition per proof, so that each state change of a block is a batch of its own.
+type aggregationRollup struct {
+	w        *Wrappers
+	players  *testPlayers
+	balances map[int64]int64
+}
+
+// newAggregationRollup sets up the small rollup circuits and funds players 100 and 101.
+func newAggregationRollup(tb testing.TB) *aggregationRollup {
+	tb.Helper()
+	batch, transfer := batchSize, transferSize
+	batchSize, transferSize = 1, 1
+	tb.Cleanup(func() { batchSize, transferSize = batch, transfer })
+
+	p, err := prover.New(prover.Groth16, newRollupCircuit(hasher.MiMC))
+	if err != nil {
+		tb.Fatalf("Failed to setup ProofMerkleCircuit: %v", err)
+	}
+	tp, err := prover.New(prover.Groth16, newTransferCircuit(hasher.MiMC))
+	if err != nil {
+		tb.Fatalf("Failed to setup TransferCircuit: %v", err)
+	}
+
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	r := &aggregationRollup{
+		w: &Wrappers{
+			UserStates:     users,
+			StateRoots:     []string{genesis},
+			LatestRoot:     1,
+			LatestRootHash: genesis,
+			Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+			Prover:         p,
+			TransferProver: tp,
+		},
+		players:  newTestPlayers(tb),
+		balances: map[int64]int64{100: 1000, 101: 1000},
+	}
+	fund := blocks.Transaction{TxID: "fund", Writes: []blocks.Write{
+		playerWrite(tb, "pasic", r.players.signed(100, 1000, 1000)),
+		playerWrite(tb, "pasic", r.players.signed(101, 1000, 1000)),
+	}}
+	if err := r.w.processTransactions([]blocks.Transaction{fund}); err != nil {
+		tb.Fatalf("processTransactions failed: %v", err)
+	}
+	if _, err := r.w.buildAssignments(); err != nil {
+		tb.Fatalf("buildAssignments failed: %v", err)
+	}
+	return r
+}
+
+// aggregator sets up the aggregation circuit of slots slots for the rollup circuits.
+func (r *aggregationRollup) aggregator(tb testing.TB, slots int) (a *Aggregator, constraints int) {
+	tb.Helper()
+	c, err := newAggregationCircuit([]prover.Verifier{r.w.Prover, r.w.TransferProver}, slots)
+	if err != nil {
+		tb.Fatalf("newAggregationCircuit failed: %v", err)
+	}
+	ccs, err := prover.Compile(prover.Groth16BW6761, c)
+	if err != nil {
+		tb.Fatalf("Failed to compile the aggregation circuit: %v", err)
+	}
+	p, err := prover.FromCompiled(prover.Groth16BW6761, ccs)
+	if err != nil {
+		tb.Fatalf("Failed to setup the aggregation circuit: %v", err)
+	}
+	return &Aggregator{Slots: slots, Prover: p}, ccs.GetNbConstraints()
+}
+
+// block applies a block of n state changes, alternating transfers from player 100 to player 101
+// and exchanges of player 101, and returns its batches, one per change.
+func (r *aggregationRollup) block(tb testing.TB, n int) []proofBatch {
+	tb.Helper()
+	var txs []blocks.Transaction
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			txs = append(txs, transferTx(tb, r.players, r.balances, 100, 101, int64(i+1)))
+			continue
+		}
+		r.balances[101] += 5
+		txs = append(txs, blocks.Transaction{TxID: fmt.Sprintf("exchange-%d", i), Writes: []blocks.Write{
+			playerWrite(tb, "pasic", r.players.signed(101, r.balances[101], 5)),
+		}})
+	}
+	if err := r.w.processTransactions(txs); err != nil {
+		tb.Fatalf("processTransactions failed: %v", err)
+	}
+	batches, err := r.w.buildAssignments()
+	if err != nil {
+		tb.Fatalf("buildAssignments failed: %v", err)
+	}
+	if len(batches) != n {
+		tb.Fatalf("expected %d batches, got %d", n, len(batches))
+	}
+	return batches
+}
+
+// prove runs the prover stage of the pipeline on a block of batches.
+func (r *aggregationRollup) prove(tb testing.TB, blockNumber uint64, batches []proofBatch) *blockJob {
+	tb.Helper()
+	in, out := make(chan *blockJob, 1), make(chan *blockJob, 1)
+	in <- &blockJob{blockNumber: blockNumber, batches: batches}
+	close(in)
+	if err := r.w.proveJobs(context.Background(), in, out); err != nil {
+		tb.Fatalf("proveJobs failed: %v", err)
+	}
+	return <-out
+}
+
+// TestAggregateRealBatches proves a block split into a TransferCircuit batch and a
+// ProofMerkleCircuit batch, checks that the prover stage folds both proofs into the aggregated
+// proof verify-block accepts, and that blocks of more batches than slots fall back to a proof
+// chain.
+func TestAggregateRealBatches(t *testing.T) {
+	if testing.Short() {
+		t.Skip("sets up and proves the rollup and aggregation circuits")
+	}
+	r := newAggregationRollup(t)
+	r.w.Aggregator, _ = r.aggregator(t, 2)
+	if err := r.w.CheckCircuit(false); err != nil {
+		t.Fatalf("CheckCircuit failed: %v", err)
+	}
+
+	oldRoot := r.w.LatestRootHash
+	batches := r.block(t, 2)
+	newRoot := r.w.LatestRootHash
+	if !batches[0].transfer || batches[1].transfer {
+		t.Fatalf("expected a transfer batch and a state change batch")
+	}
+
+	job := r.prove(t, 3, batches)
+	agg := job.aggregated
+	if agg == nil {
+		t.Fatalf("the proofs of a block of 2 batches were not aggregated")
+	}
+	if len(agg.Roots) != 3 || agg.Roots[0] != oldRoot || agg.Roots[1] != merkle.MerkleRootToBase64(batches[0].newRoot) || agg.Roots[2] != newRoot {
+		t.Errorf("aggregated proof does not chain the roots of the batches: %v", agg.Roots)
+	}
+	if want := AggregationCircuitID(hasher.MiMC, 2); agg.CircuitID != want {
+		t.Errorf("aggregated proof committed under %s, want %s", agg.CircuitID, want)
+	}
+
+	// verify-block checks the aggregated proof as ZKContract:QueryAggregatedProof returns it
+	aggJSON, err := json.Marshal(agg)
+	if err != nil {
+		t.Fatalf("failed to marshal the aggregated proof: %v", err)
+	}
+	notFound := status.Error(codes.Unknown, "chaincode response 500, not found for block 3")
+	zk := gateway.NewZKClient(&zkLedger{
+		roots:   map[string]string{"2": oldRoot, "3": newRoot},
+		errs:    map[string]error{"ZKContract:QueryProof": notFound, "ZKContract:QueryProofChain": notFound},
+		results: map[string]string{"ZKContract:QueryAggregatedProof": string(aggJSON)},
+	})
+	res, err := verifyBlock(zk, Verifiers{agg.CircuitID: r.w.Aggregator.Prover}, 3)
+	if err != nil {
+		t.Fatalf("verifyBlock failed: %v", err)
+	}
+	if !res.HasProof || res.Proofs != 1 || res.Aggregated != 2 || res.Backend != prover.Groth16BW6761 {
+		t.Errorf("unexpected verification %+v", res)
+	}
+
+	// The proof only holds for the roots it chains
+	forged := *agg
+	forged.Roots = []string{oldRoot, oldRoot, newRoot}
+	forgedJSON, _ := json.Marshal(forged)
+	zk = gateway.NewZKClient(&zkLedger{
+		roots:   map[string]string{"2": oldRoot, "3": newRoot},
+		errs:    map[string]error{"ZKContract:QueryProof": notFound, "ZKContract:QueryProofChain": notFound},
+		results: map[string]string{"ZKContract:QueryAggregatedProof": string(forgedJSON)},
+	})
+	if _, err := verifyBlock(zk, Verifiers{agg.CircuitID: r.w.Aggregator.Prover}, 3); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Errorf("aggregated proof with a forged intermediate root returned %v", err)
+	}
+
+	job = r.prove(t, 4, r.block(t, 3))
+	if job.aggregated != nil || len(job.proofs) != 3 {
+		t.Errorf("a block of more batches than slots must be committed as a proof chain")
+	}
+}
+
+// BenchmarkAggregate compares, for blocks of N real batches, what Layer 1 pays to verify the N
+// batch proofs of a proof chain with what it pays for one aggregated proof, and what the
+// operator pays to aggregate them.
+func BenchmarkAggregate(b *testing.B) {
+	for _, n := range []int{2, 4} {
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			r := newAggregationRollup(b)
+			a, constraints := r.aggregator(b, n)
+			r.w.Aggregator = a
+
+			batches := r.block(b, n)
+			proofs := make([][]byte, n)
+			var separateBytes int
+			for i, batch := range batches {
+				p := r.w.Prover
+				if batch.transfer {
+					p = r.w.TransferProver
+				}
+				proof, err := p.Prove(batch.assignment)
+				if err != nil {
+					b.Fatalf("Failed to prove batch %d: %v", i, err)
+				}
+				proofs[i] = proof
+				separateBytes += len(proof)
+			}
+
+			var aggregation, separate, aggregated time.Duration
+			var aggregatedBytes int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				agg, err := r.w.aggregate(batches, proofs)
+				if err != nil {
+					b.Fatalf("Failed to aggregate: %v", err)
+				}
+				aggregation += time.Since(start)
+
+				start = time.Now()
+				for k, batch := range batches {
+					p := r.w.Prover
+					if batch.transfer {
+						p = r.w.TransferProver
+					}
+					if err := verifyRootProof(p, proofs[k], batch.oldRoot, batch.newRoot); err != nil {
+						b.Fatalf("Failed to verify batch %d: %v", k, err)
+					}
+				}
+				separate += time.Since(start)
+
+				proof, err := base64.StdEncoding.DecodeString(agg.Proof)
+				if err != nil {
+					b.Fatalf("Failed to decode the aggregated proof: %v", err)
+				}
+				roots := make([]*big.Int, len(agg.Roots))
+				for k, root := range agg.Roots {
+					if roots[k], err = rootFromBase64(root); err != nil {
+						b.Fatal(err)
+					}
+				}
+				start = time.Now()
+				if err := verifyAggregatedProof(a.Prover, proof, roots); err != nil {
+					b.Fatalf("Failed to verify the aggregated proof: %v", err)
+				}
+				aggregated += time.Since(start)
+				aggregatedBytes = len(proof)
+			}
+			b.ReportMetric(float64(constraints), "constraints")
+			b.ReportMetric(aggregation.Seconds()/float64(b.N), "aggregate-s/op")
+			b.ReportMetric(float64(separate.Microseconds())/1000/float64(b.N), "separate-verify-ms/op")
+			b.ReportMetric(float64(aggregated.Microseconds())/1000/float64(b.N), "aggregated-verify-ms/op")
+			b.ReportMetric(float64(separateBytes), "separate-bytes")
+			b.ReportMetric(float64(aggregatedBytes), "aggregated-bytes")
+		})
+	}
+}
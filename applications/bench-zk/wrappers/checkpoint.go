@@ -0,0 +1,44 @@
+// wrappers/checkpoint.go
+
+package wrappers
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// checkpointer records the last Layer 2 block the operator committed, and tells the block
+// event stream where to resume.
+type checkpointer interface {
+	client.Checkpoint
+	CheckpointBlock(blockNumber uint64) error
+	Close() error
+}
+
+// newCheckpointer opens a file checkpointer at path, or an in-memory one if path is empty.
+func newCheckpointer(path string) (checkpointer, error) {
+	if path == "" {
+		return &memoryCheckpointer{}, nil
+	}
+
+	fileCheckpointer, err := client.NewFileCheckpointer(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint %s: %w", path, err)
+	}
+	return fileCheckpointer, nil
+}
+
+// memoryCheckpointer adapts client.InMemoryCheckpointer to the checkpointer interface.
+type memoryCheckpointer struct {
+	client.InMemoryCheckpointer
+}
+
+func (c *memoryCheckpointer) CheckpointBlock(blockNumber uint64) error {
+	c.InMemoryCheckpointer.CheckpointBlock(blockNumber)
+	return nil
+}
+
+func (c *memoryCheckpointer) Close() error {
+	return nil
+}
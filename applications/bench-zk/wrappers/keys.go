@@ -0,0 +1,237 @@
+// wrappers/keys.go
+
+package wrappers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"bench-zk/gateway"
+	"bench-zk/prover"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/kzg"
+	"github.com/consensys/gnark/frontend"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// Names of the rollup circuits in the circuit IDs of ZKContract's verifier registry.
+const (
+	rollupCircuit   = "ProofMerkleCircuit"
+	transferCircuit = "TransferCircuit"
+)
+
+// CircuitID returns the ID under which ZKContract verifies proofs of ProofMerkleCircuit built
+// with h and generated with backend. MiMC circuits keep the IDs they had before the hash
+// function could be chosen.
+func CircuitID(h *hasher.Hasher, backend string) string {
+	return namedCircuitID(rollupCircuit, h, backend)
+}
+
+// TransferCircuitID returns the ID under which ZKContract verifies proofs of TransferCircuit
+// built with h and generated with backend.
+func TransferCircuitID(h *hasher.Hasher, backend string) string {
+	return namedCircuitID(transferCircuit, h, backend)
+}
+
+// namedCircuitID returns the circuit ID of the rollup circuit name built with h and proven with
+// backend.
+func namedCircuitID(name string, h *hasher.Hasher, backend string) string {
+	if h == hasher.MiMC {
+		return name + "/" + backend
+	}
+	return name + "/" + h.Name() + "/" + backend
+}
+
+// circuitID returns the circuit ID the operator's ProofMerkleCircuit proofs are verified under
+// on Layer 1.
+func (w *Wrappers) circuitID() string {
+	return CircuitID(w.stateHasher(), w.Prover.Backend())
+}
+
+// transferCircuitID returns the circuit ID the operator's TransferCircuit proofs are verified
+// under on Layer 1.
+func (w *Wrappers) transferCircuitID() string {
+	return TransferCircuitID(w.stateHasher(), w.TransferProver.Backend())
+}
+
+// circuitKeyDir returns the directory inside keyDir holding the keys of the circuit built with
+// h: keyDir itself for MiMC and a subdirectory named after the hasher otherwise. Each proof
+// backend then has its own subdirectory (see prover.Dir).
+func circuitKeyDir(keyDir string, h *hasher.Hasher) string {
+	if h == hasher.MiMC {
+		return keyDir
+	}
+	return filepath.Join(keyDir, h.Name())
+}
+
+// transferKeyDir returns the directory inside keyDir holding the keys of the TransferCircuit
+// built with h, next to those of ProofMerkleCircuit.
+func transferKeyDir(keyDir string, h *hasher.Hasher) string {
+	return filepath.Join(circuitKeyDir(keyDir, h), "transfer")
+}
+
+// SetupKeys compiles ProofMerkleCircuit and TransferCircuit with h, runs the setup of backend
+// and writes the constraint systems, proving keys and verifying keys to keyDir. PLONK is set up
+// with srs, or with an SRS of known secret if nil (see prover.Setup). The same keys must be used
+// by init-l1 (which installs the verifying keys on Layer 1) and by every later run of the operator.
+func SetupKeys(keyDir, backend string, h *hasher.Hasher, srs *kzg.SRS) error {
+	if err := prover.Setup(backend, newRollupCircuit(h), circuitKeyDir(keyDir, h), srs); err != nil {
+		return err
+	}
+	return prover.Setup(backend, newTransferCircuit(h), transferKeyDir(keyDir, h), srs)
+}
+
+// newRollupCircuit returns the ProofMerkleCircuit the operator proves blocks with.
+func newRollupCircuit(h *hasher.Hasher) *rollup.ProofMerkleCircuit {
+	c := rollup.NewProofMerkleCircuit(rollup.D2, batchSize)
+	c.Hasher = h
+	return c
+}
+
+// newTransferCircuit returns the TransferCircuit the operator proves the transfers of blocks with.
+func newTransferCircuit(h *hasher.Hasher) *rollup.TransferCircuit {
+	c := rollup.NewTransferCircuit(rollup.D2, transferSize)
+	c.Hasher = h
+	return c
+}
+
+// CircuitFingerprint compiles ProofMerkleCircuit with h for backend, as this build of the
+// operator defines it, and returns the fingerprint of its constraint system.
+func CircuitFingerprint(backend string, h *hasher.Hasher) (string, error) {
+	return fingerprint(backend, newRollupCircuit(h))
+}
+
+// fingerprint compiles c for backend and returns the fingerprint of its constraint system.
+func fingerprint(backend string, c frontend.Circuit) (string, error) {
+	ccs, err := prover.Compile(backend, c)
+	if err != nil {
+		return "", err
+	}
+	return rollup.Fingerprint(ccs)
+}
+
+// operatorCircuit is a circuit the operator proves, with its prover and its circuit ID on Layer 1.
+type operatorCircuit struct {
+	id      string
+	prover  prover.Prover
+	circuit frontend.Circuit // As this build of the operator compiles it
+}
+
+// circuits returns the circuits the operator proves blocks with, including the aggregation
+// circuit if it aggregates batch proofs.
+func (w *Wrappers) circuits() ([]operatorCircuit, error) {
+	circuits := []operatorCircuit{
+		{w.circuitID(), w.Prover, newRollupCircuit(w.stateHasher())},
+		{w.transferCircuitID(), w.TransferProver, newTransferCircuit(w.stateHasher())},
+	}
+	if w.Aggregator == nil {
+		return circuits, nil
+	}
+	inner := make([]prover.Verifier, 2)
+	inner[rollupCircuitIndex], inner[transferCircuitIndex] = w.Prover, w.TransferProver
+	c, err := newAggregationCircuit(inner, w.Aggregator.Slots)
+	if err != nil {
+		return nil, err
+	}
+	return append(circuits, operatorCircuit{w.aggregationCircuitID(), w.Aggregator.Prover, c}), nil
+}
+
+// CheckCircuit checks that the operator's keys were set up for the circuits this build of the
+// operator compiles and, with l1, that ZKContract on Layer 1 verifies proofs of those circuits
+// with the operator's verifying keys (see registerVerifier). Proofs of a circuit that drifted
+// from its verifying key are rejected by Layer 1 without telling why, so the operator refuses to
+// start instead.
+func (w *Wrappers) CheckCircuit(l1 bool) error {
+	circuits, err := w.circuits()
+	if err != nil {
+		return err
+	}
+	fingerprints := make([]string, 0, len(circuits))
+	for _, c := range circuits {
+		compiled, err := fingerprint(c.prover.Backend(), c.circuit)
+		if err != nil {
+			return err
+		}
+		keys, err := c.prover.Fingerprint()
+		if err != nil {
+			return err
+		}
+		if keys != compiled {
+			return fmt.Errorf("the keys of %s were set up for constraint system %s, but the circuit compiles to %s: re-run setup, and init-l1 on a new Layer 1 ledger", c.id, keys, compiled)
+		}
+		fingerprints = append(fingerprints, compiled)
+	}
+	if !l1 {
+		return nil
+	}
+
+	if _, err := w.zk().QueryCircuitID(); err != nil {
+		return fmt.Errorf("failed to query the circuit of the rollup on Layer 1 (run init-l1 first?): %w", err)
+	}
+	for i, c := range circuits {
+		if err := w.registerVerifier(c.id, c.prover, fingerprints[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerVerifier makes sure ZKContract verifies the proofs committed under circuitID with the
+// verifying key of p, set up for the constraint system of fingerprint. A verifier registered
+// under circuitID must have the backend and fingerprint of p; a missing one is registered with
+// RegisterVerifier, which only the admin of the rollup may call.
+func (w *Wrappers) registerVerifier(circuitID string, p prover.Prover, fingerprint string) error {
+	zk := w.zk()
+	backend, err := zk.QueryVerifierBackend(circuitID)
+	if gateway.IsNotFound(err) {
+		vk, err := p.VerifyingKey()
+		if err != nil {
+			return fmt.Errorf("failed to serialize the verifying key of %s: %w", circuitID, err)
+		}
+		if err := zk.RegisterVerifier(circuitID, p.Backend(), base64.StdEncoding.EncodeToString(vk), fingerprint); err != nil {
+			return fmt.Errorf("failed to register the verifier of %s on Layer 1: %w", circuitID, err)
+		}
+		slog.Info("Registered verifier on Layer 1", "circuit", circuitID, "backend", p.Backend(), "fingerprint", fingerprint)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query the verifier of %s on Layer 1: %w", circuitID, err)
+	}
+	if backend != p.Backend() {
+		return fmt.Errorf("the verifier of %s on Layer 1 checks %s proofs, but the operator proves with %s", circuitID, backend, p.Backend())
+	}
+
+	registered, err := zk.QueryVerifierFingerprint(circuitID)
+	if err != nil {
+		return fmt.Errorf("failed to query the verifier of %s on Layer 1: %w", circuitID, err)
+	}
+	if registered != fingerprint {
+		return fmt.Errorf("the verifier of %s on Layer 1 was set up for constraint system %s, but the circuit compiles to %s: re-run setup, and init-l1 on a new Layer 1 ledger", circuitID, registered, fingerprint)
+	}
+	return nil
+}
+
+// LoadVerifiers reads only the verifying keys written by SetupKeys for backend and h, and by
+// SetupAggregator for aggregate slots unless it is 0, keyed by the circuit ID the operator
+// commits their proofs under.
+func LoadVerifiers(keyDir, backend string, h *hasher.Hasher, aggregate int) (Verifiers, error) {
+	v, err := prover.LoadVerifier(backend, circuitKeyDir(keyDir, h))
+	if err != nil {
+		return nil, err
+	}
+	transfer, err := prover.LoadVerifier(backend, transferKeyDir(keyDir, h))
+	if err != nil {
+		return nil, err
+	}
+	verifiers := Verifiers{CircuitID(h, backend): v, TransferCircuitID(h, backend): transfer}
+	if aggregate > 0 {
+		if verifiers[AggregationCircuitID(h, aggregate)], err = prover.LoadVerifier(prover.Groth16BW6761, aggregateKeyDir(keyDir, h, aggregate)); err != nil {
+			return nil, err
+		}
+	}
+	return verifiers, nil
+}
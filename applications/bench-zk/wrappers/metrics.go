@@ -0,0 +1,296 @@
+// wrappers/metrics.go
+
+package wrappers
+
+// Prometheus metrics of the operator pipeline, so that dashboards can follow the health of the
+// rollup during long runs: how far Layer 1 lags behind Layer 2, where the time of a block goes,
+// and which root was committed last.
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Ways a block is committed to Layer 1, the values of the "commit" label of
+// bench_zk_blocks_processed_total.
+const (
+	commitUnchanged  = "unchanged"   // No state change, the root is committed as is
+	commitProof      = "proof"       // One proof
+	commitProofChain = "proof_chain" // A chain of proofs of consecutive sub-batches
+	commitAggregated = "aggregated"  // The proofs of consecutive sub-batches folded into one
+	commitReplayed   = "replayed"    // Already committed before a restart, only checkpointed
+)
+
+// Metrics are the Prometheus metrics of an operator, in their own registry. A nil *Metrics
+// records nothing, so the pipeline is instrumented unconditionally.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	blocks    *prometheus.CounterVec
+	applied   prometheus.Counter
+	skipped   *prometheus.CounterVec
+	keyless   prometheus.Counter
+	witness   prometheus.Histogram
+	proof     *prometheus.HistogramVec
+	submit    *prometheus.HistogramVec
+	rootBlock prometheus.Gauge
+	root      *prometheus.GaugeVec
+	rootMu    sync.Mutex // Keeps rootBlock and root consistent
+	lag       lagTracker
+}
+
+// NewMetrics creates the operator metrics, along with the Go runtime and process metrics, in a
+// new registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		blocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bench_zk_blocks_processed_total",
+			Help: "Layer 2 blocks committed to Layer 1, by how they were committed.",
+		}, []string{"commit"}),
+		applied: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bench_zk_transactions_applied_total",
+			Help: "Valid Layer 2 transactions applied to the rollup state.",
+		}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bench_zk_transactions_skipped_total",
+			Help: "Layer 2 transactions skipped because Fabric invalidated them, by validation code.",
+		}, []string{"code"}),
+		keyless: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bench_zk_keyless_players_skipped_total",
+			Help: "Player writes left out of the rollup state because the player holds BEN but registered no public key.",
+		}),
+		witness: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bench_zk_witness_duration_seconds",
+			Help:    "Time to apply a block to the rollup state and build its circuit assignments.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		proof: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bench_zk_proof_duration_seconds",
+			Help:    "Time to generate the proof of one batch, by proof backend.",
+			Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+		}, []string{"backend"}),
+		submit: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bench_zk_l1_submit_duration_seconds",
+			Help:    "Time to submit the root of a block to Layer 1 and have it committed, by how it was committed.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}, []string{"commit"}),
+		rootBlock: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bench_zk_committed_block",
+			Help: "Layer 2 block number of the latest root committed to Layer 1.",
+		}),
+		root: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "bench_zk_state_root_info",
+			Help: "Latest state root committed to Layer 1, in the root label; always 1.",
+		}, []string{"root"}),
+	}
+	m.lag.now = time.Now
+	lagBlocks := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bench_zk_commit_lag_blocks",
+		Help: "Layer 2 blocks not committed to Layer 1 yet, up to the height of the Layer 2 chain.",
+	}, func() float64 { return float64(m.lag.blocks()) })
+	lagSeconds := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bench_zk_commit_lag_seconds",
+		Help: "Age of the oldest Layer 2 block not committed to Layer 1 yet, from the timestamp of the block.",
+	}, func() float64 { return m.lag.age().Seconds() })
+
+	m.Registry.MustRegister(
+		m.blocks, m.applied, m.skipped, m.keyless, m.witness, m.proof, m.submit,
+		m.rootBlock, m.root, lagBlocks, lagSeconds,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// start records the block and root the operator resumes from.
+func (m *Metrics) start(number uint64, root string) {
+	if m == nil {
+		return
+	}
+	m.lag.commit(number)
+	m.setRoot(number, root)
+}
+
+// blockReceived records that block number, created at, arrived from Layer 2.
+func (m *Metrics) blockReceived(number uint64, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.lag.receive(number, at)
+}
+
+// chainHead records newest, the newest block of the Layer 2 chain, which the block events may
+// not have delivered yet.
+func (m *Metrics) chainHead(newest uint64) {
+	if m == nil {
+		return
+	}
+	m.lag.head(newest)
+}
+
+// missingBlock returns the oldest block not committed to Layer 1 if its timestamp is unknown,
+// for the caller to fetch it and record it with blockReceived.
+func (m *Metrics) missingBlock() (uint64, bool) {
+	if m == nil {
+		return 0, false
+	}
+	return m.lag.missing()
+}
+
+// blockWitnessed records the transactions of a block applied to the rollup state and skipped,
+// and how long building its witness took.
+func (m *Metrics) blockWitnessed(applied int, skipped map[peer.TxValidationCode]int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.applied.Add(float64(applied))
+	for code, n := range skipped {
+		m.skipped.WithLabelValues(code.String()).Add(float64(n))
+	}
+	m.witness.Observe(d.Seconds())
+}
+
+// keylessPlayerSkipped records a player write left out of the rollup state because the player
+// holds BEN without a public key.
+func (m *Metrics) keylessPlayerSkipped() {
+	if m == nil {
+		return
+	}
+	m.keyless.Inc()
+}
+
+// proofGenerated records the time one proof took with backend.
+func (m *Metrics) proofGenerated(backend string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.proof.WithLabelValues(backend).Observe(d.Seconds())
+}
+
+// blockSubmitted records the time the commit transaction of a block took on Layer 1.
+func (m *Metrics) blockSubmitted(commit string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.submit.WithLabelValues(commit).Observe(d.Seconds())
+}
+
+// blockCommitted records that block number is committed to Layer 1 with root. Replayed blocks
+// carry no root and leave the latest root as it is, since they are not newer than it.
+func (m *Metrics) blockCommitted(number uint64, root, commit string) {
+	if m == nil {
+		return
+	}
+	m.blocks.WithLabelValues(commit).Inc()
+	m.lag.commit(number)
+	if root != "" {
+		m.setRoot(number, root)
+	}
+}
+
+// setRoot records root as the latest root committed, for block number.
+func (m *Metrics) setRoot(number uint64, root string) {
+	m.rootMu.Lock()
+	defer m.rootMu.Unlock()
+	m.rootBlock.Set(float64(number))
+	m.root.Reset()
+	m.root.WithLabelValues(root).Set(1)
+}
+
+// lagTracker follows the Layer 2 blocks not committed to Layer 1 yet: from the newest block
+// committed up to the newest block of the chain, whether it was received or only seen in the
+// height of the chain. The age of a block is taken from its timestamp, so that blocks held back
+// by a busy pipeline or a broken event stream still age.
+type lagTracker struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	pending []receivedBlock // Blocks not committed yet whose timestamp is known, in block order
+	newest  uint64          // Newest block of the chain
+	latest  uint64          // Newest block committed
+}
+
+// receivedBlock is a block waiting to be committed and when it was created.
+type receivedBlock struct {
+	number uint64
+	at     time.Time
+}
+
+func (l *lagTracker) receive(number uint64, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.newest = max(l.newest, number)
+	if number <= l.latest {
+		return
+	}
+	// Blocks are mostly received in order, but delivered again after a reconnection and fetched
+	// out of order by the caller of missing
+	i := len(l.pending)
+	for i > 0 && l.pending[i-1].number >= number {
+		i--
+	}
+	if i < len(l.pending) && l.pending[i].number == number {
+		return
+	}
+	l.pending = append(l.pending, receivedBlock{})
+	copy(l.pending[i+1:], l.pending[i:])
+	l.pending[i] = receivedBlock{number, at}
+}
+
+func (l *lagTracker) head(newest uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.newest = max(l.newest, newest)
+}
+
+func (l *lagTracker) commit(number uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := 0
+	for i < len(l.pending) && l.pending[i].number <= number {
+		i++
+	}
+	l.pending = l.pending[i:]
+	l.latest = max(l.latest, number)
+	l.newest = max(l.newest, number)
+}
+
+// missing returns the oldest block not committed yet if its timestamp is unknown.
+func (l *lagTracker) missing() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := l.latest + 1
+	if l.newest < next || (len(l.pending) > 0 && l.pending[0].number == next) {
+		return 0, false
+	}
+	return next, true
+}
+
+// blocks returns the number of blocks of the chain after the newest committed one.
+func (l *lagTracker) blocks() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.newest - l.latest
+}
+
+// age returns the time since the oldest block not committed yet whose timestamp is known was
+// created, 0 if there is none.
+func (l *lagTracker) age() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 || l.newest == l.latest {
+		return 0
+	}
+	return max(l.now().Sub(l.pending[0].at), 0)
+}
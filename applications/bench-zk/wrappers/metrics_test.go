@@ -0,0 +1,185 @@
+// wrappers/metrics_test.go
+package wrappers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bench-zk/blocks"
+	"bench-zk/blocks/blockstest"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// scrape returns the metrics m serves on /metrics.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// expectMetrics checks that every line of want is in the metrics m serves.
+func expectMetrics(t *testing.T, m *Metrics, want ...string) {
+	t.Helper()
+	body := scrape(t, m)
+	for _, line := range want {
+		if !strings.Contains(body, "\n"+line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+}
+
+func TestMetricsLag(t *testing.T) {
+	var none *Metrics
+	none.blockReceived(1, time.Now()) // Records nothing
+	none.chainHead(1)
+	none.blockCommitted(1, "root", commitProof)
+
+	m := NewMetrics()
+	created := time.Unix(1000, 0)
+	now := created.Add(3 * time.Second)
+	m.lag.now = func() time.Time { return now }
+
+	m.start(3, "root3")
+	m.blockReceived(4, created)
+	m.blockReceived(5, created.Add(time.Second))
+	m.blockReceived(4, created) // Delivered again after a reconnection
+	expectMetrics(t, m,
+		"bench_zk_commit_lag_blocks 2",
+		"bench_zk_commit_lag_seconds 3",
+		"bench_zk_committed_block 3",
+		`bench_zk_state_root_info{root="root3"} 1`,
+	)
+
+	// The chain grew past the blocks the events delivered
+	m.chainHead(7)
+	expectMetrics(t, m, "bench_zk_commit_lag_blocks 4", "bench_zk_commit_lag_seconds 3")
+
+	m.blockSubmitted(commitProof, 100*time.Millisecond)
+	m.blockCommitted(4, "root4", commitProof)
+	expectMetrics(t, m,
+		"bench_zk_commit_lag_blocks 3",
+		"bench_zk_commit_lag_seconds 2",
+		"bench_zk_committed_block 4",
+		`bench_zk_state_root_info{root="root4"} 1`,
+		`bench_zk_blocks_processed_total{commit="proof"} 1`,
+		`bench_zk_l1_submit_duration_seconds_count{commit="proof"} 1`,
+	)
+	if strings.Contains(scrape(t, m), `root="root3"`) {
+		t.Error("the previous root is still exported")
+	}
+
+	// Block 6 was not received: its timestamp is missing until it is fetched
+	m.blockCommitted(5, "root5", commitUnchanged)
+	if number, ok := m.missingBlock(); !ok || number != 6 {
+		t.Errorf("missingBlock() = %d, %v, want 6", number, ok)
+	}
+	m.blockReceived(6, created.Add(2*time.Second))
+	if _, ok := m.missingBlock(); ok {
+		t.Error("block 6 is still missing once received")
+	}
+	expectMetrics(t, m, "bench_zk_commit_lag_blocks 2", "bench_zk_commit_lag_seconds 1")
+
+	m.blockCommitted(7, "root7", commitUnchanged)
+	expectMetrics(t, m,
+		"bench_zk_commit_lag_blocks 0",
+		"bench_zk_commit_lag_seconds 0",
+		`bench_zk_state_root_info{root="root7"} 1`,
+	)
+	if _, ok := m.missingBlock(); ok {
+		t.Error("a block is missing with none left to commit")
+	}
+}
+
+// qsccLedger answers qscc GetChainInfo with the height of blocks and GetBlockByNumber with the
+// block of that number.
+type qsccLedger struct {
+	blocks map[string]*common.Block
+	height uint64
+}
+
+func (q *qsccLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return q.EvaluateTransaction(name, args...)
+}
+
+func (q *qsccLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "GetChainInfo":
+		return proto.Marshal(&common.BlockchainInfo{Height: q.height})
+	case "GetBlockByNumber":
+		return proto.Marshal(q.blocks[args[1]])
+	}
+	return nil, fmt.Errorf("unexpected transaction %s", name)
+}
+
+// TestReadChainHead checks that the lag follows the height of the Layer 2 chain and the
+// timestamp of its blocks, not the blocks the operator happened to receive.
+func TestReadChainHead(t *testing.T) {
+	created := time.Unix(1000, 0)
+	w := &Wrappers{Gw2: &gateway.Gateway{ChannelName: "chains02"}, Metrics: NewMetrics()}
+	w.Metrics.lag.now = func() time.Time { return created.Add(10 * time.Second) }
+	w.Metrics.start(3, "root3")
+
+	qscc := &qsccLedger{height: 7, blocks: map[string]*common.Block{"4": blockstest.TimedBlock(t, 4, created)}}
+	if err := w.readChainHead(qscc); err != nil {
+		t.Fatalf("readChainHead failed: %v", err)
+	}
+	expectMetrics(t, w.Metrics, "bench_zk_commit_lag_blocks 3", "bench_zk_commit_lag_seconds 10")
+}
+
+// TestWitnessMetrics checks that the witness stage counts applied and skipped transactions, and
+// the keyless players it leaves out.
+func TestWitnessMetrics(t *testing.T) {
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	w := &Wrappers{
+		UserStates:     users,
+		StateRoots:     []string{genesis},
+		LatestRootHash: genesis,
+		Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+		Metrics:        NewMetrics(),
+	}
+
+	created := newTestPlayers(t).signed(4, 0, 0)
+	received := make(chan *common.Block, 3)
+	received <- blockstest.Block(1, peer.TxValidationCode_VALID,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "create", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", created)}, "CurrencyContract:CreatePlayer", "4", created.PubKey, created.Signature))
+	received <- blockstest.Block(2, peer.TxValidationCode_MVCC_READ_CONFLICT,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "conflict", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", gateway.Player{ID: 4, Balance: 99, PubKey: created.PubKey})}, "CurrencyContract:ExchangeInGameCurrency", "4", "99", ""))
+	received <- blockstest.Block(3, peer.TxValidationCode_VALID,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "keyless", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", gateway.Player{ID: 6, Balance: 99})}, "CurrencyContract:ExchangeInGameCurrency", "6", "99", ""))
+	close(received)
+
+	if err := w.buildWitnesses(context.Background(), received, make(chan *blockJob, 3)); err != nil {
+		t.Fatalf("buildWitnesses failed: %v", err)
+	}
+	expectMetrics(t, w.Metrics,
+		"bench_zk_transactions_applied_total 2",
+		`bench_zk_transactions_skipped_total{code="MVCC_READ_CONFLICT"} 1`,
+		"bench_zk_keyless_players_skipped_total 1",
+		"bench_zk_witness_duration_seconds_count 3",
+	)
+}
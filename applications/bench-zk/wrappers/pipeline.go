@@ -0,0 +1,452 @@
+// wrappers/pipeline.go
+
+package wrappers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/logging"
+	"bench-zk/merkle"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"golang.org/x/sync/errgroup"
+)
+
+// pipelineDepth bounds the number of blocks buffered between two pipeline stages.
+const pipelineDepth = 4
+
+// headInterval is how often the height of the Layer 2 chain is read for the commit lag metrics.
+const headInterval = 5 * time.Second
+
+// blockJob carries one Layer 2 block through the operator pipeline.
+type blockJob struct {
+	seq         uint64                   // Order in which the witness stage produced the job
+	blockNumber uint64                   // Layer 2 block number
+	skip        bool                     // Already committed before a restart; only checkpoint it
+	batches     []proofBatch             // Chained sub-batches of the block, empty when the state did not change
+	proofs      [][]byte                 // Serialized proof of each batch, filled in by a prover
+	aggregated  *gateway.AggregatedProof // Proof folding proofs, if the prover aggregated them
+	state       stateSnapshot            // Rollup state after the block, saved once it is committed
+}
+
+// Operate follows Layer 2 through block events and commits a state root (with a proof when the
+// state changed) to Layer 1 for every new block, until ctx is cancelled. Layer 1 is initialized
+// first unless the state was restored with LoadState.
+//
+// The operator runs as a pipeline connected by bounded channels:
+//
+//	ingest -> witness -> prover x ProverWorkers -> submitter
+//
+// The witness stage applies blocks to the rollup state one after another, so every block's
+// assignment is built against the intermediate roots of the blocks before it. Those assignments
+// are proven concurrently, and the submitter restores block order before committing to Layer 1.
+//
+// Each block is checkpointed once its root is committed and the state saved, so a restarted
+// operator resumes with the next block. The state is reconciled with Layer 1 first, replaying
+// the blocks committed after the snapshot was saved. A block that cannot be committed stops the
+// operator instead of being skipped, since every later root builds on it.
+func (w *Wrappers) Operate(ctx context.Context) error {
+	if !w.L1Initialized {
+		if err := w.InitL1(); err != nil {
+			return err
+		}
+	}
+
+	// Without the rollup enabled, Layer 2 lets players without a key get BEN the operator
+	// cannot roll up
+	enabled, err := w.Gw2.Currency().RollupEnabled()
+	if err != nil {
+		return fmt.Errorf("failed to query whether the rollup is enabled on Layer 2: %w", err)
+	}
+	if !enabled {
+		return fmt.Errorf("the rollup is not enabled on Layer 2 (run init-l1 first?)")
+	}
+
+	qscc := w.Gw2.Gateway.GetNetwork(w.Gw2.ChannelName).GetContract("qscc")
+	if err := w.reconcile(w.zk(), qscc); err != nil {
+		return fmt.Errorf("failed to reconcile the operator state with Layer 1: %w", err)
+	}
+
+	checkpointer, err := newCheckpointer(w.CheckpointPath)
+	if err != nil {
+		return err
+	}
+	defer checkpointer.Close()
+	w.Metrics.start(uint64(w.LatestRoot), w.LatestRootHash)
+
+	workers := w.ProverWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	received := make(chan *common.Block, pipelineDepth)
+	jobs := make(chan *blockJob, pipelineDepth)
+	proved := make(chan *blockJob, workers)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(received)
+		return w.ingestBlocks(gctx, checkpointer, received)
+	})
+	g.Go(func() error {
+		defer close(jobs)
+		return w.buildWitnesses(gctx, received, jobs)
+	})
+
+	var provers sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		provers.Add(1)
+		g.Go(func() error {
+			defer provers.Done()
+			return w.proveJobs(gctx, jobs, proved)
+		})
+	}
+	g.Go(func() error {
+		provers.Wait()
+		close(proved)
+		return nil
+	})
+
+	g.Go(func() error {
+		return w.submitInOrder(gctx, checkpointer, proved)
+	})
+	if w.Metrics != nil {
+		g.Go(func() error {
+			w.watchChainHead(gctx, qscc)
+			return nil
+		})
+	}
+
+	err = g.Wait()
+
+	// The checkpoint holds the next block to read, i.e. one past the latest committed block
+	if next := checkpointer.BlockNumber(); next > 0 {
+		w.LatestRoot = int64(next - 1)
+	}
+
+	if ctx.Err() != nil {
+		slog.Info("Operator stopped due to context cancellation")
+		return nil
+	}
+	return err
+}
+
+// ingestBlocks forwards Layer 2 blocks from block events to out, reconnecting when the peer
+// closes the stream. The first connection resumes from the checkpoint (or right after the
+// latest block committed to Layer 1); later ones resume after the last block forwarded, since
+// the checkpoint lags behind the blocks still in the pipeline.
+func (w *Wrappers) ingestBlocks(ctx context.Context, checkpoint client.Checkpoint, out chan<- *common.Block) error {
+	network := w.Gw2.Gateway.GetNetwork(w.Gw2.ChannelName)
+	options := []client.BlockEventsOption{
+		client.WithStartBlock(uint64(w.LatestRoot) + 1),
+		client.WithCheckpoint(checkpoint),
+	}
+
+	for {
+		events, err := network.BlockEvents(ctx, options...)
+		if err != nil {
+			return fmt.Errorf("failed to start block events: %w", err)
+		}
+
+		for block := range events {
+			at, ok := blocks.Time(block)
+			if !ok {
+				at = time.Now()
+			}
+			w.Metrics.blockReceived(block.GetHeader().GetNumber(), at)
+			select {
+			case out <- block:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			options = []client.BlockEventsOption{client.WithStartBlock(block.GetHeader().GetNumber() + 1)}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// The peer closed the stream; reconnect after the last forwarded block
+		slog.Warn("Block event stream closed, reconnecting")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// watchChainHead feeds the commit lag metrics with the height of the Layer 2 chain, read through
+// qscc every headInterval until ctx is cancelled, since the block events stall while the pipeline
+// is full. When the oldest block not committed has not been received yet, its timestamp is read
+// as well. Failures only leave the metrics behind, so they are logged and retried.
+func (w *Wrappers) watchChainHead(ctx context.Context, qscc gateway.Invoker) {
+	ticker := time.NewTicker(headInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.readChainHead(qscc); err != nil {
+			slog.Debug("Failed to read the Layer 2 chain head", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readChainHead records the newest block of the Layer 2 chain, and the timestamp of the oldest
+// block not committed if it is missing, in the metrics.
+func (w *Wrappers) readChainHead(qscc gateway.Invoker) error {
+	newest, err := blocks.NewestBlockNumber(qscc, w.Gw2.ChannelName)
+	if err != nil {
+		return err
+	}
+	w.Metrics.chainHead(newest)
+
+	number, ok := w.Metrics.missingBlock()
+	if !ok {
+		return nil
+	}
+	block, err := blocks.GetBlock(qscc, w.Gw2.ChannelName, number)
+	if err != nil {
+		return err
+	}
+	if at, ok := blocks.Time(block); ok {
+		w.Metrics.blockReceived(number, at)
+	}
+	return nil
+}
+
+// buildWitnesses applies each block to the rollup state and emits a job holding the block's
+// circuit assignment and resulting state. It is the only stage touching the rollup state.
+func (w *Wrappers) buildWitnesses(ctx context.Context, in <-chan *common.Block, out chan<- *blockJob) error {
+	var seq uint64
+	for block := range in {
+		job, err := w.buildJob(block)
+		if err != nil {
+			return fmt.Errorf("failed to build witness for block %d: %w", block.GetHeader().GetNumber(), err)
+		}
+		job.seq = seq
+		seq++
+
+		select {
+		case out <- job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// buildJob applies the valid transactions of block to the rollup state and builds its assignment.
+func (w *Wrappers) buildJob(block *common.Block) (*blockJob, error) {
+	blockNumber := block.GetHeader().GetNumber()
+	job := &blockJob{blockNumber: blockNumber}
+	log := slog.With(logging.Block(blockNumber))
+	w.blockLog = log
+
+	// A crash between saving the state and checkpointing replays blocks already in the state
+	if blockNumber <= uint64(w.LatestRoot) {
+		job.skip = true
+		return job, nil
+	}
+
+	start := time.Now()
+	transactions, err := blocks.Transactions(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract transactions: %w", err)
+	}
+
+	// Only transactions Fabric validated changed the Layer 2 state
+	valid, skipped := blocks.Valid(transactions)
+	log.Info("Applying block", "transactions", len(transactions), "valid", len(valid))
+	if n := len(transactions) - len(valid); n > 0 {
+		log.Warn("Skipped invalid transactions", "count", n, "codes", blocks.FormatSkipped(skipped))
+	}
+
+	// Process transactions before computing Merkle root
+	if err := w.processTransactions(valid); err != nil {
+		return nil, fmt.Errorf("failed to process transactions: %w", err)
+	}
+
+	job.batches, err = w.buildAssignments()
+	if err != nil {
+		return nil, err
+	}
+
+	job.state = w.snapshot()
+	job.state.LatestRoot = int64(blockNumber)
+	w.Metrics.blockWitnessed(len(valid), skipped, time.Since(start))
+	return job, nil
+}
+
+// proveJobs generates and locally verifies the proofs of state-changing blocks, one per batch.
+// With an Aggregator, the proofs of a block split into several batches are then folded into one,
+// unless there are more batches than it has slots. Several proveJobs run at once; they only
+// share read-only circuit data.
+func (w *Wrappers) proveJobs(ctx context.Context, in <-chan *blockJob, out chan<- *blockJob) error {
+	for job := range in {
+		log := slog.With(logging.Block(job.blockNumber))
+		for i, batch := range job.batches {
+			p, circuitID := w.Prover, w.circuitID()
+			if batch.transfer {
+				p, circuitID = w.TransferProver, w.transferCircuitID()
+			}
+
+			start := time.Now()
+			proof, err := w.proveAssignment(p, batch.assignment)
+			if err != nil {
+				return fmt.Errorf("failed to prove batch %d of block %d: %w", i, job.blockNumber, err)
+			}
+			proved := time.Now()
+			if err := verifyRootProof(p, proof, batch.oldRoot, batch.newRoot); err != nil {
+				return fmt.Errorf("block %d, batch %d: %w", job.blockNumber, i, err)
+			}
+			log.Info("Proof generated and verified", "batch", i+1, "batches", len(job.batches), "circuit", circuitID,
+				"bytes", len(proof), "prove", proved.Sub(start), "verify", time.Since(proved))
+
+			job.proofs = append(job.proofs, proof)
+			job.batches[i].circuitID = circuitID
+			job.batches[i].assignment = nil // The witness is no longer needed
+		}
+
+		if a := w.Aggregator; a != nil && len(job.batches) > 1 {
+			if len(job.batches) > a.Slots {
+				log.Warn("Too many batches to aggregate, committing a proof chain", "batches", len(job.batches), "slots", a.Slots)
+			} else {
+				start := time.Now()
+				aggregated, err := w.aggregate(job.batches, job.proofs)
+				if err != nil {
+					return fmt.Errorf("failed to aggregate the proofs of block %d: %w", job.blockNumber, err)
+				}
+				log.Info("Proofs aggregated and verified", "batches", len(job.batches), "circuit", aggregated.CircuitID, "duration", time.Since(start))
+				job.aggregated = aggregated
+			}
+		}
+
+		select {
+		case out <- job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// submitInOrder commits proven blocks to Layer 1 strictly in block order, holding back jobs
+// that overtook an earlier block in the prover stage, and checkpoints each committed block.
+func (w *Wrappers) submitInOrder(ctx context.Context, checkpointer checkpointer, in <-chan *blockJob) error {
+	zk := w.zk()
+
+	pending := make(map[uint64]*blockJob)
+	var next uint64
+	for job := range in {
+		pending[job.seq] = job
+
+		for {
+			job, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if job.skip {
+				slog.Info("Block already committed, skipping", logging.Block(job.blockNumber))
+				w.Metrics.blockCommitted(job.blockNumber, "", commitReplayed)
+			} else if err := w.submitJob(zk, job); err != nil {
+				return fmt.Errorf("failed to commit block %d: %w", job.blockNumber, err)
+			}
+
+			if err := checkpointer.CheckpointBlock(job.blockNumber); err != nil {
+				return fmt.Errorf("failed to checkpoint block %d: %w", job.blockNumber, err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// submitJob commits the root of one block to Layer 1, with its proof when the state changed,
+// checks the committed root and saves the state after the block.
+func (w *Wrappers) submitJob(zk *gateway.ZKClient, job *blockJob) error {
+	log := slog.With(logging.Block(job.blockNumber))
+	start := time.Now()
+	var commit string
+	switch {
+	case len(job.proofs) == 0:
+		commit = commitUnchanged
+		// No state-changing transactions; commit the current root as unchanged
+		if err := zk.CommitNoChange(job.blockNumber, job.state.LatestRootHash); err != nil {
+			return fmt.Errorf("failed to commit no-change state: %w", err)
+		}
+		log.Info("Committed unchanged state root")
+	case len(job.proofs) == 1:
+		commit = commitProof
+		proofBase64 := base64.StdEncoding.EncodeToString(job.proofs[0])
+		oldRootBase64 := merkle.MerkleRootToBase64(job.batches[0].oldRoot)
+		newRootBase64 := merkle.MerkleRootToBase64(job.batches[0].newRoot)
+		if err := zk.CommitProof(job.blockNumber, job.batches[0].circuitID, oldRootBase64, newRootBase64, proofBase64); err != nil {
+			return fmt.Errorf("failed to commit proof: %w", err)
+		}
+		log.Info("Committed proof")
+	case job.aggregated != nil:
+		commit = commitAggregated
+		if err := zk.CommitAggregatedProof(job.blockNumber, *job.aggregated); err != nil {
+			return fmt.Errorf("failed to commit aggregated proof: %w", err)
+		}
+		log.Info("Committed aggregated proof", "proofs", len(job.proofs))
+	default:
+		// More state changes than one proof covers; commit the whole chain in one transaction
+		commit = commitProofChain
+		chain := encodeProofChain(job.batches, job.proofs)
+		if err := zk.CommitProofChain(job.blockNumber, chain); err != nil {
+			return fmt.Errorf("failed to commit proof chain: %w", err)
+		}
+		log.Info("Committed proof chain", "proofs", len(job.proofs))
+	}
+	w.Metrics.blockSubmitted(commit, time.Since(start))
+
+	// Query the state root for the just-committed block and verify it
+	committed, err := zk.QueryStateRoot(job.blockNumber)
+	if err != nil {
+		return fmt.Errorf("failed to query state root: %w", err)
+	}
+	if committed != job.state.LatestRootHash {
+		return fmt.Errorf("state root mismatch: expected %s, got %s", job.state.LatestRootHash, committed)
+	}
+	log.Info("State root verified", "root", job.state.LatestRootHash, "duration", time.Since(start))
+
+	// Save the state before the block is checkpointed
+	if w.StatePath != "" {
+		if err := saveSnapshot(w.StatePath, job.state); err != nil {
+			return err
+		}
+	}
+	w.Metrics.blockCommitted(job.blockNumber, job.state.LatestRootHash, commit)
+	return nil
+}
+
+// encodeProofChain encodes the chained roots and proofs of a block's batches in base64, with the
+// circuit ID of each proof, as ZKContract:CommitProofChain expects them.
+func encodeProofChain(batches []proofBatch, proofs [][]byte) gateway.ProofChain {
+	roots := []string{merkle.MerkleRootToBase64(batches[0].oldRoot)}
+	circuitIDs := make([]string, len(batches))
+	for i, batch := range batches {
+		roots = append(roots, merkle.MerkleRootToBase64(batch.newRoot))
+		circuitIDs[i] = batch.circuitID
+	}
+	encodedProofs := make([]string, len(proofs))
+	for i, proof := range proofs {
+		encodedProofs[i] = base64.StdEncoding.EncodeToString(proof)
+	}
+	return gateway.ProofChain{Roots: roots, Proofs: encodedProofs, CircuitIDs: circuitIDs}
+}
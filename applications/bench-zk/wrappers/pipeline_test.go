@@ -0,0 +1,158 @@
+// wrappers/pipeline_test.go
+package wrappers
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"bench-zk/blocks"
+	"bench-zk/blocks/blockstest"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// TestBuildWitnessesChainsRoots checks that consecutive blocks are witnessed against the
+// intermediate roots of the blocks before them, so they can be proven independently.
+func TestBuildWitnessesChainsRoots(t *testing.T) {
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	w := &Wrappers{
+		UserStates:     users,
+		StateRoots:     []string{genesis},
+		LatestRoot:     1,
+		LatestRootHash: genesis,
+		Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+	}
+
+	players := newTestPlayers(t)
+	create := players.signed(4, 0, 0)
+	exchange := players.signed(4, 15000, 15000)
+	received := make(chan *common.Block, 4)
+	received <- blockstest.Block(1, peer.TxValidationCode_VALID) // replayed after a restart
+	received <- blockstest.Block(2, peer.TxValidationCode_VALID,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "create", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", create)}, "CurrencyContract:CreatePlayer", "4", create.PubKey, create.Signature))
+	received <- blockstest.Block(3, peer.TxValidationCode_MVCC_READ_CONFLICT,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "conflict", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", gateway.Player{ID: 4, Balance: 99, PubKey: create.PubKey})}, "CurrencyContract:ExchangeInGameCurrency", "4", "99", ""))
+	received <- blockstest.Block(4, peer.TxValidationCode_VALID,
+		blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "exchange", "pasic",
+			[]blocks.Write{playerWrite(t, "pasic", exchange)}, "CurrencyContract:ExchangeInGameCurrency", "4", "50000", exchange.Signature))
+	close(received)
+
+	jobs := make(chan *blockJob, 4)
+	if err := w.buildWitnesses(context.Background(), received, jobs); err != nil {
+		t.Fatalf("buildWitnesses failed: %v", err)
+	}
+	close(jobs)
+
+	var got []*blockJob
+	for job := range jobs {
+		got = append(got, job)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 jobs, got %d", len(got))
+	}
+	for i, job := range got {
+		if job.seq != uint64(i) || job.blockNumber != uint64(i+1) {
+			t.Errorf("job %d has seq %d for block %d", i, job.seq, job.blockNumber)
+		}
+	}
+
+	if !got[0].skip {
+		t.Errorf("block 1 is already committed and must be skipped")
+	}
+
+	created, conflicted, exchanged := got[1], got[2], got[3]
+	if len(created.batches) != 1 || merkle.MerkleRootToBase64(created.batches[0].oldRoot) != genesis {
+		t.Fatalf("block 2 must change the state starting from the genesis root")
+	}
+	if len(conflicted.batches) != 0 || conflicted.state.LatestRootHash != merkle.MerkleRootToBase64(created.batches[0].newRoot) {
+		t.Errorf("block 3 only holds an invalid transaction and must keep the root of block 2")
+	}
+	if len(exchanged.batches) != 1 || exchanged.batches[0].oldRoot.Cmp(created.batches[0].newRoot) != 0 {
+		t.Fatalf("block 4 must start from the root of block 2")
+	}
+	if exchanged.state.LatestRoot != 4 || exchanged.state.UserStates[0].Ben.Int64() != 15000 {
+		t.Errorf("unexpected state after block 4: %+v", exchanged.state.UserStates[0])
+	}
+	if created.state.UserStates[0].Ben.Sign() != 0 {
+		t.Errorf("the state snapshot of block 2 was changed by block 4")
+	}
+
+	// Each assignment must hold the roots the prover will be asked to prove
+	batch := exchanged.batches[0]
+	assignment := batch.assignment.(*rollup.ProofMerkleCircuit)
+	if assignment.OldRoot.(*big.Int).Cmp(batch.oldRoot) != 0 || assignment.NewRoot.(*big.Int).Cmp(batch.newRoot) != 0 {
+		t.Errorf("assignment roots do not match the batch roots")
+	}
+}
+
+// TestBuildAssignmentsSplitsLargeBlocks checks that a block with more than B2 state changes is
+// split into sub-batches whose roots chain from the old root of the block to its new root.
+func TestBuildAssignmentsSplitsLargeBlocks(t *testing.T) {
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	w := &Wrappers{
+		UserStates:     users,
+		StateRoots:     []string{genesis},
+		LatestRootHash: genesis,
+		Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+	}
+
+	players := newTestPlayers(t)
+	changes := 2*rollup.B2 + 3
+	var writes []blocks.Write
+	for i := 0; i < changes; i++ {
+		writes = append(writes, playerWrite(t, "pasic", players.signed(int64(100+i), int64(i+1), int64(i+1))))
+	}
+	if err := w.processTransactions([]blocks.Transaction{{TxID: "bulk", Writes: writes}}); err != nil {
+		t.Fatalf("processTransactions failed: %v", err)
+	}
+	finalRoot := w.LatestRootHash
+
+	batches, err := w.buildAssignments()
+	if err != nil {
+		t.Fatalf("buildAssignments failed: %v", err)
+	}
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches for %d changes, got %d", changes, len(batches))
+	}
+	if merkle.MerkleRootToBase64(batches[0].oldRoot) != genesis {
+		t.Errorf("the first batch must start from the old root of the block")
+	}
+	for i := 1; i < len(batches); i++ {
+		if batches[i].oldRoot.Cmp(batches[i-1].newRoot) != 0 {
+			t.Errorf("batch %d does not start from the new root of batch %d", i, i-1)
+		}
+	}
+	if merkle.MerkleRootToBase64(batches[len(batches)-1].newRoot) != finalRoot {
+		t.Errorf("the last batch must end at the new root of the block")
+	}
+
+	// The last batch holds 3 real changes; the rest of its slots are disabled
+	last := batches[2].assignment.(*rollup.ProofMerkleCircuit)
+	for k := 0; k < rollup.B2; k++ {
+		if enabled := last.Transactions[k].Enabled; (k < 3) != (enabled == 1) {
+			t.Errorf("slot %d of the last batch has Enabled=%v", k, enabled)
+		}
+	}
+
+	chain := encodeProofChain(batches, [][]byte{{1}, {2}, {3}})
+	roots, proofs := chain.Roots, chain.Proofs
+	if len(roots) != 4 || len(proofs) != 3 || roots[0] != genesis || roots[3] != finalRoot {
+		t.Errorf("unexpected proof chain: roots %v, proofs %v", roots, proofs)
+	}
+}
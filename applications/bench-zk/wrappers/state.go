@@ -0,0 +1,154 @@
+// wrappers/state.go
+
+package wrappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/logging"
+	"bench-zk/merkle"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+)
+
+// stateSnapshot is the on-disk form of the operator's rollup state.
+// It lets a restarted operator continue from the last root it committed to Layer 1
+// instead of rebuilding (and diverging from) the genesis state.
+type stateSnapshot struct {
+	UserStates     []merkle.UserState `json:"userStates"`
+	DummyUserIndex int                `json:"dummyUserIndex"`
+	LatestRoot     int64              `json:"latestRoot"`
+	LatestRootHash string             `json:"latestRootHash"`
+	Hasher         string             `json:"hasher,omitempty"` // Hash function of the tree; MiMC for older snapshots
+}
+
+// SaveState writes the current rollup state to path.
+func (w *Wrappers) SaveState(path string) error {
+	return saveSnapshot(path, w.snapshot())
+}
+
+// snapshot captures the current rollup state. UserStates entries are replaced, never mutated,
+// by processTransactions, so copying the slice is enough to decouple the snapshot from later blocks.
+func (w *Wrappers) snapshot() stateSnapshot {
+	return stateSnapshot{
+		UserStates:     append([]merkle.UserState(nil), w.UserStates...),
+		DummyUserIndex: w.DummyUserIndex,
+		LatestRoot:     w.LatestRoot,
+		LatestRootHash: w.LatestRootHash,
+		Hasher:         w.stateHasher().Name(),
+	}
+}
+
+// saveSnapshot writes snapshot to path.
+// The file is replaced atomically so a crash never leaves a half-written snapshot behind.
+func saveSnapshot(path string, snapshot stateSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal operator state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write operator state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace operator state: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores the rollup state written by SaveState and marks Layer 1 as initialized.
+func (w *Wrappers) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read operator state (run init-l1 first?): %w", err)
+	}
+
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse operator state: %w", err)
+	}
+	if len(snapshot.UserStates) == 0 {
+		return fmt.Errorf("operator state %s holds no user states", path)
+	}
+
+	// Leaves without a key and nonce cannot be updated by the signed circuit
+	for i, user := range snapshot.UserStates {
+		if !user.IsAccount() {
+			return fmt.Errorf("operator state %s predates signed accounts (leaf %d); re-run init-l1", path, i)
+		}
+	}
+
+	// The roots of the snapshot are only meaningful with the hash function they were built with
+	snapshotHasher := snapshot.Hasher
+	if snapshotHasher == "" {
+		snapshotHasher = hasher.MiMC.Name()
+	}
+	if snapshotHasher != w.stateHasher().Name() {
+		return fmt.Errorf("operator state %s was built with %s, not %s; re-run init-l1", path, snapshotHasher, w.stateHasher().Name())
+	}
+
+	// Make sure the snapshot is self-consistent before trusting it
+	root := merkle.MerkleRootToBase64(merkle.BuildMerkleStatesWith(w.stateHasher(), snapshot.UserStates))
+	if root != snapshot.LatestRootHash {
+		return fmt.Errorf("operator state %s is corrupt: root %s does not match recorded root %s", path, root, snapshot.LatestRootHash)
+	}
+
+	w.UserStates = snapshot.UserStates
+	w.DummyUserIndex = snapshot.DummyUserIndex
+	w.LatestRoot = snapshot.LatestRoot
+	w.LatestRootHash = snapshot.LatestRootHash
+	w.StateRoots = []string{snapshot.LatestRootHash}
+	w.L1Initialized = true
+	return nil
+}
+
+// reconcile brings the rollup state up to the latest root committed to Layer 1. submitJob commits
+// a block before saving the state after it, so an operator stopped in between restarts from a
+// snapshot behind Layer 1. The blocks Layer 1 already holds are replayed from Layer 2 through qscc
+// without proving them, and each root is checked against the one Layer 1 committed.
+func (w *Wrappers) reconcile(zk *gateway.ZKClient, qscc gateway.Invoker) error {
+	committed, err := zk.QueryStateRoot(uint64(w.LatestRoot))
+	if err != nil {
+		return fmt.Errorf("failed to query state root for block %d: %w", w.LatestRoot, err)
+	}
+	if committed != w.LatestRootHash {
+		return fmt.Errorf("state root of block %d is %s on Layer 1, not %s", w.LatestRoot, committed, w.LatestRootHash)
+	}
+
+	for {
+		number := uint64(w.LatestRoot) + 1
+		committed, err := zk.QueryStateRoot(number)
+		if gateway.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query state root for block %d: %w", number, err)
+		}
+
+		block, err := blocks.GetBlock(qscc, w.Gw2.ChannelName, number)
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %w", number, err)
+		}
+		job, err := w.buildJob(block)
+		if err != nil {
+			return fmt.Errorf("failed to replay block %d: %w", number, err)
+		}
+		if job.state.LatestRootHash != committed {
+			return fmt.Errorf("replayed block %d has root %s, but Layer 1 committed %s", number, job.state.LatestRootHash, committed)
+		}
+
+		w.LatestRoot = int64(number)
+		if w.StatePath != "" {
+			if err := saveSnapshot(w.StatePath, job.state); err != nil {
+				return err
+			}
+		}
+		slog.Info("Replayed block committed before the operator stopped", logging.Block(number), "root", committed)
+	}
+}
@@ -0,0 +1,121 @@
+// wrappers/state_test.go
+package wrappers
+
+import (
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bench-zk/blocks"
+	"bench-zk/blocks/blockstest"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// TestLoadStateChecksHasher checks that a snapshot is only loaded by an operator hashing the
+// rollup state the way the snapshot was built.
+func TestLoadStateChecksHasher(t *testing.T) {
+	users := []merkle.UserState{
+		merkle.NewAccount(big.NewInt(1), big.NewInt(100)),
+		merkle.NewAccount(big.NewInt(2), big.NewInt(200)),
+	}
+	root := merkle.MerkleRootToBase64(merkle.BuildMerkleStatesWith(hasher.Poseidon2, users))
+	saved := &Wrappers{UserStates: users, LatestRoot: 3, LatestRootHash: root, Hasher: hasher.Poseidon2}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saved.SaveState(path); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	loaded := &Wrappers{Hasher: hasher.Poseidon2}
+	if err := loaded.LoadState(path); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if loaded.LatestRoot != 3 || loaded.LatestRootHash != root {
+		t.Errorf("unexpected state after LoadState: block %d, root %s", loaded.LatestRoot, loaded.LatestRootHash)
+	}
+
+	if err := (&Wrappers{}).LoadState(path); err == nil {
+		t.Errorf("Poseidon2 snapshot loaded by a MiMC operator")
+	}
+}
+
+// TestReconcile checks that an operator restarted from a snapshot older than Layer 1, as left by
+// a crash between committing a block and saving the state, replays the blocks Layer 1 holds.
+func TestReconcile(t *testing.T) {
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	operator := func() *Wrappers {
+		return &Wrappers{
+			UserStates:     append([]merkle.UserState(nil), users...),
+			StateRoots:     []string{genesis},
+			LatestRoot:     1,
+			LatestRootHash: genesis,
+			Gw2:            &gateway.Gateway{ChaincodeName: "pasic", ChannelName: "chains02"},
+		}
+	}
+
+	players := newTestPlayers(t)
+	created, exchanged := players.signed(4, 0, 0), players.signed(4, 15000, 15000)
+	qscc := &qsccLedger{height: 4, blocks: map[string]*common.Block{
+		"2": blockstest.Block(2, peer.TxValidationCode_VALID,
+			blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "create", "pasic",
+				[]blocks.Write{playerWrite(t, "pasic", created)}, "CurrencyContract:CreatePlayer", "4", created.PubKey, created.Signature)),
+		"3": blockstest.Block(3, peer.TxValidationCode_VALID,
+			blockstest.Envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "exchange", "pasic",
+				[]blocks.Write{playerWrite(t, "pasic", exchanged)}, "CurrencyContract:ExchangeInGameCurrency", "4", "50000", exchanged.Signature)),
+	}}
+
+	// The roots Layer 1 committed for blocks 2 and 3 before the operator stopped
+	committed := operator()
+	roots := map[string]string{"1": genesis}
+	for _, number := range []string{"2", "3"} {
+		job, err := committed.buildJob(qscc.blocks[number])
+		if err != nil {
+			t.Fatalf("buildJob failed: %v", err)
+		}
+		roots[number] = job.state.LatestRootHash
+	}
+
+	restarted := operator()
+	restarted.StatePath = filepath.Join(t.TempDir(), "state.json")
+	if err := restarted.reconcile(gateway.NewZKClient(&zkLedger{roots: roots}), qscc); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if restarted.LatestRoot != 3 || restarted.LatestRootHash != roots["3"] {
+		t.Errorf("reconciled to block %d, root %s, want block 3, root %s", restarted.LatestRoot, restarted.LatestRootHash, roots["3"])
+	}
+	saved := &Wrappers{}
+	if err := saved.LoadState(restarted.StatePath); err != nil || saved.LatestRoot != 3 {
+		t.Errorf("the reconciled state was not saved: block %d, %v", saved.LatestRoot, err)
+	}
+
+	// Up to date with Layer 1: nothing to replay
+	if err := restarted.reconcile(gateway.NewZKClient(&zkLedger{roots: roots}), qscc); err != nil || restarted.LatestRoot != 3 {
+		t.Errorf("reconcile of an up to date state: block %d, %v", restarted.LatestRoot, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		roots   map[string]string
+		wantErr string
+	}{
+		{"diverged snapshot", map[string]string{"1": "other"}, "on Layer 1"},
+		{"diverged replay", map[string]string{"1": genesis, "2": "other"}, "Layer 1 committed other"},
+		{"snapshot ahead of Layer 1", map[string]string{}, "failed to query state root for block 1"},
+	} {
+		err := operator().reconcile(gateway.NewZKClient(&zkLedger{roots: tc.roots}), qscc)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: error %v, expected %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// wrappers/status.go
+
+package wrappers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+	"bench-zk/prover"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// BlockVerification describes the outcome of VerifyBlock.
+type BlockVerification struct {
+	BlockNumber uint64
+	OldRoot     string
+	NewRoot     string
+	HasProof    bool // false for blocks committed through CommitNoChange
+	Proofs      int  // Number of proofs; more than one for blocks committed through CommitProofChain
+	Aggregated  int  // Slots of the proof of blocks committed through CommitAggregatedProof, 0 otherwise
+
+	Backend    string        // Proof system the proofs were verified with
+	ProofBytes int           // Total size of the proofs
+	VerifyTime time.Duration // Total time spent verifying the proofs locally
+}
+
+// QueryCommittedRoots returns every state root committed to ZKContract through gw (Layer 1).
+func QueryCommittedRoots(gw *gateway.Gateway) ([]gateway.StateRoot, error) {
+	roots, err := gw.ZK().QueryAllStateRoots()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query all state roots: %w", err)
+	}
+	return roots, nil
+}
+
+// VerifyBlock re-checks the commitment of one Layer 2 block on Layer 1 against the local verifying keys,
+// keyed by the circuit ID ZKContract verified each proof under. Blocks committed with a proof must carry
+// a proof for (root of blockNumber-1, root of blockNumber) that the verifier of its circuit accepts;
+// blocks committed without one must leave the root unchanged. An aggregated proof must chain the
+// state from the one root to the other.
+func VerifyBlock(gw *gateway.Gateway, verifiers Verifiers, blockNumber uint64) (*BlockVerification, error) {
+	return verifyBlock(gw.ZK(), verifiers, blockNumber)
+}
+
+func verifyBlock(zk *gateway.ZKClient, verifiers Verifiers, blockNumber uint64) (*BlockVerification, error) {
+	if blockNumber < 2 {
+		return nil, fmt.Errorf("block %d is the genesis state and has no commitment to verify", blockNumber)
+	}
+
+	oldRoot, err := zk.QueryStateRoot(blockNumber - 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query state root for block %d: %w", blockNumber-1, err)
+	}
+	newRoot, err := zk.QueryStateRoot(blockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query state root for block %d: %w", blockNumber, err)
+	}
+
+	res := &BlockVerification{
+		BlockNumber: blockNumber,
+		OldRoot:     oldRoot,
+		NewRoot:     newRoot,
+	}
+
+	// Only a proof that is not on the ledger is looked up elsewhere; any other failure leaves the
+	// commitment unverified
+	proofBase64, err := zk.QueryProof(blockNumber)
+	if err != nil && !gateway.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to query proof for block %d: %w", blockNumber, err)
+	}
+	if proofBase64 == "" {
+		// No single proof stored: the block was either split into several batches or unchanged
+		chain, err := zk.QueryProofChain(blockNumber)
+		if err == nil {
+			return res, verifyProofChain(verifiers, res, chain)
+		}
+		if !gateway.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to query proof chain for block %d: %w", blockNumber, err)
+		}
+		aggregated, err := zk.QueryAggregatedProof(blockNumber)
+		if err == nil {
+			return res, verifyAggregated(verifiers, res, aggregated)
+		}
+		if !gateway.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to query aggregated proof for block %d: %w", blockNumber, err)
+		}
+		if res.OldRoot != res.NewRoot {
+			return res, fmt.Errorf("block %d changed the state root but has no proof on Layer 1", blockNumber)
+		}
+		return res, nil
+	}
+	res.HasProof = true
+	res.Proofs = 1
+
+	circuitID, err := zk.QueryProofCircuitID(blockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the circuit of the proof for block %d: %w", blockNumber, err)
+	}
+	v, err := verifiers.lookup(circuitID)
+	if err != nil {
+		return res, fmt.Errorf("block %d: %w", blockNumber, err)
+	}
+	res.Backend = v.Backend()
+	if err := verifyEncodedProof(v, res, proofBase64, res.OldRoot, res.NewRoot); err != nil {
+		return res, fmt.Errorf("block %d: %w", blockNumber, err)
+	}
+	return res, nil
+}
+
+// verifyProofChain checks that the proof chain of a block links the block's old root to its new
+// root and that every proof in it is valid under the verifier of its circuit.
+func verifyProofChain(verifiers Verifiers, res *BlockVerification, chain *gateway.ProofChain) error {
+	res.HasProof = true
+	res.Proofs = len(chain.Proofs)
+
+	if len(chain.Proofs) == 0 || len(chain.Roots) != len(chain.Proofs)+1 || len(chain.CircuitIDs) != len(chain.Proofs) {
+		return fmt.Errorf("block %d: malformed proof chain with %d roots, %d proofs and %d circuit IDs", res.BlockNumber, len(chain.Roots), len(chain.Proofs), len(chain.CircuitIDs))
+	}
+	if chain.Roots[0] != res.OldRoot || chain.Roots[len(chain.Roots)-1] != res.NewRoot {
+		return fmt.Errorf("block %d: proof chain does not link the committed roots", res.BlockNumber)
+	}
+	for i, proofBase64 := range chain.Proofs {
+		v, err := verifiers.lookup(chain.CircuitIDs[i])
+		if err != nil {
+			return fmt.Errorf("block %d, batch %d: %w", res.BlockNumber, i, err)
+		}
+		res.Backend = v.Backend()
+		if err := verifyEncodedProof(v, res, proofBase64, chain.Roots[i], chain.Roots[i+1]); err != nil {
+			return fmt.Errorf("block %d, batch %d: %w", res.BlockNumber, i, err)
+		}
+	}
+	return nil
+}
+
+// verifyAggregated checks that the aggregated proof of a block chains the block's old root to its
+// new root and is valid under the verifier of its circuit.
+func verifyAggregated(verifiers Verifiers, res *BlockVerification, aggregated *gateway.AggregatedProof) error {
+	res.HasProof = true
+	res.Proofs = 1
+	res.Aggregated = len(aggregated.Roots) - 1
+
+	if len(aggregated.Roots) < 2 {
+		return fmt.Errorf("block %d: malformed aggregated proof with %d roots", res.BlockNumber, len(aggregated.Roots))
+	}
+	if aggregated.Roots[0] != res.OldRoot || aggregated.Roots[len(aggregated.Roots)-1] != res.NewRoot {
+		return fmt.Errorf("block %d: aggregated proof does not link the committed roots", res.BlockNumber)
+	}
+	v, err := verifiers.lookup(aggregated.CircuitID)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", res.BlockNumber, err)
+	}
+	res.Backend = v.Backend()
+
+	proofBytes, err := base64.StdEncoding.DecodeString(aggregated.Proof)
+	if err != nil {
+		return fmt.Errorf("block %d: failed to decode proof: %w", res.BlockNumber, err)
+	}
+	roots := make([]*big.Int, len(aggregated.Roots))
+	for i, rootBase64 := range aggregated.Roots {
+		if roots[i], err = rootFromBase64(rootBase64); err != nil {
+			return fmt.Errorf("block %d: %w", res.BlockNumber, err)
+		}
+	}
+
+	start := time.Now()
+	err = verifyAggregatedProof(v, proofBytes, roots)
+	res.VerifyTime += time.Since(start)
+	res.ProofBytes += len(proofBytes)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", res.BlockNumber, err)
+	}
+	return nil
+}
+
+// Verifiers holds local verifiers by the circuit ID ZKContract verifies their proofs under.
+type Verifiers map[string]prover.Verifier
+
+// lookup returns the verifier of circuitID.
+func (vs Verifiers) lookup(circuitID string) (prover.Verifier, error) {
+	v, ok := vs[circuitID]
+	if !ok {
+		return nil, fmt.Errorf("no local verifying key for circuit %s", circuitID)
+	}
+	return v, nil
+}
+
+// verifyEncodedProof checks a base64 proof of the transition between two base64 roots and adds
+// its size and verification time to res.
+func verifyEncodedProof(v prover.Verifier, res *BlockVerification, proofBase64, oldRootBase64, newRootBase64 string) error {
+	proofBytes, err := base64.StdEncoding.DecodeString(proofBase64)
+	if err != nil {
+		return fmt.Errorf("failed to decode proof: %w", err)
+	}
+	oldRoot, err := rootFromBase64(oldRootBase64)
+	if err != nil {
+		return err
+	}
+	newRoot, err := rootFromBase64(newRootBase64)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = verifyRootProof(v, proofBytes, oldRoot, newRoot)
+	res.VerifyTime += time.Since(start)
+	res.ProofBytes += len(proofBytes)
+	return err
+}
+
+// verifyRootProof checks a serialized proof of the state transition oldRoot -> newRoot. The
+// rollup circuits share their public inputs, so it checks proofs of any of them.
+func verifyRootProof(v prover.Verifier, proofBytes []byte, oldRoot, newRoot *big.Int) error {
+	var publicAssignment rollup.ProofMerkleCircuit
+	publicAssignment.OldRoot = oldRoot
+	publicAssignment.NewRoot = newRoot
+	return v.Verify(proofBytes, &publicAssignment)
+}
+
+// rootFromBase64 decodes a base64 state root as committed on Layer 1.
+func rootFromBase64(encoded string) (*big.Int, error) {
+	root, err := merkle.Base64ToBigInt(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode root %q: %w", encoded, err)
+	}
+	return root, nil
+}
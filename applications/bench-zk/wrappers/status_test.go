@@ -0,0 +1,94 @@
+// wrappers/status_test.go
+package wrappers
+
+import (
+	"strings"
+	"testing"
+
+	"bench-zk/gateway"
+
+	"github.com/consensys/gnark/frontend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// zkLedger answers QueryStateRoot with the root of the block it is called with, or as ZKContract
+// does for a block without one, fails the queries named in errs with their errors and answers the
+// others from results, or with an empty result.
+type zkLedger struct {
+	roots   map[string]string
+	errs    map[string]error
+	results map[string]string
+}
+
+func (l *zkLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return l.EvaluateTransaction(name, args...)
+}
+
+func (l *zkLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if err := l.errs[name]; err != nil {
+		return nil, err
+	}
+	if name == "ZKContract:QueryStateRoot" {
+		root, ok := l.roots[args[0]]
+		if !ok {
+			return nil, status.Error(codes.Unknown, "chaincode response 500, state root not found for block "+args[0])
+		}
+		return []byte(root), nil
+	}
+	if result, ok := l.results[name]; ok {
+		return []byte(result), nil
+	}
+	return nil, nil
+}
+
+// rejectingVerifier fails every proof; the blocks of TestVerifyBlockLookups have none it could verify.
+type rejectingVerifier struct{}
+
+func (rejectingVerifier) Backend() string { return "test" }
+func (rejectingVerifier) Verify([]byte, frontend.Circuit) error {
+	return status.Error(codes.Internal, "unexpected verification")
+}
+func (rejectingVerifier) VerifyingKey() ([]byte, error) { return nil, nil }
+
+// TestVerifyBlockLookups checks that a block only counts as committed without a proof when
+// ZKContract reports that no proof is stored, not when the query fails, and that a proof is only
+// checked with the local verifier of the circuit ZKContract verified it under.
+func TestVerifyBlockLookups(t *testing.T) {
+	notFound := func(what string) error {
+		return status.Error(codes.Unknown, "chaincode response 500, "+what+" not found for block 5")
+	}
+	unavailable := status.Error(codes.Unavailable, "no peers")
+
+	for _, tc := range []struct {
+		name    string
+		newRoot string
+		errs    map[string]error
+		results map[string]string
+		wantErr string // Empty if the block verifies
+	}{
+		{"unchanged", "a", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": notFound("proof chain"), "ZKContract:QueryAggregatedProof": notFound("aggregated proof")}, nil, ""},
+		{"empty proof", "a", map[string]error{"ZKContract:QueryProofChain": notFound("proof chain"), "ZKContract:QueryAggregatedProof": notFound("aggregated proof")}, nil, ""},
+		{"changed without proof", "b", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": notFound("proof chain"), "ZKContract:QueryAggregatedProof": notFound("aggregated proof")}, nil, "has no proof"},
+		{"proof unavailable", "a", map[string]error{"ZKContract:QueryProof": unavailable}, nil, "failed to query proof for block 5"},
+		{"proof chain unavailable", "a", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": unavailable}, nil, "failed to query proof chain for block 5"},
+		{"aggregated proof unavailable", "a", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": notFound("proof chain"), "ZKContract:QueryAggregatedProof": unavailable}, nil, "failed to query aggregated proof for block 5"},
+		{"proof of unknown circuit", "b", nil, map[string]string{"ZKContract:QueryProof": "AA==", "ZKContract:QueryProofCircuitId": "d"}, "no local verifying key for circuit d"},
+		{"proof chain of unknown circuit", "b", map[string]error{"ZKContract:QueryProof": notFound("proof")}, map[string]string{"ZKContract:QueryProofChain": `{"roots":["a","b"],"proofs":["AA=="],"circuitIds":["d"]}`}, "batch 0: no local verifying key for circuit d"},
+		{"proof chain without circuits", "b", map[string]error{"ZKContract:QueryProof": notFound("proof")}, map[string]string{"ZKContract:QueryProofChain": `{"roots":["a","b"],"proofs":["AA=="]}`}, "malformed proof chain"},
+		{"aggregated proof of unknown circuit", "b", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": notFound("proof chain")}, map[string]string{"ZKContract:QueryAggregatedProof": `{"roots":["a","b","b"],"proof":"AA==","circuitId":"d"}`}, "no local verifying key for circuit d"},
+		{"aggregated proof of other roots", "b", map[string]error{"ZKContract:QueryProof": notFound("proof"), "ZKContract:QueryProofChain": notFound("proof chain")}, map[string]string{"ZKContract:QueryAggregatedProof": `{"roots":["a","c"],"proof":"AA==","circuitId":"c"}`}, "does not link the committed roots"},
+	} {
+		zk := gateway.NewZKClient(&zkLedger{map[string]string{"4": "a", "5": tc.newRoot}, tc.errs, tc.results})
+
+		res, err := verifyBlock(zk, Verifiers{"c": rejectingVerifier{}}, 5)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.wantErr == "" && res.HasProof:
+			t.Errorf("%s: unexpected proof %+v", tc.name, res)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: error %v, expected %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// wrappers/transfer.go
+
+package wrappers
+
+import (
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strconv"
+
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// transferFunction is the function name under which Layer 2 blocks record CurrencyContract's
+// Transfer transactions.
+const transferFunction = gateway.CurrencyContractName + ":Transfer"
+
+// Transfer moves Amount BEN from player From to player To on the rollup state.
+type Transfer struct {
+	From   int64
+	To     int64
+	Amount *big.Int
+}
+
+// parseTransfer decodes the arguments (fromID, toID, amount, signature) of a Transfer
+// transaction. The signature is read from the sender's PLAYER write instead, which holds the one
+// CurrencyContract checked.
+func parseTransfer(args []string) (Transfer, error) {
+	if len(args) != 4 {
+		return Transfer{}, fmt.Errorf("expected 4 arguments, got %d", len(args))
+	}
+	var values [3]int64
+	for i, arg := range args[:3] {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return Transfer{}, fmt.Errorf("argument %d: %w", i, err)
+		}
+		values[i] = v
+	}
+	return Transfer{From: values[0], To: values[1], Amount: big.NewInt(values[2])}, nil
+}
+
+// applyTransferTx applies the Transfer transaction tx to UserStates and records it as a transfer
+// of TransferCircuit, which proves the debit and the credit in one slot, signed by the sender on
+// Layer 2. The PLAYER writes of tx must then match the leaves of both players, so the rollup
+// state still mirrors Layer 2.
+func (w *Wrappers) applyTransferTx(log *slog.Logger, tx blocks.Transaction) error {
+	transfer, err := parseTransfer(tx.Args)
+	if err != nil {
+		return err
+	}
+
+	players := make(map[int64]*gateway.Player)
+	for _, write := range tx.Writes {
+		if write.Namespace != w.Gw2.ChaincodeName {
+			continue
+		}
+		player, err := playerFromWrite(write)
+		if err != nil {
+			return fmt.Errorf("write of %q: %w", write.Key, err)
+		}
+		if player == nil {
+			continue // not a PLAYER key
+		}
+		if player.ID != transfer.From && player.ID != transfer.To {
+			return fmt.Errorf("transfer from %d to %d wrote player %d", transfer.From, transfer.To, player.ID)
+		}
+		players[player.ID] = player
+	}
+	sender, ok := players[transfer.From]
+	if !ok {
+		return fmt.Errorf("transfer from %d to %d wrote no sender", transfer.From, transfer.To)
+	}
+
+	slot, newRoot, err := w.applyTransfer(w.UserStates, transfer, sender)
+	if err != nil {
+		return err
+	}
+	w.LatestRootHash = merkle.MerkleRootToBase64(newRoot)
+	w.StateRoots = append(w.StateRoots, w.LatestRootHash)
+	w.CircuitTransactions = append(w.CircuitTransactions, CircuitTransaction{transfer: &slot})
+
+	for _, player := range players {
+		if ben := w.UserStates[w.playerSlot(player.ID)].Ben; ben.Cmp(big.NewInt(player.Balance)) != 0 {
+			return fmt.Errorf("player %d holds %d BEN on Layer 2 but %s on the rollup", player.ID, player.Balance, ben)
+		}
+	}
+
+	log.Debug("Transferred", "from", transfer.From, "to", transfer.To, "amount", transfer.Amount.String())
+	return nil
+}
+
+// transferSlot is the witness of one transfer, ready to be assigned to a TransferCircuit slot.
+type transferSlot struct {
+	amount    *big.Int
+	from      merkle.UserState // Sender leaf before the transfer
+	fromProof *merkle.MProof
+	to        merkle.UserState // Receiver leaf after the debit
+	toProof   *merkle.MProof
+	pubKeyX   *big.Int
+	pubKeyY   *big.Int
+	signature *rollup.Signature
+}
+
+// applyTransfer debits and credits the leaves of transfer in users and returns its witness and
+// the root after it. sender is the sender as Layer 2 wrote it, with its signature of the
+// transfer. Both players must already hold a registered slot.
+func (w *Wrappers) applyTransfer(users []merkle.UserState, transfer Transfer, sender *gateway.Player) (transferSlot, *big.Int, error) {
+	amount := transfer.Amount
+	if amount == nil || amount.Sign() < 0 {
+		return transferSlot{}, nil, fmt.Errorf("invalid amount %v", amount)
+	}
+	fromIndex, toIndex := w.playerSlot(transfer.From), w.playerSlot(transfer.To)
+	if fromIndex < 0 {
+		return transferSlot{}, nil, fmt.Errorf("sender %d has no slot", transfer.From)
+	}
+	if toIndex < 0 {
+		return transferSlot{}, nil, fmt.Errorf("receiver %d has no slot", transfer.To)
+	}
+
+	from := users[fromIndex]
+	if !from.IsAccount() || !users[toIndex].IsAccount() {
+		return transferSlot{}, nil, fmt.Errorf("slots %d and %d must both be account leaves", fromIndex, toIndex)
+	}
+	if from.Ben.Cmp(amount) < 0 {
+		return transferSlot{}, nil, fmt.Errorf("player %d holds %s BEN and cannot send %s", transfer.From, from.Ben, amount)
+	}
+	toBen := new(big.Int).Add(users[toIndex].Ben, amount)
+	if toBen.BitLen() > rollup.BalanceBits {
+		return transferSlot{}, nil, fmt.Errorf("player %d would exceed the maximum balance", transfer.To)
+	}
+
+	if !from.IsRegistered() || !users[toIndex].IsRegistered() {
+		return transferSlot{}, nil, fmt.Errorf("players %d and %d must both have registered a public key", transfer.From, transfer.To)
+	}
+	pubKeyX, pubKeyY := from.PubKeyX, from.PubKeyY
+	signature, err := playerSignature(sender, from.Nonce)
+	if err != nil {
+		return transferSlot{}, nil, err
+	}
+	if !rollup.VerifyTransfer(pubKeyX, pubKeyY, signature, from.Name, users[toIndex].Name, amount, from.Nonce) {
+		return transferSlot{}, nil, fmt.Errorf("the signature of player %d does not sign a transfer of %s BEN to player %d at nonce %s", transfer.From, amount, transfer.To, from.Nonce)
+	}
+
+	// Debit the sender
+	fromProof, err := merkle.GenerateMerkleProofAtWith(w.stateHasher(), users, fromIndex)
+	if err != nil {
+		return transferSlot{}, nil, fmt.Errorf("failed to generate Merkle proof: %w", err)
+	}
+	users[fromIndex] = merkle.UserState{
+		Name:    from.Name,
+		Ben:     new(big.Int).Sub(from.Ben, amount),
+		PubKeyX: pubKeyX,
+		PubKeyY: pubKeyY,
+		Nonce:   new(big.Int).Add(from.Nonce, big.NewInt(1)),
+	}
+
+	// Credit the receiver, proven against the tree after the debit
+	to := users[toIndex]
+	toProof, err := merkle.GenerateMerkleProofAtWith(w.stateHasher(), users, toIndex)
+	if err != nil {
+		return transferSlot{}, nil, fmt.Errorf("failed to generate Merkle proof: %w", err)
+	}
+	users[toIndex] = merkle.UserState{Name: to.Name, Ben: toBen, PubKeyX: to.PubKeyX, PubKeyY: to.PubKeyY, Nonce: to.Nonce}
+	newRoot := merkle.UpdateMerkleRootWith(w.stateHasher(), toProof, users[toIndex])
+
+	return transferSlot{
+		amount:    amount,
+		from:      from,
+		fromProof: fromProof,
+		to:        to,
+		toProof:   toProof,
+		pubKeyX:   pubKeyX,
+		pubKeyY:   pubKeyY,
+		signature: signature,
+	}, newRoot, nil
+}
+
+// assign fills transfer slot k of assignment with s.
+func (s transferSlot) assign(assignment *rollup.TransferCircuit, k int) {
+	slot := &assignment.Transfers[k]
+	slot.Amount = s.amount
+	slot.Enabled = 1
+	slot.From = transferLeaf(s.from, s.fromProof)
+	slot.To = transferLeaf(s.to, s.toProof)
+	slot.PubKey.A.X = s.pubKeyX
+	slot.PubKey.A.Y = s.pubKeyY
+	slot.Signature.R.X = s.signature.RX
+	slot.Signature.R.Y = s.signature.RY
+	slot.Signature.S = s.signature.S
+}
+
+// transferLeaf converts an account leaf and its Merkle proof into a TransferCircuit leaf.
+func transferLeaf(state merkle.UserState, proof *merkle.MProof) rollup.TransferLeaf {
+	leaf := rollup.NewTransferLeaf(rollup.D2)
+	leaf.Name = state.Name
+	leaf.Balance = state.Ben
+	leaf.PubKeyX = state.PubKeyX
+	leaf.PubKeyY = state.PubKeyY
+	leaf.Nonce = state.Nonce
+	for i := range leaf.Siblings {
+		leaf.Siblings[i] = big.NewInt(0)
+		leaf.PathBits[i] = big.NewInt(0)
+		if i < len(proof.Siblings) {
+			leaf.Siblings[i] = proof.Siblings[i]
+		}
+		if i < len(proof.PathBits) && proof.PathBits[i] {
+			leaf.PathBits[i] = big.NewInt(1)
+		}
+	}
+	return leaf
+}
+
+// zeroTransferLeaf returns an all-zero TransferLeaf, as held by disabled transfer slots.
+func zeroTransferLeaf() rollup.TransferLeaf {
+	leaf := rollup.NewTransferLeaf(rollup.D2)
+	leaf.Name, leaf.Balance, leaf.PubKeyX, leaf.PubKeyY, leaf.Nonce = 0, 0, 0, 0, 0
+	for i := range leaf.Siblings {
+		leaf.Siblings[i] = 0
+		leaf.PathBits[i] = 0
+	}
+	return leaf
+}
+
+// disableTransferSlot fills transfer slot k of assignment with a disabled all-zero transfer,
+// signed with the padding key since the circuit verifies every slot's signature.
+func disableTransferSlot(assignment *rollup.TransferCircuit, k int) {
+	slot := &assignment.Transfers[k]
+	slot.Amount = 0
+	slot.Enabled = 0
+	slot.From = zeroTransferLeaf()
+	slot.To = zeroTransferLeaf()
+
+	paddingX, paddingY, paddingSig := rollup.TransferPadding()
+	slot.PubKey.A.X = paddingX
+	slot.PubKey.A.Y = paddingY
+	slot.Signature.R.X = paddingSig.RX
+	slot.Signature.R.Y = paddingSig.RY
+	slot.Signature.S = paddingSig.S
+}
@@ -0,0 +1,145 @@
+// wrappers/transfer_test.go
+package wrappers
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"bench-zk/blocks"
+	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+
+	"github.com/consensys/gnark/test"
+)
+
+// transferTx returns the Transfer transaction of amount BEN from player from to player to, signed
+// by the sender, with the PLAYER writes CurrencyContract makes given the balances before it,
+// which it updates.
+func transferTx(t testing.TB, players *testPlayers, balances map[int64]int64, from, to, amount int64) blocks.Transaction {
+	t.Helper()
+	balances[from] -= amount
+	balances[to] += amount
+	signature := players.signTransfer(from, to, amount)
+	sender := gateway.Player{ID: from, Balance: balances[from], PubKey: players.pubKey(from), Nonce: players.nonces[from], Signature: signature}
+	receiver := gateway.Player{ID: to, Balance: balances[to], PubKey: players.pubKey(to), Nonce: players.nonces[to]}
+	return blocks.Transaction{
+		TxID:          fmt.Sprintf("transfer-%d-%d-%d", from, to, amount),
+		ChaincodeName: "pasic",
+		Function:      transferFunction,
+		Args:          []string{fmt.Sprint(from), fmt.Sprint(to), fmt.Sprint(amount), signature},
+		Writes:        []blocks.Write{playerWrite(t, "pasic", sender), playerWrite(t, "pasic", receiver)},
+	}
+}
+
+// TestProcessTransactionsRollsUpTransfers checks that Transfer transactions are witnessed in
+// chained TransferCircuit batches that the circuit accepts, that a change between transfers ends
+// their batch, and that transfers the rollup cannot mirror fail the block.
+func TestProcessTransactionsRollsUpTransfers(t *testing.T) {
+	users := make([]merkle.UserState, 1<<rollup.D2)
+	for i := range users {
+		users[i] = merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0))
+	}
+	genesis := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))
+	w := &Wrappers{
+		UserStates:     users,
+		StateRoots:     []string{genesis},
+		LatestRootHash: genesis,
+		Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+	}
+
+	// Fund players 100..103 through Layer 2 writes
+	players := newTestPlayers(t)
+	balances := map[int64]int64{}
+	var writes []blocks.Write
+	for id := int64(100); id < 104; id++ {
+		balances[id] = 1000
+		writes = append(writes, playerWrite(t, "pasic", players.signed(id, 1000, 1000)))
+	}
+	if err := w.processTransactions([]blocks.Transaction{{TxID: "fund", Writes: writes}}); err != nil {
+		t.Fatalf("processTransactions failed: %v", err)
+	}
+	if _, err := w.buildAssignments(); err != nil {
+		t.Fatalf("buildAssignments failed: %v", err)
+	}
+	funded := w.LatestRootHash
+
+	// A block of transfers, then an exchange of player 100, then one more transfer
+	var txs []blocks.Transaction
+	for i := 0; i < rollup.T2+1; i++ {
+		txs = append(txs, transferTx(t, players, balances, int64(100+i%4), int64(100+(i+1)%4), int64(10*(i%4+1))))
+	}
+	balances[100] += 500
+	txs = append(txs, blocks.Transaction{TxID: "exchange", Writes: []blocks.Write{
+		playerWrite(t, "pasic", players.signed(100, balances[100], 500)),
+	}})
+	txs = append(txs, transferTx(t, players, balances, 100, 103, 7))
+	if err := w.processTransactions(txs); err != nil {
+		t.Fatalf("processTransactions failed: %v", err)
+	}
+	for id, balance := range balances {
+		if ben := w.UserStates[w.playerSlot(id)].Ben.Int64(); ben != balance {
+			t.Errorf("player %d holds %d BEN on the rollup, %d on Layer 2", id, ben, balance)
+		}
+	}
+	final := w.LatestRootHash
+
+	batches, err := w.buildAssignments()
+	if err != nil {
+		t.Fatalf("buildAssignments failed: %v", err)
+	}
+	kinds := []bool{true, true, false, true}
+	if len(batches) != len(kinds) {
+		t.Fatalf("expected %d batches, got %d", len(kinds), len(batches))
+	}
+	for i, batch := range batches {
+		if batch.transfer != kinds[i] {
+			t.Errorf("batch %d: transfer=%v", i, batch.transfer)
+		}
+		if i > 0 && batch.oldRoot.Cmp(batches[i-1].newRoot) != 0 {
+			t.Errorf("batch %d does not start from the new root of batch %d", i, i-1)
+		}
+	}
+	if merkle.MerkleRootToBase64(batches[0].oldRoot) != funded || merkle.MerkleRootToBase64(batches[3].newRoot) != final {
+		t.Errorf("batches do not chain from the old root to the new root")
+	}
+	// Player 100 was funded, sent 5 + 1 transfers and exchanged once; receiving leaves its nonce
+	if w.UserStates[0].Nonce.Int64() != 1+6+1 {
+		t.Errorf("expected player 100 to have nonce 8, got %v", w.UserStates[0].Nonce)
+	}
+
+	for _, i := range []int{1, 3} {
+		if err := test.IsSolved(rollup.NewTransferCircuit(rollup.D2, rollup.T2), batches[i].assignment, rollup.Curve.ScalarField()); err != nil {
+			t.Errorf("batch %d not solved: %v", i, err)
+		}
+	}
+
+	// Transfers the rollup cannot mirror fail the block. Player 100 signs each at its current
+	// nonce, as none of them is committed.
+	nonce := players.nonces[100]
+	attempt := func(balances map[int64]int64, amount int64) blocks.Transaction {
+		tx := transferTx(t, players, balances, 100, 101, amount)
+		players.nonces[100] = nonce
+		return tx
+	}
+	mismatched := attempt(map[int64]int64{100: balances[100], 101: balances[101]}, 1)
+	mismatched.Args[2] = "2"
+	unsigned := attempt(map[int64]int64{100: balances[100], 101: balances[101]}, 1)
+	unsigned.Writes[0] = playerWrite(t, "pasic", gateway.Player{ID: 100, Balance: balances[100] - 1, PubKey: players.pubKey(100), Nonce: nonce + 1})
+	for name, tx := range map[string]blocks.Transaction{
+		"mismatched writes": mismatched,
+		"unsigned":          unsigned,
+		"overdraft":         attempt(map[int64]int64{}, 100000),
+		"unknown receiver":  attempt(map[int64]int64{}, 1),
+		"malformed":         {TxID: "malformed", ChaincodeName: "pasic", Function: transferFunction, Args: []string{"100", "x", "1", ""}},
+	} {
+		if name == "unknown receiver" {
+			tx.Args[1] = "999"
+		}
+		if err := w.processTransactions([]blocks.Transaction{tx}); err == nil {
+			t.Errorf("%s: processTransactions accepted the transfer", name)
+		}
+	}
+}
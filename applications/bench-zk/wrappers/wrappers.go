@@ -2,61 +2,122 @@
 package wrappers
 
 import (
-	"bytes"
-	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
-	"strconv"
 	"time"
 
-	"bench-zk/circuit"
+	"bench-zk/blocks"
 	"bench-zk/gateway"
+	"bench-zk/logging"
 	"bench-zk/merkle"
+	"bench-zk/prover"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+	"github.com/weids-dev/benchains/circuits/rollup"
 
-	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
-	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Transitions proven by one ProofMerkleCircuit proof and transfers proven by one TransferCircuit
+// proof. Tests shrink them to prove small circuits.
+var (
+	batchSize    = rollup.B2
+	transferSize = rollup.T2
 )
 
 // The Operator wiil use UserState root as input to generate proof for exchangeBen
 // The Operator will use Deposit root as input to generate proof for depositTransaction
 type Wrappers struct {
-	UserStates        []merkle.UserState
-	StateRoots        []string                 // set of intermediate states between each transactions
-	StateProofs       []merkle.MProof          // each Merkle proof to show that the state is exactly in the tree root
-	Gw1               *gateway.Gateway         // Gw1 represents the way operator communicate with Layer 1
-	Gw2               *gateway.Gateway         // Gw2 represents the way operator communicate with Layer 2
-	LatestRoot        int64                    // The latest root committed to Layer 1
-	LatestRootHash    string                   // The latest root hash committed to Layer 1
-	BlockTransactions []merkle.TransactionData // Store transactions for current block
-	DummyUserIndex    int                      // Index of the next available dummy user slot
+	UserStates     []merkle.UserState
+	StateRoots     []string         // set of intermediate states between each transactions
+	Gw1            *gateway.Gateway // Gw1 represents the way operator communicate with Layer 1
+	Gw2            *gateway.Gateway // Gw2 represents the way operator communicate with Layer 2
+	L1Pool         *gateway.Pool    // If set, ZKContract calls are spread over these Layer 1 gateways instead of Gw1
+	LatestRoot     int64            // Block number of the latest root committed to Layer 1
+	LatestRootHash string           // The latest root hash committed to Layer 1
+	DummyUserIndex int              // Index of the next available dummy user slot
+	L1Initialized  bool             // Whether ZKContract holds our verifying key and genesis root
+	StatePath      string           // If set, the state is saved here after every committed block
+	CheckpointPath string           // If set, the last committed block is checkpointed here
+	ProverWorkers  int              // Number of blocks proven concurrently by Operate (at least 1)
+	Metrics        *Metrics         // If set, Operate records its Prometheus metrics here
 
 	// ZK circuit related fields
-	ProofCircuit        *circuit.ProofMerkleCircuit // The circuit for generating proofs
-	CircuitR1CS         constraint.ConstraintSystem // Compiled circuit
-	ProvingKey          interface{}                 // Proving key for the circuit
-	VerifyingKey        interface{}                 // Verifying key for the circuit
-	Initialized         bool                        // Flag to track if circuit is initialized
-	CircuitTransactions []struct {                  // Pre-prepared transaction data for the circuit
-		OldName    *big.Int
-		OldBalance *big.Int
-		NewName    *big.Int
-		BenChange  *big.Int
-		Siblings   []*big.Int
-		PathBits   []bool
-	}
+	Prover              prover.Prover        // Proves and verifies ProofMerkleCircuit with the configured backend
+	TransferProver      prover.Prover        // Proves and verifies TransferCircuit with the same backend
+	Aggregator          *Aggregator          // If set, folds the batch proofs of a block into one proof
+	Hasher              *hasher.Hasher       // Hash function of the rollup state and circuit, MiMC if nil
+	CircuitTransactions []CircuitTransaction // Pre-prepared transaction data for the circuit
+
+	blockLog *slog.Logger // Logger of the block the witness stage is applying, with its number
+}
+
+// CircuitTransaction is one state transition of the rollup state, ready to be assigned to a
+// ProofMerkleCircuit transaction slot.
+type CircuitTransaction struct {
+	OldName    *big.Int
+	OldBalance *big.Int
+	NewName    *big.Int
+	BenChange  *big.Int
+	Siblings   []*big.Int
+	PathBits   []bool
+	OldPubKeyX *big.Int // Key bound by the old leaf, (0, 0) for a fresh account
+	OldPubKeyY *big.Int
+	Nonce      *big.Int // Account nonce before the transition
+	PubKeyX    *big.Int // Key signing the transition and bound by the new leaf
+	PubKeyY    *big.Int
+	Signature  *rollup.Signature // Signature on rollup.Message(NewName, BenChange, Nonce)
+
+	// transfer is set instead of the fields above for a CurrencyContract:Transfer, which is
+	// assigned to a TransferCircuit transfer slot
+	transfer *transferSlot
 }
 
 // NewWrappers initializes a new Wrappers instance.
 // It receives two hain configurations to initialize Gw1 and Gw2,
 // and initializes UserStates and Deposits as empty slices.
+// The circuits are compiled and a fresh Groth16 setup is run, so the keys only live in memory.
 func NewWrappers(chain1, chain2 gateway.Chain) (*Wrappers, error) {
+	// Initialize ZK Circuit
+	slog.Info("Initializing ZK circuits")
+	p, err := prover.New(prover.Groth16, rollup.NewProofMerkleCircuit(rollup.D2, batchSize))
+	if err != nil {
+		return nil, err
+	}
+	tp, err := prover.New(prover.Groth16, rollup.NewTransferCircuit(rollup.D2, transferSize))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("ZK circuits initialized")
+
+	return newWrappers(chain1, chain2, p, tp)
+}
+
+// NewWrappersWithKeys initializes a new Wrappers instance using the circuits and keys
+// previously written to keyDir by SetupKeys for backend and h.
+func NewWrappersWithKeys(chain1, chain2 gateway.Chain, keyDir, backend string, h *hasher.Hasher) (*Wrappers, error) {
+	slog.Info("Loading ZK circuits and keys", "hasher", h.Name(), "backend", backend, "keyDir", keyDir)
+	p, err := prover.Load(backend, circuitKeyDir(keyDir, h))
+	if err != nil {
+		return nil, err
+	}
+	tp, err := prover.Load(backend, transferKeyDir(keyDir, h))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("ZK circuits loaded")
+
+	w, err := newWrappers(chain1, chain2, p, tp)
+	if err != nil {
+		return nil, err
+	}
+	w.Hasher = h
+	return w, nil
+}
+
+func newWrappers(chain1, chain2 gateway.Chain, p, tp prover.Prover) (*Wrappers, error) {
 	// Initialize Gw1
 	gw1, err := gateway.NewGateway(chain1)
 	if err != nil {
@@ -70,60 +131,66 @@ func NewWrappers(chain1, chain2 gateway.Chain) (*Wrappers, error) {
 		return nil, fmt.Errorf("failed to initialize Gw2: %w", err)
 	}
 
-	// Initialize ZK Circuit
-	log.Println("Initializing ZK circuit...")
-	var zkCircuit circuit.ProofMerkleCircuit
+	// Initialize Wrappers with empty UserStates and Deposits
+	return &Wrappers{
+		UserStates:     []merkle.UserState{},
+		StateRoots:     []string{}, // set of intermediate states between each transactions
+		Gw1:            gw1,
+		Gw2:            gw2,
+		LatestRoot:     0,
+		LatestRootHash: "",
+		DummyUserIndex: 0,
+		ProverWorkers:  1,
+		Prover:         p,
+		TransferProver: tp,
+
+		CircuitTransactions: []CircuitTransaction{},
+	}, nil
+}
 
-	// Compile the circuit to R1CS
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &zkCircuit)
-	if err != nil {
-		gw1.Close()
-		gw2.Close()
-		return nil, fmt.Errorf("failed to compile ZK circuit: %w", err)
+// stateHasher returns the hash function of the rollup state.
+func (w *Wrappers) stateHasher() *hasher.Hasher {
+	if w.Hasher == nil {
+		return hasher.MiMC
 	}
+	return w.Hasher
+}
 
-	// Setup proving and verifying keys
-	pk, vk, err := groth16.Setup(ccs)
-	if err != nil {
-		gw1.Close()
-		gw2.Close()
-		return nil, fmt.Errorf("failed to setup ZK proving/verifying keys: %w", err)
+// zk returns the client of ZKContract on Layer 1, through L1Pool if it is set.
+func (w *Wrappers) zk() *gateway.ZKClient {
+	if w.L1Pool != nil {
+		return gateway.NewZKClient(w.L1Pool)
 	}
-	log.Println("ZK circuit initialized successfully")
+	return w.Gw1.ZK()
+}
 
-	// Initialize Wrappers with empty UserStates and Deposits
-	return &Wrappers{
-		UserStates:        []merkle.UserState{},
-		StateRoots:        []string{},        // set of intermediate states between each transactions
-		StateProofs:       []merkle.MProof{}, // each Merkle proof to show that the state is exactly in the tree root.
-		Gw1:               gw1,
-		Gw2:               gw2,
-		LatestRoot:        0,
-		LatestRootHash:    "",
-		BlockTransactions: []merkle.TransactionData{},
-		DummyUserIndex:    0,
-		ProofCircuit:      &zkCircuit,
-		CircuitR1CS:       ccs,
-		ProvingKey:        pk,
-		VerifyingKey:      vk,
-		Initialized:       true,
-		CircuitTransactions: []struct {
-			OldName    *big.Int
-			OldBalance *big.Int
-			NewName    *big.Int
-			BenChange  *big.Int
-			Siblings   []*big.Int
-			PathBits   []bool
-		}{},
-	}, nil
+// CheckContracts checks that the chaincodes deployed on Layer 1 and Layer 2 have the
+// transactions the operator calls, with the same signatures, so that a chaincode of another
+// version is reported at startup rather than when the first block is committed.
+func (w *Wrappers) CheckContracts() error {
+	var l1 gateway.Invoker = w.Gw1.Contract
+	if w.L1Pool != nil {
+		l1 = w.L1Pool
+	}
+	if err := gateway.CheckContracts(l1, gateway.ZKSpec); err != nil {
+		return fmt.Errorf("chaincode %s on Layer 1: %w", w.Gw1.ChaincodeName, err)
+	}
+	if err := gateway.CheckContracts(w.Gw2.Contract, gateway.CurrencySpec); err != nil {
+		return fmt.Errorf("chaincode %s on Layer 2: %w", w.Gw2.ChaincodeName, err)
+	}
+	return nil
 }
 
-// Close gracefully closes both gateways within Wrappers.
+// Close gracefully closes both gateways and the Layer 1 pool within Wrappers.
 func (w *Wrappers) Close() error {
 	if w.Gw1 != nil {
 		w.Gw1.Close()
 	}
 
+	if w.L1Pool != nil {
+		w.L1Pool.Close()
+	}
+
 	if w.Gw2 != nil {
 		w.Gw2.Close()
 	}
@@ -138,723 +205,457 @@ func (w *Wrappers) initializeUserStates() error {
 	}
 
 	// Get all players from Layer 2
-	currencyContract := w.Gw2.Gateway.GetNetwork(w.Gw2.ChannelName).GetContract(w.Gw2.ChaincodeName)
-	evaluateResult, err := currencyContract.EvaluateTransaction("CurrencyContract:GetAllPlayers")
+	players, err := w.Gw2.Currency().GetAllPlayers()
 	if err != nil {
 		return fmt.Errorf("failed to get players: %w", err)
 	}
 
-	var players []*gateway.Player
-	if len(evaluateResult) > 0 {
-		if err := json.Unmarshal(evaluateResult, &players); err != nil {
-			return fmt.Errorf("failed to unmarshal players: %w", err)
-		}
-	}
-
-	// Initialize UserStates with existing players
+	// Initialize UserStates with existing players, bound to the keys they registered. Players
+	// without a key have no BEN, which EnableRollup checked, and get no slot.
 	for _, player := range players {
-		balanceInt := int64(player.Balance * 1000) // Assuming 3 decimal places
 		nameInt := big.NewInt(player.ID)
-		benInt := big.NewInt(balanceInt)
-		w.UserStates = append(w.UserStates, merkle.UserState{
-			Name: nameInt,
-			Ben:  benInt,
-		})
+		benInt := big.NewInt(player.Balance) // Already in 3 decimal places
+		if player.PubKey == "" {
+			if player.Balance != 0 {
+				return fmt.Errorf("player %d holds %d BEN but registered no public key", player.ID, player.Balance)
+			}
+			continue
+		}
+		pubKeyX, pubKeyY, err := rollup.DecodePublicKey(player.PubKey)
+		if err != nil {
+			return fmt.Errorf("player %d: %w", player.ID, err)
+		}
+		leaf := merkle.NewRegisteredAccount(nameInt, benInt, pubKeyX, pubKeyY)
+		leaf.Nonce = big.NewInt(player.Nonce) // The next change is signed at the player's nonce on Layer 2
+		w.UserStates = append(w.UserStates, leaf)
 	}
 
+	// Set DummyUserIndex
+	w.DummyUserIndex = len(w.UserStates)
+
 	// Fill remaining slots with dummy users
-	maxUsers := 1 << circuit.D2 // 2^D2 users
-	for i := len(players); i < maxUsers; i++ {
+	maxUsers := 1 << rollup.D2 // 2^D2 users
+	for i := len(w.UserStates); i < maxUsers; i++ {
 		nameInt := big.NewInt(int64(i + 1)) // Names start at 1
 		benInt := big.NewInt(0)
-		w.UserStates = append(w.UserStates, merkle.UserState{
-			Name: nameInt,
-			Ben:  benInt,
-		})
+		w.UserStates = append(w.UserStates, merkle.NewAccount(nameInt, benInt))
 	}
 
-	// Set DummyUserIndex
-	w.DummyUserIndex = len(players)
-
 	// Compute initial root
-	initialRoot := merkle.BuildMerkleStates(w.UserStates)
+	initialRoot := merkle.BuildMerkleStatesWith(w.stateHasher(), w.UserStates)
 	w.LatestRootHash = merkle.MerkleRootToBase64(initialRoot)
 	w.LatestRoot = 0 // Initial block number
 
 	// Store the initial root in StateRoots
 	w.StateRoots = append(w.StateRoots, w.LatestRootHash)
 
-	log.Printf("Initialized state with %d users (%d existing, %d dummy), root: %s",
-		maxUsers, len(players), maxUsers-len(players), w.LatestRootHash)
+	slog.Info("Initialized state", "users", maxUsers, "existing", w.DummyUserIndex, "dummy", maxUsers-w.DummyUserIndex,
+		"root", w.LatestRootHash)
 
 	return nil
 }
 
-func (w *Wrappers) Operate(ctx context.Context) error {
+// InitL1 enables the rollup on Layer 2, builds the genesis rollup state from the players
+// currently there and installs the verifying key and the genesis root in ZKContract on Layer 1.
+// The genesis root is recorded as the root of block 1, so the first Layer 2 block the operator
+// commits is block 2.
+func (w *Wrappers) InitL1() error {
+	// From now on Layer 2 rejects players the rollup cannot sign for: players without a key
+	// cannot get BEN, and new players must register one
+	if err := w.Gw2.Currency().EnableRollup(); err != nil {
+		slog.Error("Failed to enable the rollup on Layer 2", "err", err)
+		return err
+	}
+
 	// Initialize user states
 	if err := w.initializeUserStates(); err != nil {
-		log.Printf("Failed to initialize user states: %v", err)
+		slog.Error("Failed to initialize user states", "err", err)
 		return err
 	}
 
 	// Serialize verifying key
-	var buf bytes.Buffer
-	vk := w.VerifyingKey.(groth16.VerifyingKey)
-	_, err := vk.WriteRawTo(&buf)
+	vk, err := w.Prover.VerifyingKey()
 	if err != nil {
-		log.Printf("Failed to serialize verifying key: %v", err)
+		slog.Error("Failed to serialize verifying key", "err", err)
+		return err
+	}
+	verifyingKeyBase64 := base64.StdEncoding.EncodeToString(vk)
+	fingerprint, err := w.Prover.Fingerprint()
+	if err != nil {
+		slog.Error("Failed to fingerprint circuit", "err", err)
 		return err
 	}
-	verifyingKeyBase64 := base64.StdEncoding.EncodeToString(buf.Bytes())
-
-	// Get ZKContract from Layer 1 gateway
-	zkContract := w.Gw1.Gateway.GetNetwork(w.Gw1.ChannelName).GetContract(w.Gw1.ChaincodeName)
 
-	// Call InitLedger on ZKContract
-	_, err = zkContract.SubmitTransaction("ZKContract:InitLedger", verifyingKeyBase64, w.LatestRootHash)
+	// Call InitLedger on ZKContract, registering the verifier of our circuit and backend
+	// together with the fingerprint of the constraint system its key was set up for
+	err = w.zk().InitLedger(w.circuitID(), w.Prover.Backend(), verifyingKeyBase64, fingerprint, w.LatestRootHash)
 	if err != nil {
-		log.Printf("Failed to initialize ZKContract: %v", err)
+		slog.Error("Failed to initialize ZKContract", "err", err)
 		return err
 	}
-	log.Println("Initialized ZKContract successfully")
-	newestCommittedBlockNumber := uint64(1)   // Initially, no blocks committed
-	ticker := time.NewTicker(5 * time.Second) // 5 seconds interval
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Operator stopped due to context cancellation")
-			return nil
-		case <-ticker.C:
-			// Step 1: Get the newest block number from Layer 2
-			syscontract := w.Gw2.Gateway.GetNetwork(w.Gw2.ChannelName).GetContract("qscc")
-			newestBlockNumber, err := getNewestBlockNumber(syscontract, w.Gw2.ChannelName)
-			if err != nil {
-				log.Println("Error getting newest block number:", err)
-				continue
-			}
+	slog.Info("Initialized ZKContract")
 
-			fmt.Printf("Newest block number: %d || Newest committed block number: %d\n", newestBlockNumber, newestCommittedBlockNumber)
-
-			// Step 2: Check if there are new blocks to process
-			if newestBlockNumber > newestCommittedBlockNumber {
-				fmt.Printf("Found new blocks to commit: %d to %d    || ", newestCommittedBlockNumber+1, newestBlockNumber)
-
-				// Step 3: Process all new blocks
-				for blockNumber := newestCommittedBlockNumber + 1; blockNumber <= newestBlockNumber; blockNumber++ {
-					snum := strconv.FormatUint(blockNumber, 10)
-					blockBytes := getBlockByNumber(syscontract, w.Gw2.ChannelName, snum)
-					block, err := decodeBlock(blockBytes) // Using decode package
-					if err != nil {
-						fmt.Println("Error decoding block:", err)
-						continue
-					}
-
-					transactions, err := extractTransactions(block)
-					if err != nil {
-						fmt.Println("Error extracting transactions:", err)
-						continue
-					}
-
-					fmt.Printf("Number of transactions in this block: %d   || ", len(transactions))
-
-					// Clear block transactions before processing new ones
-					w.BlockTransactions = []merkle.TransactionData{}
-
-					// Process transactions before computing Merkle root
-					err = w.processTransactions(transactions)
-
-					if err != nil {
-						fmt.Printf("Error processing transactions: %v\n", err)
-						continue
-					}
-
-					// Generate ZK proof for this block
-					oldRoot, newRoot, proofBytes, err := w.generateZKProof()
-					if err != nil {
-						fmt.Printf("Error generating ZK proof: %v\n", err)
-						continue
-					}
-
-					// Get the current state root to use in case of no change
-					currentRootBase64 := w.LatestRootHash
-					zkContract := w.Gw1.Gateway.GetNetwork(w.Gw1.ChannelName).GetContract(w.Gw1.ChaincodeName)
-
-					if proofBytes == nil {
-						// No state-changing transactions; commit the current root as unchanged
-						fmt.Printf("No state-changing transactions for block %d, committing unchanged state root\n", blockNumber)
-						_, err = zkContract.SubmitTransaction("ZKContract:CommitNoChange", snum, currentRootBase64)
-						if err != nil {
-							log.Printf("Failed to commit no-change state for block %s: %v", snum, err)
-							continue
-						}
-						log.Printf("Committed unchanged state root for block %s successfully", snum)
-					} else {
-						// State changed; verify and commit the proof
-						log.Printf("Old root: %v, New root: %v", oldRoot, newRoot)
-						log.Printf("Proof: %v", proofBytes)
-
-						proof, err := deserializeProof(proofBytes)
-						if err != nil {
-							log.Printf("Error deserializing proof: %v", err)
-							continue
-						}
-
-						var publicAssignment circuit.ProofMerkleCircuit
-						publicAssignment.OldRoot = oldRoot
-						publicAssignment.NewRoot = newRoot
-
-						publicWitness, err := frontend.NewWitness(&publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
-						if err != nil {
-							log.Printf("Error creating public witness: %v", err)
-							continue
-						}
-
-						vk := w.VerifyingKey.(groth16.VerifyingKey)
-						err = groth16.Verify(proof, vk, publicWitness)
-						if err != nil {
-							log.Printf("Proof verification failed for block %d: %v", blockNumber, err)
-							continue
-						}
-						log.Printf("Proof verified successfully for block %d", blockNumber)
-
-						proofBase64 := base64.StdEncoding.EncodeToString(proofBytes)
-						oldRootBase64 := merkle.MerkleRootToBase64(oldRoot)
-						newRootBase64 := merkle.MerkleRootToBase64(newRoot)
-						_, err = zkContract.SubmitTransaction("ZKContract:CommitProof", snum, oldRootBase64, newRootBase64, proofBase64)
-						if err != nil {
-							log.Printf("Failed to commit proof for block %s: %v", snum, err)
-							continue
-						}
-						log.Printf("Committed proof for block %s successfully", snum)
-					}
-
-					// Step 1: Query the state root for the just-committed block and verify it
-					stateRootBytes, err := zkContract.EvaluateTransaction("ZKContract:QueryStateRoot", snum)
-					if err != nil {
-						log.Printf("Failed to query state root for block %s: %v", snum, err)
-						continue
-					}
-					committedStateRoot := string(stateRootBytes)
-					if committedStateRoot != w.LatestRootHash {
-						log.Printf("State root mismatch for block %s: expected %s, got %s", snum, w.LatestRootHash, committedStateRoot)
-						continue
-					}
-					log.Printf("State root for block %s verified: %s", snum, committedStateRoot)
-
-					// Step 2: Query all state roots and pretty-print them
-					allStateRootsBytes, err := zkContract.EvaluateTransaction("ZKContract:QueryAllStateRoots")
-					if err != nil {
-						log.Printf("Failed to query all state roots: %v", err)
-						continue
-					}
-					allStateRootsJSON := string(allStateRootsBytes)
-					prettyPrintStateRoots(allStateRootsJSON)
-				}
-				newestCommittedBlockNumber = newestBlockNumber
-			}
-		}
+	// InitLedger binds the rollup to ProofMerkleCircuit; the transfers are proven by
+	// TransferCircuit, whose verifier is registered next to it
+	transferFingerprint, err := w.TransferProver.Fingerprint()
+	if err != nil {
+		slog.Error("Failed to fingerprint circuit", "err", err)
+		return err
 	}
+	if err := w.registerVerifier(w.transferCircuitID(), w.TransferProver, transferFingerprint); err != nil {
+		slog.Error("Failed to register the verifier of TransferCircuit", "err", err)
+		return err
+	}
+
+	w.LatestRoot = 1
+	w.L1Initialized = true
+	return nil
 }
 
-// generateZKProof generates a ZK proof for the current block's transactions
-func (w *Wrappers) generateZKProof() (*big.Int, *big.Int, []byte, error) {
-	if !w.Initialized {
-		return nil, nil, nil, fmt.Errorf("ZK circuit not initialized")
-	}
+// proofBatch is one proof's worth of state transitions, moving the state from oldRoot to newRoot:
+// at most batchSize transitions of ProofMerkleCircuit, or transferSize transfers of TransferCircuit.
+type proofBatch struct {
+	oldRoot    *big.Int
+	newRoot    *big.Int
+	transfer   bool   // Proven by TransferCircuit rather than ProofMerkleCircuit
+	circuitID  string // Circuit ID ZKContract verifies the proof under, set by the prover
+	assignment frontend.Circuit
+}
 
+// buildAssignments turns the state transitions recorded by processTransactions into circuit
+// assignments for the current block, and resets them for the next block. The transitions are
+// split into consecutive batches whose roots chain into each other: the first starts at the
+// block's old root and the last ends at its new root. A batch holds consecutive transitions of
+// one circuit, so a transfer between two other changes ends a batch, and at most as many as the
+// circuit has slots. It returns no batches when the block did not change the state. Proving the
+// assignments is left to proveAssignment, so it can run concurrently with building the next
+// block's assignments.
+func (w *Wrappers) buildAssignments() ([]proofBatch, error) {
+	log := w.witnessLog()
 	if len(w.StateRoots) < 2 {
-		log.Printf("Possible reason: No transactions that will change the UserStates in this block")
-		return nil, nil, nil, nil
+		log.Debug("No transactions changed the user states")
+		return nil, nil
 	}
 
-	oldRootBase64 := w.StateRoots[0]
-	newRootBase64 := w.StateRoots[len(w.StateRoots)-1]
-	log.Printf("Old root: %v, New root: %v", oldRootBase64, newRootBase64)
-
-	oldRootBytes, err := merkle.Base64ToBytes(oldRootBase64)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to decode old root: %w", err)
+	// StateRoots[k] is the root before transition k and StateRoots[k+1] the root after it
+	roots := make([]*big.Int, len(w.StateRoots))
+	for k, rootBase64 := range w.StateRoots {
+		rootBytes, err := merkle.Base64ToBytes(rootBase64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode state root %d: %w", k, err)
+		}
+		roots[k] = new(big.Int).SetBytes(rootBytes)
 	}
-	oldRoot := new(big.Int).SetBytes(oldRootBytes)
 
-	newRootBytes, err := merkle.Base64ToBytes(newRootBase64)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to decode new root: %w", err)
+	txCount := len(w.CircuitTransactions)
+	var batches []proofBatch
+	for start := 0; start < txCount; {
+		transfer := w.CircuitTransactions[start].transfer != nil
+		size := batchSize
+		if transfer {
+			size = transferSize
+		}
+		end := start + 1
+		for end < txCount && end-start < size && (w.CircuitTransactions[end].transfer != nil) == transfer {
+			end++
+		}
+
+		batch := proofBatch{oldRoot: roots[start], newRoot: roots[end], transfer: transfer}
+		if transfer {
+			batch.assignment = transferAssignment(roots[start], roots[end], w.CircuitTransactions[start:end])
+		} else {
+			batch.assignment = merkleAssignment(roots[start], roots[end], w.CircuitTransactions[start:end])
+		}
+		batches = append(batches, batch)
+		start = end
 	}
-	newRoot := new(big.Int).SetBytes(newRootBytes)
+	log.Info("Generated ZK assignments", "transitions", txCount, "batches", len(batches),
+		"oldRoot", w.StateRoots[0], "newRoot", w.StateRoots[len(w.StateRoots)-1])
+
+	w.StateRoots = []string{w.StateRoots[len(w.StateRoots)-1]}
+	w.CircuitTransactions = []CircuitTransaction{}
+
+	return batches, nil
+}
 
-	var assignment circuit.ProofMerkleCircuit
+// transferAssignment returns the TransferCircuit assignment of txs, at most transferSize transfers
+// moving the state from oldRoot to newRoot.
+func transferAssignment(oldRoot, newRoot *big.Int, txs []CircuitTransaction) *rollup.TransferCircuit {
+	assignment := rollup.NewTransferCircuit(rollup.D2, transferSize)
 	assignment.OldRoot = oldRoot
 	assignment.NewRoot = newRoot
-
-	txCount := len(w.CircuitTransactions)
-	if txCount > circuit.B2 {
-		txCount = circuit.B2
+	for k, ctxData := range txs {
+		ctxData.transfer.assign(assignment, k)
 	}
-	log.Printf("Generating ZK proof for %d transactions", txCount)
+	for k := len(txs); k < transferSize; k++ {
+		disableTransferSlot(assignment, k)
+	}
+	return assignment
+}
+
+// merkleAssignment returns the ProofMerkleCircuit assignment of txs, at most batchSize state
+// transitions moving the state from oldRoot to newRoot.
+func merkleAssignment(oldRoot, newRoot *big.Int, txs []CircuitTransaction) *rollup.ProofMerkleCircuit {
+	assignment := rollup.NewProofMerkleCircuit(rollup.D2, batchSize)
+	assignment.OldRoot = oldRoot
+	assignment.NewRoot = newRoot
 
 	// Process real transactions
-	for k := 0; k < txCount; k++ {
-		ctxData := w.CircuitTransactions[k]
-		var pathBits [circuit.D2]frontend.Variable
-		for i := 0; i < circuit.D2; i++ {
-			if i < len(ctxData.PathBits) {
-				if ctxData.PathBits[i] {
-					pathBits[i] = big.NewInt(1)
-				} else {
-					pathBits[i] = big.NewInt(0)
-				}
-			} else {
-				pathBits[i] = big.NewInt(0)
+	for k, ctxData := range txs {
+		slot := &assignment.Transactions[k]
+		for i := range slot.PathBits {
+			slot.PathBits[i] = big.NewInt(0)
+			if i < len(ctxData.PathBits) && ctxData.PathBits[i] {
+				slot.PathBits[i] = big.NewInt(1)
 			}
 		}
-		var siblings [circuit.D2]frontend.Variable
-		for i := 0; i < circuit.D2; i++ {
+		for i := range slot.Siblings {
+			slot.Siblings[i] = big.NewInt(0)
 			if i < len(ctxData.Siblings) {
-				siblings[i] = ctxData.Siblings[i]
-			} else {
-				siblings[i] = big.NewInt(0)
+				slot.Siblings[i] = ctxData.Siblings[i]
 			}
 		}
-		assignment.Transactions[k].OldName = ctxData.OldName
-		assignment.Transactions[k].OldBalance = ctxData.OldBalance
-		assignment.Transactions[k].NewName = ctxData.NewName
-		assignment.Transactions[k].BenChange = ctxData.BenChange
-		assignment.Transactions[k].Siblings = siblings
-		assignment.Transactions[k].PathBits = pathBits
-	}
-
-	// Fill remaining slots with valid dummy transactions
-	for k := txCount; k < circuit.B2; k++ {
-		// Use leaf index 0 from current state as a dummy
-		dummyIndex := 0
-		oldState := w.UserStates[dummyIndex]
-		oldStateHash := merkle.HashUserState(oldState)
-		proof, err := merkle.GenerateMerkleProof(w.UserStates, oldStateHash)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to generate dummy proof: %w", err)
-		}
-		var pathBits [circuit.D2]frontend.Variable
-		for i := 0; i < circuit.D2; i++ {
-			if i < len(proof.PathBits) {
-				if proof.PathBits[i] {
-					pathBits[i] = big.NewInt(1)
-				} else {
-					pathBits[i] = big.NewInt(0)
-				}
-			} else {
-				pathBits[i] = big.NewInt(0)
-			}
-		}
-		var siblings [circuit.D2]frontend.Variable
-		for i := 0; i < circuit.D2; i++ {
-			if i < len(proof.Siblings) {
-				siblings[i] = proof.Siblings[i]
-			} else {
-				siblings[i] = big.NewInt(0)
-			}
-		}
-		assignment.Transactions[k].OldName = oldState.Name
-		assignment.Transactions[k].OldBalance = oldState.Ben
-		assignment.Transactions[k].NewName = oldState.Name
-		assignment.Transactions[k].BenChange = big.NewInt(0)
-		assignment.Transactions[k].Siblings = siblings
-		assignment.Transactions[k].PathBits = pathBits
-	}
+		slot.OldName = ctxData.OldName
+		slot.OldBalance = ctxData.OldBalance
+		slot.NewName = ctxData.NewName
+		slot.BenChange = ctxData.BenChange
+		slot.Enabled = 1
+		slot.OldPubKeyX = ctxData.OldPubKeyX
+		slot.OldPubKeyY = ctxData.OldPubKeyY
+		slot.Nonce = ctxData.Nonce
+		slot.PubKey.A.X = ctxData.PubKeyX
+		slot.PubKey.A.Y = ctxData.PubKeyY
+		slot.Signature.R.X = ctxData.Signature.RX
+		slot.Signature.R.Y = ctxData.Signature.RY
+		slot.Signature.S = ctxData.Signature.S
+	}
+
+	// Disable the remaining slots; the circuit ignores their contents, so zeros will do
+	for k := len(txs); k < batchSize; k++ {
+		disableSlot(assignment, k)
+	}
+	return assignment
+}
 
-	log.Println("Creating witness for ZK proof...")
-	fullWitness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to create witness: %w", err)
-	}
+// disableSlot fills transaction slot k of assignment with a disabled all-zero transaction,
+// signed with the padding key since the circuit verifies every slot's signature.
+func disableSlot(assignment *rollup.ProofMerkleCircuit, k int) {
+	slot := &assignment.Transactions[k]
+	slot.OldName = 0
+	slot.OldBalance = 0
+	slot.NewName = 0
+	slot.BenChange = 0
+	for i := range slot.Siblings {
+		slot.Siblings[i] = 0
+		slot.PathBits[i] = 0
+	}
+	slot.Enabled = 0
+
+	paddingX, paddingY, paddingSig := rollup.Padding()
+	slot.OldPubKeyX = 0
+	slot.OldPubKeyY = 0
+	slot.Nonce = 0
+	slot.PubKey.A.X = paddingX
+	slot.PubKey.A.Y = paddingY
+	slot.Signature.R.X = paddingSig.RX
+	slot.Signature.R.Y = paddingSig.RY
+	slot.Signature.S = paddingSig.S
+}
 
-	log.Println("Generating ZK proof...")
+// proveAssignment generates a proof for assignment with p, the prover of its circuit.
+// It only reads the compiled circuit and proving key, so several proofs can be generated at once.
+func (w *Wrappers) proveAssignment(p prover.Prover, assignment frontend.Circuit) ([]byte, error) {
 	start := time.Now()
-	proof, err := groth16.Prove(w.CircuitR1CS, w.ProvingKey.(groth16.ProvingKey), fullWitness)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to generate proof: %w", err)
-	}
-	log.Printf("ZK proof generated in %v", time.Since(start))
-
-	proofBytes, err := serializeProof(proof)
+	proofBytes, err := p.Prove(assignment)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to serialize proof: %w", err)
+		return nil, err
 	}
-
-	w.StateProofs = []merkle.MProof{}
-	w.StateRoots = []string{newRootBase64}
-	w.CircuitTransactions = []struct {
-		OldName    *big.Int
-		OldBalance *big.Int
-		NewName    *big.Int
-		BenChange  *big.Int
-		Siblings   []*big.Int
-		PathBits   []bool
-	}{}
-
-	return oldRoot, newRoot, proofBytes, nil
+	w.Metrics.proofGenerated(p.Backend(), time.Since(start))
+	return proofBytes, nil
 }
 
-func (w *Wrappers) processTransactions(transactions []merkle.TransactionData) error {
+// processTransactions applies the PLAYER writes of the block's valid transactions to UserStates.
+// The operator does not re-implement CurrencyContract: whatever the chaincode computed (exchange
+// rates, balance checks, ...) is taken from the read-write set, so the rollup state mirrors Layer 2.
+// Transfers are the exception: they are applied from their arguments as transfers of
+// TransferCircuit (see applyTransferTx), then checked against their writes.
+// A PLAYER write that cannot be decoded or applied fails the whole block.
+func (w *Wrappers) processTransactions(transactions []blocks.Transaction) error {
 	if len(transactions) == 0 {
 		return nil
 	}
 
-	// Store transactions for this block
-	w.BlockTransactions = transactions
-
 	// Clear CircuitTransactions before processing new transactions
-	w.CircuitTransactions = []struct {
-		OldName    *big.Int
-		OldBalance *big.Int
-		NewName    *big.Int
-		BenChange  *big.Int
-		Siblings   []*big.Int
-		PathBits   []bool
-	}{}
+	w.CircuitTransactions = []CircuitTransaction{}
 
 	// Process each transaction in the block
 	for i, tx := range transactions {
-		log.Printf("Processing transaction %d: %s", i, tx.TxID)
+		log := w.witnessLog().With(logging.Tx(tx.TxID))
+		log.Debug("Processing transaction", "index", i, "function", tx.Function)
 
-		// Skip transaction if there are no args
-		if len(tx.Args) < 1 {
-			log.Printf("Transaction %s has no arguments, skipping", tx.TxID)
+		if tx.ChaincodeName == w.Gw2.ChaincodeName && tx.Function == transferFunction {
+			if err := w.applyTransferTx(log, tx); err != nil {
+				return fmt.Errorf("transaction %s: %w", tx.TxID, err)
+			}
 			continue
 		}
 
-		contractMethod := tx.Args[0]
-
-		switch contractMethod {
-		case "CurrencyContract:CreatePlayer":
-			log.Printf("CreatePlayer transaction args: %v", tx.Args)
-			if len(tx.Args) < 2 {
-				log.Printf("Invalid CreatePlayer transaction: missing arguments")
+		for _, write := range tx.Writes {
+			if write.Namespace != w.Gw2.ChaincodeName {
 				continue
 			}
 
-			// Parse player name (usually a number in string form)
-			playerName, err := strconv.ParseInt(tx.Args[1], 10, 64)
+			// A write the rollup cannot mirror would make its state drift from Layer 2, so it
+			// fails the block instead of being skipped
+			player, err := playerFromWrite(write)
 			if err != nil {
-				log.Printf("Error parsing player name: %v", err)
-				continue
+				return fmt.Errorf("transaction %s: write of %q: %w", tx.TxID, write.Key, err)
 			}
-			nameInt := big.NewInt(playerName)
-
-			// Check if dummy user slots are available
-			if w.DummyUserIndex >= len(w.UserStates) {
-				log.Printf("No available slots for new player")
-				continue
+			if player == nil {
+				continue // not a PLAYER key
 			}
 
-			dummyIndex := w.DummyUserIndex
-			if dummyIndex >= len(w.UserStates) {
-				log.Printf("No available slots for new player")
-				continue
+			if err := w.applyPlayer(log, player); err != nil {
+				return fmt.Errorf("transaction %s: player %d: %w", tx.TxID, player.ID, err)
 			}
-
-			// Get old state and generate proof *before* update
-			oldState := w.UserStates[dummyIndex]
-			oldStateHash := merkle.HashUserState(oldState)
-			proof, err := merkle.GenerateMerkleProof(w.UserStates, oldStateHash)
-			if err != nil {
-				log.Printf("Error generating Merkle proof: %v", err)
-				continue
-			}
-
-			// Now update the state
-			w.UserStates[dummyIndex].Name = nameInt // Ben remains 0
-			w.DummyUserIndex++
-
-			// Compute new root
-			newRoot := merkle.UpdateMerkleRoot(proof, w.UserStates[dummyIndex])
-			w.LatestRootHash = merkle.MerkleRootToBase64(newRoot)
-
-			// Store proof and root
-			w.StateProofs = append(w.StateProofs, *proof)
-			w.StateRoots = append(w.StateRoots, w.LatestRootHash)
-
-			// Prepare circuit transaction
-			w.CircuitTransactions = append(w.CircuitTransactions, struct {
-				OldName    *big.Int
-				OldBalance *big.Int
-				NewName    *big.Int
-				BenChange  *big.Int
-				Siblings   []*big.Int
-				PathBits   []bool
-			}{
-				OldName:    oldState.Name,
-				OldBalance: oldState.Ben,
-				NewName:    nameInt,
-				BenChange:  big.NewInt(0),
-				Siblings:   proof.Siblings,
-				PathBits:   proof.PathBits,
-			})
-
-			log.Printf("Created new player with name %s in slot %d", nameInt.String(), dummyIndex)
-
-		case "CurrencyContract:RecordBankTransaction":
-			// For RecordBankTransaction, just acknowledge it
-			// Since bank deposits are tracked on Layer 2, we don't need to update our states
-			log.Printf("Acknowledged bank transaction: %s", tx.TxID)
-
-		case "CurrencyContract:ExchangeInGameCurrency":
-			// Check if we have enough arguments
-			if len(tx.Args) < 3 { // Method, PlayerID, BenAmountChange
-				log.Printf("Invalid ExchangeInGameCurrency transaction: missing arguments")
-				continue
-			}
-
-			// Parse player name
-			playerName, err := strconv.ParseInt(tx.Args[1], 10, 64)
-			if err != nil {
-				log.Printf("Error parsing player name: %v", err)
-				continue
-			}
-			nameInt := big.NewInt(playerName)
-
-			// Parse BEN amount change
-			benAmountStr := tx.Args[2]
-			benAmount, err := strconv.ParseInt(benAmountStr, 10, 64)
-			if err != nil {
-				log.Printf("Error parsing BEN amount: %v", err)
-				continue
-			}
-
-			// Convert to a big.Int (assuming 3 decimal places precision)
-			benInt := big.NewInt(benAmount)
-
-			found := false
-			for i := range w.UserStates {
-				if w.UserStates[i].Name.Cmp(nameInt) == 0 {
-					// Get old state and generate proof *before* update
-					oldState := w.UserStates[i]
-					oldStateHash := merkle.HashUserState(oldState)
-					proof, err := merkle.GenerateMerkleProof(w.UserStates, oldStateHash)
-					if err != nil {
-						log.Printf("Error generating Merkle proof: %v", err)
-						continue
-					}
-
-					// Now update the state
-					newBen := new(big.Int).Add(oldState.Ben, benInt)
-					w.UserStates[i].Ben = newBen
-
-					// Compute new root
-					newRoot := merkle.UpdateMerkleRoot(proof, w.UserStates[i])
-					w.LatestRootHash = merkle.MerkleRootToBase64(newRoot)
-
-					// Store proof and root
-					w.StateProofs = append(w.StateProofs, *proof)
-					w.StateRoots = append(w.StateRoots, w.LatestRootHash)
-
-					// Prepare circuit transaction
-					w.CircuitTransactions = append(w.CircuitTransactions, struct {
-						OldName    *big.Int
-						OldBalance *big.Int
-						NewName    *big.Int
-						BenChange  *big.Int
-						Siblings   []*big.Int
-						PathBits   []bool
-					}{
-						OldName:    oldState.Name,
-						OldBalance: oldState.Ben,
-						NewName:    nameInt,
-						BenChange:  benInt,
-						Siblings:   proof.Siblings,
-						PathBits:   proof.PathBits,
-					})
-
-					found = true
-					log.Printf("Updated player %s balance by %s BEN to %s",
-						nameInt.String(), benInt.String(), newBen.String())
-					break
-				}
-			}
-
-			if !found {
-				log.Printf("Player not found for ExchangeInGameCurrency: %s", nameInt.String())
-			}
-
-		default:
-			// No need to throw error here, just log it
-			log.Printf("Unknown contract method: %s", contractMethod)
 		}
 	}
 
 	return nil
 }
 
-// -------------------------------------------------------------
-// Helper functions below
-// -------------------------------------------------------------
-
-// getPlayersNum evaluates a transaction to query ledger state and prints the number of players
-func getPlayersNum(contract *client.Contract) {
-	log.Println("\n--> Evaluate Transaction: getPlayersNum, function returns the number of current players on the ledger")
-
-	evaluateResult, err := contract.EvaluateTransaction("CurrencyContract:GetAllPlayers")
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
-	}
-
-	// Unmarshal the JSON bytes into a slice of player structs
-	var players []*gateway.Player
-	if err := json.Unmarshal(evaluateResult, &players); err != nil {
-		panic(fmt.Errorf("failed to unmarshal JSON: %w", err))
+// applyPlayer sets the leaf of player to its Layer 2 BEN balance, registering players the
+// operator has not seen before in a dummy slot with the public key they registered, and records
+// the transition for the circuit. Writes that leave the leaf unchanged (e.g. USD-only updates)
+// produce no transition. The changes are logged to log, which carries the transaction.
+func (w *Wrappers) applyPlayer(log *slog.Logger, player *gateway.Player) error {
+	// The circuit only accepts balances in [0, 2^BalanceBits)
+	if player.Balance < 0 {
+		return fmt.Errorf("negative balance %d cannot be proven", player.Balance)
+	}
+	// and changes signed by the key the player registered. Players without a key have nothing
+	// to roll up: Layer 2 does not let them hold BEN once the rollup is enabled, so one that
+	// does is left out rather than stopping the operator.
+	if player.PubKey == "" {
+		if player.Balance != 0 {
+			log.Warn("Skipped player holding BEN without a public key", "player", player.ID, "balance", player.Balance)
+			w.Metrics.keylessPlayerSkipped()
+		}
+		return nil
 	}
-
-	// Now you can accurately get the number of players
-	log.Printf("*** Number of Records: %d\n", len(players))
-}
-
-// createPlayer directly create a player with all attr initialized default
-func createPlayer(contract *client.Contract, playerIdStr string) {
-	log.Printf("\n--> Submit Transaction: CreatePlayer \n")
-
-	// Convert string to int64, then to big.Int
-	playerId, err := strconv.ParseInt(playerIdStr, 10, 64)
+	pubKeyX, pubKeyY, err := rollup.DecodePublicKey(player.PubKey)
 	if err != nil {
-		panic(fmt.Errorf("failed to parse player ID: %w", err))
+		return err
 	}
 
-	playerIdBig := big.NewInt(playerId)
+	nameInt := big.NewInt(player.ID)
+	benInt := big.NewInt(player.Balance)
 
-	_, err = contract.SubmitTransaction("CurrencyContract:CreatePlayer", playerIdBig.String())
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+	index := w.playerSlot(player.ID)
+	if index < 0 {
+		if w.DummyUserIndex >= len(w.UserStates) {
+			return fmt.Errorf("no available slots for new player")
+		}
+		index = w.DummyUserIndex
 	}
 
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// recordBankTransaction records a new bank transaction to the ledger
-func recordBankTransaction(contract *client.Contract, userIDStr, amountUSDStr, transactionIDStr string) {
-	log.Printf("\n--> Submit Transaction: RecordBankTransaction \n")
-
-	// Convert strings to big.Int
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse user ID: %w", err))
+	oldState := w.UserStates[index]
+	if !oldState.IsAccount() {
+		return fmt.Errorf("slot %d is not an account leaf", index)
 	}
-
-	// For USD amount, we need to convert it to the 3 decimal places format
-	amountFloat, err := strconv.ParseFloat(amountUSDStr, 64)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse USD amount: %w", err))
+	if oldState.IsRegistered() && (oldState.PubKeyX.Cmp(pubKeyX) != 0 || oldState.PubKeyY.Cmp(pubKeyY) != 0) {
+		return fmt.Errorf("public key differs from the key bound by slot %d", index)
 	}
-	amountUSD := big.NewInt(int64(amountFloat * 1000)) // Multiply by 1000 for 3 decimal places
-
-	transactionID, err := strconv.ParseInt(transactionIDStr, 10, 64)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse transaction ID: %w", err))
+	if oldState.IsRegistered() && oldState.Name.Cmp(nameInt) == 0 && oldState.Ben.Cmp(benInt) == 0 {
+		return nil
 	}
 
-	userIDBig := big.NewInt(userID)
-	transactionIDBig := big.NewInt(transactionID)
-
-	_, err = contract.SubmitTransaction(
-		"CurrencyContract:RecordBankTransaction",
-		userIDBig.String(),
-		amountUSD.String(),
-		transactionIDBig.String(),
-	)
-
+	// The player signed the change on Layer 2; the operator only hands the signature over
+	benChange := new(big.Int).Sub(benInt, oldState.Ben)
+	signature, err := playerSignature(player, oldState.Nonce)
 	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+		return err
 	}
-
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-// exchangeInGameCurrency allows users to exchange currency (USD to BEN or BEN to USD)
-func exchangeInGameCurrency(contract *client.Contract, userIDStr, benAmountChangeStr string) {
-	log.Printf("\n--> Submit Transaction: ExchangeInGameCurrency \n")
-
-	// Convert strings to big.Int
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse user ID: %w", err))
+	if !rollup.Verify(pubKeyX, pubKeyY, signature, nameInt, benChange, oldState.Nonce) {
+		return fmt.Errorf("the signature of player %d does not sign a change of %s BEN at nonce %s", player.ID, benChange, oldState.Nonce)
 	}
 
-	// For BEN amount, we need to convert it to the 3 decimal places format
-	amountFloat, err := strconv.ParseFloat(benAmountChangeStr, 64)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse BEN amount: %w", err))
+	if index == w.DummyUserIndex {
+		w.DummyUserIndex++
+		log.Debug("Allocated slot", "slot", index, "player", player.ID)
 	}
-	benAmountChange := big.NewInt(int64(amountFloat * 1000)) // Multiply by 1000 for 3 decimal places
-
-	userIDBig := big.NewInt(userID)
-
-	_, err = contract.SubmitTransaction(
-		"CurrencyContract:ExchangeInGameCurrency",
-		userIDBig.String(),
-		benAmountChange.String(),
-	)
 
+	// Generate proof *before* update
+	proof, err := merkle.GenerateMerkleProofAtWith(w.stateHasher(), w.UserStates, index)
 	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+		return fmt.Errorf("failed to generate Merkle proof: %w", err)
 	}
 
-	log.Printf("*** Transaction committed successfully\n")
-}
-
-func getNewestBlockNumber(contract *client.Contract, channelName string) (uint64, error) {
-	log.Println("\n--> Evaluate Transaction: getChainInfo from system chaincode qscc GetChainInfo")
-
-	// Call QSCC to get the chain info of the specified channel
-	evaluateResult, err := contract.EvaluateTransaction("GetChainInfo", channelName)
-	if err != nil {
-		return 0, fmt.Errorf("failed to evaluate transaction: %w", err)
+	// Now update the state
+	w.UserStates[index] = merkle.UserState{
+		Name:    nameInt,
+		Ben:     benInt,
+		PubKeyX: pubKeyX,
+		PubKeyY: pubKeyY,
+		Nonce:   new(big.Int).Add(oldState.Nonce, big.NewInt(1)),
 	}
 
-	// Decode the returned protobuf data to JSON
-	chainInfoJSON, err := decodeChainInfo(evaluateResult)
-	if err != nil {
-		return 0, fmt.Errorf("failed to decode chain info: %w", err)
-	}
+	// Compute new root
+	newRoot := merkle.UpdateMerkleRootWith(w.stateHasher(), proof, w.UserStates[index])
+	w.LatestRootHash = merkle.MerkleRootToBase64(newRoot)
 
-	// Extract the height and compute the newest block number
-	newestBlockNumber, err := extractNewestBlockNumber(chainInfoJSON)
-	if err != nil {
-		return 0, fmt.Errorf("failed to extract newest block number: %w", err)
-	}
+	// Store root
+	w.StateRoots = append(w.StateRoots, w.LatestRootHash)
 
-	return newestBlockNumber, nil
+	// Prepare circuit transaction
+	w.CircuitTransactions = append(w.CircuitTransactions, CircuitTransaction{
+		OldName:    oldState.Name,
+		OldBalance: oldState.Ben,
+		NewName:    nameInt,
+		BenChange:  benChange,
+		Siblings:   proof.Siblings,
+		PathBits:   proof.PathBits,
+		OldPubKeyX: oldState.PubKeyX,
+		OldPubKeyY: oldState.PubKeyY,
+		Nonce:      oldState.Nonce,
+		PubKeyX:    pubKeyX,
+		PubKeyY:    pubKeyY,
+		Signature:  signature,
+	})
+
+	log.Debug("Updated player", "player", player.ID, "slot", index, "change", benChange.String(), "balance", benInt.String())
+	return nil
 }
 
-func commitMerkleRoot(contract *client.Contract, blockNumber, merkleRoot string) {
-	log.Printf("\n--> Submit Transaction: CommitMerkleRoot \n")
-
-	_, err := contract.SubmitTransaction("PlasmaContract:CommitMerkleRoot", blockNumber, merkleRoot)
-	if err != nil {
-		errorHandling(contract, err)
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+// witnessLog returns the logger of the block the witness stage is applying, the default logger
+// outside of Operate.
+func (w *Wrappers) witnessLog() *slog.Logger {
+	if w.blockLog == nil {
+		return slog.Default()
 	}
-
-	log.Printf("*** Transaction committed successfully\n")
+	return w.blockLog
 }
 
-// serializeProof converts a groth16.Proof to a byte slice using Gnark's encoding
-func serializeProof(proof groth16.Proof) ([]byte, error) {
-	var buf bytes.Buffer
-	_, err := proof.WriteRawTo(&buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize proof: %w", err)
+// playerSlot returns the index of the leaf of player id, or -1 if no slot is allocated to it.
+// Only allocated slots hold real players; dummy names may collide with player IDs.
+func (w *Wrappers) playerSlot(id int64) int {
+	name := big.NewInt(id)
+	for i := 0; i < w.DummyUserIndex; i++ {
+		if w.UserStates[i].Name.Cmp(name) == 0 {
+			return i
+		}
 	}
-	return buf.Bytes(), nil
+	return -1
 }
 
-// deserializeProof converts a byte slice back to a groth16.Proof using Gnark's decoding
-func deserializeProof(proofBytes []byte) (groth16.Proof, error) {
-	proof := groth16.NewProof(ecc.BN254) // Initialize a new proof for BN254 curve
-	_, err := proof.ReadFrom(bytes.NewReader(proofBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize proof: %w", err)
+// playerSignature returns the signature of the last BEN change of player, which Layer 2 checked
+// and recorded with the player, at nonce, the nonce of the player's leaf before the change.
+func playerSignature(player *gateway.Player, nonce *big.Int) (*rollup.Signature, error) {
+	if player.Signature == "" {
+		return nil, fmt.Errorf("player %d changed its BEN without a signature (was the rollup enabled on Layer 2?)", player.ID)
+	}
+	if signed := new(big.Int).Sub(big.NewInt(player.Nonce), big.NewInt(1)); signed.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("player %d signed its last change at nonce %s, but its leaf is at nonce %s", player.ID, signed, nonce)
 	}
-	return proof, nil
+	return rollup.DecodeSignature(player.Signature)
 }
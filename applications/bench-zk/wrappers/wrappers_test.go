@@ -3,11 +3,21 @@ package wrappers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"log"
+	"math/big"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"bench-zk/blocks"
 	"bench-zk/gateway"
+	"bench-zk/merkle"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+	"github.com/weids-dev/benchains/circuits/rollup"
 )
 
 // getTestChainConfig1 returns a Chain configuration for testing Layer 1
@@ -48,6 +58,12 @@ func TestMain(m *testing.M) {
 	chain1 := getTestChainConfig1()
 	chain2 := getTestChainConfig2()
 
+	// Without the network certificates only the offline tests can run
+	if _, err := os.Stat(chain1.TLSCertPath); err != nil {
+		log.Printf("Fabric network not available, skipping integration tests: %v", err)
+		os.Exit(m.Run())
+	}
+
 	// Initialize Wrappers
 	var err error
 	wp, err = NewWrappers(chain1, chain2)
@@ -71,15 +87,15 @@ func TestMain(m *testing.M) {
 		log.Printf("UserStates initialized as empty")
 	}
 
-	if err := wp.Gw2.InitLedger(); err != nil {
+	if err := wp.Gw2.Currency().InitLedger(); err != nil {
 		log.Fatalf("InitLedger failed: %v\n", err)
 	}
 
-	evaluateResult, err := wp.Gw2.Contract.EvaluateTransaction("CurrencyContract:GetAllPlayers")
+	players, err := wp.Gw2.Currency().GetAllPlayers()
 	if err != nil {
 		log.Fatalf("GetAllPlayers failed: %v\n", err)
 	}
-	log.Printf("EvaluateResult Players: %v", evaluateResult)
+	log.Printf("Players: %+v", players)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
@@ -100,51 +116,320 @@ func TestMain(m *testing.M) {
 	<-ctx.Done()
 }
 
+// requireNetwork skips t unless TestMain connected to the Fabric network.
+func requireNetwork(t *testing.T) {
+	if wp == nil {
+		t.Skip("Fabric network not available")
+	}
+}
+
 // TestSimulateTransactions tests the operator by simulating transactions and observing the output.
 func TestSimulateTransactions(t *testing.T) {
+	requireNetwork(t)
+
 	// Wait briefly to ensure Operate starts
 	time.Sleep(1 * time.Second)
 
 	// Simulate transactions on Layer 2
-	contract := wp.Gw2.Contract
+	currency := wp.Gw2.Currency()
 
-	// Create a player with ID 1
-	createPlayer(contract, "4")
+	// Create a player with ID 4, registering its key
+	players := newTestPlayers(t)
+	if err := currency.CreatePlayer(4, players.pubKey(4), players.sign(4, 0)); err != nil {
+		t.Fatalf("CreatePlayer failed: %v", err)
+	}
 
 	// Record a bank transaction: deposit 100 USD for user 4 (txID 123)
-	recordBankTransaction(contract, "4", "100", "123")
+	if err := currency.RecordBankTransaction(4, 100000, 123); err != nil {
+		t.Fatalf("RecordBankTransaction failed: %v", err)
+	}
 
 	// Exchange 50 USD to BEN (this adds 50 BEN to the user's balance)
-	exchangeInGameCurrency(contract, "4", "50.0")
+	if err := currency.ExchangeInGameCurrency(4, 50000, players.sign(4, 50000)); err != nil {
+		t.Fatalf("ExchangeInGameCurrency failed: %v", err)
+	}
 
 	// Exchange BEN back to USD (this removes 20 BEN from the user's balance)
-	exchangeInGameCurrency(contract, "4", "-20.0")
+	if err := currency.ExchangeInGameCurrency(4, -20000, players.sign(4, -20000)); err != nil {
+		t.Fatalf("ExchangeInGameCurrency failed: %v", err)
+	}
 
 	// Wait for the context to timeout, giving Operate time to process blocks
 }
 
 // TestExchangeRateChanges tests the effect of changing the exchange rate.
 func TestExchangeRateChanges(t *testing.T) {
+	requireNetwork(t)
+
 	// Wait briefly to ensure Operate starts
 	time.Sleep(1 * time.Second)
 
-	contract := wp.Gw2.Contract
+	currency := wp.Gw2.Currency()
 
 	// Create a player
-	createPlayer(contract, "5")
+	players := newTestPlayers(t)
+	if err := currency.CreatePlayer(5, players.pubKey(5), players.sign(5, 0)); err != nil {
+		t.Fatalf("CreatePlayer failed: %v", err)
+	}
 
 	// Deposit 1000 USD
-	recordBankTransaction(contract, "5", "1000", "456")
+	if err := currency.RecordBankTransaction(5, 1000000, 456); err != nil {
+		t.Fatalf("RecordBankTransaction failed: %v", err)
+	}
 
 	// Exchange 100 BEN at the default rate (1.0)
-	exchangeInGameCurrency(contract, "5", "100.0")
+	if err := currency.ExchangeInGameCurrency(5, 100000, players.sign(5, 100000)); err != nil {
+		t.Fatalf("ExchangeInGameCurrency failed: %v", err)
+	}
 
 	// Set a new exchange rate (2.0 - meaning 1 USD = 2 BEN)
-	_, err := contract.SubmitTransaction("CurrencyContract:SetExchangeRate", "2000") // 2.0 with 3 decimal places
-	if err != nil {
+	if err := currency.SetExchangeRate(2000); err != nil { // 2.0 with 3 decimal places
 		t.Errorf("Failed to set exchange rate: %v", err)
 	}
 
 	// Exchange another 100 BEN at the new rate (should cost less USD)
-	exchangeInGameCurrency(contract, "5", "100.0")
+	if err := currency.ExchangeInGameCurrency(5, 100000, players.sign(5, 100000)); err != nil {
+		t.Fatalf("ExchangeInGameCurrency failed: %v", err)
+	}
+}
+
+// playerWrite builds the rwset write CurrencyContract produces when it stores player.
+func playerWrite(t testing.TB, namespace string, player gateway.Player) blocks.Write {
+	t.Helper()
+	value, err := json.Marshal(player)
+	if err != nil {
+		t.Fatalf("failed to marshal player: %v", err)
+	}
+	return blocks.Write{Namespace: namespace, Key: playerKeyPrefix + big.NewInt(player.ID).String() + "\x00", Value: value}
+}
+
+// testPlayers are the players of a test, who sign their BEN changes the way CurrencyContract
+// requires once the rollup is enabled.
+type testPlayers struct {
+	t      testing.TB
+	keys   map[int64]*eddsa.PrivateKey
+	nonces map[int64]int64 // Nonce of each player on Layer 2
+}
+
+func newTestPlayers(t testing.TB) *testPlayers {
+	return &testPlayers{t: t, keys: make(map[int64]*eddsa.PrivateKey), nonces: make(map[int64]int64)}
+}
+
+// key returns the key of player id, created on first use.
+func (p *testPlayers) key(id int64) *eddsa.PrivateKey {
+	p.t.Helper()
+	if key, ok := p.keys[id]; ok {
+		return key
+	}
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		p.t.Fatalf("failed to create the key of player %d: %v", id, err)
+	}
+	p.keys[id] = key
+	return key
+}
+
+// pubKey returns the public key player id registers with CurrencyContract:CreatePlayer.
+func (p *testPlayers) pubKey(id int64) string {
+	return rollup.EncodePublicKey(rollup.PublicKey(p.key(id)))
+}
+
+// account returns the leaf of player id holding ben, bound to its key, at its nonce.
+func (p *testPlayers) account(id, ben int64) merkle.UserState {
+	pubKeyX, pubKeyY := rollup.PublicKey(p.key(id))
+	leaf := merkle.NewRegisteredAccount(big.NewInt(id), big.NewInt(ben), pubKeyX, pubKeyY)
+	leaf.Nonce = big.NewInt(p.nonces[id])
+	return leaf
+}
+
+// sign returns the signature of player id of the change of its BEN by benChange at its nonce,
+// which it then increments as CurrencyContract does. Creating a player changes 0 BEN.
+func (p *testPlayers) sign(id, benChange int64) string {
+	p.t.Helper()
+	sig, err := rollup.Sign(p.key(id), big.NewInt(id), big.NewInt(benChange), big.NewInt(p.nonces[id]))
+	if err != nil {
+		p.t.Fatalf("failed to sign: %v", err)
+	}
+	p.nonces[id]++
+	return rollup.EncodeSignature(sig)
+}
+
+// signTransfer is sign for a transfer of amount BEN from player from to player to.
+func (p *testPlayers) signTransfer(from, to, amount int64) string {
+	p.t.Helper()
+	sig, err := rollup.SignTransfer(p.key(from), big.NewInt(from), big.NewInt(to), big.NewInt(amount), big.NewInt(p.nonces[from]))
+	if err != nil {
+		p.t.Fatalf("failed to sign: %v", err)
+	}
+	p.nonces[from]++
+	return rollup.EncodeSignature(sig)
+}
+
+// signed returns player id as CurrencyContract stores it after the player changed its BEN by
+// benChange, to balance, signing the change.
+func (p *testPlayers) signed(id, balance, benChange int64) gateway.Player {
+	signature := p.sign(id, benChange)
+	return gateway.Player{ID: id, Balance: balance, PubKey: p.pubKey(id), Nonce: p.nonces[id], Signature: signature}
+}
+
+// TestProcessTransactionsAppliesPlayerWrites checks that the rollup state follows the PLAYER
+// writes of Layer 2 rather than the transaction arguments.
+func TestProcessTransactionsAppliesPlayerWrites(t *testing.T) {
+	players := newTestPlayers(t)
+	users := []merkle.UserState{players.account(10, 5000)}
+	for i := 1; i < 8; i++ {
+		users = append(users, merkle.NewAccount(big.NewInt(int64(i+1)), big.NewInt(0)))
+	}
+	w := &Wrappers{
+		UserStates:     users,
+		StateRoots:     []string{merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))},
+		Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+		DummyUserIndex: 1,
+	}
+	created := players.signed(4, 0, 0)
+	deposited := created
+	deposited.UsdBalance = 100000
+	exchanged := players.signed(4, 15000, 15000)
+	exchanged.UsdBalance = 50000
+
+	transactions := []blocks.Transaction{
+		{
+			TxID:     "create",
+			Function: "CurrencyContract:CreatePlayer",
+			Args:     []string{"4", created.PubKey, created.Signature},
+			Writes:   []blocks.Write{playerWrite(t, "pasic", created)},
+		},
+		{
+			// Deposits only touch the USD balance, which is not part of the rollup state
+			TxID:     "deposit",
+			Function: "CurrencyContract:RecordBankTransaction",
+			Writes: []blocks.Write{
+				{Namespace: "pasic", Key: "\x00TRANSACTION\x00123\x00", Value: []byte(`{}`)},
+				playerWrite(t, "pasic", deposited),
+			},
+		},
+		{
+			// The arguments say 50 BEN but the exchange rate made it 15 BEN, which the player signed
+			TxID:     "exchange",
+			Function: "CurrencyContract:ExchangeInGameCurrency",
+			Args:     []string{"4", "50000", exchanged.Signature},
+			Writes:   []blocks.Write{playerWrite(t, "pasic", exchanged)},
+		},
+		{
+			// Players without a key have nothing to roll up as long as they hold no BEN
+			TxID:     "create-keyless",
+			Function: "CurrencyContract:CreatePlayer",
+			Args:     []string{"6", "", ""},
+			Writes:   []blocks.Write{playerWrite(t, "pasic", gateway.Player{ID: 6})},
+		},
+		{
+			// and one holding BEN anyway is left out rather than stopping the operator
+			TxID:   "keyless-ben",
+			Writes: []blocks.Write{playerWrite(t, "pasic", gateway.Player{ID: 6, Balance: 5})},
+		},
+		{
+			TxID:   "other-chaincode",
+			Writes: []blocks.Write{playerWrite(t, "basic", gateway.Player{ID: 10, Balance: 1})},
+		},
+	}
+
+	if err := w.processTransactions(transactions); err != nil {
+		t.Fatalf("processTransactions failed: %v", err)
+	}
+
+	if w.DummyUserIndex != 2 {
+		t.Errorf("expected DummyUserIndex 2, got %d", w.DummyUserIndex)
+	}
+	if got := w.UserStates[1]; got.Name.Int64() != 4 || got.Ben.Int64() != 15000 {
+		t.Errorf("expected player 4 with 15000 BEN in slot 1, got %v/%v", got.Name, got.Ben)
+	}
+	if got := w.UserStates[3]; got.Name.Int64() != 4 || got.Ben.Sign() != 0 {
+		t.Errorf("dummy slot 3 must stay untouched, got %v/%v", got.Name, got.Ben)
+	}
+	if got := w.UserStates[0]; got.Ben.Int64() != 5000 {
+		t.Errorf("writes of other chaincodes must be ignored, player 10 has %v BEN", got.Ben)
+	}
+	if w.playerSlot(6) >= 0 {
+		t.Errorf("keyless player 6 must not enter the rollup state")
+	}
+
+	if len(w.CircuitTransactions) != 2 {
+		t.Fatalf("expected 2 circuit transactions, got %d", len(w.CircuitTransactions))
+	}
+	if change := w.CircuitTransactions[1].BenChange.Int64(); change != 15000 {
+		t.Errorf("expected a BEN change of 15000, got %d", change)
+	}
+
+	// Both changes carry the signatures player 4 made on Layer 2, which its new leaf binds
+	for i, ctx := range w.CircuitTransactions {
+		if ctx.Nonce.Int64() != int64(i) {
+			t.Errorf("transaction %d signed with nonce %v, want %d", i, ctx.Nonce, i)
+		}
+		if !rollup.Verify(ctx.PubKeyX, ctx.PubKeyY, ctx.Signature, ctx.NewName, ctx.BenChange, ctx.Nonce) {
+			t.Errorf("transaction %d carries an invalid signature", i)
+		}
+	}
+	if got := w.UserStates[1]; got.Nonce.Int64() != 2 || got.PubKeyX.Cmp(w.CircuitTransactions[0].PubKeyX) != 0 {
+		t.Errorf("player 4 must be bound to its registered key with nonce 2, got nonce %v", got.Nonce)
+	}
+
+	if want := merkle.MerkleRootToBase64(merkle.BuildMerkleStates(w.UserStates)); w.LatestRootHash != want {
+		t.Errorf("incremental root %s does not match rebuilt root %s", w.LatestRootHash, want)
+	}
+	if len(w.StateRoots) != 3 {
+		t.Errorf("expected 3 state roots, got %d", len(w.StateRoots))
+	}
+}
+
+// TestProcessTransactionsRejectsMalformedWrites checks that a PLAYER write the rollup cannot
+// mirror fails the block instead of being skipped, so the operator stops before checkpointing a
+// state that drifted from Layer 2.
+func TestProcessTransactionsRejectsMalformedWrites(t *testing.T) {
+	players := newTestPlayers(t)
+	pubKey := players.pubKey(4)
+	wrongID := playerWrite(t, "pasic", gateway.Player{ID: 4, PubKey: pubKey})
+	wrongID.Key = playerKeyPrefix + "5\x00"
+
+	// Changes of player 1, which holds 0 BEN at nonce 0
+	signed := func(balance, benChange, nonce int64) gateway.Player {
+		sig, err := rollup.Sign(players.key(1), big.NewInt(1), big.NewInt(benChange), big.NewInt(nonce))
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return gateway.Player{ID: 1, Balance: balance, PubKey: players.pubKey(1), Nonce: nonce + 1, Signature: rollup.EncodeSignature(sig)}
+	}
+	unsigned := signed(5, 5, 0)
+	unsigned.Signature = ""
+	forged := signed(5, 5, 0)
+	forged.Signature = players.sign(4, 5)
+
+	for _, tc := range []struct {
+		name  string
+		write blocks.Write
+	}{
+		{"not JSON", blocks.Write{Namespace: "pasic", Key: playerKeyPrefix + "4\x00", Value: []byte("{")}},
+		{"wrong ID", wrongID},
+		{"deleted", blocks.Write{Namespace: "pasic", Key: playerKeyPrefix + "4\x00", IsDelete: true}},
+		{"negative balance", playerWrite(t, "pasic", gateway.Player{ID: 4, Balance: -1, PubKey: pubKey})},
+		{"invalid public key", playerWrite(t, "pasic", gateway.Player{ID: 4, PubKey: "AAAA"})},
+		{"key of another player", playerWrite(t, "pasic", gateway.Player{ID: 1, Balance: 5, PubKey: pubKey})},
+		{"unsigned change", playerWrite(t, "pasic", unsigned)},
+		{"wrong nonce", playerWrite(t, "pasic", signed(5, 5, 1))},
+		{"signature of another change", playerWrite(t, "pasic", signed(5, 6, 0))},
+		{"signature of another player", playerWrite(t, "pasic", forged)},
+		{"malformed signature", playerWrite(t, "pasic", gateway.Player{ID: 1, Balance: 5, PubKey: players.pubKey(1), Nonce: 1, Signature: "AAAA"})},
+	} {
+		users := []merkle.UserState{players.account(1, 0), merkle.NewAccount(big.NewInt(2), big.NewInt(0))}
+		w := &Wrappers{
+			UserStates:     users,
+			StateRoots:     []string{merkle.MerkleRootToBase64(merkle.BuildMerkleStates(users))},
+			Gw2:            &gateway.Gateway{ChaincodeName: "pasic"},
+			DummyUserIndex: 1,
+		}
+
+		err := w.processTransactions([]blocks.Transaction{{TxID: "bad", Writes: []blocks.Write{tc.write}}})
+		if err == nil || !strings.Contains(err.Error(), "transaction bad") {
+			t.Errorf("%s: processTransactions returned %v, expected an error naming the transaction", tc.name, err)
+		}
+	}
 }
@@ -3,10 +3,13 @@ package currency
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 
+	"github.com/weids-dev/benchains/chaincodes/wrappers/logging"
 	"github.com/weids-dev/benchains/chaincodes/wrappers/types"
+	"github.com/weids-dev/benchains/circuits/rollup"
 )
 
 // CurrencyContract defines the Smart Contract structure.
@@ -18,13 +21,16 @@ type CurrencyContract struct {
 const PLAYER string = "PLAYER"
 const TRANSACTION string = "TRANS"
 
-// InitLedger adds a base set of players to the ledger
+// ROLLUP is the key EnableRollup sets once the ZK rollup rolls up the players of the chain.
+const ROLLUP string = "ROLLUP"
+
+// InitLedger adds a base set of players, without public keys, to the ledger
 func (c *CurrencyContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	// Set default exchange rate (1.0 with 3 decimal places = 1000)
 	c.ExchangeRate = 1000
 
 	for i := 1; i <= 3; i++ {
-		err := c.CreatePlayer(ctx, int64(i))
+		err := c.CreatePlayer(ctx, int64(i), "", "")
 		if err != nil {
 			return err
 		}
@@ -32,8 +38,25 @@ func (c *CurrencyContract) InitLedger(ctx contractapi.TransactionContextInterfac
 	return nil
 }
 
-// CreatePlayer adds a new player to the ledger, and initialize it
-func (c *CurrencyContract) CreatePlayer(ctx contractapi.TransactionContextInterface, id int64) error {
+// CreatePlayer adds a new player to the ledger, and initialize it.
+// pubKey is the player's compressed EdDSA public key in base64, which the ZK rollup
+// requires to sign the player's state changes; it can be empty until EnableRollup is called.
+// Once the rollup is enabled, signature is the player's signature of its first state change,
+// which takes an empty slot of the rollup: rollup.Message(id, 0, 0). It is ignored before.
+func (c *CurrencyContract) CreatePlayer(ctx contractapi.TransactionContextInterface, id int64, pubKey string, signature string) error {
+	if pubKey != "" {
+		if _, _, err := rollup.DecodePublicKey(pubKey); err != nil {
+			return err
+		}
+	}
+	enabled, err := c.RollupEnabled(ctx)
+	if err != nil {
+		return err
+	}
+	if enabled && pubKey == "" {
+		return fmt.Errorf("player %d registers no public key, which the ZK rollup requires", id)
+	}
+
 	exists, err := c.PlayerExists(ctx, id)
 	if err != nil {
 		return err
@@ -46,6 +69,12 @@ func (c *CurrencyContract) CreatePlayer(ctx contractapi.TransactionContextInterf
 		ID:         id,
 		Balance:    0,
 		UsdBalance: 0,
+		PubKey:     pubKey,
+	}
+	if enabled {
+		if err := signChange(&player, 0, signature); err != nil {
+			return err
+		}
 	}
 
 	// Marshal Player to JSON
@@ -63,6 +92,93 @@ func (c *CurrencyContract) CreatePlayer(ctx contractapi.TransactionContextInterf
 	return ctx.GetStub().PutState(player_key, playerJSON)
 }
 
+// EnableRollup marks the chain as rolled up by the ZK rollup; its operator calls it when it
+// initializes Layer 1. The rollup only accepts state changes signed by the key of the player,
+// so from then on CreatePlayer requires a public key and players without one cannot get BEN.
+// It fails if such a player already holds BEN, and does nothing once the rollup is enabled.
+func (c *CurrencyContract) EnableRollup(ctx contractapi.TransactionContextInterface) error {
+	players, err := c.GetAllPlayers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, player := range players {
+		if player.PubKey == "" && player.Balance != 0 {
+			return fmt.Errorf("player %d holds %d BEN but registered no public key", player.ID, player.Balance)
+		}
+	}
+	return ctx.GetStub().PutState(ROLLUP, []byte("true"))
+}
+
+// RollupEnabled returns true once EnableRollup was called.
+func (c *CurrencyContract) RollupEnabled(ctx contractapi.TransactionContextInterface) (bool, error) {
+	enabled, err := ctx.GetStub().GetState(ROLLUP)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return enabled != nil, nil
+}
+
+// checkRollupKey fails if the ZK rollup is enabled and player, whose BEN balance is about to
+// change, registered no public key: the rollup cannot accept a change its owner cannot sign.
+// It returns whether the rollup is enabled.
+func (c *CurrencyContract) checkRollupKey(ctx contractapi.TransactionContextInterface, player *types.Player) (bool, error) {
+	enabled, err := c.RollupEnabled(ctx)
+	if err != nil {
+		return false, err
+	}
+	if enabled && player.PubKey == "" {
+		return true, fmt.Errorf("player %d registered no public key and cannot hold BEN on the ZK rollup", player.ID)
+	}
+	return enabled, nil
+}
+
+// signChange checks that signature is the signature of player, by its registered key, of the
+// change of its BEN balance by benChange at its current nonce, as the rollup circuits check it.
+// It then records the signature in player for the operator of the rollup and increments the nonce.
+func signChange(player *types.Player, benChange int64, signature string) error {
+	pubKeyX, pubKeyY, sig, err := decodeSignature(player, signature)
+	if err != nil {
+		return err
+	}
+	if !rollup.Verify(pubKeyX, pubKeyY, sig, big.NewInt(player.ID), big.NewInt(benChange), big.NewInt(player.Nonce)) {
+		return fmt.Errorf("invalid signature: player %d did not sign a change of %d BEN at nonce %d", player.ID, benChange, player.Nonce)
+	}
+	player.Nonce++
+	player.Signature = signature
+	return nil
+}
+
+// signTransfer is signChange for a transfer of amount BEN from player from to player to, signed
+// by the sender.
+func signTransfer(from, to *types.Player, amount int64, signature string) error {
+	pubKeyX, pubKeyY, sig, err := decodeSignature(from, signature)
+	if err != nil {
+		return err
+	}
+	if !rollup.VerifyTransfer(pubKeyX, pubKeyY, sig, big.NewInt(from.ID), big.NewInt(to.ID), big.NewInt(amount), big.NewInt(from.Nonce)) {
+		return fmt.Errorf("invalid signature: player %d did not sign a transfer of %d BEN to player %d at nonce %d", from.ID, amount, to.ID, from.Nonce)
+	}
+	from.Nonce++
+	from.Signature = signature
+	return nil
+}
+
+// decodeSignature decodes the registered public key of player and signature.
+func decodeSignature(player *types.Player, signature string) (*big.Int, *big.Int, *rollup.Signature, error) {
+	pubKeyX, pubKeyY, err := rollup.DecodePublicKey(player.PubKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("player %d: %w", player.ID, err)
+	}
+	if signature == "" {
+		return nil, nil, nil, fmt.Errorf("the ZK rollup requires player %d to sign its BEN changes", player.ID)
+	}
+	sig, err := rollup.DecodeSignature(signature)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return pubKeyX, pubKeyY, sig, nil
+}
+
 // PlayerExists returns true if a player with the given ID exists in the ledger
 func (c *CurrencyContract) PlayerExists(ctx contractapi.TransactionContextInterface, id int64) (bool, error) {
 	player_key, err := ctx.GetStub().CreateCompositeKey(PLAYER, []string{fmt.Sprintf("%d", id)})
@@ -104,7 +220,7 @@ func (c *CurrencyContract) GetPlayer(ctx contractapi.TransactionContextInterface
 // RecordBankTransaction records a new bank transaction to the ledger.
 func (c *CurrencyContract) RecordBankTransaction(ctx contractapi.TransactionContextInterface, userID, amountUSD, transactionID int64) error {
 	// Validate transaction (in a real system, this would verify the bank transaction)
-	fmt.Printf("Validating bank transaction ID: %d for user: %d with amount: %d\n", transactionID, userID, amountUSD)
+	logging.Debug(ctx, "Validating bank transaction", "bankTx", transactionID, "player", userID, "usd", amountUSD)
 
 	// Check if player exists
 	exists, err := c.PlayerExists(ctx, userID)
@@ -160,25 +276,36 @@ func (c *CurrencyContract) RecordBankTransaction(ctx contractapi.TransactionCont
 }
 
 // ExchangeInGameCurrency allows users to exchange currency (USD to BEN or BEN to USD).
-func (c *CurrencyContract) ExchangeInGameCurrency(ctx contractapi.TransactionContextInterface, userID, benAmountChange int64) error {
-	fmt.Printf("Starting ExchangeInGameCurrency: userID=%d, benAmountChange=%d\n", userID, benAmountChange)
+// Once the ZK rollup is enabled, signature is the player's signature of the change:
+// rollup.Message(userID, benAmountChange, nonce) with the player's current nonce.
+func (c *CurrencyContract) ExchangeInGameCurrency(ctx contractapi.TransactionContextInterface, userID, benAmountChange int64, signature string) error {
+	logging.Debug(ctx, "Starting ExchangeInGameCurrency", "player", userID, "benChange", benAmountChange)
 
 	// Check exchange rate
 	if c.ExchangeRate == 0 {
 		return fmt.Errorf("exchange rate is zero")
 	}
-	fmt.Printf("ExchangeRate=%d\n", c.ExchangeRate)
 
 	// Get player
 	player, err := c.GetPlayer(ctx, userID)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Player fetched: UsdBalance=%d, Balance=%d\n", player.UsdBalance, player.Balance)
+	logging.Debug(ctx, "Player fetched", "usdBalance", player.UsdBalance, "balance", player.Balance, "exchangeRate", c.ExchangeRate)
+	if benAmountChange != 0 {
+		enabled, err := c.checkRollupKey(ctx, player)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			if err := signChange(player, benAmountChange, signature); err != nil {
+				return err
+			}
+		}
+	}
 
 	if benAmountChange > 0 {
 		usdRequired := (benAmountChange * 1000) / c.ExchangeRate
-		fmt.Printf("usdRequired=%d\n", usdRequired)
 
 		if player.UsdBalance < usdRequired {
 			return fmt.Errorf("insufficient USD balance: have %d, need %d", player.UsdBalance, usdRequired)
@@ -186,7 +313,7 @@ func (c *CurrencyContract) ExchangeInGameCurrency(ctx contractapi.TransactionCon
 
 		player.UsdBalance -= usdRequired
 		player.Balance += benAmountChange
-		fmt.Printf("Updated: UsdBalance=%d, Balance=%d\n", player.UsdBalance, player.Balance)
+		logging.Debug(ctx, "Player updated", "usdRequired", usdRequired, "usdBalance", player.UsdBalance, "balance", player.Balance)
 	} else {
 		benToExchange := -benAmountChange
 		if player.Balance < benToExchange {
@@ -207,10 +334,68 @@ func (c *CurrencyContract) ExchangeInGameCurrency(ctx contractapi.TransactionCon
 		return err
 	}
 
-	fmt.Printf("Writing player state: userID=%d\n", userID)
 	return ctx.GetStub().PutState(player_key, updatedPlayerJSON)
 }
 
+// Transfer moves amount BEN from player fromID to player toID.
+// Once the ZK rollup is enabled, signature is the sender's signature of the transfer:
+// rollup.TransferMessage(fromID, toID, amount, nonce) with the sender's current nonce.
+func (c *CurrencyContract) Transfer(ctx contractapi.TransactionContextInterface, fromID, toID, amount int64, signature string) error {
+	logging.Debug(ctx, "Starting Transfer", "from", fromID, "to", toID, "amount", amount)
+
+	if amount <= 0 {
+		return fmt.Errorf("transfer amount must be positive, got %d", amount)
+	}
+	if fromID == toID {
+		return fmt.Errorf("player %d cannot transfer to itself", fromID)
+	}
+
+	from, err := c.GetPlayer(ctx, fromID)
+	if err != nil {
+		return err
+	}
+	to, err := c.GetPlayer(ctx, toID)
+	if err != nil {
+		return err
+	}
+	if from.Balance < amount {
+		return fmt.Errorf("insufficient BEN balance: have %d, need %d", from.Balance, amount)
+	}
+	if _, err := c.checkRollupKey(ctx, to); err != nil {
+		return err
+	}
+	enabled, err := c.checkRollupKey(ctx, from)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		if err := signTransfer(from, to, amount, signature); err != nil {
+			return err
+		}
+	}
+
+	from.Balance -= amount
+	to.Balance += amount
+	logging.Debug(ctx, "Players updated", "fromBalance", from.Balance, "toBalance", to.Balance)
+
+	for _, player := range []*types.Player{from, to} {
+		playerJSON, err := json.Marshal(player)
+		if err != nil {
+			return err
+		}
+
+		player_key, err := ctx.GetStub().CreateCompositeKey(PLAYER, []string{fmt.Sprintf("%d", player.ID)})
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().PutState(player_key, playerJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SetExchangeRate sets the exchange rate for USD to BEN conversion
 func (c *CurrencyContract) SetExchangeRate(ctx contractapi.TransactionContextInterface, newRate int64) error {
 	c.ExchangeRate = newRate
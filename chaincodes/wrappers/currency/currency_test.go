@@ -1,16 +1,21 @@
 package currency
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"testing"
 
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/weids-dev/benchains/chaincodes/wrappers/types"
+	"github.com/weids-dev/benchains/circuits/rollup"
 )
 
 // MockTransactionContext is a mock of TransactionContextInterface
@@ -106,6 +111,9 @@ func TestInitLedger(t *testing.T) {
 		stub.On("PutState", compositeKey, mock.AnythingOfType("[]uint8")).Return(nil)
 	}
 
+	// The players have no public key, which is only allowed without the ZK rollup
+	stub.On("GetState", ROLLUP).Return(nil, nil)
+
 	cc := new(CurrencyContract)
 	cc.ExchangeRate = 1000
 
@@ -127,23 +135,26 @@ func TestCreatePlayer(t *testing.T) {
 
 	playerID := int64(123)
 	compositeKey := "PLAYER_" + fmt.Sprintf("%d", playerID)
+	pubKey := testPublicKey(t)
 
 	// Mock CreateCompositeKey
 	stub.On("CreateCompositeKey", PLAYER, []string{fmt.Sprintf("%d", playerID)}).Return(compositeKey, nil)
 
 	// Mock GetState to simulate the player does not exist
 	stub.On("GetState", compositeKey).Return(nil, nil)
+	stub.On("GetState", ROLLUP).Return(nil, nil)
 
 	// Mock PutState to simulate successful write to the ledger
 	player := types.Player{
 		ID:         playerID,
 		Balance:    0,
 		UsdBalance: 0,
+		PubKey:     pubKey,
 	}
 	playerJSON, _ := json.Marshal(player)
 	stub.On("PutState", compositeKey, playerJSON).Return(nil)
 
-	err := cc.CreatePlayer(ctx, playerID)
+	err := cc.CreatePlayer(ctx, playerID, pubKey, "")
 	if err != nil {
 		t.Errorf("CreatePlayer failed with error: %s", err)
 	}
@@ -153,6 +164,171 @@ func TestCreatePlayer(t *testing.T) {
 	stub.AssertExpectations(t)
 }
 
+// testPublicKey returns a fresh EdDSA public key as CreatePlayer takes it
+func testPublicKey(t *testing.T) string {
+	_, pubKey := testKey(t)
+	return pubKey
+}
+
+// testKey returns a fresh EdDSA key and its public key as CreatePlayer takes it
+func testKey(t *testing.T) (*eddsa.PrivateKey, string) {
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	return key, base64.StdEncoding.EncodeToString(key.PublicKey.Bytes())
+}
+
+// testSignature returns the signature of key of the change of player id's BEN by benChange at nonce
+func testSignature(t *testing.T, key *eddsa.PrivateKey, id, benChange, nonce int64) string {
+	sig, err := rollup.Sign(key, big.NewInt(id), big.NewInt(benChange), big.NewInt(nonce))
+	if err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	return rollup.EncodeSignature(sig)
+}
+
+// TestCreatePlayerInvalidKey tests that CreatePlayer rejects a public key off the curve
+func TestCreatePlayerInvalidKey(t *testing.T) {
+	ctx := new(MockTransactionContext)
+	stub := new(MockStub)
+	ctx.On("GetStub").Return(stub)
+
+	cc := new(CurrencyContract)
+
+	for _, pubKey := range []string{"not base64", base64.StdEncoding.EncodeToString([]byte("short")), base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if err := cc.CreatePlayer(ctx, 123, pubKey, ""); err == nil {
+			t.Errorf("CreatePlayer accepted public key %q", pubKey)
+		}
+	}
+
+	// The key is checked before the ledger is touched
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+// TestRollup tests that once the ZK rollup is enabled, players must register a public key and
+// players without one cannot get BEN
+func TestRollup(t *testing.T) {
+	ctx := new(MockTransactionContext)
+	stub := new(MockStub)
+	ctx.On("GetStub").Return(stub)
+
+	cc := new(CurrencyContract)
+	cc.ExchangeRate = 1000
+
+	keyless, _ := json.Marshal(types.Player{ID: 10, UsdBalance: 5000})
+	keyed, _ := json.Marshal(types.Player{ID: 11, Balance: 3000, PubKey: testPublicKey(t)})
+	stub.On("CreateCompositeKey", PLAYER, []string{"10"}).Return("PLAYER_10", nil)
+	stub.On("CreateCompositeKey", PLAYER, []string{"11"}).Return("PLAYER_11", nil)
+	stub.On("GetState", "PLAYER_10").Return(keyless, nil)
+	stub.On("GetState", "PLAYER_11").Return(keyed, nil)
+
+	// A player without BEN does not need a key to enable the rollup
+	stub.On("GetStateByPartialCompositeKey", PLAYER, []string{}).Return(&MockStateIterator{Results: []*queryresult.KV{
+		{Key: "PLAYER_10", Value: keyless}, {Key: "PLAYER_11", Value: keyed},
+	}}, nil)
+	stub.On("PutState", ROLLUP, []byte("true")).Return(nil)
+	if err := cc.EnableRollup(ctx); err != nil {
+		t.Fatalf("EnableRollup failed with error: %s", err)
+	}
+	stub.On("GetState", ROLLUP).Return([]byte("true"), nil)
+
+	if err := cc.CreatePlayer(ctx, 12, "", ""); err == nil {
+		t.Errorf("CreatePlayer accepted a player without public key")
+	}
+	if err := cc.ExchangeInGameCurrency(ctx, 10, 1000, ""); err == nil {
+		t.Errorf("ExchangeInGameCurrency credited BEN to a player without public key")
+	}
+	if err := cc.Transfer(ctx, 11, 10, 1000, ""); err == nil {
+		t.Errorf("Transfer credited BEN to a player without public key")
+	}
+	stub.AssertNumberOfCalls(t, "PutState", 1)
+}
+
+// TestRollupSignatures tests that once the ZK rollup is enabled, every BEN change must be signed
+// by its player at the player's nonce, and that the signature is recorded for the operator
+func TestRollupSignatures(t *testing.T) {
+	ctx := new(MockTransactionContext)
+	stub := new(MockStub)
+	ctx.On("GetStub").Return(stub)
+	stub.On("GetState", ROLLUP).Return([]byte("true"), nil)
+
+	cc := new(CurrencyContract)
+	cc.ExchangeRate = 1000
+
+	// A new player signs its first change, which takes an empty slot of the rollup
+	key, pubKey := testKey(t)
+	stub.On("CreateCompositeKey", PLAYER, []string{"12"}).Return("PLAYER_12", nil)
+	stub.On("GetState", "PLAYER_12").Return(nil, nil).Twice()
+	created := testSignature(t, key, 12, 0, 0)
+	createdJSON, _ := json.Marshal(types.Player{ID: 12, PubKey: pubKey, Nonce: 1, Signature: created})
+	stub.On("PutState", "PLAYER_12", createdJSON).Return(nil).Once()
+	if err := cc.CreatePlayer(ctx, 12, pubKey, testSignature(t, key, 12, 1, 0)); err == nil {
+		t.Errorf("CreatePlayer accepted the signature of another change")
+	}
+	if err := cc.CreatePlayer(ctx, 12, pubKey, created); err != nil {
+		t.Fatalf("CreatePlayer failed with error: %s", err)
+	}
+
+	// Exchanges are signed at the nonce of the player, so signatures cannot be replayed
+	exchanged := testSignature(t, key, 12, -500, 1)
+	withUSD, _ := json.Marshal(types.Player{ID: 12, Balance: 1000, PubKey: pubKey, Nonce: 1, Signature: created})
+	stub.On("GetState", "PLAYER_12").Return(withUSD, nil)
+	exchangedJSON, _ := json.Marshal(types.Player{ID: 12, Balance: 500, UsdBalance: 500, PubKey: pubKey, Nonce: 2, Signature: exchanged})
+	stub.On("PutState", "PLAYER_12", exchangedJSON).Return(nil).Once()
+	for _, signature := range []string{"", "not base64", created, testSignature(t, key, 12, -500, 0)} {
+		if err := cc.ExchangeInGameCurrency(ctx, 12, -500, signature); err == nil {
+			t.Errorf("ExchangeInGameCurrency accepted signature %q", signature)
+		}
+	}
+	if err := cc.ExchangeInGameCurrency(ctx, 12, -500, exchanged); err != nil {
+		t.Fatalf("ExchangeInGameCurrency failed with error: %s", err)
+	}
+
+	// Transfers are signed by the sender only; the receiver's nonce does not change
+	receiver, _ := json.Marshal(types.Player{ID: 13, PubKey: testPublicKey(t), Nonce: 4})
+	stub.On("CreateCompositeKey", PLAYER, []string{"13"}).Return("PLAYER_13", nil)
+	stub.On("GetState", "PLAYER_13").Return(receiver, nil)
+	sig, err := rollup.SignTransfer(key, big.NewInt(12), big.NewInt(13), big.NewInt(300), big.NewInt(1))
+	if err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	transferred := rollup.EncodeSignature(sig)
+	if err := cc.Transfer(ctx, 12, 13, 400, transferred); err == nil {
+		t.Errorf("Transfer accepted the signature of another amount")
+	}
+	sentJSON, _ := json.Marshal(types.Player{ID: 12, Balance: 700, PubKey: pubKey, Nonce: 2, Signature: transferred})
+	var received types.Player
+	_ = json.Unmarshal(receiver, &received)
+	received.Balance = 300
+	receivedJSON, _ := json.Marshal(received)
+	stub.On("PutState", "PLAYER_12", sentJSON).Return(nil).Once()
+	stub.On("PutState", "PLAYER_13", receivedJSON).Return(nil).Once()
+	if err := cc.Transfer(ctx, 12, 13, 300, transferred); err != nil {
+		t.Fatalf("Transfer failed with error: %s", err)
+	}
+	stub.AssertNumberOfCalls(t, "PutState", 4)
+	stub.AssertExpectations(t)
+}
+
+// TestEnableRollupKeylessBalance tests that the rollup cannot be enabled while a player without
+// public key holds BEN, which it could not roll up
+func TestEnableRollupKeylessBalance(t *testing.T) {
+	ctx := new(MockTransactionContext)
+	stub := new(MockStub)
+	ctx.On("GetStub").Return(stub)
+
+	keyless, _ := json.Marshal(types.Player{ID: 10, Balance: 1000})
+	stub.On("GetStateByPartialCompositeKey", PLAYER, []string{}).Return(&MockStateIterator{Results: []*queryresult.KV{
+		{Key: "PLAYER_10", Value: keyless},
+	}}, nil)
+
+	if err := new(CurrencyContract).EnableRollup(ctx); err == nil {
+		t.Errorf("EnableRollup succeeded with a keyless player holding BEN")
+	}
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
 // TestRecordBankTransaction tests the RecordBankTransaction function
 func TestRecordBankTransaction(t *testing.T) {
 	ctx := new(MockTransactionContext)
@@ -242,13 +418,14 @@ func TestExchangeInGameCurrency(t *testing.T) {
 
 	// Mock GetState to simulate the player exists
 	stub.On("GetState", playerKey).Return(existingPlayerJSON, nil)
+	stub.On("GetState", ROLLUP).Return(nil, nil)
 
 	// Mock PutState to simulate successful write to the ledger
 	stub.On("PutState", playerKey, updatedPlayerJSON).Return(nil)
 
 	cc.ExchangeRate = 1000 // 1.000 exchange rate
 
-	err := cc.ExchangeInGameCurrency(ctx, userID, benAmountChange)
+	err := cc.ExchangeInGameCurrency(ctx, userID, benAmountChange, "")
 	if err != nil {
 		t.Errorf("ExchangeInGameCurrency failed with error: %s", err)
 	}
@@ -258,6 +435,45 @@ func TestExchangeInGameCurrency(t *testing.T) {
 	stub.AssertExpectations(t)
 }
 
+// TestTransfer tests the Transfer function
+func TestTransfer(t *testing.T) {
+	ctx := new(MockTransactionContext)
+	stub := new(MockStub)
+	ctx.On("GetStub").Return(stub)
+
+	cc := new(CurrencyContract)
+
+	fromKey, toKey := "PLAYER_10", "PLAYER_11"
+	stub.On("CreateCompositeKey", PLAYER, []string{"10"}).Return(fromKey, nil)
+	stub.On("CreateCompositeKey", PLAYER, []string{"11"}).Return(toKey, nil)
+
+	fromJSON, _ := json.Marshal(types.Player{ID: 10, Balance: 3000, UsdBalance: 500})
+	toJSON, _ := json.Marshal(types.Player{ID: 11, Balance: 1000})
+	stub.On("GetState", fromKey).Return(fromJSON, nil)
+	stub.On("GetState", toKey).Return(toJSON, nil)
+	stub.On("GetState", ROLLUP).Return(nil, nil)
+
+	// 2.500 BEN move from player 10 to player 11; USD balances are untouched
+	updatedFromJSON, _ := json.Marshal(types.Player{ID: 10, Balance: 500, UsdBalance: 500})
+	updatedToJSON, _ := json.Marshal(types.Player{ID: 11, Balance: 3500})
+	stub.On("PutState", fromKey, updatedFromJSON).Return(nil)
+	stub.On("PutState", toKey, updatedToJSON).Return(nil)
+
+	if err := cc.Transfer(ctx, 10, 11, 2500, ""); err != nil {
+		t.Errorf("Transfer failed with error: %s", err)
+	}
+	stub.AssertNumberOfCalls(t, "PutState", 2)
+	stub.AssertExpectations(t)
+
+	// Overdrafts, self-transfers and non-positive amounts write nothing
+	for _, tc := range []struct{ from, to, amount int64 }{{10, 11, 3001}, {10, 10, 1}, {10, 11, 0}, {10, 11, -1}} {
+		if err := cc.Transfer(ctx, tc.from, tc.to, tc.amount, ""); err == nil {
+			t.Errorf("Transfer(%d, %d, %d) succeeded", tc.from, tc.to, tc.amount)
+		}
+	}
+	stub.AssertNumberOfCalls(t, "PutState", 2)
+}
+
 // TestGetAllPlayers tests the GetAllPlayers function
 func TestGetAllPlayers(t *testing.T) {
 	ctx := new(MockTransactionContext)
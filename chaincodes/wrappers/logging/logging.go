@@ -0,0 +1,66 @@
+// Package logging is the logging of the contracts, on top of log/slog. Debug messages are only
+// formatted when the level asks for them, since the contracts run in the endorsement hot path.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EnvLevel is the environment variable setting the lowest level logged: debug, info, warn or
+// error. Without it the chaincode follows CORE_CHAINCODE_LOGGING_LEVEL, which the peer sets
+// from chaincode.logging.level in core.yaml, and logs at info otherwise.
+const EnvLevel = "CHAINCODE_LOG_LEVEL"
+
+// envPeerLevel is the level the peer passes to the chaincodes it launches.
+const envPeerLevel = "CORE_CHAINCODE_LOGGING_LEVEL"
+
+// KeyTx is the attribute key of the ID of the transaction a message is about.
+const KeyTx = "tx"
+
+// Logger writes the messages of the contracts to standard error.
+var Logger = New(os.Stderr, LevelFromEnv())
+
+// New returns a text logger writing records of level and above to w.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// LevelFromEnv returns the level set by EnvLevel, or else by the peer.
+func LevelFromEnv() slog.Level {
+	for _, env := range []string{EnvLevel, envPeerLevel} {
+		if level, ok := ParseLevel(os.Getenv(env)); ok {
+			return level
+		}
+	}
+	return slog.LevelInfo
+}
+
+// ParseLevel parses a level name of slog or of Fabric (warning, critical, ...), in any case.
+func ParseLevel(name string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error", "critical", "fatal", "panic":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// Debug logs msg and args at debug level with the ID of the transaction of ctx. Below debug it
+// returns before reading the transaction ID or formatting anything.
+func Debug(ctx contractapi.TransactionContextInterface, msg string, args ...any) {
+	if !Logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	Logger.Debug(msg, append([]any{slog.String(KeyTx, ctx.GetStub().GetTxID())}, args...)...)
+}
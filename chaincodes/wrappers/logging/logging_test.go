@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestLevelFromEnv(t *testing.T) {
+	t.Setenv(EnvLevel, "")
+	t.Setenv(envPeerLevel, "WARNING")
+	if level := LevelFromEnv(); level != slog.LevelWarn {
+		t.Errorf("Level from the peer = %v, want WARN", level)
+	}
+	t.Setenv(EnvLevel, "debug")
+	if level := LevelFromEnv(); level != slog.LevelDebug {
+		t.Errorf("Level = %v, want DEBUG", level)
+	}
+	t.Setenv(EnvLevel, "verbose")
+	t.Setenv(envPeerLevel, "")
+	if level := LevelFromEnv(); level != slog.LevelInfo {
+		t.Errorf("Default level = %v, want INFO", level)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	saved := Logger
+	defer func() { Logger = saved }()
+	var buf bytes.Buffer
+
+	// Below debug the transaction context is not even read
+	Logger = New(&buf, slog.LevelInfo)
+	Debug(nil, "hidden")
+	if buf.Len() != 0 {
+		t.Errorf("Debug logged at info: %q", buf.String())
+	}
+
+	Logger = New(&buf, slog.LevelDebug)
+	stub := shimtest.NewMockStub("wrappers", nil)
+	stub.TxID = "tx1"
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+	Debug(ctx, "Player fetched", "balance", 5)
+	if line := buf.String(); !strings.Contains(line, "tx=tx1") || !strings.Contains(line, "balance=5") {
+		t.Errorf("Unexpected record %q", line)
+	}
+}
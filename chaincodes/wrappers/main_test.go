@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"github.com/weids-dev/benchains/chaincodes/wrappers/currency"
+	"github.com/weids-dev/benchains/chaincodes/wrappers/plasma"
+	"github.com/weids-dev/benchains/chaincodes/wrappers/zk"
+)
+
+// metadataFile holds the contract metadata the clients in applications/bench-zk/gateway are
+// checked against. Regenerate it with `go test -run TestMetadata -update` after changing a
+// transaction signature.
+const metadataFile = "metadata.json"
+
+var update = flag.Bool("update", false, "rewrite "+metadataFile)
+
+func TestMetadata(t *testing.T) {
+	chaincode, err := contractapi.NewChaincode(&currency.CurrencyContract{}, &plasma.PlasmaContract{}, &zk.ZKContract{})
+	if err != nil {
+		t.Fatalf("Error creating chaincode: %v", err)
+	}
+	stub := shimtest.NewMockStub("wrappers", chaincode)
+	response := stub.MockInvoke("metadata", [][]byte{[]byte("org.hyperledger.fabric:GetMetadata")})
+	if response.Status != 200 {
+		t.Fatalf("GetMetadata failed: %s", response.Message)
+	}
+
+	var metadata bytes.Buffer
+	if err := json.Indent(&metadata, response.Payload, "", "  "); err != nil {
+		t.Fatalf("Invalid metadata: %v", err)
+	}
+	metadata.WriteByte('\n')
+
+	if *update {
+		if err := os.WriteFile(metadataFile, metadata.Bytes(), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", metadataFile, err)
+		}
+	}
+	committed, err := os.ReadFile(metadataFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", metadataFile, err)
+	}
+	if !bytes.Equal(committed, metadata.Bytes()) {
+		t.Errorf("%s is out of date; regenerate it with go test -run TestMetadata -update", metadataFile)
+	}
+}
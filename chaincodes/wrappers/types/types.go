@@ -7,6 +7,16 @@ type Player struct {
 	ID         int64 `json:"id"`         // ID is the player's unique identifier.
 	Balance    int64 `json:"balance"`    // Balance tracks the BEN currency (3 decimal places)
 	UsdBalance int64 `json:"usdBalance"` // UsdBalance tracks USD available for exchange
+
+	// PubKey is the EdDSA public key registered by the player, compressed and base64.
+	// The ZK rollup only accepts state changes of the player signed by this key.
+	PubKey string `json:"pubKey,omitempty"`
+	// Nonce counts the BEN changes the player signed since the ZK rollup was enabled, like the
+	// nonce of the player's account leaf; the next change is signed with it.
+	Nonce int64 `json:"nonce,omitempty"`
+	// Signature is the player's signature of its last signed BEN change, base64, which the
+	// operator of the ZK rollup hands to the rollup circuits.
+	Signature string `json:"signature,omitempty"`
 }
 
 // BankTransaction represents a transaction from the bank to buy in-game currency.
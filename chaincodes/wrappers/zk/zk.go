@@ -2,7 +2,9 @@ package zk
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -10,9 +12,12 @@ import (
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"github.com/weids-dev/benchains/circuits/aggregate"
+	"github.com/weids-dev/benchains/circuits/rollup"
 )
 
 // ZKContract defines the smart contract for handling ZK-Rollups on Hyperledger Fabric Layer 1
@@ -20,121 +25,64 @@ type ZKContract struct {
 	contractapi.Contract
 }
 
-// PublicInputs defines the public inputs for ZK proof verification, matching the operator's circuit
-type PublicInputs struct {
-	OldRoot *big.Int `gnark:"oldRoot,public"`
-	NewRoot *big.Int `gnark:"newRoot,public"`
-}
-
-// Adjustable constants for ProofMerkleCircuit
+// Proof systems ZKContract can verify proofs of.
 const (
-	D2 = 10 // ProofMerkleCircuit: Number of Leaves would be 2^10 = 1024
-	B2 = 32 // Number of transactions in the batch
+	BackendGroth16 = "groth16"
+	BackendPlonk   = "plonk"
+
+	// BackendGroth16BW6761 verifies Groth16 proofs over BW6-761 of the aggregation circuit,
+	// which folds the batch proofs of a block (see CommitAggregatedProof)
+	BackendGroth16BW6761 = "groth16-bw6761"
 )
 
-// ProofMerkleCircuit verifies a batch of transactions updating a Merkle tree sequentially.
-type ProofMerkleCircuit struct {
-	// Public inputs
-	OldRoot frontend.Variable `gnark:"oldRoot,public"`
-	NewRoot frontend.Variable `gnark:"newRoot,public"`
-
-	// Private inputs: B2 transactions
-	Transactions [B2]struct {
-		OldName    frontend.Variable     `gnark:"oldName"`
-		OldBalance frontend.Variable     `gnark:"oldBalance"`
-		NewName    frontend.Variable     `gnark:"newName"`
-		BenChange  frontend.Variable     `gnark:"benChange"` // Changed from NewBalance
-		Siblings   [D2]frontend.Variable `gnark:"siblings"`
-		PathBits   [D2]frontend.Variable `gnark:"pathBits"`
-	}
+// Verifier is the verifying key registered for one circuit ID, together with the proof system
+// it belongs to. Every circuit verified here has the public inputs of the rollup circuits
+// (rollup.PublicWitness), except aggregation circuits, whose public inputs are the roots of the
+// chain they fold (aggregate.PublicWitness). Fingerprint identifies the constraint system the
+// key was set up for (see rollup.Fingerprint), so that an operator can check it proves the
+// same circuit.
+type Verifier struct {
+	Backend      string `json:"backend"`
+	VerifyingKey []byte `json:"verifyingKey"`
+	Fingerprint  string `json:"fingerprint"`
 }
 
-// Define implements the circuit constraints.
-func (c *ProofMerkleCircuit) Define(api frontend.API) error {
-	var previousNewRoot frontend.Variable
-
-	for k := 0; k < B2; k++ {
-		tx := c.Transactions[k]
-
-		// Compute old leaf hash: H_old_k = MiMC(OldName, OldBalance)
-		mimcOld, err := mimc.NewMiMC(api)
-		if err != nil {
-			return err
-		}
-		mimcOld.Write(tx.OldName, tx.OldBalance)
-		H_old_k := mimcOld.Sum()
-
-		// Compute old root from H_old_k and Merkle proof
-		currentHash := H_old_k
-		for i := 0; i < D2; i++ {
-			left := api.Select(tx.PathBits[i], currentHash, tx.Siblings[i])
-			right := api.Select(tx.PathBits[i], tx.Siblings[i], currentHash)
-			mimcLevel, err := mimc.NewMiMC(api)
-			if err != nil {
-				return err
-			}
-			mimcLevel.Write(left, right)
-			currentHash = mimcLevel.Sum()
-		}
-		ComputedOldRoot_k := currentHash
-
-		// Calculate NewBalance inside the circuit by adding OldBalance and BenChange
-		NewBalance := api.Add(tx.OldBalance, tx.BenChange)
-
-		// Compute new leaf hash: H_new_k = MiMC(NewName, NewBalance)
-		mimcNew, err := mimc.NewMiMC(api)
-		if err != nil {
-			return err
-		}
-		mimcNew.Write(tx.NewName, NewBalance)
-		H_new_k := mimcNew.Sum()
-
-		// Compute new root from H_new_k and the same Merkle proof
-		currentHash = H_new_k
-		for i := 0; i < D2; i++ {
-			left := api.Select(tx.PathBits[i], currentHash, tx.Siblings[i])
-			right := api.Select(tx.PathBits[i], tx.Siblings[i], currentHash)
-			mimcLevel, err := mimc.NewMiMC(api)
-			if err != nil {
-				return err
-			}
-			mimcLevel.Write(left, right)
-			currentHash = mimcLevel.Sum()
-		}
-		ComputedNewRoot_k := currentHash
-
-		// Chain the roots: old root matches previous new root (or OldRoot for k=0)
-		if k == 0 {
-			api.AssertIsEqual(ComputedOldRoot_k, c.OldRoot)
-		} else {
-			api.AssertIsEqual(ComputedOldRoot_k, previousNewRoot)
-		}
-		previousNewRoot = ComputedNewRoot_k
+// Keys under which InitLedger records the configuration of the rollup. They are written once:
+// the rollup stays bound to the circuit it was initialized with.
+const (
+	circuitIdKey = "circuitId" // circuit ID of the verifier registered by InitLedger
+	adminMSPKey  = "adminMSP"  // MSP allowed to register further verifiers
+)
 
-		// Ensure PathBits are boolean (0 or 1)
-		for i := 0; i < D2; i++ {
-			api.AssertIsBoolean(tx.PathBits[i])
-		}
+// InitLedger initializes the chaincode with the verifier of the rollup circuit and the initial state root.
+// circuitId names the circuit and backend the operator proves blocks with (e.g. "ProofMerkleCircuit/groth16"),
+// and fingerprint the constraint system its verifying key was set up for. The MSP of the caller
+// becomes the admin of the rollup, who may register the verifiers of further circuits.
+// InitLedger fails once the ledger is initialized.
+func (c *ZKContract) InitLedger(ctx contractapi.TransactionContextInterface, circuitId string, backend string, verifyingKeyBase64 string, fingerprint string, initialRootBase64 string) error {
+	latestBlockNumberBytes, err := ctx.GetStub().GetState("latestBlockNumber")
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %v", err)
 	}
-
-	// Verify the final root matches NewRoot
-	api.AssertIsEqual(previousNewRoot, c.NewRoot)
-
-	return nil
-}
-
-// InitLedger initializes the chaincode with the verifying key and initial state root
-func (c *ZKContract) InitLedger(ctx contractapi.TransactionContextInterface, verifyingKeyBase64 string, initialRootBase64 string) error {
-	// Decode the verifying key from base64
-	verifyingKeyBytes, err := base64.StdEncoding.DecodeString(verifyingKeyBase64)
+	if latestBlockNumberBytes != nil {
+		return fmt.Errorf("ZKContract is already initialized")
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return fmt.Errorf("failed to decode verifying key: %v", err)
+		return fmt.Errorf("failed to get the MSP of the caller: %v", err)
 	}
 
-	// Store the verifying key in the ledger state
-	err = ctx.GetStub().PutState("verifyingKey", verifyingKeyBytes)
+	// Store the verifier of the rollup circuit and bind the rollup to it
+	if err := putVerifier(ctx, circuitId, backend, verifyingKeyBase64, fingerprint); err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(circuitIdKey, []byte(circuitId))
+	if err != nil {
+		return fmt.Errorf("failed to set circuit ID: %v", err)
+	}
+	err = ctx.GetStub().PutState(adminMSPKey, []byte(mspID))
 	if err != nil {
-		return fmt.Errorf("failed to store verifying key: %v", err)
+		return fmt.Errorf("failed to set admin MSP: %v", err)
 	}
 
 	// Set the initial state root for block 1 (similar to PlasmaContract)
@@ -152,6 +100,48 @@ func (c *ZKContract) InitLedger(ctx contractapi.TransactionContextInterface, ver
 	return nil
 }
 
+// RegisterVerifier registers the verifying key of another circuit or backend under circuitId.
+// Only members of the MSP that initialized the ledger may register verifiers. Registered
+// verifiers cannot be replaced, so a circuit ID keeps meaning the same thing: a proof committed
+// under circuitId is checked with this verifier for good.
+func (c *ZKContract) RegisterVerifier(ctx contractapi.TransactionContextInterface, circuitId string, backend string, verifyingKeyBase64 string, fingerprint string) error {
+	if err := checkAdmin(ctx); err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState("verifier:" + circuitId)
+	if err != nil {
+		return fmt.Errorf("failed to get verifier %s: %v", circuitId, err)
+	}
+	if existing != nil {
+		return fmt.Errorf("verifier %s is already registered", circuitId)
+	}
+	return putVerifier(ctx, circuitId, backend, verifyingKeyBase64, fingerprint)
+}
+
+// QueryCircuitId returns the circuit ID of the verifier the rollup was initialized with
+func (c *ZKContract) QueryCircuitId(ctx contractapi.TransactionContextInterface) (string, error) {
+	return getCircuitId(ctx)
+}
+
+// QueryVerifierBackend returns the proof system of the verifier registered under circuitId
+func (c *ZKContract) QueryVerifierBackend(ctx contractapi.TransactionContextInterface, circuitId string) (string, error) {
+	v, err := getVerifier(ctx, circuitId)
+	if err != nil {
+		return "", err
+	}
+	return v.Backend, nil
+}
+
+// QueryVerifierFingerprint returns the fingerprint of the constraint system the verifier
+// registered under circuitId was set up for
+func (c *ZKContract) QueryVerifierFingerprint(ctx contractapi.TransactionContextInterface, circuitId string) (string, error) {
+	v, err := getVerifier(ctx, circuitId)
+	if err != nil {
+		return "", err
+	}
+	return v.Fingerprint, nil
+}
+
 // CommitNoChange commits a state root for a block with no state-changing transactions
 func (c *ZKContract) CommitNoChange(ctx contractapi.TransactionContextInterface, blockId string, stateRootBase64 string) error {
 	// Retrieve the latest committed block number
@@ -208,114 +198,218 @@ func (c *ZKContract) CommitNoChange(ctx contractapi.TransactionContextInterface,
 	return nil
 }
 
-// CommitProof verifies a ZK proof and updates the state root if valid
-func (c *ZKContract) CommitProof(ctx contractapi.TransactionContextInterface, blockId string, oldRootBase64 string, newRootBase64 string, proofBase64 string) error {
-	// Retrieve the latest committed block number
-	latestBlockNumberBytes, err := ctx.GetStub().GetState("latestBlockNumber")
+// CommitProof verifies a ZK proof with the verifier registered under circuitId and updates the state root if valid
+func (c *ZKContract) CommitProof(ctx contractapi.TransactionContextInterface, blockId string, circuitId string, oldRootBase64 string, newRootBase64 string, proofBase64 string) error {
+	// Get the previous state root (blockId - 1), checking that blockId is the next block
+	prevStateRootBase64, err := previousStateRoot(ctx, blockId)
 	if err != nil {
-		return fmt.Errorf("failed to get latest block number: %v", err)
+		return err
 	}
-	if latestBlockNumberBytes == nil {
-		return fmt.Errorf("latest block number not initialized")
+
+	// Verify that oldRoot matches the previous state root
+	if prevStateRootBase64 != oldRootBase64 {
+		return fmt.Errorf("oldRoot does not match the state root of the previous block")
 	}
-	latestBlockNumber, err := strconv.Atoi(string(latestBlockNumberBytes))
+
+	v, verify, err := loadVerifier(ctx, circuitId)
 	if err != nil {
-		return fmt.Errorf("invalid latest block number: %v", err)
+		return err
+	}
+	if err := verifyTransition(circuitId, v, verify, oldRootBase64, newRootBase64, proofBase64); err != nil {
+		return err
 	}
 
-	// Convert blockId to integer and verify it's the next block
-	blockIdInt, err := strconv.Atoi(blockId)
+	// Proof is valid, update the state
+	// Store the new state root
+	newStateRootKey := "stateRoot:" + blockId
+	err = ctx.GetStub().PutState(newStateRootKey, []byte(newRootBase64))
 	if err != nil {
-		return fmt.Errorf("invalid blockId: %v", err)
-	}
-	if blockIdInt != latestBlockNumber+1 {
-		return fmt.Errorf("expected blockId %d, got %d", latestBlockNumber+1, blockIdInt)
+		return fmt.Errorf("failed to store new state root for block %s: %v", blockId, err)
 	}
 
-	// Get the previous state root (blockId - 1)
-	prevBlockIdStr := strconv.Itoa(blockIdInt - 1)
-	prevStateRootKey := "stateRoot:" + prevBlockIdStr
-	prevStateRootBase64, err := ctx.GetStub().GetState(prevStateRootKey)
+	// Store the proof and the circuit it was verified for
+	proofKey := "proof:" + blockId
+	err = ctx.GetStub().PutState(proofKey, []byte(proofBase64))
 	if err != nil {
-		return fmt.Errorf("failed to get previous state root for block %s: %v", prevBlockIdStr, err)
+		return fmt.Errorf("failed to store proof for block %s: %v", blockId, err)
 	}
-	if prevStateRootBase64 == nil {
-		return fmt.Errorf("previous state root not found for block %s", prevBlockIdStr)
+	err = ctx.GetStub().PutState("proofCircuitId:"+blockId, []byte(circuitId))
+	if err != nil {
+		return fmt.Errorf("failed to store proof circuit for block %s: %v", blockId, err)
 	}
 
-	// Verify that oldRoot matches the previous state root
-	if string(prevStateRootBase64) != oldRootBase64 {
-		return fmt.Errorf("oldRoot does not match the state root of block %s", prevBlockIdStr)
+	// Update the latest block number
+	err = ctx.GetStub().PutState("latestBlockNumber", []byte(blockId))
+	if err != nil {
+		return fmt.Errorf("failed to update latest block number: %v", err)
 	}
 
-	// Decode oldRoot and newRoot from base64 to *big.Int for verification
-	oldRootBytes, err := base64.StdEncoding.DecodeString(oldRootBase64)
+	return nil
+}
+
+// ProofChain holds the proofs of a block whose state changes did not fit in one batch.
+// Proofs[i] proves the transition Roots[i] -> Roots[i+1] and is checked with the verifier
+// registered under CircuitIds[i]; Roots[0] is the state root of the previous block and the last
+// root is the state root of the block.
+type ProofChain struct {
+	Roots      []string `json:"roots"`
+	Proofs     []string `json:"proofs"`
+	CircuitIds []string `json:"circuitIds"`
+}
+
+// CommitProofChain verifies a chain of ZK proofs covering one block, each with the verifier
+// registered under its circuit ID, and updates the state root if all of them are valid. The
+// block is committed atomically: either every sub-batch verifies or nothing is written.
+func (c *ZKContract) CommitProofChain(ctx contractapi.TransactionContextInterface, blockId string, rootsJSON string, proofsJSON string, circuitIdsJSON string) error {
+	var chain ProofChain
+	if err := json.Unmarshal([]byte(rootsJSON), &chain.Roots); err != nil {
+		return fmt.Errorf("failed to parse roots: %v", err)
+	}
+	if err := json.Unmarshal([]byte(proofsJSON), &chain.Proofs); err != nil {
+		return fmt.Errorf("failed to parse proofs: %v", err)
+	}
+	if err := json.Unmarshal([]byte(circuitIdsJSON), &chain.CircuitIds); err != nil {
+		return fmt.Errorf("failed to parse circuit IDs: %v", err)
+	}
+	if len(chain.Proofs) == 0 {
+		return fmt.Errorf("proof chain for block %s is empty", blockId)
+	}
+	if len(chain.Roots) != len(chain.Proofs)+1 {
+		return fmt.Errorf("expected %d roots for %d proofs, got %d", len(chain.Proofs)+1, len(chain.Proofs), len(chain.Roots))
+	}
+	if len(chain.CircuitIds) != len(chain.Proofs) {
+		return fmt.Errorf("expected %d circuit IDs for %d proofs, got %d", len(chain.Proofs), len(chain.Proofs), len(chain.CircuitIds))
+	}
+
+	// Get the previous state root (blockId - 1), checking that blockId is the next block
+	prevStateRootBase64, err := previousStateRoot(ctx, blockId)
 	if err != nil {
-		return fmt.Errorf("failed to decode oldRoot: %v", err)
+		return err
+	}
+	if prevStateRootBase64 != chain.Roots[0] {
+		return fmt.Errorf("first root does not match the state root of the previous block")
+	}
+
+	// Batches of a chain mostly share their circuit, so each verifier is loaded once
+	type loaded struct {
+		v      *Verifier
+		verify verifyFunc
+	}
+	verifiers := make(map[string]loaded)
+	for i, proofBase64 := range chain.Proofs {
+		circuitId := chain.CircuitIds[i]
+		l, ok := verifiers[circuitId]
+		if !ok {
+			v, verify, err := loadVerifier(ctx, circuitId)
+			if err != nil {
+				return fmt.Errorf("sub-batch %d: %v", i, err)
+			}
+			l = loaded{v, verify}
+			verifiers[circuitId] = l
+		}
+		if err := verifyTransition(circuitId, l.v, l.verify, chain.Roots[i], chain.Roots[i+1], proofBase64); err != nil {
+			return fmt.Errorf("sub-batch %d: %v", i, err)
+		}
 	}
-	oldRoot := new(big.Int).SetBytes(oldRootBytes)
 
-	newRootBytes, err := base64.StdEncoding.DecodeString(newRootBase64)
+	// All proofs are valid, update the state
+	newRootBase64 := chain.Roots[len(chain.Roots)-1]
+	err = ctx.GetStub().PutState("stateRoot:"+blockId, []byte(newRootBase64))
 	if err != nil {
-		return fmt.Errorf("failed to decode newRoot: %v", err)
+		return fmt.Errorf("failed to store new state root for block %s: %v", blockId, err)
 	}
-	newRoot := new(big.Int).SetBytes(newRootBytes)
 
-	// Decode and deserialize the proof
-	proofBytes, err := base64.StdEncoding.DecodeString(proofBase64)
+	chainJSON, err := json.Marshal(chain)
 	if err != nil {
-		return fmt.Errorf("failed to decode proof: %v", err)
+		return fmt.Errorf("failed to marshal proof chain: %v", err)
 	}
-	proof, err := deserializeProof(proofBytes)
+	err = ctx.GetStub().PutState("proofChain:"+blockId, chainJSON)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize proof: %v", err)
+		return fmt.Errorf("failed to store proof chain for block %s: %v", blockId, err)
 	}
 
-	// Retrieve and deserialize the verifying key
-	verifyingKeyBytes, err := ctx.GetStub().GetState("verifyingKey")
+	err = ctx.GetStub().PutState("latestBlockNumber", []byte(blockId))
 	if err != nil {
-		return fmt.Errorf("failed to get verifying key: %v", err)
+		return fmt.Errorf("failed to update latest block number: %v", err)
+	}
+
+	return nil
+}
+
+// AggregatedProof holds the proof of a block whose batch proofs were folded into one proof of
+// the aggregation circuit registered under CircuitId. The proof shows that the batches chain the
+// state through Roots; Roots[0] is the state root of the previous block and the last root is the
+// state root of the block.
+type AggregatedProof struct {
+	Roots     []string `json:"roots"`
+	Proof     string   `json:"proof"`
+	CircuitId string   `json:"circuitId"`
+}
+
+// CommitAggregatedProof verifies one aggregated proof covering the batches of a block with the
+// verifier registered under circuitId and updates the state root if it is valid. It accepts the
+// same chain of roots as CommitProofChain, but verifies a single proof whatever its length.
+func (c *ZKContract) CommitAggregatedProof(ctx contractapi.TransactionContextInterface, blockId string, circuitId string, rootsJSON string, proofBase64 string) error {
+	agg := AggregatedProof{Proof: proofBase64, CircuitId: circuitId}
+	if err := json.Unmarshal([]byte(rootsJSON), &agg.Roots); err != nil {
+		return fmt.Errorf("failed to parse roots: %v", err)
 	}
-	if verifyingKeyBytes == nil {
-		return fmt.Errorf("verifying key not initialized")
+	if len(agg.Roots) < 2 {
+		return fmt.Errorf("expected at least 2 roots, got %d", len(agg.Roots))
 	}
-	vk, err := deserializeVerifyingKey(verifyingKeyBytes)
+
+	// Get the previous state root (blockId - 1), checking that blockId is the next block
+	prevStateRootBase64, err := previousStateRoot(ctx, blockId)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize verifying key: %v", err)
+		return err
+	}
+	if prevStateRootBase64 != agg.Roots[0] {
+		return fmt.Errorf("first root does not match the state root of the previous block")
 	}
 
-	var publicAssignment ProofMerkleCircuit
-	publicAssignment.OldRoot = oldRoot
-	publicAssignment.NewRoot = newRoot
-
-	// Generate public witness
-	publicWitness, err := frontend.NewWitness(&publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	v, verify, err := loadVerifier(ctx, circuitId)
 	if err != nil {
-		return fmt.Errorf("failed to create public witness: %v", err)
+		return err
+	}
+	if v.Backend != BackendGroth16BW6761 {
+		return fmt.Errorf("circuit %s verifies %s proofs of single batches, not aggregated proofs", circuitId, v.Backend)
 	}
 
-	// Verify the proof using Gnark
-	err = groth16.Verify(proof, vk, publicWitness)
+	// The public inputs of the aggregation circuit are the roots of the chain
+	roots := make([]*big.Int, len(agg.Roots))
+	for i, rootBase64 := range agg.Roots {
+		if roots[i], err = decodeRoot(rootBase64); err != nil {
+			return fmt.Errorf("root %d: %v", i, err)
+		}
+	}
+	proofBytes, err := base64.StdEncoding.DecodeString(proofBase64)
 	if err != nil {
-		return fmt.Errorf("proof verification failed: %v", err)
+		return fmt.Errorf("failed to decode proof: %v", err)
+	}
+	publicWitness, err := aggregate.PublicWitness(roots)
+	if err != nil {
+		return err
+	}
+	if err := verify(proofBytes, publicWitness); err != nil {
+		return verificationFailed(circuitId, v, err)
 	}
 
 	// Proof is valid, update the state
-	// Store the new state root
-	newStateRootKey := "stateRoot:" + blockId
-	err = ctx.GetStub().PutState(newStateRootKey, []byte(newRootBase64))
+	newRootBase64 := agg.Roots[len(agg.Roots)-1]
+	err = ctx.GetStub().PutState("stateRoot:"+blockId, []byte(newRootBase64))
 	if err != nil {
 		return fmt.Errorf("failed to store new state root for block %s: %v", blockId, err)
 	}
 
-	// Store the proof
-	proofKey := "proof:" + blockId
-	err = ctx.GetStub().PutState(proofKey, []byte(proofBase64))
+	aggJSON, err := json.Marshal(agg)
 	if err != nil {
-		return fmt.Errorf("failed to store proof for block %s: %v", blockId, err)
+		return fmt.Errorf("failed to marshal aggregated proof: %v", err)
+	}
+	err = ctx.GetStub().PutState("aggregatedProof:"+blockId, aggJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store aggregated proof for block %s: %v", blockId, err)
 	}
 
-	// Update the latest block number
 	err = ctx.GetStub().PutState("latestBlockNumber", []byte(blockId))
 	if err != nil {
 		return fmt.Errorf("failed to update latest block number: %v", err)
@@ -337,6 +431,59 @@ func (c *ZKContract) QueryStateRoot(ctx contractapi.TransactionContextInterface,
 	return string(stateRootBytes), nil
 }
 
+// QueryProof retrieves the base64 proof committed for a specific block.
+// Blocks committed with CommitNoChange have no proof.
+func (c *ZKContract) QueryProof(ctx contractapi.TransactionContextInterface, blockId string) (string, error) {
+	proofKey := "proof:" + blockId
+	proofBytes, err := ctx.GetStub().GetState(proofKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get proof for block %s: %v", blockId, err)
+	}
+	if proofBytes == nil {
+		return "", fmt.Errorf("proof not found for block %s", blockId)
+	}
+	return string(proofBytes), nil
+}
+
+// QueryProofCircuitId retrieves the circuit ID whose verifier checked the proof committed for a
+// specific block by CommitProof.
+func (c *ZKContract) QueryProofCircuitId(ctx contractapi.TransactionContextInterface, blockId string) (string, error) {
+	circuitIdBytes, err := ctx.GetStub().GetState("proofCircuitId:" + blockId)
+	if err != nil {
+		return "", fmt.Errorf("failed to get proof circuit for block %s: %v", blockId, err)
+	}
+	if circuitIdBytes == nil {
+		return "", fmt.Errorf("proof circuit not found for block %s", blockId)
+	}
+	return string(circuitIdBytes), nil
+}
+
+// QueryProofChain retrieves the proof chain committed for a specific block as JSON.
+// Only blocks committed with CommitProofChain have one.
+func (c *ZKContract) QueryProofChain(ctx contractapi.TransactionContextInterface, blockId string) (string, error) {
+	chainBytes, err := ctx.GetStub().GetState("proofChain:" + blockId)
+	if err != nil {
+		return "", fmt.Errorf("failed to get proof chain for block %s: %v", blockId, err)
+	}
+	if chainBytes == nil {
+		return "", fmt.Errorf("proof chain not found for block %s", blockId)
+	}
+	return string(chainBytes), nil
+}
+
+// QueryAggregatedProof retrieves the aggregated proof committed for a specific block as JSON.
+// Only blocks committed with CommitAggregatedProof have one.
+func (c *ZKContract) QueryAggregatedProof(ctx contractapi.TransactionContextInterface, blockId string) (string, error) {
+	aggBytes, err := ctx.GetStub().GetState("aggregatedProof:" + blockId)
+	if err != nil {
+		return "", fmt.Errorf("failed to get aggregated proof for block %s: %v", blockId, err)
+	}
+	if aggBytes == nil {
+		return "", fmt.Errorf("aggregated proof not found for block %s", blockId)
+	}
+	return string(aggBytes), nil
+}
+
 // QueryAllStateRoots retrieves all committed state roots
 func (c *ZKContract) QueryAllStateRoots(ctx contractapi.TransactionContextInterface) (string, error) {
 	// Get the latest block number
@@ -379,22 +526,219 @@ func (c *ZKContract) QueryAllStateRoots(ctx contractapi.TransactionContextInterf
 	return string(resultsJSON), nil
 }
 
-// deserializeProof converts proof bytes back to a groth16.Proof object
-func deserializeProof(proofBytes []byte) (groth16.Proof, error) {
-	proof := groth16.NewProof(ecc.BN254)
-	_, err := proof.ReadFrom(bytes.NewReader(proofBytes))
+// previousStateRoot checks that blockId is the block following the latest committed one
+// and returns the state root of the latest committed block.
+func previousStateRoot(ctx contractapi.TransactionContextInterface, blockId string) (string, error) {
+	// Retrieve the latest committed block number
+	latestBlockNumberBytes, err := ctx.GetStub().GetState("latestBlockNumber")
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest block number: %v", err)
+	}
+	if latestBlockNumberBytes == nil {
+		return "", fmt.Errorf("latest block number not initialized")
+	}
+	latestBlockNumber, err := strconv.Atoi(string(latestBlockNumberBytes))
+	if err != nil {
+		return "", fmt.Errorf("invalid latest block number: %v", err)
+	}
+
+	// Convert blockId to integer and verify it's the next block
+	blockIdInt, err := strconv.Atoi(blockId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize proof: %v", err)
+		return "", fmt.Errorf("invalid blockId: %v", err)
 	}
-	return proof, nil
+	if blockIdInt != latestBlockNumber+1 {
+		return "", fmt.Errorf("expected blockId %d, got %d", latestBlockNumber+1, blockIdInt)
+	}
+
+	// Get the previous state root (blockId - 1)
+	prevBlockIdStr := strconv.Itoa(blockIdInt - 1)
+	prevStateRootBase64, err := ctx.GetStub().GetState("stateRoot:" + prevBlockIdStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to get previous state root for block %s: %v", prevBlockIdStr, err)
+	}
+	if prevStateRootBase64 == nil {
+		return "", fmt.Errorf("previous state root not found for block %s", prevBlockIdStr)
+	}
+	return string(prevStateRootBase64), nil
+}
+
+// getCircuitId returns the circuit ID the rollup was initialized with
+func getCircuitId(ctx contractapi.TransactionContextInterface) (string, error) {
+	circuitIdBytes, err := ctx.GetStub().GetState(circuitIdKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get circuit ID: %v", err)
+	}
+	if circuitIdBytes == nil {
+		return "", fmt.Errorf("circuit ID not initialized")
+	}
+	return string(circuitIdBytes), nil
 }
 
-// deserializeVerifyingKey converts verifying key bytes back to a groth16.VerifyingKey object
-func deserializeVerifyingKey(vkBytes []byte) (groth16.VerifyingKey, error) {
-	vk := groth16.NewVerifyingKey(ecc.BN254)
-	_, err := vk.ReadFrom(bytes.NewReader(vkBytes))
+// checkAdmin checks that the caller belongs to the MSP that initialized the ledger
+func checkAdmin(ctx contractapi.TransactionContextInterface) error {
+	adminMSPBytes, err := ctx.GetStub().GetState(adminMSPKey)
 	if err != nil {
+		return fmt.Errorf("failed to get admin MSP: %v", err)
+	}
+	if adminMSPBytes == nil {
+		return fmt.Errorf("admin MSP not initialized")
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get the MSP of the caller: %v", err)
+	}
+	if mspID != string(adminMSPBytes) {
+		return fmt.Errorf("caller of MSP %s is not the admin of the rollup (%s)", mspID, adminMSPBytes)
+	}
+	return nil
+}
+
+// putVerifier validates and stores the verifying key of circuitId for backend
+func putVerifier(ctx contractapi.TransactionContextInterface, circuitId string, backend string, verifyingKeyBase64 string, fingerprint string) error {
+	if circuitId == "" {
+		return fmt.Errorf("circuit ID is required")
+	}
+	if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid constraint system fingerprint %q: expected a hex SHA-256", fingerprint)
+	}
+	verifyingKeyBytes, err := base64.StdEncoding.DecodeString(verifyingKeyBase64)
+	if err != nil {
+		return fmt.Errorf("failed to decode verifying key: %v", err)
+	}
+
+	// Reject keys that could never verify anything
+	v := Verifier{Backend: backend, VerifyingKey: verifyingKeyBytes, Fingerprint: fingerprint}
+	if _, err := v.load(); err != nil {
+		return err
+	}
+
+	vJSON, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal verifier: %v", err)
+	}
+	if err := ctx.GetStub().PutState("verifier:"+circuitId, vJSON); err != nil {
+		return fmt.Errorf("failed to store verifier %s: %v", circuitId, err)
+	}
+	return nil
+}
+
+// getVerifier retrieves the verifier registered under circuitId
+func getVerifier(ctx contractapi.TransactionContextInterface, circuitId string) (*Verifier, error) {
+	vJSON, err := ctx.GetStub().GetState("verifier:" + circuitId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verifier %s: %v", circuitId, err)
+	}
+	if vJSON == nil {
+		return nil, fmt.Errorf("verifier not found for circuit %s", circuitId)
+	}
+	var v Verifier
+	if err := json.Unmarshal(vJSON, &v); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal verifier %s: %v", circuitId, err)
+	}
+	return &v, nil
+}
+
+// verifyFunc checks a serialized proof against its public witness
+type verifyFunc func(proofBytes []byte, publicWitness witness.Witness) error
+
+// loadVerifier retrieves the verifier registered under circuitId, ready to check proofs
+func loadVerifier(ctx contractapi.TransactionContextInterface, circuitId string) (*Verifier, verifyFunc, error) {
+	v, err := getVerifier(ctx, circuitId)
+	if err != nil {
+		return nil, nil, err
+	}
+	verify, err := v.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, verify, nil
+}
+
+// load deserializes the verifying key of v and returns a function checking proofs of its backend
+func (v *Verifier) load() (verifyFunc, error) {
+	switch v.Backend {
+	case BackendGroth16:
+		return loadGroth16(rollup.Curve, v.VerifyingKey)
+	case BackendGroth16BW6761:
+		return loadGroth16(aggregate.Curve, v.VerifyingKey)
+	case BackendPlonk:
+		vk := plonk.NewVerifyingKey(rollup.Curve)
+		if _, err := vk.ReadFrom(bytes.NewReader(v.VerifyingKey)); err != nil {
+			return nil, fmt.Errorf("failed to deserialize verifying key: %v", err)
+		}
+		return func(proofBytes []byte, publicWitness witness.Witness) error {
+			proof := plonk.NewProof(rollup.Curve)
+			if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+				return fmt.Errorf("failed to deserialize proof: %v", err)
+			}
+			return plonk.Verify(proof, vk, publicWitness)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown proof backend %q", v.Backend)
+	}
+}
+
+// loadGroth16 deserializes a Groth16 verifying key over curve
+func loadGroth16(curve ecc.ID, verifyingKey []byte) (verifyFunc, error) {
+	vk := groth16.NewVerifyingKey(curve)
+	if _, err := vk.ReadFrom(bytes.NewReader(verifyingKey)); err != nil {
 		return nil, fmt.Errorf("failed to deserialize verifying key: %v", err)
 	}
-	return vk, nil
+	return func(proofBytes []byte, publicWitness witness.Witness) error {
+		proof := groth16.NewProof(curve)
+		if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+			return fmt.Errorf("failed to deserialize proof: %v", err)
+		}
+		return groth16.Verify(proof, vk, publicWitness)
+	}, nil
+}
+
+// verifyTransition verifies a base64 proof that the state moved from oldRoot to newRoot
+func verifyTransition(circuitId string, v *Verifier, verify verifyFunc, oldRootBase64 string, newRootBase64 string, proofBase64 string) error {
+	if v.Backend == BackendGroth16BW6761 {
+		return fmt.Errorf("circuit %s verifies aggregated proofs, commit them with CommitAggregatedProof", circuitId)
+	}
+
+	// Decode oldRoot and newRoot from base64 to *big.Int for verification
+	oldRoot, err := decodeRoot(oldRootBase64)
+	if err != nil {
+		return fmt.Errorf("oldRoot: %v", err)
+	}
+	newRoot, err := decodeRoot(newRootBase64)
+	if err != nil {
+		return fmt.Errorf("newRoot: %v", err)
+	}
+
+	// Decode the proof
+	proofBytes, err := base64.StdEncoding.DecodeString(proofBase64)
+	if err != nil {
+		return fmt.Errorf("failed to decode proof: %v", err)
+	}
+
+	// Verify the proof using Gnark, with the public inputs as the rollup circuits declare them
+	publicWitness, err := rollup.PublicWitness(oldRoot, newRoot)
+	if err != nil {
+		return err
+	}
+	if err := verify(proofBytes, publicWitness); err != nil {
+		return verificationFailed(circuitId, v, err)
+	}
+	return nil
+}
+
+// verificationFailed reports a proof rejected by the verifier v of circuitId. Besides invalid
+// proofs, this is what an operator proving another circuit than the registered one gets, so
+// the error names the constraint system the verifier expects.
+func verificationFailed(circuitId string, v *Verifier, err error) error {
+	return fmt.Errorf("proof verification failed for circuit %s (constraint system %s): %v", circuitId, v.Fingerprint, err)
+}
+
+// decodeRoot decodes a base64 state root into the integer the circuits take as public input
+func decodeRoot(rootBase64 string) (*big.Int, error) {
+	rootBytes, err := base64.StdEncoding.DecodeString(rootBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode root: %v", err)
+	}
+	return new(big.Int).SetBytes(rootBytes), nil
 }
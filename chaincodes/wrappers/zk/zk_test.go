@@ -0,0 +1,332 @@
+package zk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"github.com/weids-dev/benchains/circuits/aggregate"
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+const fingerprint = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// MemStub is a ChaincodeStubInterface keeping the world state in a map
+type MemStub struct {
+	shim.ChaincodeStubInterface
+	state map[string][]byte
+}
+
+// GetState returns the value of key, or nil if it is not set
+func (ms *MemStub) GetState(key string) ([]byte, error) {
+	return ms.state[key], nil
+}
+
+// PutState sets the value of key
+func (ms *MemStub) PutState(key string, value []byte) error {
+	ms.state[key] = value
+	return nil
+}
+
+// MSPIdentity is a client identity of the MSP it names
+type MSPIdentity struct {
+	cid.ClientIdentity
+	mspID string
+}
+
+// GetMSPID returns the MSP of the identity
+func (id MSPIdentity) GetMSPID() (string, error) {
+	return id.mspID, nil
+}
+
+// newContext returns a transaction context calling into stub as a member of mspID
+func newContext(stub *MemStub, mspID string) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(MSPIdentity{mspID: mspID})
+	return ctx
+}
+
+// squareCircuit proves knowledge of the square root of a public input
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+// verifyingKey returns the base64 Groth16 verifying key of a small circuit
+func verifyingKey(t *testing.T) string {
+	ccs, err := frontend.Compile(rollup.Curve.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	_, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := vk.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to serialize verifying key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// TestInitLedgerOnce tests that InitLedger binds the rollup to its circuit and cannot run twice
+func TestInitLedgerOnce(t *testing.T) {
+	vk := verifyingKey(t)
+	stub := &MemStub{state: map[string][]byte{}}
+	ctx := newContext(stub, "Org1MSP")
+	zc := new(ZKContract)
+
+	if err := zc.InitLedger(ctx, "ProofMerkleCircuit/groth16", BackendGroth16, vk, fingerprint, "cm9vdA=="); err != nil {
+		t.Fatalf("InitLedger failed: %v", err)
+	}
+	if circuitId, err := zc.QueryCircuitId(ctx); err != nil || circuitId != "ProofMerkleCircuit/groth16" {
+		t.Errorf("QueryCircuitId returned %q, %v", circuitId, err)
+	}
+
+	// A second InitLedger must not rebind the circuit nor reset the chain of roots
+	stub.state["latestBlockNumber"] = []byte("7")
+	err := zc.InitLedger(ctx, "ProofMerkleCircuit/plonk", BackendGroth16, vk, fingerprint, "b3RoZXI=")
+	if err == nil || !strings.Contains(err.Error(), "already initialized") {
+		t.Errorf("second InitLedger returned %v, expected it to fail", err)
+	}
+	if circuitId, _ := zc.QueryCircuitId(ctx); circuitId != "ProofMerkleCircuit/groth16" {
+		t.Errorf("circuit ID changed to %q", circuitId)
+	}
+	if root := string(stub.state["stateRoot:1"]); root != "cm9vdA==" {
+		t.Errorf("initial state root changed to %q", root)
+	}
+	if latest := string(stub.state["latestBlockNumber"]); latest != "7" {
+		t.Errorf("latest block number changed to %q", latest)
+	}
+}
+
+// TestRegisterVerifierAdmin tests that only the MSP that initialized the ledger registers verifiers
+func TestRegisterVerifierAdmin(t *testing.T) {
+	vk := verifyingKey(t)
+	stub := &MemStub{state: map[string][]byte{}}
+	zc := new(ZKContract)
+
+	if err := zc.RegisterVerifier(newContext(stub, "Org1MSP"), "other", BackendGroth16, vk, fingerprint); err == nil {
+		t.Errorf("RegisterVerifier succeeded before InitLedger")
+	}
+	if err := zc.InitLedger(newContext(stub, "Org1MSP"), "ProofMerkleCircuit/groth16", BackendGroth16, vk, fingerprint, "cm9vdA=="); err != nil {
+		t.Fatalf("InitLedger failed: %v", err)
+	}
+
+	err := zc.RegisterVerifier(newContext(stub, "Org2MSP"), "other", BackendGroth16, vk, fingerprint)
+	if err == nil || !strings.Contains(err.Error(), "not the admin") {
+		t.Errorf("RegisterVerifier from another MSP returned %v, expected it to fail", err)
+	}
+	if stub.state["verifier:other"] != nil {
+		t.Errorf("verifier registered by another MSP")
+	}
+
+	if err := zc.RegisterVerifier(newContext(stub, "Org1MSP"), "other", BackendGroth16, vk, fingerprint); err != nil {
+		t.Errorf("RegisterVerifier from the admin MSP failed: %v", err)
+	}
+	if err := zc.RegisterVerifier(newContext(stub, "Org1MSP"), "other", BackendGroth16, vk, fingerprint); err == nil {
+		t.Errorf("RegisterVerifier replaced a registered verifier")
+	}
+}
+
+// stepCircuit proves that NewRoot is OldRoot plus a secret step. It has the public inputs of the
+// rollup circuits, so ZKContract verifies its proofs like those of a block.
+type stepCircuit struct {
+	OldRoot frontend.Variable `gnark:",public"`
+	NewRoot frontend.Variable `gnark:",public"`
+	Step    frontend.Variable
+}
+
+func (c *stepCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Add(c.OldRoot, c.Step), c.NewRoot)
+	return nil
+}
+
+// stepProver proves a small circuit over curve with the keys of its own setup
+type stepProver struct {
+	curve ecc.ID
+	ccs   constraint.ConstraintSystem
+	pk    groth16.ProvingKey
+	vk    string // base64 verifying key
+}
+
+// newStepProver sets up stepCircuit over the curve of the rollup circuits with fresh keys
+func newStepProver(t *testing.T) *stepProver {
+	return newProver(t, rollup.Curve, &stepCircuit{})
+}
+
+// newProver sets up circuit over curve with fresh keys
+func newProver(t *testing.T, curve ecc.ID, circuit frontend.Circuit) *stepProver {
+	ccs, err := frontend.Compile(curve.ScalarField(), r1cs.NewBuilder, circuit)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := vk.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to serialize verifying key: %v", err)
+	}
+	return &stepProver{curve: curve, ccs: ccs, pk: pk, vk: base64.StdEncoding.EncodeToString(buf.Bytes())}
+}
+
+// prove returns the base64 proof of the transition oldRoot -> newRoot
+func (p *stepProver) prove(t *testing.T, oldRoot, newRoot int) string {
+	return p.proveAssignment(t, &stepCircuit{OldRoot: oldRoot, NewRoot: newRoot, Step: newRoot - oldRoot})
+}
+
+// proveAssignment returns the base64 proof of assignment
+func (p *stepProver) proveAssignment(t *testing.T, assignment frontend.Circuit) string {
+	w, err := frontend.NewWitness(assignment, p.curve.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := groth16.Prove(p.ccs, p.pk, w)
+	if err != nil {
+		t.Fatalf("Prove failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to serialize proof: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// root encodes a small state root as ZKContract stores it
+func root(n int) string {
+	return base64.StdEncoding.EncodeToString([]byte{byte(n)})
+}
+
+// TestCommitProofCircuitIds tests that every committed proof is checked with the verifier
+// registered under the circuit ID it is committed with
+func TestCommitProofCircuitIds(t *testing.T) {
+	a, b := newStepProver(t), newStepProver(t)
+	stub := &MemStub{state: map[string][]byte{}}
+	ctx := newContext(stub, "Org1MSP")
+	zc := new(ZKContract)
+
+	if err := zc.InitLedger(ctx, "a", BackendGroth16, a.vk, fingerprint, root(1)); err != nil {
+		t.Fatalf("InitLedger failed: %v", err)
+	}
+	if err := zc.RegisterVerifier(ctx, "b", BackendGroth16, b.vk, fingerprint); err != nil {
+		t.Fatalf("RegisterVerifier failed: %v", err)
+	}
+
+	proof := b.prove(t, 1, 2)
+	if err := zc.CommitProof(ctx, "2", "a", root(1), root(2), proof); err == nil || !strings.Contains(err.Error(), "verification failed for circuit a") {
+		t.Errorf("proof of b committed under a returned %v, expected it to fail", err)
+	}
+	if err := zc.CommitProof(ctx, "2", "c", root(1), root(2), proof); err == nil || !strings.Contains(err.Error(), "verifier not found for circuit c") {
+		t.Errorf("proof committed under an unregistered circuit returned %v, expected it to fail", err)
+	}
+	if err := zc.CommitProof(ctx, "2", "b", root(1), root(2), proof); err != nil {
+		t.Fatalf("CommitProof failed: %v", err)
+	}
+	if circuitId, err := zc.QueryProofCircuitId(ctx, "2"); err != nil || circuitId != "b" {
+		t.Errorf("QueryProofCircuitId returned %q, %v", circuitId, err)
+	}
+
+	roots, _ := json.Marshal([]string{root(2), root(3), root(4)})
+	proofs, _ := json.Marshal([]string{a.prove(t, 2, 3), b.prove(t, 3, 4)})
+	for _, circuitIds := range []string{`["a"]`, `["b","b"]`} {
+		if err := zc.CommitProofChain(ctx, "3", string(roots), string(proofs), circuitIds); err == nil {
+			t.Errorf("proof chain committed with circuit IDs %s", circuitIds)
+		}
+	}
+	if err := zc.CommitProofChain(ctx, "3", string(roots), string(proofs), `["a","b"]`); err != nil {
+		t.Fatalf("CommitProofChain failed: %v", err)
+	}
+	chainJSON, err := zc.QueryProofChain(ctx, "3")
+	if err != nil {
+		t.Fatalf("QueryProofChain failed: %v", err)
+	}
+	var chain ProofChain
+	if err := json.Unmarshal([]byte(chainJSON), &chain); err != nil || len(chain.CircuitIds) != 2 || chain.CircuitIds[1] != "b" {
+		t.Errorf("unexpected proof chain %s: %v", chainJSON, err)
+	}
+}
+
+// chainCircuit proves that its public roots count up by one. It has the public inputs of the
+// aggregation circuit of two slots, so ZKContract verifies its proofs like aggregated ones.
+type chainCircuit struct {
+	Roots [3]frontend.Variable `gnark:",public"`
+}
+
+func (c *chainCircuit) Define(api frontend.API) error {
+	for i := 1; i < len(c.Roots); i++ {
+		api.AssertIsEqual(api.Add(c.Roots[i-1], 1), c.Roots[i])
+	}
+	return nil
+}
+
+// TestCommitAggregatedProof tests that aggregated proofs are checked against the chain of roots
+// they are committed with, and only with verifiers of the aggregation backend
+func TestCommitAggregatedProof(t *testing.T) {
+	step, chain := newStepProver(t), newProver(t, aggregate.Curve, &chainCircuit{})
+	stub := &MemStub{state: map[string][]byte{}}
+	ctx := newContext(stub, "Org1MSP")
+	zc := new(ZKContract)
+
+	if err := zc.InitLedger(ctx, "step", BackendGroth16, step.vk, fingerprint, root(1)); err != nil {
+		t.Fatalf("InitLedger failed: %v", err)
+	}
+	if err := zc.RegisterVerifier(ctx, "chain", BackendGroth16BW6761, chain.vk, fingerprint); err != nil {
+		t.Fatalf("RegisterVerifier failed: %v", err)
+	}
+
+	proof := chain.proveAssignment(t, &chainCircuit{Roots: [3]frontend.Variable{1, 2, 3}})
+	roots, _ := json.Marshal([]string{root(1), root(2), root(3)})
+	if err := zc.CommitAggregatedProof(ctx, "2", "step", string(roots), proof); err == nil || !strings.Contains(err.Error(), "not aggregated proofs") {
+		t.Errorf("aggregated proof committed under a batch circuit returned %v, expected it to fail", err)
+	}
+	if err := zc.CommitProof(ctx, "2", "chain", root(1), root(2), step.prove(t, 1, 2)); err == nil || !strings.Contains(err.Error(), "CommitAggregatedProof") {
+		t.Errorf("batch proof committed under an aggregation circuit returned %v, expected it to fail", err)
+	}
+	forged, _ := json.Marshal([]string{root(1), root(2), root(4)})
+	if err := zc.CommitAggregatedProof(ctx, "2", "chain", string(forged), proof); err == nil || !strings.Contains(err.Error(), "verification failed for circuit chain") {
+		t.Errorf("aggregated proof committed with other roots returned %v, expected it to fail", err)
+	}
+	shifted, _ := json.Marshal([]string{root(2), root(3), root(4)})
+	if err := zc.CommitAggregatedProof(ctx, "2", "chain", string(shifted), chain.proveAssignment(t, &chainCircuit{Roots: [3]frontend.Variable{2, 3, 4}})); err == nil {
+		t.Errorf("aggregated proof not starting from the previous state root was committed")
+	}
+
+	if err := zc.CommitAggregatedProof(ctx, "2", "chain", string(roots), proof); err != nil {
+		t.Fatalf("CommitAggregatedProof failed: %v", err)
+	}
+	if stateRoot, err := zc.QueryStateRoot(ctx, "2"); err != nil || stateRoot != root(3) {
+		t.Errorf("QueryStateRoot returned %q, %v", stateRoot, err)
+	}
+	aggJSON, err := zc.QueryAggregatedProof(ctx, "2")
+	if err != nil {
+		t.Fatalf("QueryAggregatedProof failed: %v", err)
+	}
+	var agg AggregatedProof
+	if err := json.Unmarshal([]byte(aggJSON), &agg); err != nil || len(agg.Roots) != 3 || agg.CircuitId != "chain" || agg.Proof != proof {
+		t.Errorf("unexpected aggregated proof %s: %v", aggJSON, err)
+	}
+
+	// The next block starts from the last root of the aggregated chain
+	if err := zc.CommitProof(ctx, "3", "step", root(3), root(5), step.prove(t, 3, 5)); err != nil {
+		t.Errorf("CommitProof after an aggregated proof failed: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// aggregate/aggregate.go
+
+// Package aggregate defines the circuit folding the batch proofs of a block into one proof, and
+// its public inputs. Like package rollup, it is shared by the operator, which proves it, and by
+// ZKContract, which checks the aggregated proofs on Layer 1.
+//
+// Batch proofs are Groth16 proofs of the rollup circuits over BLS12-377 (rollup.Curve), whose
+// base field is the scalar field of BW6-761, so the aggregation circuit verifies them over
+// BW6-761 with native arithmetic instead of field emulation. One aggregated proof replaces the
+// verification of every batch proof of a block on Layer 1.
+package aggregate
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/sw_bls12377"
+	"github.com/consensys/gnark/std/math/emulated"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// Curve is the curve the aggregation circuit is proven over, with Groth16.
+const Curve = ecc.BW6_761
+
+type (
+	innerFr = sw_bls12377.ScalarField
+	innerG1 = sw_bls12377.G1Affine
+	innerG2 = sw_bls12377.G2Affine
+	innerGT = sw_bls12377.GT
+)
+
+// Slot is one batch proof of the aggregation circuit, proving the transition OldRoot -> NewRoot
+// with the inner circuit of index Circuit. A disabled slot keeps the state root: it still holds
+// a valid proof, since every slot is verified, but its roots are not chained.
+type Slot struct {
+	Enabled frontend.Variable                  `gnark:"enabled"`
+	Circuit frontend.Variable                  `gnark:"circuit"`
+	OldRoot frontend.Variable                  `gnark:"oldRoot"`
+	NewRoot frontend.Variable                  `gnark:"newRoot"`
+	Proof   stdgroth16.Proof[innerG1, innerG2] `gnark:"proof"`
+}
+
+// Circuit verifies len(Slots) Groth16 proofs of the rollup circuits it was built for and chains
+// them: enabled slot i must prove the transition Roots[i] -> Roots[i+1], and a disabled one
+// must have Roots[i+1] = Roots[i]. All roots are public, so Layer 1 learns the state root after
+// every batch, like with ZKContract:CommitProofChain. Blocks with fewer batches than slots
+// disable the slots left.
+type Circuit struct {
+	Roots []frontend.Variable `gnark:"roots,public"`
+	Slots []Slot              `gnark:"slots"`
+
+	// The inner verifying keys are compiled into the circuit, so the outer verifying key only
+	// accepts aggregations of proofs of the inner circuits it was set up for
+	vks []stdgroth16.VerifyingKey[innerG1, innerG2, innerGT] `gnark:"-"`
+}
+
+// Batch is a proof of the inner circuit of index Circuit, proving the transition OldRoot ->
+// NewRoot.
+type Batch struct {
+	Circuit int
+	OldRoot *big.Int
+	NewRoot *big.Int
+	Proof   groth16.Proof
+}
+
+// Define implements the circuit constraints.
+func (c *Circuit) Define(api frontend.API) error {
+	if len(c.Roots) != len(c.Slots)+1 {
+		return fmt.Errorf("expected %d roots for %d slots, got %d", len(c.Slots)+1, len(c.Slots), len(c.Roots))
+	}
+	field, err := emulated.NewField[innerFr](api)
+	if err != nil {
+		return err
+	}
+	verifier, err := stdgroth16.NewVerifier[innerFr, innerG1, innerG2, innerGT](api)
+	if err != nil {
+		return err
+	}
+
+	// The inner public inputs are elements of the BLS12-377 scalar field. A root must be
+	// canonical, or a proof for x would also be accepted for the root x + r.
+	nbBits := rollup.Curve.ScalarField().BitLen()
+	innerRoot := func(root frontend.Variable) *emulated.Element[innerFr] {
+		e := field.FromBits(api.ToBinary(root, nbBits)...)
+		field.AssertIsInRange(e)
+		return e
+	}
+
+	for i, slot := range c.Slots {
+		api.AssertIsBoolean(slot.Enabled)
+
+		// Enabled: (OldRoot, NewRoot) = (Roots[i], Roots[i+1]); disabled: Roots[i+1] = Roots[i]
+		api.AssertIsEqual(api.Mul(slot.Enabled, api.Sub(slot.OldRoot, c.Roots[i])), 0)
+		api.AssertIsEqual(api.Select(slot.Enabled, slot.NewRoot, c.Roots[i]), c.Roots[i+1])
+
+		vk, err := verifier.SwitchVerificationKey(slot.Circuit, c.vks)
+		if err != nil {
+			return fmt.Errorf("slot %d: %w", i, err)
+		}
+		witness := stdgroth16.Witness[innerFr]{Public: []emulated.Element[innerFr]{*innerRoot(slot.OldRoot), *innerRoot(slot.NewRoot)}}
+		if err := verifier.AssertProof(vk, slot.Proof, witness); err != nil {
+			return fmt.Errorf("slot %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// NewCircuit returns the aggregation circuit of n slots verifying proofs of the rollup circuits
+// set up with the Groth16 verifying keys inner, ready to compile. Slots refer to the inner
+// circuits by their index in inner.
+func NewCircuit(inner []groth16.VerifyingKey, n int) (*Circuit, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("cannot aggregate %d proofs", n)
+	}
+	if len(inner) == 0 {
+		return nil, fmt.Errorf("no inner circuit to aggregate proofs of")
+	}
+
+	c := &Circuit{Roots: make([]frontend.Variable, n+1), Slots: make([]Slot, n)}
+	for i, innerVK := range inner {
+		vk, err := stdgroth16.ValueOfVerifyingKeyFixed[innerG1, innerG2, innerGT](innerVK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert the verifying key of inner circuit %d: %w", i, err)
+		}
+		// Proofs of circuits with commitments could only be checked if they were generated with
+		// the hash to field of the outer circuit; the rollup circuits have none
+		if len(vk.CommitmentKeys) != 0 {
+			return nil, fmt.Errorf("inner circuit %d has commitments, which aggregation does not support", i)
+		}
+		if nbPublic := len(vk.G1.K) - 1; nbPublic != 2 {
+			return nil, fmt.Errorf("inner circuit %d must have the public inputs (oldRoot, newRoot), got %d public inputs", i, nbPublic)
+		}
+		c.vks = append(c.vks, vk)
+	}
+	return c, nil
+}
+
+// Roots returns the public roots of the aggregation of batches into n slots: the roots the
+// batches chain the state through, repeated to fill the disabled slots.
+func Roots(batches []Batch, n int) ([]*big.Int, error) {
+	if len(batches) == 0 || len(batches) > n {
+		return nil, fmt.Errorf("cannot aggregate %d proofs in %d slots", len(batches), n)
+	}
+	roots := make([]*big.Int, n+1)
+	roots[0] = batches[0].OldRoot
+	for i := range roots[1:] {
+		roots[i+1] = roots[i]
+		if i < len(batches) {
+			roots[i+1] = batches[i].NewRoot
+		}
+	}
+	return roots, nil
+}
+
+// Assign returns the assignment of the aggregation circuit of n slots for batches, which must
+// chain the state root. The slots left are disabled and hold the proof of the first batch.
+func Assign(batches []Batch, n int) (*Circuit, error) {
+	roots, err := Roots(batches, n)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(batches); i++ {
+		if batches[i].OldRoot.Cmp(batches[i-1].NewRoot) != 0 {
+			return nil, fmt.Errorf("batch %d does not start from the root batch %d ends with", i, i-1)
+		}
+	}
+
+	c := &Circuit{Roots: make([]frontend.Variable, n+1), Slots: make([]Slot, n)}
+	for i, root := range roots {
+		c.Roots[i] = root
+	}
+	for i := range c.Slots {
+		batch, enabled := batches[0], 0
+		if i < len(batches) {
+			batch, enabled = batches[i], 1
+		}
+		proof, err := stdgroth16.ValueOfProof[innerG1, innerG2](batch.Proof)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert proof %d: %w", i, err)
+		}
+		c.Slots[i] = Slot{Enabled: enabled, Circuit: batch.Circuit, OldRoot: batch.OldRoot, NewRoot: batch.NewRoot, Proof: proof}
+	}
+	return c, nil
+}
+
+// PublicWitness returns the public witness of an aggregated proof chaining the state through
+// roots, the public inputs of the aggregation circuit of len(roots)-1 slots.
+func PublicWitness(roots []*big.Int) (witness.Witness, error) {
+	assignment := &Circuit{Roots: make([]frontend.Variable, len(roots))}
+	for i, root := range roots {
+		assignment.Roots[i] = root
+	}
+	w, err := frontend.NewWitness(assignment, Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public witness: %w", err)
+	}
+	return w, nil
+}
@@ -0,0 +1,192 @@
+// aggregate/aggregate_test.go
+
+package aggregate
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/rangecheck"
+	"github.com/consensys/gnark/test"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// stepCircuit proves that NewRoot is OldRoot plus a secret step. It has the public inputs of the
+// rollup circuits, and stands in for them as an inner circuit.
+type stepCircuit struct {
+	OldRoot frontend.Variable `gnark:",public"`
+	NewRoot frontend.Variable `gnark:",public"`
+	Step    frontend.Variable
+}
+
+func (c *stepCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Add(c.OldRoot, c.Step), c.NewRoot)
+	return nil
+}
+
+// rootCircuit has a single public input.
+type rootCircuit struct {
+	Root frontend.Variable `gnark:",public"`
+}
+
+func (c *rootCircuit) Define(api frontend.API) error {
+	api.AssertIsDifferent(c.Root, 0)
+	return nil
+}
+
+// committedCircuit has the public inputs of the rollup circuits, but range checks with a
+// commitment.
+type committedCircuit struct {
+	OldRoot frontend.Variable `gnark:",public"`
+	NewRoot frontend.Variable `gnark:",public"`
+}
+
+func (c *committedCircuit) Define(api frontend.API) error {
+	rangecheck.New(api).Check(api.Sub(c.NewRoot, c.OldRoot), 8)
+	return nil
+}
+
+// innerProver proves an inner circuit over rollup.Curve.
+type innerProver struct {
+	ccs constraint.ConstraintSystem
+	pk  groth16.ProvingKey
+	vk  groth16.VerifyingKey
+}
+
+func newInnerProver(t *testing.T, c frontend.Circuit) *innerProver {
+	t.Helper()
+	ccs, err := frontend.Compile(rollup.Curve.ScalarField(), r1cs.NewBuilder, c)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	return &innerProver{ccs: ccs, pk: pk, vk: vk}
+}
+
+// batch proves the transition oldRoot -> newRoot of stepCircuit, as inner circuit index.
+func (p *innerProver) batch(t *testing.T, index int, oldRoot, newRoot int64) Batch {
+	t.Helper()
+	w, err := frontend.NewWitness(&stepCircuit{OldRoot: oldRoot, NewRoot: newRoot, Step: newRoot - oldRoot}, rollup.Curve.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := groth16.Prove(p.ccs, p.pk, w)
+	if err != nil {
+		t.Fatalf("Prove failed: %v", err)
+	}
+	return Batch{Circuit: index, OldRoot: big.NewInt(oldRoot), NewRoot: big.NewInt(newRoot), Proof: proof}
+}
+
+// TestNewCircuitInnerCircuits checks that only circuits with the public inputs of the rollup
+// circuits and without commitments are aggregated.
+func TestNewCircuitInnerCircuits(t *testing.T) {
+	step := newInnerProver(t, &stepCircuit{})
+	if _, err := NewCircuit([]groth16.VerifyingKey{step.vk}, 0); err == nil {
+		t.Errorf("aggregation circuit of 0 slots created")
+	}
+	if _, err := NewCircuit(nil, 2); err == nil {
+		t.Errorf("aggregation circuit without inner circuits created")
+	}
+	for name, c := range map[string]frontend.Circuit{"one public input": &rootCircuit{}, "commitment": &committedCircuit{}} {
+		inner := newInnerProver(t, c)
+		if _, err := NewCircuit([]groth16.VerifyingKey{step.vk, inner.vk}, 2); err == nil || !strings.Contains(err.Error(), "inner circuit 1") {
+			t.Errorf("%s: NewCircuit returned %v, expected it to fail", name, err)
+		}
+	}
+	c, err := NewCircuit([]groth16.VerifyingKey{step.vk, step.vk}, 3)
+	if err != nil {
+		t.Fatalf("NewCircuit failed: %v", err)
+	}
+	if len(c.Roots) != 4 || len(c.Slots) != 3 || len(c.vks) != 2 {
+		t.Errorf("unexpected circuit of %d roots, %d slots and %d inner circuits", len(c.Roots), len(c.Slots), len(c.vks))
+	}
+}
+
+// TestRoots checks that the roots of the disabled slots repeat the last root of the chain.
+func TestRoots(t *testing.T) {
+	batches := []Batch{{OldRoot: big.NewInt(1), NewRoot: big.NewInt(2)}, {OldRoot: big.NewInt(2), NewRoot: big.NewInt(5)}}
+	roots, err := Roots(batches, 4)
+	if err != nil {
+		t.Fatalf("Roots failed: %v", err)
+	}
+	for i, want := range []int64{1, 2, 5, 5, 5} {
+		if roots[i].Int64() != want {
+			t.Errorf("root %d is %v, want %d", i, roots[i], want)
+		}
+	}
+	if _, err := Roots(batches, 1); err == nil {
+		t.Errorf("2 batches fit in 1 slot")
+	}
+	if _, err := Roots(nil, 1); err == nil {
+		t.Errorf("no batch aggregated")
+	}
+	if _, err := Assign([]Batch{batches[0], {OldRoot: big.NewInt(3), NewRoot: big.NewInt(5)}}, 2); err == nil {
+		t.Errorf("batches not chaining the state root assigned")
+	}
+}
+
+// TestPublicWitness checks that the public inputs of an aggregated proof are the roots, in order.
+func TestPublicWitness(t *testing.T) {
+	w, err := PublicWitness([]*big.Int{big.NewInt(7), big.NewInt(8), big.NewInt(9)})
+	if err != nil {
+		t.Fatalf("PublicWitness failed: %v", err)
+	}
+	vector := w.Vector().(fr.Vector)
+	if len(vector) != 3 {
+		t.Fatalf("expected 3 public inputs, got %d", len(vector))
+	}
+	for i, e := range vector {
+		if e.Uint64() != uint64(7+i) {
+			t.Errorf("public input %d is %s, want %d", i, e.String(), 7+i)
+		}
+	}
+}
+
+// TestCircuit checks that the aggregation circuit accepts proofs of both inner circuits chaining
+// the roots, with the slots left disabled, and rejects any other chain.
+func TestCircuit(t *testing.T) {
+	a, b := newInnerProver(t, &stepCircuit{}), newInnerProver(t, &stepCircuit{})
+	c, err := NewCircuit([]groth16.VerifyingKey{a.vk, b.vk}, 3)
+	if err != nil {
+		t.Fatalf("NewCircuit failed: %v", err)
+	}
+	batches := []Batch{a.batch(t, 0, 1, 2), b.batch(t, 1, 2, 4)}
+
+	valid, err := Assign(batches, 3)
+	if err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+	if err := test.IsSolved(c, valid, Curve.ScalarField()); err != nil {
+		t.Errorf("valid aggregation not solved: %v", err)
+	}
+
+	// A proof verified with the key of the other inner circuit
+	swapped, _ := Assign([]Batch{{Circuit: 1, OldRoot: batches[0].OldRoot, NewRoot: batches[0].NewRoot, Proof: batches[0].Proof}, batches[1]}, 3)
+	if test.IsSolved(c, swapped, Curve.ScalarField()) == nil {
+		t.Errorf("proof of inner circuit 0 accepted as a proof of inner circuit 1")
+	}
+
+	// A root the proofs do not chain through
+	forged, _ := Assign(batches, 3)
+	forged.Roots[1] = big.NewInt(3)
+	if test.IsSolved(c, forged, Curve.ScalarField()) == nil {
+		t.Errorf("aggregation with a forged intermediate root accepted")
+	}
+
+	// A disabled slot that changes the root
+	skipped, _ := Assign(batches, 3)
+	skipped.Roots[3] = big.NewInt(5)
+	if test.IsSolved(c, skipped, Curve.ScalarField()) == nil {
+		t.Errorf("disabled slot changing the root accepted")
+	}
+}
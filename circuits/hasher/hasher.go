@@ -0,0 +1,102 @@
+// hasher/hasher.go
+
+// Package hasher provides the hash functions of the rollup state. The same Hasher builds the
+// Merkle tree off-circuit (package merkle) and recomputes it in-circuit, so a root computed by
+// the operator is the root the circuit proves. Both hashers work on field elements of
+// the scalar field of BLS12-377, the curve the rollup circuits are proven over.
+package hasher
+
+import (
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	gcMiMC "github.com/consensys/gnark-crypto/ecc/bls12-377/fr/mimc"
+	gcPoseidon2 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr/poseidon2"
+	"github.com/consensys/gnark/frontend"
+	stdHash "github.com/consensys/gnark/std/hash"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/hash/poseidon2"
+)
+
+// Hasher is a hash function over field elements, available both off-circuit and in-circuit.
+type Hasher struct {
+	name       string
+	newHash    func() hash.Hash
+	newCircuit func(api frontend.API) (stdHash.FieldHasher, error)
+}
+
+// Supported hashers.
+var (
+	// MiMC is MiMC_BLS12_377, the default hash of the rollup state.
+	MiMC = &Hasher{
+		name:       "mimc",
+		newHash:    func() hash.Hash { return gcMiMC.NewMiMC() },
+		newCircuit: mimc.New,
+	}
+	// Poseidon2 is Poseidon2 over BLS12-377 in Merkle-Damgard mode, with gnark's default parameters.
+	Poseidon2 = &Hasher{
+		name:       "poseidon2",
+		newHash:    func() hash.Hash { return gcPoseidon2.NewMerkleDamgardHasher() },
+		newCircuit: poseidon2.NewMerkleDamgardHasher,
+	}
+)
+
+// All lists the supported hashers.
+var All = []*Hasher{MiMC, Poseidon2}
+
+// ByName returns the hasher called name ("mimc" or "poseidon2").
+func ByName(name string) (*Hasher, error) {
+	for _, h := range All {
+		if h.name == name {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown hasher %q (expected %q or %q)", name, MiMC.name, Poseidon2.name)
+}
+
+// Name returns the name of the hash function, as accepted by ByName.
+func (h *Hasher) Name() string {
+	return h.name
+}
+
+// New returns an off-circuit hasher. Field elements must be written as 32-byte big-endian
+// values to match the in-circuit hasher.
+func (h *Hasher) New() hash.Hash {
+	return h.newHash()
+}
+
+// NewCircuit returns an in-circuit hasher.
+func (h *Hasher) NewCircuit(api frontend.API) (stdHash.FieldHasher, error) {
+	return h.newCircuit(api)
+}
+
+// Hash hashes fields off-circuit and returns the digest as a field element. It matches
+// writing the same fields to NewCircuit.
+func (h *Hasher) Hash(fields ...*big.Int) *big.Int {
+	hasher := h.newHash()
+	for _, field := range fields {
+		// Convert each field to fr.Element and then to bytes
+		var fieldFr fr.Element
+		fieldFr.SetBigInt(field)
+		fieldBytes := fieldFr.Bytes()
+		_, _ = hasher.Write(fieldBytes[:])
+	}
+
+	var outFr fr.Element
+	outFr.SetBytes(hasher.Sum(nil))
+	res := new(big.Int)
+	outFr.BigInt(res)
+	return res
+}
+
+// HashCircuit hashes fields in-circuit.
+func (h *Hasher) HashCircuit(api frontend.API, fields ...frontend.Variable) (frontend.Variable, error) {
+	hasher, err := h.newCircuit(api)
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(fields...)
+	return hasher.Sum(), nil
+}
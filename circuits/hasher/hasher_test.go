@@ -0,0 +1,73 @@
+// hasher/hasher_test.go
+
+package hasher
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// hashCircuit checks that Inputs hash to Digest in-circuit.
+type hashCircuit struct {
+	hasher *Hasher
+	Inputs []frontend.Variable
+	Digest frontend.Variable `gnark:",public"`
+}
+
+func (c *hashCircuit) Define(api frontend.API) error {
+	digest, err := c.hasher.HashCircuit(api, c.Inputs...)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(digest, c.Digest)
+	return nil
+}
+
+// TestOffCircuitMatchesInCircuit checks that Hash and HashCircuit agree for every hasher, on the
+// input lengths the rollup uses: Merkle nodes (2), signed messages (3) and account leaves (5).
+func TestOffCircuitMatchesInCircuit(t *testing.T) {
+	for _, h := range All {
+		t.Run(h.Name(), func(t *testing.T) {
+			for _, n := range []int{1, 2, 3, 5} {
+				fields := make([]*big.Int, n)
+				inputs := make([]frontend.Variable, n)
+				for i := range fields {
+					fields[i] = big.NewInt(int64(1000*n + i))
+					inputs[i] = fields[i]
+				}
+				digest := h.Hash(fields...)
+
+				circuit := hashCircuit{hasher: h, Inputs: make([]frontend.Variable, n)}
+				assignment := hashCircuit{hasher: h, Inputs: inputs, Digest: digest}
+				if err := test.IsSolved(&circuit, &assignment, ecc.BLS12_377.ScalarField()); err != nil {
+					t.Errorf("%d inputs: off-circuit digest not matched in-circuit: %v", n, err)
+				}
+
+				assignment.Digest = new(big.Int).Add(digest, big.NewInt(1))
+				if test.IsSolved(&circuit, &assignment, ecc.BLS12_377.ScalarField()) == nil {
+					t.Errorf("%d inputs: wrong digest accepted", n)
+				}
+			}
+		})
+	}
+
+	if MiMC.Hash(big.NewInt(1), big.NewInt(2)).Cmp(Poseidon2.Hash(big.NewInt(1), big.NewInt(2))) == 0 {
+		t.Errorf("MiMC and Poseidon2 digests must differ")
+	}
+}
+
+func TestByName(t *testing.T) {
+	for _, h := range All {
+		got, err := ByName(h.Name())
+		if err != nil || got != h {
+			t.Errorf("ByName(%q) = %v, %v", h.Name(), got, err)
+		}
+	}
+	if _, err := ByName("sha256"); err == nil {
+		t.Errorf("unknown hasher accepted")
+	}
+}
@@ -0,0 +1,78 @@
+// rollup/keys.go
+
+package rollup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	tedwards "github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards"
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+)
+
+// EncodePublicKey returns the twisted Edwards public key (x, y) as players register it with
+// CurrencyContract:CreatePlayer: the compressed point, base64.
+func EncodePublicKey(x, y *big.Int) string {
+	var p tedwards.PointAffine
+	p.X.SetBigInt(x)
+	p.Y.SetBigInt(y)
+	b := p.Bytes()
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// DecodePublicKey returns the coordinates of a public key encoded by EncodePublicKey, as
+// account leaves bind them. Only points of the prime-order subgroup other than the identity
+// are keys: the leaf of an account without a key binds (0, 0), which is not on the curve.
+func DecodePublicKey(pubKeyBase64 string) (*big.Int, *big.Int, error) {
+	b, err := base64.StdEncoding.DecodeString(pubKeyBase64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(b) != fr.Bytes {
+		return nil, nil, fmt.Errorf("invalid public key: expected %d bytes, got %d", fr.Bytes, len(b))
+	}
+	var p tedwards.PointAffine
+	if _, err := p.SetBytes(b); err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	order := tedwards.GetEdwardsCurve().Order
+	var q tedwards.PointAffine
+	if p.IsZero() || !q.ScalarMultiplication(&p, &order).IsZero() {
+		return nil, nil, fmt.Errorf("invalid public key: not a point of the prime-order subgroup")
+	}
+	x, y := new(big.Int), new(big.Int)
+	p.X.BigInt(x)
+	p.Y.BigInt(y)
+	return x, y, nil
+}
+
+// EncodeSignature returns sig as players hand it to CurrencyContract with their state changes:
+// the compressed R point followed by S, base64.
+func EncodeSignature(sig *Signature) string {
+	var s eddsa.Signature
+	s.R.X.SetBigInt(sig.RX)
+	s.R.Y.SetBigInt(sig.RY)
+	sig.S.FillBytes(s.S[:])
+	return base64.StdEncoding.EncodeToString(s.Bytes())
+}
+
+// DecodeSignature returns the signature encoded by EncodeSignature.
+func DecodeSignature(sigBase64 string) (*Signature, error) {
+	b, err := base64.StdEncoding.DecodeString(sigBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	if len(b) != 2*fr.Bytes {
+		return nil, fmt.Errorf("invalid signature: expected %d bytes, got %d", 2*fr.Bytes, len(b))
+	}
+	var s eddsa.Signature
+	if _, err := s.SetBytes(b); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	sig := &Signature{RX: new(big.Int), RY: new(big.Int), S: new(big.Int).SetBytes(s.S[:])}
+	s.R.X.BigInt(sig.RX)
+	s.R.Y.BigInt(sig.RY)
+	return sig, nil
+}
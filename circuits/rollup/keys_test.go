@@ -0,0 +1,83 @@
+// rollup/keys_test.go
+
+package rollup
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	tedwards "github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards"
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+)
+
+// TestPublicKeyEncoding checks that registered keys decode to the coordinates of the key and
+// that points which are not keys are rejected.
+func TestPublicKeyEncoding(t *testing.T) {
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	x, y := new(big.Int), new(big.Int)
+	key.PublicKey.A.X.BigInt(x)
+	key.PublicKey.A.Y.BigInt(y)
+
+	encoded := EncodePublicKey(x, y)
+	if compressed := key.PublicKey.Bytes(); encoded != base64.StdEncoding.EncodeToString(compressed) {
+		t.Errorf("EncodePublicKey differs from the compressed key")
+	}
+	gotX, gotY, err := DecodePublicKey(encoded)
+	if err != nil {
+		t.Fatalf("DecodePublicKey failed: %v", err)
+	}
+	if gotX.Cmp(x) != 0 || gotY.Cmp(y) != 0 {
+		t.Errorf("DecodePublicKey returned (%v, %v), expected (%v, %v)", gotX, gotY, x, y)
+	}
+
+	var identity tedwards.PointAffine
+	identity.Y.SetOne()
+	identityBytes := identity.Bytes()
+	for name, pubKey := range map[string]string{
+		"not base64":  "not base64",
+		"short":       base64.StdEncoding.EncodeToString([]byte("short")),
+		"identity":    base64.StdEncoding.EncodeToString(identityBytes[:]),
+		"small order": base64.StdEncoding.EncodeToString(make([]byte, 32)), // y = 0 has order 4
+	} {
+		if _, _, err := DecodePublicKey(pubKey); err == nil {
+			t.Errorf("%s: DecodePublicKey accepted %q", name, pubKey)
+		}
+	}
+}
+
+// TestSignatureEncoding checks that encoded signatures decode to the signature and still verify,
+// and that malformed ones are rejected.
+func TestSignatureEncoding(t *testing.T) {
+	key := newTestKey(t)
+	x, y := PublicKey(key)
+	name, benChange, nonce := big.NewInt(4), big.NewInt(-50), big.NewInt(3)
+	sig, err := Sign(key, name, benChange, nonce)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	decoded, err := DecodeSignature(EncodeSignature(sig))
+	if err != nil {
+		t.Fatalf("DecodeSignature failed: %v", err)
+	}
+	if decoded.RX.Cmp(sig.RX) != 0 || decoded.RY.Cmp(sig.RY) != 0 || decoded.S.Cmp(sig.S) != 0 {
+		t.Errorf("DecodeSignature returned %+v, expected %+v", decoded, sig)
+	}
+	if !Verify(x, y, decoded, name, benChange, nonce) {
+		t.Errorf("decoded signature does not verify")
+	}
+
+	for name, encoded := range map[string]string{
+		"not base64": "not base64",
+		"short":      base64.StdEncoding.EncodeToString([]byte("short")),
+	} {
+		if _, err := DecodeSignature(encoded); err == nil {
+			t.Errorf("%s: DecodeSignature accepted %q", name, encoded)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// rollup/rollup.go
+
+// Package rollup defines the circuits of the ZK-Rollup and their public inputs. It is the
+// single definition shared by the operator, which proves the circuits, and by ZKContract,
+// which checks proofs of them on Layer 1.
+package rollup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/signature/eddsa"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+)
+
+// Curve is the curve the rollup circuits are proven over, whatever the proof backend.
+const Curve = ecc.BLS12_377
+
+// Default sizes of the rollup circuits, which are sized at construction time (see
+// NewProofMerkleCircuit and NewTransferCircuit); the operator proves with these
+const (
+	D2 = 10 // ProofMerkleCircuit: Number of Leaves would be 2^10 = 1024
+	B2 = 32 // Number of transactions in the batch
+
+	T2 = 16 // TransferCircuit: Number of transfers in the batch (each updates two leaves)
+
+	BalanceBits = 64 // New balances must be non-negative and fit in BalanceBits bits
+)
+
+// PublicWitness returns the public witness of a state transition from oldRoot to newRoot, the
+// public inputs of both ProofMerkleCircuit and TransferCircuit.
+func PublicWitness(oldRoot, newRoot *big.Int) (witness.Witness, error) {
+	assignment := &ProofMerkleCircuit{OldRoot: oldRoot, NewRoot: newRoot}
+	w, err := frontend.NewWitness(assignment, Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public witness: %w", err)
+	}
+	return w, nil
+}
+
+// Fingerprint identifies a compiled constraint system: the hex SHA-256 of its serialization.
+// Two builds of a circuit have the same fingerprint exactly when they compile to the same
+// constraints, so it tells whether a verifying key was set up for the circuit at hand.
+func Fingerprint(ccs constraint.ConstraintSystem) (string, error) {
+	h := sha256.New()
+	if _, err := ccs.WriteTo(h); err != nil {
+		return "", fmt.Errorf("failed to serialize constraint system: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Transaction is one slot of ProofMerkleCircuit: the update of an account leaf by BenChange.
+type Transaction struct {
+	OldName    frontend.Variable   `gnark:"oldName"`
+	OldBalance frontend.Variable   `gnark:"oldBalance"`
+	NewName    frontend.Variable   `gnark:"newName"`
+	BenChange  frontend.Variable   `gnark:"benChange"` // Changed from NewBalance
+	Siblings   []frontend.Variable `gnark:"siblings"`
+	PathBits   []frontend.Variable `gnark:"pathBits"`
+	Enabled    frontend.Variable   `gnark:"enabled"` // 1 for a real transaction, 0 for an unused slot
+
+	// Authorization: the old leaf binds (OldPubKeyX, OldPubKeyY) and Nonce; the new leaf
+	// binds PubKey and Nonce+1, and Signature must be PubKey's signature on
+	// MiMC(NewName, BenChange, Nonce). The leaf of a registered account (one with a key) keeps
+	// its key and name. A leaf without a key, (0, 0), is a free slot: it must hold no balance,
+	// and its first change registers a player there with the key that signed it.
+	OldPubKeyX frontend.Variable `gnark:"oldPubKeyX"`
+	OldPubKeyY frontend.Variable `gnark:"oldPubKeyY"`
+	Nonce      frontend.Variable `gnark:"nonce"`
+	PubKey     eddsa.PublicKey   `gnark:"pubKey"`
+	Signature  eddsa.Signature   `gnark:"signature"`
+}
+
+// ProofMerkleCircuit verifies a batch of transactions updating a Merkle tree sequentially.
+// Its depth and batch size are fixed by NewProofMerkleCircuit; the zero value has no slots and
+// only serves as a public assignment.
+type ProofMerkleCircuit struct {
+	// Hash function of the Merkle tree and its leaves, MiMC if nil. It is part of the compiled
+	// circuit, not of the witness. Signed messages are always hashed with MiMC.
+	Hasher *hasher.Hasher `gnark:"-"`
+
+	// Public inputs
+	OldRoot frontend.Variable `gnark:"oldRoot,public"`
+	NewRoot frontend.Variable `gnark:"newRoot,public"`
+
+	// Private inputs: one slot per transaction of the batch
+	Transactions []Transaction
+}
+
+// NewProofMerkleCircuit returns a ProofMerkleCircuit for batches of batch transactions on a
+// Merkle tree of the given depth (2^depth leaves). It serves both as the circuit to compile and
+// as the assignment to fill.
+func NewProofMerkleCircuit(depth, batch int) *ProofMerkleCircuit {
+	c := &ProofMerkleCircuit{Transactions: make([]Transaction, batch)}
+	for k := range c.Transactions {
+		c.Transactions[k].Siblings = make([]frontend.Variable, depth)
+		c.Transactions[k].PathBits = make([]frontend.Variable, depth)
+	}
+	return c
+}
+
+// Depth returns the depth of the Merkle tree c is sized for.
+func (c *ProofMerkleCircuit) Depth() int {
+	if len(c.Transactions) == 0 {
+		return 0
+	}
+	return len(c.Transactions[0].Siblings)
+}
+
+// Define implements the circuit constraints.
+// Disabled transactions leave the running root untouched, so unused slots can be filled with
+// zeros instead of valid no-op updates. The constraints of every slot are still generated (the
+// constraint system is fixed at compile time); only the witness of a disabled slot is free.
+func (c *ProofMerkleCircuit) Define(api frontend.API) error {
+	curve, err := twistededwards.NewEdCurve(api, tedwards.BLS12_377)
+	if err != nil {
+		return err
+	}
+	if len(c.Transactions) == 0 {
+		return fmt.Errorf("ProofMerkleCircuit has no transaction slots, build it with NewProofMerkleCircuit")
+	}
+	h := orMiMC(c.Hasher)
+	depth := c.Depth()
+	currentRoot := c.OldRoot
+
+	for k, tx := range c.Transactions {
+		if len(tx.Siblings) != depth || len(tx.PathBits) != depth {
+			return fmt.Errorf("transaction %d: expected a Merkle proof of depth %d", k, depth)
+		}
+
+		// Verify the account owner's signature on the state change. Verification cannot be
+		// switched off, so disabled slots carry a valid padding signature.
+		mimcMsg, err := mimc.NewMiMC(api)
+		if err != nil {
+			return err
+		}
+		mimcMsg.Write(tx.NewName, tx.BenChange, tx.Nonce)
+		msg := mimcMsg.Sum()
+		mimcSig, err := mimc.NewMiMC(api)
+		if err != nil {
+			return err
+		}
+		if err := eddsa.Verify(curve, tx.Signature, msg, tx.PubKey, &mimcSig); err != nil {
+			return err
+		}
+
+		// A registered account keeps its key and name, whatever its nonce; a free slot holds
+		// nothing, so registering a player there takes no one's balance
+		bound := api.Mul(tx.Enabled, registered(api, tx.OldPubKeyX, tx.OldPubKeyY))
+		api.AssertIsEqual(api.Mul(bound, api.Sub(tx.OldPubKeyX, tx.PubKey.A.X)), 0)
+		api.AssertIsEqual(api.Mul(bound, api.Sub(tx.OldPubKeyY, tx.PubKey.A.Y)), 0)
+		api.AssertIsEqual(api.Mul(bound, api.Sub(tx.NewName, tx.OldName)), 0)
+		api.AssertIsEqual(api.Mul(api.Sub(tx.Enabled, bound), tx.OldBalance), 0)
+
+		// Compute old leaf hash: H_old_k = H(OldName, OldBalance, OldPubKeyX, OldPubKeyY, Nonce)
+		H_old_k, err := accountLeaf(api, h, tx.OldName, tx.OldBalance, tx.OldPubKeyX, tx.OldPubKeyY, tx.Nonce)
+		if err != nil {
+			return err
+		}
+
+		// Compute old root from H_old_k and Merkle proof
+		ComputedOldRoot_k, err := merkleRoot(api, h, H_old_k, tx.Siblings, tx.PathBits)
+		if err != nil {
+			return err
+		}
+
+		// Calculate NewBalance inside the circuit by adding OldBalance and BenChange
+		NewBalance := api.Add(tx.OldBalance, tx.BenChange)
+
+		// Range check NewBalance to 64 bits, so an overdraft cannot wrap around the field
+		// to a huge balance (disabled slots are checked as zero)
+		api.ToBinary(api.Select(tx.Enabled, NewBalance, 0), BalanceBits)
+
+		// Compute new leaf hash: H_new_k = H(NewName, NewBalance, PubKey, Nonce+1)
+		H_new_k, err := accountLeaf(api, h, tx.NewName, NewBalance, tx.PubKey.A.X, tx.PubKey.A.Y, api.Add(tx.Nonce, 1))
+		if err != nil {
+			return err
+		}
+
+		// Compute new root from H_new_k and the same Merkle proof
+		ComputedNewRoot_k, err := merkleRoot(api, h, H_new_k, tx.Siblings, tx.PathBits)
+		if err != nil {
+			return err
+		}
+
+		// Chain the roots: an enabled transaction must start from the current root (OldRoot for
+		// the first one) and moves it to its new root; a disabled one is not checked at all
+		api.AssertIsBoolean(tx.Enabled)
+		api.AssertIsEqual(api.Select(tx.Enabled, ComputedOldRoot_k, currentRoot), currentRoot)
+		currentRoot = api.Select(tx.Enabled, ComputedNewRoot_k, currentRoot)
+
+		// Ensure PathBits are boolean (0 or 1)
+		for i := range tx.PathBits {
+			api.AssertIsBoolean(tx.PathBits[i])
+		}
+	}
+
+	// Verify the final root matches NewRoot
+	api.AssertIsEqual(currentRoot, c.NewRoot)
+
+	return nil
+}
+
+// TransferLeaf is an account leaf touched by a transfer, with its Merkle proof against the
+// root the leaf is updated in.
+type TransferLeaf struct {
+	Name     frontend.Variable   `gnark:"name"`
+	Balance  frontend.Variable   `gnark:"balance"`
+	PubKeyX  frontend.Variable   `gnark:"pubKeyX"`
+	PubKeyY  frontend.Variable   `gnark:"pubKeyY"`
+	Nonce    frontend.Variable   `gnark:"nonce"`
+	Siblings []frontend.Variable `gnark:"siblings"`
+	PathBits []frontend.Variable `gnark:"pathBits"`
+}
+
+// NewTransferLeaf returns a TransferLeaf with room for a Merkle proof of the given depth.
+func NewTransferLeaf(depth int) TransferLeaf {
+	return TransferLeaf{
+		Siblings: make([]frontend.Variable, depth),
+		PathBits: make([]frontend.Variable, depth),
+	}
+}
+
+// Transfer is one slot of TransferCircuit: Amount moved from the From leaf to the To leaf.
+type Transfer struct {
+	Amount    frontend.Variable `gnark:"amount"`
+	Enabled   frontend.Variable `gnark:"enabled"` // 1 for a real transfer, 0 for an unused slot
+	From      TransferLeaf      `gnark:"from"`    // Sender leaf before the transfer
+	To        TransferLeaf      `gnark:"to"`      // Receiver leaf after the debit, before the credit
+	PubKey    eddsa.PublicKey   `gnark:"pubKey"`  // Sender key, the one bound by the sender's leaf
+	Signature eddsa.Signature   `gnark:"signature"`
+}
+
+// TransferCircuit verifies a batch of transfers between accounts of the Merkle tree.
+// Each enabled transfer debits Amount from the From leaf and credits it to the To leaf:
+//
+//  1. From is proven against the current root, and its update gives an intermediate root
+//  2. To is proven against the intermediate root, and its update gives the next root
+//  3. Amount, the sender's new balance and the receiver's new balance fit in BalanceBits,
+//     so the sender holds at least Amount and no balance can wrap around the field
+//  4. The sender signs MiMC(From.Name, To.Name, Amount, From.Nonce) with the key its leaf
+//     binds; both leaves must be registered accounts (see Transaction)
+//
+// Value is conserved by construction: the sender's new leaf is computed with its balance minus
+// Amount and the receiver's with its balance plus the same Amount, and the range checks keep
+// either from wrapping around the field. Like ProofMerkleCircuit, it is sized at construction
+// time by NewTransferCircuit.
+type TransferCircuit struct {
+	// Hash function of the Merkle tree and its leaves, as in ProofMerkleCircuit
+	Hasher *hasher.Hasher `gnark:"-"`
+
+	// Public inputs
+	OldRoot frontend.Variable `gnark:"oldRoot,public"`
+	NewRoot frontend.Variable `gnark:"newRoot,public"`
+
+	// Private inputs: one slot per transfer of the batch
+	Transfers []Transfer
+}
+
+// NewTransferCircuit returns a TransferCircuit for batches of batch transfers on a Merkle tree
+// of the given depth.
+func NewTransferCircuit(depth, batch int) *TransferCircuit {
+	c := &TransferCircuit{Transfers: make([]Transfer, batch)}
+	for k := range c.Transfers {
+		c.Transfers[k].From = NewTransferLeaf(depth)
+		c.Transfers[k].To = NewTransferLeaf(depth)
+	}
+	return c
+}
+
+// Define implements the circuit constraints.
+// Disabled transfers leave the running root untouched, as in ProofMerkleCircuit.
+func (c *TransferCircuit) Define(api frontend.API) error {
+	curve, err := twistededwards.NewEdCurve(api, tedwards.BLS12_377)
+	if err != nil {
+		return err
+	}
+	if len(c.Transfers) == 0 {
+		return fmt.Errorf("TransferCircuit has no transfer slots, build it with NewTransferCircuit")
+	}
+	h := orMiMC(c.Hasher)
+	currentRoot := c.OldRoot
+
+	for _, tx := range c.Transfers {
+		api.AssertIsBoolean(tx.Enabled)
+
+		// Verify the sender's signature; disabled slots carry the padding signature
+		mimcMsg, err := mimc.NewMiMC(api)
+		if err != nil {
+			return err
+		}
+		mimcMsg.Write(tx.From.Name, tx.To.Name, tx.Amount, tx.From.Nonce)
+		msg := mimcMsg.Sum()
+		mimcSig, err := mimc.NewMiMC(api)
+		if err != nil {
+			return err
+		}
+		if err := eddsa.Verify(curve, tx.Signature, msg, tx.PubKey, &mimcSig); err != nil {
+			return err
+		}
+
+		// The sender signs with the key of its leaf. Free slots cannot take part: a transfer
+		// would register the sender's slot without a signature of the player, and credit a
+		// slot that must hold no balance
+		api.AssertIsEqual(api.Mul(tx.Enabled, api.Sub(tx.From.PubKeyX, tx.PubKey.A.X)), 0)
+		api.AssertIsEqual(api.Mul(tx.Enabled, api.Sub(tx.From.PubKeyY, tx.PubKey.A.Y)), 0)
+		api.AssertIsEqual(api.Mul(tx.Enabled, api.Sub(1, registered(api, tx.From.PubKeyX, tx.From.PubKeyY))), 0)
+		api.AssertIsEqual(api.Mul(tx.Enabled, api.Sub(1, registered(api, tx.To.PubKeyX, tx.To.PubKeyY))), 0)
+
+		// Range check the amount and both new balances (disabled slots are checked as zero)
+		amount := api.Select(tx.Enabled, tx.Amount, 0)
+		fromBalance := api.Sub(tx.From.Balance, tx.Amount)
+		toBalance := api.Add(tx.To.Balance, tx.Amount)
+		api.ToBinary(amount, BalanceBits)
+		api.ToBinary(api.Select(tx.Enabled, fromBalance, 0), BalanceBits)
+		api.ToBinary(api.Select(tx.Enabled, toBalance, 0), BalanceBits)
+
+		// Debit the sender: its new leaf keeps its key and binds Nonce+1
+		fromOld, err := accountLeaf(api, h, tx.From.Name, tx.From.Balance, tx.From.PubKeyX, tx.From.PubKeyY, tx.From.Nonce)
+		if err != nil {
+			return err
+		}
+		fromNew, err := accountLeaf(api, h, tx.From.Name, fromBalance, tx.PubKey.A.X, tx.PubKey.A.Y, api.Add(tx.From.Nonce, 1))
+		if err != nil {
+			return err
+		}
+		fromOldRoot, err := rootFromProof(api, h, fromOld, tx.From.Siblings, tx.From.PathBits)
+		if err != nil {
+			return err
+		}
+		debitedRoot, err := rootFromProof(api, h, fromNew, tx.From.Siblings, tx.From.PathBits)
+		if err != nil {
+			return err
+		}
+
+		// Credit the receiver: its key and nonce are unchanged
+		toOld, err := accountLeaf(api, h, tx.To.Name, tx.To.Balance, tx.To.PubKeyX, tx.To.PubKeyY, tx.To.Nonce)
+		if err != nil {
+			return err
+		}
+		toNew, err := accountLeaf(api, h, tx.To.Name, toBalance, tx.To.PubKeyX, tx.To.PubKeyY, tx.To.Nonce)
+		if err != nil {
+			return err
+		}
+		toOldRoot, err := rootFromProof(api, h, toOld, tx.To.Siblings, tx.To.PathBits)
+		if err != nil {
+			return err
+		}
+		creditedRoot, err := rootFromProof(api, h, toNew, tx.To.Siblings, tx.To.PathBits)
+		if err != nil {
+			return err
+		}
+
+		// Chain the roots through the debit and the credit of enabled transfers
+		api.AssertIsEqual(api.Select(tx.Enabled, fromOldRoot, currentRoot), currentRoot)
+		api.AssertIsEqual(api.Select(tx.Enabled, toOldRoot, debitedRoot), debitedRoot)
+		currentRoot = api.Select(tx.Enabled, creditedRoot, currentRoot)
+	}
+
+	// Verify the final root matches NewRoot
+	api.AssertIsEqual(currentRoot, c.NewRoot)
+
+	return nil
+}
+
+// orMiMC returns h, or MiMC when the circuit does not set a hasher.
+func orMiMC(h *hasher.Hasher) *hasher.Hasher {
+	if h == nil {
+		return hasher.MiMC
+	}
+	return h
+}
+
+// registered returns 1 if the public key (pubKeyX, pubKeyY) of a leaf is set, 0 for the (0, 0)
+// of a free slot, which is not a point of the curve.
+func registered(api frontend.API, pubKeyX, pubKeyY frontend.Variable) frontend.Variable {
+	return api.Sub(1, api.Mul(api.IsZero(pubKeyX), api.IsZero(pubKeyY)))
+}
+
+// accountLeaf computes the hash of an account leaf: H(name, balance, pubKeyX, pubKeyY, nonce).
+func accountLeaf(api frontend.API, h *hasher.Hasher, name, balance, pubKeyX, pubKeyY, nonce frontend.Variable) (frontend.Variable, error) {
+	return h.HashCircuit(api, name, balance, pubKeyX, pubKeyY, nonce)
+}
+
+// merkleRoot computes the root of a Merkle tree from a leaf hash and its proof, whose length
+// is the depth of the tree.
+func merkleRoot(api frontend.API, h *hasher.Hasher, leaf frontend.Variable, siblings, pathBits []frontend.Variable) (frontend.Variable, error) {
+	if len(siblings) != len(pathBits) {
+		return nil, fmt.Errorf("Merkle proof has %d siblings but %d path bits", len(siblings), len(pathBits))
+	}
+	currentHash := leaf
+	for i := range siblings {
+		left := api.Select(pathBits[i], currentHash, siblings[i])
+		right := api.Select(pathBits[i], siblings[i], currentHash)
+		node, err := h.HashCircuit(api, left, right)
+		if err != nil {
+			return nil, err
+		}
+		currentHash = node
+	}
+	return currentHash, nil
+}
+
+// rootFromProof computes the root of a Merkle tree from a leaf hash and its proof, and
+// constrains the path bits to be boolean.
+func rootFromProof(api frontend.API, h *hasher.Hasher, leaf frontend.Variable, siblings, pathBits []frontend.Variable) (frontend.Variable, error) {
+	for i := range pathBits {
+		api.AssertIsBoolean(pathBits[i])
+	}
+	return merkleRoot(api, h, leaf, siblings, pathBits)
+}
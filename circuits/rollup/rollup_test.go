@@ -0,0 +1,893 @@
+// rollup/rollup_test.go
+
+package rollup
+
+// Tests of the rollup circuits against an off-circuit state built the way the operator builds
+// it: account leaves in a Merkle tree hashed with the circuit's hasher, changes signed with the
+// players' keys.
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+)
+
+// account is an account leaf of the test state.
+type account struct {
+	Name    *big.Int
+	Ben     *big.Int
+	PubKeyX *big.Int // (0, 0) for a free slot
+	PubKeyY *big.Int
+	Nonce   *big.Int
+}
+
+// registeredAccount returns the leaf of player name holding ben, bound to (pubKeyX, pubKeyY).
+func registeredAccount(name, ben, pubKeyX, pubKeyY *big.Int) account {
+	return account{Name: name, Ben: ben, PubKeyX: pubKeyX, PubKeyY: pubKeyY, Nonce: big.NewInt(0)}
+}
+
+// freeAccount returns a leaf holding ben for name with no key bound: a free slot.
+func freeAccount(name, ben *big.Int) account {
+	return registeredAccount(name, ben, big.NewInt(0), big.NewInt(0))
+}
+
+// treeLevels hashes users into leaves with h and returns every level of their Merkle tree, from
+// the leaves up to the root. The number of users must be a power of two.
+func treeLevels(h *hasher.Hasher, users []account) [][]*big.Int {
+	level := make([]*big.Int, len(users))
+	for i, u := range users {
+		level[i] = h.Hash(u.Name, u.Ben, u.PubKeyX, u.PubKeyY, u.Nonce)
+	}
+	levels := [][]*big.Int{level}
+	for len(level) > 1 {
+		next := make([]*big.Int, len(level)/2)
+		for i := range next {
+			next[i] = h.Hash(level[2*i], level[2*i+1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// stateRoot returns the Merkle root of users hashed with h.
+func stateRoot(h *hasher.Hasher, users []account) *big.Int {
+	levels := treeLevels(h, users)
+	return levels[len(levels)-1][0]
+}
+
+// testProof is the Merkle proof of a leaf. PathBits[i] is set when the path goes through the
+// left child at level i.
+type testProof struct {
+	Siblings []*big.Int
+	PathBits []bool
+}
+
+// merkleProof returns the Merkle proof of users[index] in the tree hashed with h.
+func merkleProof(h *hasher.Hasher, users []account, index int) (*testProof, error) {
+	if index < 0 || index >= len(users) {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, len(users))
+	}
+	levels := treeLevels(h, users)
+	proof := &testProof{}
+	for _, level := range levels[:len(levels)-1] {
+		proof.Siblings = append(proof.Siblings, level[index^1])
+		proof.PathBits = append(proof.PathBits, index%2 == 0)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// testKeyring holds the private keys of the players of the test state, by name.
+type testKeyring map[int64]*eddsa.PrivateKey
+
+// getOrCreate returns the key of player id, generating one if needed.
+func (keys testKeyring) getOrCreate(id int64) (*eddsa.PrivateKey, error) {
+	if key, ok := keys[id]; ok {
+		return key, nil
+	}
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key for player %d: %w", id, err)
+	}
+	keys[id] = key
+	return key, nil
+}
+
+// fingerprint compiles c to R1CS and returns its fingerprint.
+func fingerprint(t *testing.T, c frontend.Circuit) string {
+	t.Helper()
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, c)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	fp, err := Fingerprint(ccs)
+	if err != nil {
+		t.Fatalf("Fingerprint failed: %v", err)
+	}
+	return fp
+}
+
+// TestFingerprint checks that fingerprints are stable across compilations and change with
+// anything that changes the constraints.
+func TestFingerprint(t *testing.T) {
+	base := fingerprint(t, NewProofMerkleCircuit(2, 1))
+	if len(base) != 64 {
+		t.Fatalf("Expected a hex SHA-256, got %q", base)
+	}
+	if again := fingerprint(t, NewProofMerkleCircuit(2, 1)); again != base {
+		t.Errorf("Fingerprint changed between compilations: %s, %s", base, again)
+	}
+
+	poseidon2 := NewProofMerkleCircuit(2, 1)
+	poseidon2.Hasher = hasher.Poseidon2
+	others := map[string]frontend.Circuit{
+		"deeper tree":   NewProofMerkleCircuit(3, 1),
+		"larger batch":  NewProofMerkleCircuit(2, 2),
+		"other hasher":  poseidon2,
+		"other circuit": NewTransferCircuit(2, 1),
+	}
+	for name, c := range others {
+		if fingerprint(t, c) == base {
+			t.Errorf("%s: same fingerprint as the base circuit", name)
+		}
+	}
+
+	// The constraint system of another backend is another circuit as well
+	ccs, err := frontend.Compile(Curve.ScalarField(), scs.NewBuilder, NewProofMerkleCircuit(2, 1))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	if fp, err := Fingerprint(ccs); err != nil || fp == base {
+		t.Errorf("SparseR1CS fingerprint %q (err %v) matches the R1CS one", fp, err)
+	}
+}
+
+// TestPublicWitness checks the order of the public inputs.
+func TestPublicWitness(t *testing.T) {
+	w, err := PublicWitness(big.NewInt(7), big.NewInt(9))
+	if err != nil {
+		t.Fatalf("PublicWitness failed: %v", err)
+	}
+	vector, ok := w.Vector().(interface{ String() string })
+	if !ok {
+		t.Fatalf("Unexpected witness vector %T", w.Vector())
+	}
+	if got := vector.String(); got != "[7,9]" {
+		t.Errorf("Public inputs = %s, want [oldRoot, newRoot] = [7,9]", got)
+	}
+}
+
+// TestUnsizedCircuit checks that circuits must be built with their constructors.
+func TestUnsizedCircuit(t *testing.T) {
+	if _, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, &ProofMerkleCircuit{}); err == nil {
+		t.Errorf("ProofMerkleCircuit without slots compiled")
+	}
+	if _, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, &TransferCircuit{}); err == nil {
+		t.Errorf("TransferCircuit without slots compiled")
+	}
+}
+
+// TestProofMerkleCircuit tests the ProofMerkleCircuit with adjustable D2 and B2.
+func TestProofMerkleCircuit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Groth16 setup of ProofMerkleCircuit is slow")
+	}
+	const N2 = 1 << D2 // Number of leaves, e.g., 1024 for D2=10
+
+	// Step 1: Initialize users
+	var users [N2]account
+	for i := 0; i < N2; i++ {
+		users[i] = testAccount(
+			int64(i+1),      // Names: 1 to N2
+			big.NewInt(100), // Initial balance: 100
+		)
+	}
+
+	// Step 2: Compute initial Merkle root
+	oldRoot := stateRoot(hasher.MiMC, users[:])
+
+	// Step 3: Generate B2 signed transactions with proofs, updating the state as we go
+	start := time.Now()
+	currentUsers := make([]account, N2)
+	copy(currentUsers, users[:])
+	keys := testKeys
+
+	assignment := *NewProofMerkleCircuit(D2, B2)
+	for k := 0; k < B2; k++ {
+		leafIndex := mathrand.Intn(N2)
+		depositAmount := big.NewInt(int64(mathrand.Intn(11))) // Random deposit: 0-10
+		assignAccountUpdate(t, hasher.MiMC, &assignment, k, currentUsers, leafIndex, depositAmount, keys)
+	}
+
+	// Step 4: Compute final Merkle root
+	newRoot := stateRoot(hasher.MiMC, currentUsers)
+
+	// Step 5: Assign circuit roots
+	assignment.OldRoot = oldRoot
+	assignment.NewRoot = newRoot
+	prepareTime := time.Since(start)
+
+	start = time.Now()
+	// Step 6: Compile the circuit
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, NewProofMerkleCircuit(D2, B2))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	compileTime := time.Since(start)
+
+	start = time.Now()
+	// Step 7: Setup proving/verifying keys
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatalf("Failed to setup keys: %v", err)
+	}
+	setupTime := time.Since(start)
+
+	start = time.Now()
+	// Step 8: Generate proof and measure time
+	fullWitness, err := frontend.NewWitness(&assignment, Curve.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create full witness: %v", err)
+	}
+	witnessTime := time.Since(start)
+
+	start = time.Now()
+	proof, err := groth16.Prove(ccs, pk, fullWitness)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	proofTime := time.Since(start)
+
+	// Step 9: Verify proof
+	publicWitness, err := frontend.NewWitness(&assignment, Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	start = time.Now()
+	err = groth16.Verify(proof, vk, publicWitness)
+	if err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	verifyTime := time.Since(start)
+
+	t.Logf("ProofMerkleCircuit: Depth=%d, Leaves=%d, Batch Size=%d, Proof Generation Time=%v, Verification Time=%v, Preparation Time=%v, Compile Time=%v, Witness Time=%v, Setup Time=%v", D2, N2, B2, proofTime, verifyTime, prepareTime, compileTime, witnessTime, setupTime)
+}
+
+// assignAccountUpdate fills slot k of assignment with the update of users[index] by benChange,
+// signed with the key of its name in keys, and applies it to users.
+func assignAccountUpdate(tb testing.TB, h *hasher.Hasher, assignment *ProofMerkleCircuit, k int, users []account, index int, benChange *big.Int, keys testKeyring) {
+	tb.Helper()
+	oldState := users[index]
+	proof, err := merkleProof(h, users, index)
+	if err != nil {
+		tb.Fatalf("Failed to generate Merkle proof for transaction %d: %v", k, err)
+	}
+	key, err := keys.getOrCreate(oldState.Name.Int64())
+	if err != nil {
+		tb.Fatalf("Failed to get key for transaction %d: %v", k, err)
+	}
+	pubKeyX, pubKeyY := PublicKey(key)
+	sig, err := Sign(key, oldState.Name, benChange, oldState.Nonce)
+	if err != nil {
+		tb.Fatalf("Failed to sign transaction %d: %v", k, err)
+	}
+
+	tx := &assignment.Transactions[k]
+	tx.OldName = oldState.Name
+	tx.OldBalance = oldState.Ben
+	tx.NewName = oldState.Name
+	tx.BenChange = benChange
+	tx.Enabled = 1
+	for i := range tx.Siblings {
+		tx.Siblings[i] = proof.Siblings[i]
+		if proof.PathBits[i] {
+			tx.PathBits[i] = big.NewInt(1)
+		} else {
+			tx.PathBits[i] = big.NewInt(0)
+		}
+	}
+	tx.OldPubKeyX = oldState.PubKeyX
+	tx.OldPubKeyY = oldState.PubKeyY
+	tx.Nonce = oldState.Nonce
+	tx.PubKey.A.X = pubKeyX
+	tx.PubKey.A.Y = pubKeyY
+	tx.Signature.R.X = sig.RX
+	tx.Signature.R.Y = sig.RY
+	tx.Signature.S = sig.S
+
+	users[index] = account{
+		Name:    oldState.Name,
+		Ben:     new(big.Int).Add(oldState.Ben, benChange),
+		PubKeyX: pubKeyX,
+		PubKeyY: pubKeyY,
+		Nonce:   new(big.Int).Add(oldState.Nonce, big.NewInt(1)),
+	}
+}
+
+// disableTestSlot fills slot k of assignment with a disabled all-zero transaction carrying the
+// padding signature.
+func disableTestSlot(assignment *ProofMerkleCircuit, k int) {
+	tx := &assignment.Transactions[k]
+	tx.OldName, tx.OldBalance, tx.NewName, tx.BenChange, tx.Enabled = 0, 0, 0, 0, 0
+	for i := range tx.Siblings {
+		tx.Siblings[i] = 0
+		tx.PathBits[i] = 0
+	}
+	paddingX, paddingY, paddingSig := Padding()
+	tx.OldPubKeyX, tx.OldPubKeyY, tx.Nonce = 0, 0, 0
+	tx.PubKey.A.X, tx.PubKey.A.Y = paddingX, paddingY
+	tx.Signature.R.X, tx.Signature.R.Y, tx.Signature.S = paddingSig.RX, paddingSig.RY, paddingSig.S
+}
+
+// batchAssignment builds a ProofMerkleCircuit assignment holding `real` signed deposits on
+// distinct random leaves of users. The remaining slots are either disabled or, when noopPadding
+// is set, filled the way the operator used to: enabled no-op updates on leaf 0, each with a
+// Merkle proof computed off-circuit over all leaves (and now a signature as well).
+func batchAssignment(tb testing.TB, h *hasher.Hasher, users []account, real int, noopPadding bool) ProofMerkleCircuit {
+	currentUsers := make([]account, len(users))
+	copy(currentUsers, users)
+	keys := testKeys
+
+	assignment := *NewProofMerkleCircuit(D2, B2)
+	assignment.OldRoot = stateRoot(h, currentUsers)
+
+	for k, leafIndex := range mathrand.Perm(len(users))[:real] {
+		depositAmount := big.NewInt(int64(mathrand.Intn(10) + 1))
+		assignAccountUpdate(tb, h, &assignment, k, currentUsers, leafIndex, depositAmount, keys)
+	}
+
+	for k := real; k < B2; k++ {
+		if noopPadding {
+			assignAccountUpdate(tb, h, &assignment, k, currentUsers, 0, big.NewInt(0), keys)
+		} else {
+			disableTestSlot(&assignment, k)
+		}
+	}
+
+	assignment.NewRoot = stateRoot(h, currentUsers)
+	return assignment
+}
+
+// cloneAssignment returns a copy of assignment whose slots can be changed without touching
+// the original.
+func cloneAssignment(assignment ProofMerkleCircuit) ProofMerkleCircuit {
+	clone := assignment
+	clone.Transactions = make([]Transaction, len(assignment.Transactions))
+	for k, tx := range assignment.Transactions {
+		tx.Siblings = append([]frontend.Variable(nil), tx.Siblings...)
+		tx.PathBits = append([]frontend.Variable(nil), tx.PathBits...)
+		clone.Transactions[k] = tx
+	}
+	return clone
+}
+
+// testKeys holds the keys the players of testUsers registered.
+var testKeys = testKeyring{}
+
+// testAccount returns the leaf of player name holding ben, registered with its key in testKeys.
+func testAccount(name int64, ben *big.Int) account {
+	key, err := testKeys.getOrCreate(name)
+	if err != nil {
+		panic(err)
+	}
+	pubKeyX, pubKeyY := PublicKey(key)
+	return registeredAccount(big.NewInt(name), ben, pubKeyX, pubKeyY)
+}
+
+// testUsers returns the 2^D2 registered leaves used by the rollup circuit tests.
+func testUsers() []account {
+	users := make([]account, 1<<D2)
+	for i := range users {
+		users[i] = testAccount(int64(i+1), big.NewInt(100))
+	}
+	return users
+}
+
+// TestProofMerkleCircuitDisabledSlots checks that disabled slots are skipped by the root
+// chaining whatever they hold, and that they cannot be used to hide a state change.
+func TestProofMerkleCircuitDisabledSlots(t *testing.T) {
+	users := testUsers()
+	half := batchAssignment(t, hasher.MiMC, users, B2/2, false)
+	if err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &half, Curve.ScalarField()); err != nil {
+		t.Fatalf("Half-empty batch with disabled slots not solved: %v", err)
+	}
+
+	// Garbage in a disabled slot is ignored
+	garbage := cloneAssignment(half)
+	garbage.Transactions[B2-1].OldBalance = 12345
+	garbage.Transactions[B2-1].Siblings[0] = 42
+	if err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &garbage, Curve.ScalarField()); err != nil {
+		t.Errorf("Disabled slot contents must be ignored: %v", err)
+	}
+
+	// Disabling a real transaction drops its update, so NewRoot no longer matches
+	dropped := cloneAssignment(half)
+	dropped.Transactions[0].Enabled = 0
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &dropped, Curve.ScalarField()) == nil {
+		t.Errorf("Disabling a real transaction must break the root chain")
+	}
+
+	// Enabled must be boolean
+	nonBoolean := cloneAssignment(half)
+	nonBoolean.Transactions[B2-1].Enabled = 2
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &nonBoolean, Curve.ScalarField()) == nil {
+		t.Errorf("Non-boolean Enabled must be rejected")
+	}
+}
+
+// overdraftAssignment builds a one-transaction batch moving the balance of leaf 0 from
+// oldBalance to oldBalance+benChange, with NewRoot computed off-circuit from the resulting
+// field element, i.e. a witness that is consistent apart from the range of the new balance.
+func overdraftAssignment(t *testing.T, oldBalance, benChange *big.Int) ProofMerkleCircuit {
+	users := testUsers()
+	users[0].Ben = oldBalance
+
+	assignment := *NewProofMerkleCircuit(D2, B2)
+	assignment.OldRoot = stateRoot(hasher.MiMC, users)
+	assignAccountUpdate(t, hasher.MiMC, &assignment, 0, users, 0, benChange, testKeys)
+	for k := 1; k < B2; k++ {
+		disableTestSlot(&assignment, k)
+	}
+	assignment.NewRoot = stateRoot(hasher.MiMC, users)
+	return assignment
+}
+
+// TestProofMerkleCircuitBalanceRange checks that new balances are range checked to BalanceBits
+// bits, so that overdrafts wrapping around the BLS12-377 scalar field cannot be proven.
+func TestProofMerkleCircuitBalanceRange(t *testing.T) {
+	maxBalance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), BalanceBits), big.NewInt(1))
+
+	cases := []struct {
+		name       string
+		oldBalance *big.Int
+		benChange  *big.Int
+		valid      bool
+	}{
+		{"withdraw whole balance", big.NewInt(100), big.NewInt(-100), true},
+		{"reach the maximum balance", big.NewInt(100), new(big.Int).Sub(maxBalance, big.NewInt(100)), true},
+		{"overdraft by one", big.NewInt(100), big.NewInt(-101), false},
+		{"overdraft", big.NewInt(100), big.NewInt(-150), false},
+		{"exceed the maximum balance", big.NewInt(100), new(big.Int).Sub(maxBalance, big.NewInt(99)), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assignment := overdraftAssignment(t, c.oldBalance, c.benChange)
+			err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &assignment, Curve.ScalarField())
+			if c.valid && err != nil {
+				t.Errorf("Valid balance update not solved: %v", err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Out-of-range balance update was solved")
+			}
+		})
+	}
+}
+
+// TestProofMerkleCircuitOverdraftFailsToProve checks that the Groth16 prover itself rejects an
+// overdraft witness, not only the test engine.
+func TestProofMerkleCircuitOverdraftFailsToProve(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Groth16 setup of ProofMerkleCircuit is slow")
+	}
+
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, NewProofMerkleCircuit(D2, B2))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, _, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatalf("Failed to setup keys: %v", err)
+	}
+
+	assignment := overdraftAssignment(t, big.NewInt(100), big.NewInt(-150))
+	fullWitness, err := frontend.NewWitness(&assignment, Curve.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create full witness: %v", err)
+	}
+	if _, err := groth16.Prove(ccs, pk, fullWitness); err == nil {
+		t.Fatalf("Overdraft witness was proven")
+	}
+}
+
+// signedUpdate builds a one-transaction batch updating leaf 0 of users by benChange with the
+// key held by keys. users itself is left untouched; the updated leaves are returned.
+func signedUpdate(t *testing.T, users []account, benChange *big.Int, keys testKeyring) (ProofMerkleCircuit, []account) {
+	updated := append([]account(nil), users...)
+
+	assignment := *NewProofMerkleCircuit(D2, B2)
+	assignment.OldRoot = stateRoot(hasher.MiMC, updated)
+	assignAccountUpdate(t, hasher.MiMC, &assignment, 0, updated, 0, benChange, keys)
+	for k := 1; k < B2; k++ {
+		disableTestSlot(&assignment, k)
+	}
+	assignment.NewRoot = stateRoot(hasher.MiMC, updated)
+	return assignment, updated
+}
+
+// renamedUpdate builds the one-transaction batch of signedUpdate, moving leaf 0 of users to
+// player name as the operator does when it registers a new player in a free slot.
+func renamedUpdate(t *testing.T, users []account, name int64, benChange *big.Int, keys testKeyring) ProofMerkleCircuit {
+	assignment, updated := signedUpdate(t, users, benChange, keys)
+	key, err := keys.getOrCreate(users[0].Name.Int64())
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	sig, err := Sign(key, big.NewInt(name), benChange, users[0].Nonce)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	tx := &assignment.Transactions[0]
+	tx.NewName = big.NewInt(name)
+	tx.Signature.R.X, tx.Signature.R.Y, tx.Signature.S = sig.RX, sig.RY, sig.S
+	updated[0].Name = big.NewInt(name)
+	assignment.NewRoot = stateRoot(hasher.MiMC, updated)
+	return assignment
+}
+
+// TestProofMerkleCircuitSignatures checks that state changes must be signed by the key the
+// account registered, for exactly the signed amount and nonce, and that only free slots holding
+// no balance can be claimed by another key or name.
+func TestProofMerkleCircuitSignatures(t *testing.T) {
+	owner := testKeys
+	attacker := testKeyring{}
+
+	// The first change of a registered account is signed by the key it registered...
+	first, users := signedUpdate(t, testUsers(), big.NewInt(10), owner)
+	if err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &first, Curve.ScalarField()); err != nil {
+		t.Fatalf("First change signed by the owner not solved: %v", err)
+	}
+	if users[0].Nonce.Int64() != 1 {
+		t.Fatalf("Expected nonce 1 after the first change, got %v", users[0].Nonce)
+	}
+
+	// ... and not by whichever key signs it first
+	hijacked, _ := signedUpdate(t, testUsers(), big.NewInt(-100), attacker)
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &hijacked, Curve.ScalarField()) == nil {
+		t.Errorf("First change signed by another key was solved")
+	}
+
+	valid, _ := signedUpdate(t, users, big.NewInt(-10), owner)
+	if err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &valid, Curve.ScalarField()); err != nil {
+		t.Fatalf("Change signed by the owner not solved: %v", err)
+	}
+
+	// Another key cannot move a registered account
+	forged, _ := signedUpdate(t, users, big.NewInt(-10), attacker)
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &forged, Curve.ScalarField()) == nil {
+		t.Errorf("Change signed by another key was solved")
+	}
+
+	// The signature covers the amount: reuse the owner's signature on -10 for -100
+	tampered, _ := signedUpdate(t, users, big.NewInt(-100), owner)
+	tampered.Transactions[0].Signature = valid.Transactions[0].Signature
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &tampered, Curve.ScalarField()) == nil {
+		t.Errorf("Change with a signature on another amount was solved")
+	}
+
+	// The signature covers the nonce: replay the owner's -10 once it has been applied
+	_, spent := signedUpdate(t, users, big.NewInt(-10), owner)
+	replay, _ := signedUpdate(t, spent, big.NewInt(-10), owner)
+	replay.Transactions[0].Signature = valid.Transactions[0].Signature
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &replay, Curve.ScalarField()) == nil {
+		t.Errorf("Replayed signature was solved")
+	}
+
+	// A registered account keeps its name, even when its owner signs the change
+	renamed := renamedUpdate(t, users, 5000, big.NewInt(0), owner)
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &renamed, Curve.ScalarField()) == nil {
+		t.Errorf("Renaming a registered account was solved")
+	}
+
+	// A free slot is claimed by the new player registered in it, with its key...
+	free := testUsers()
+	free[0] = freeAccount(big.NewInt(1), big.NewInt(0))
+	claimed := renamedUpdate(t, free, 5000, big.NewInt(10), attacker)
+	if err := test.IsSolved(NewProofMerkleCircuit(D2, B2), &claimed, Curve.ScalarField()); err != nil {
+		t.Errorf("Registration in a free slot not solved: %v", err)
+	}
+
+	// ... but a free slot holding a balance cannot be claimed
+	free[0].Ben = big.NewInt(100)
+	stolen := renamedUpdate(t, free, 5000, big.NewInt(-100), attacker)
+	if test.IsSolved(NewProofMerkleCircuit(D2, B2), &stolen, Curve.ScalarField()) == nil {
+		t.Errorf("Claiming a free slot holding a balance was solved")
+	}
+}
+
+// testTransferLeaf returns the TransferCircuit leaf of users[index] and its Merkle proof.
+func testTransferLeaf(tb testing.TB, h *hasher.Hasher, users []account, index int) TransferLeaf {
+	tb.Helper()
+	proof, err := merkleProof(h, users, index)
+	if err != nil {
+		tb.Fatalf("Failed to generate Merkle proof for leaf %d: %v", index, err)
+	}
+	state := users[index]
+	leaf := NewTransferLeaf(len(proof.Siblings))
+	leaf.Name, leaf.Balance, leaf.PubKeyX, leaf.PubKeyY, leaf.Nonce = state.Name, state.Ben, state.PubKeyX, state.PubKeyY, state.Nonce
+	for i := range leaf.Siblings {
+		leaf.Siblings[i] = proof.Siblings[i]
+		if proof.PathBits[i] {
+			leaf.PathBits[i] = big.NewInt(1)
+		} else {
+			leaf.PathBits[i] = big.NewInt(0)
+		}
+	}
+	return leaf
+}
+
+// assignTransfer fills slot k of assignment with a transfer of amount from users[from] to
+// users[to], signed with the sender's key from keys, and applies it to users.
+func assignTransfer(tb testing.TB, h *hasher.Hasher, assignment *TransferCircuit, k int, users []account, from, to int, amount *big.Int, keys testKeyring) {
+	tb.Helper()
+	sender := users[from]
+	key, err := keys.getOrCreate(sender.Name.Int64())
+	if err != nil {
+		tb.Fatalf("Failed to get key for transfer %d: %v", k, err)
+	}
+	pubKeyX, pubKeyY := PublicKey(key)
+	sig, err := SignTransfer(key, sender.Name, users[to].Name, amount, sender.Nonce)
+	if err != nil {
+		tb.Fatalf("Failed to sign transfer %d: %v", k, err)
+	}
+
+	tx := &assignment.Transfers[k]
+	tx.Amount = amount
+	tx.Enabled = 1
+	tx.PubKey.A.X, tx.PubKey.A.Y = pubKeyX, pubKeyY
+	tx.Signature.R.X, tx.Signature.R.Y, tx.Signature.S = sig.RX, sig.RY, sig.S
+
+	tx.From = testTransferLeaf(tb, h, users, from)
+	users[from] = account{
+		Name:    sender.Name,
+		Ben:     new(big.Int).Sub(sender.Ben, amount),
+		PubKeyX: pubKeyX,
+		PubKeyY: pubKeyY,
+		Nonce:   new(big.Int).Add(sender.Nonce, big.NewInt(1)),
+	}
+
+	tx.To = testTransferLeaf(tb, h, users, to)
+	receiver := users[to]
+	receiver.Ben = new(big.Int).Add(receiver.Ben, amount)
+	users[to] = receiver
+}
+
+// disableTestTransfer fills slot k of assignment with a disabled transfer.
+func disableTestTransfer(assignment *TransferCircuit, k int) {
+	tx := &assignment.Transfers[k]
+	tx.Amount, tx.Enabled = 0, 0
+	for _, leaf := range []*TransferLeaf{&tx.From, &tx.To} {
+		leaf.Name, leaf.Balance, leaf.PubKeyX, leaf.PubKeyY, leaf.Nonce = 0, 0, 0, 0, 0
+		for i := range leaf.Siblings {
+			leaf.Siblings[i] = 0
+			leaf.PathBits[i] = 0
+		}
+	}
+	paddingX, paddingY, paddingSig := TransferPadding()
+	tx.PubKey.A.X, tx.PubKey.A.Y = paddingX, paddingY
+	tx.Signature.R.X, tx.Signature.R.Y, tx.Signature.S = paddingSig.RX, paddingSig.RY, paddingSig.S
+}
+
+// transferAssignment builds a TransferCircuit batch of the given transfers ({from, to, amount})
+// over users, which it updates.
+func transferAssignment(tb testing.TB, h *hasher.Hasher, users []account, keys testKeyring, transfers [][3]int64) TransferCircuit {
+	tb.Helper()
+	assignment := *NewTransferCircuit(D2, T2)
+	assignment.OldRoot = stateRoot(h, users)
+	for k := 0; k < T2; k++ {
+		if k < len(transfers) {
+			tr := transfers[k]
+			assignTransfer(tb, h, &assignment, k, users, int(tr[0]), int(tr[1]), big.NewInt(tr[2]), keys)
+		} else {
+			disableTestTransfer(&assignment, k)
+		}
+	}
+	assignment.NewRoot = stateRoot(h, users)
+	return assignment
+}
+
+// TestTransferCircuit checks that transfers move value between leaves without creating any.
+func TestTransferCircuit(t *testing.T) {
+	keys := testKeys
+	users := testUsers()
+
+	// Chained transfers, including a self-transfer and sending a whole balance
+	valid := transferAssignment(t, hasher.MiMC, users, keys, [][3]int64{{0, 1, 30}, {1, 2, 130}, {3, 3, 10}, {0, 5, 70}})
+	if err := test.IsSolved(NewTransferCircuit(D2, T2), &valid, Curve.ScalarField()); err != nil {
+		t.Fatalf("Valid transfers not solved: %v", err)
+	}
+	total := new(big.Int)
+	for _, user := range users {
+		total.Add(total, user.Ben)
+	}
+	if total.Int64() != int64(100*len(users)) || users[0].Ben.Sign() != 0 || users[2].Ben.Int64() != 230 {
+		t.Fatalf("Unexpected balances after the transfers")
+	}
+
+	// The sender cannot send more than it holds
+	overdraft := transferAssignment(t, hasher.MiMC, testUsers(), keys, [][3]int64{{0, 1, 101}})
+	if test.IsSolved(NewTransferCircuit(D2, T2), &overdraft, Curve.ScalarField()) == nil {
+		t.Errorf("Overdraft was solved")
+	}
+
+	// Negative amounts would let the receiver pay the sender
+	negative := transferAssignment(t, hasher.MiMC, testUsers(), keys, [][3]int64{{0, 1, -50}})
+	if test.IsSolved(NewTransferCircuit(D2, T2), &negative, Curve.ScalarField()) == nil {
+		t.Errorf("Negative transfer was solved")
+	}
+
+	// Crediting more than was debited leads to a root the circuit cannot reach
+	minted := testUsers()
+	inflated := transferAssignment(t, hasher.MiMC, minted, keys, [][3]int64{{0, 1, 30}})
+	minted[1].Ben = new(big.Int).Add(minted[1].Ben, big.NewInt(1))
+	inflated.NewRoot = stateRoot(hasher.MiMC, minted)
+	if test.IsSolved(NewTransferCircuit(D2, T2), &inflated, Curve.ScalarField()) == nil {
+		t.Errorf("Transfer creating value was solved")
+	}
+
+	// Only the key the sender registered can send from its account
+	forged := transferAssignment(t, hasher.MiMC, testUsers(), testKeyring{}, [][3]int64{{0, 1, 30}})
+	if test.IsSolved(NewTransferCircuit(D2, T2), &forged, Curve.ScalarField()) == nil {
+		t.Errorf("Transfer signed by another key was solved")
+	}
+
+	// Free slots neither send nor receive: they would be claimed by the next registration
+	free := testUsers()
+	free[1] = freeAccount(big.NewInt(2), big.NewInt(0))
+	toFree := transferAssignment(t, hasher.MiMC, free, keys, [][3]int64{{0, 1, 30}})
+	if test.IsSolved(NewTransferCircuit(D2, T2), &toFree, Curve.ScalarField()) == nil {
+		t.Errorf("Transfer to a free slot was solved")
+	}
+
+	// The signature covers the receiver: redirect a signed transfer to another leaf
+	redirected := transferAssignment(t, hasher.MiMC, testUsers(), keys, [][3]int64{{0, 2, 30}})
+	signed := transferAssignment(t, hasher.MiMC, testUsers(), keys, [][3]int64{{0, 1, 30}})
+	redirected.Transfers[0].Signature = signed.Transfers[0].Signature
+	if test.IsSolved(NewTransferCircuit(D2, T2), &redirected, Curve.ScalarField()) == nil {
+		t.Errorf("Redirected transfer was solved")
+	}
+}
+
+// TestHasherRoots checks, for every hasher, that roots computed off-circuit with hasher.Hash
+// are the roots the rollup circuits compute in-circuit, and that a circuit built with one
+// hasher rejects roots computed with the other.
+func TestHasherRoots(t *testing.T) {
+	for _, h := range hasher.All {
+		t.Run(h.Name(), func(t *testing.T) {
+			users := testUsers()
+			keys := testKeys
+
+			update := *NewProofMerkleCircuit(D2, B2)
+			update.OldRoot = stateRoot(h, users)
+			assignAccountUpdate(t, h, &update, 0, users, 3, big.NewInt(25), keys)
+			assignAccountUpdate(t, h, &update, 1, users, 700, big.NewInt(-40), keys)
+			for k := 2; k < B2; k++ {
+				disableTestSlot(&update, k)
+			}
+			update.NewRoot = stateRoot(h, users)
+			if err := test.IsSolved(rollupCircuit(h), &update, Curve.ScalarField()); err != nil {
+				t.Errorf("ProofMerkleCircuit: off-circuit roots not matched in-circuit: %v", err)
+			}
+
+			transfer := transferAssignment(t, h, users, keys, [][3]int64{{3, 4, 50}, {700, 3, 10}})
+			transferCircuit := NewTransferCircuit(D2, T2)
+			transferCircuit.Hasher = h
+			if err := test.IsSolved(transferCircuit, &transfer, Curve.ScalarField()); err != nil {
+				t.Errorf("TransferCircuit: off-circuit roots not matched in-circuit: %v", err)
+			}
+
+			other := hasher.Poseidon2
+			if h == other {
+				other = hasher.MiMC
+			}
+			if test.IsSolved(rollupCircuit(other), &update, Curve.ScalarField()) == nil {
+				t.Errorf("ProofMerkleCircuit with %s accepted roots computed with %s", other.Name(), h.Name())
+			}
+		})
+	}
+}
+
+// rollupCircuit returns a ProofMerkleCircuit of the default size built with h.
+func rollupCircuit(h *hasher.Hasher) *ProofMerkleCircuit {
+	c := NewProofMerkleCircuit(D2, B2)
+	c.Hasher = h
+	return c
+}
+
+// alwaysEnabledCircuit is ProofMerkleCircuit with every slot hard-wired as enabled, i.e. the
+// circuit as it was before slots could be disabled. gnark folds the constant selects away, so
+// it serves as the baseline for the constraint count.
+type alwaysEnabledCircuit struct {
+	ProofMerkleCircuit
+}
+
+// Define implements the circuit constraints.
+func (c *alwaysEnabledCircuit) Define(api frontend.API) error {
+	for k := range c.Transactions {
+		c.Transactions[k].Enabled = 1
+	}
+	return c.ProofMerkleCircuit.Define(api)
+}
+
+// BenchmarkProofMerkleCircuitConstraints reports the constraint count of ProofMerkleCircuit with
+// and without per-slot Enabled flags. The constraint system does not depend on how many slots a
+// batch actually uses: disabling a slot only makes its witness free, it removes no constraints.
+func BenchmarkProofMerkleCircuitConstraints(b *testing.B) {
+	circuits := []struct {
+		name    string
+		circuit frontend.Circuit
+	}{
+		{"always-enabled", &alwaysEnabledCircuit{*NewProofMerkleCircuit(D2, B2)}},
+		{"enabled-flag", NewProofMerkleCircuit(D2, B2)},
+	}
+	for _, c := range circuits {
+		b.Run(c.name, func(b *testing.B) {
+			var ccs interface{ GetNbConstraints() int }
+			for i := 0; i < b.N; i++ {
+				compiled, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, c.circuit, frontend.IgnoreUnconstrainedInputs())
+				if err != nil {
+					b.Fatalf("Failed to compile circuit: %v", err)
+				}
+				ccs = compiled
+			}
+			b.ReportMetric(float64(ccs.GetNbConstraints()), "constraints")
+			b.ReportMetric(float64(ccs.GetNbConstraints())/B2, "constraints/slot")
+		})
+	}
+}
+
+// BenchmarkProofMerkleCircuitHalfEmpty measures preparing and proving a batch that uses half of
+// its B2 slots, with the other half padded by no-op updates on leaf 0 or by disabled slots.
+func BenchmarkProofMerkleCircuitHalfEmpty(b *testing.B) {
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, NewProofMerkleCircuit(D2, B2))
+	if err != nil {
+		b.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, _, err := groth16.Setup(ccs)
+	if err != nil {
+		b.Fatalf("Failed to setup keys: %v", err)
+	}
+	users := testUsers()
+
+	for _, padding := range []struct {
+		name string
+		noop bool
+	}{
+		{"noop-padding", true},
+		{"disabled-padding", false},
+	} {
+		b.Run(padding.name, func(b *testing.B) {
+			var prepare, prove time.Duration
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				assignment := batchAssignment(b, hasher.MiMC, users, B2/2, padding.noop)
+				fullWitness, err := frontend.NewWitness(&assignment, Curve.ScalarField())
+				if err != nil {
+					b.Fatalf("Failed to create full witness: %v", err)
+				}
+				prepare += time.Since(start)
+
+				start = time.Now()
+				if _, err := groth16.Prove(ccs, pk, fullWitness); err != nil {
+					b.Fatalf("Failed to generate proof: %v", err)
+				}
+				prove += time.Since(start)
+			}
+			b.ReportMetric(float64(prepare.Milliseconds())/float64(b.N), "prepare-ms/op")
+			b.ReportMetric(float64(prove.Milliseconds())/float64(b.N), "prove-ms/op")
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// rollup/sign.go
+
+package rollup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+
+	"github.com/weids-dev/benchains/circuits/hasher"
+)
+
+// Off-circuit counterparts of the signature checks of the rollup circuits: the messages a
+// player signs, signing them with a key of the twisted Edwards curve of BLS12-377 and verifying
+// the signatures.
+
+// Signature is an EdDSA signature split into the field elements the rollup circuits take.
+type Signature struct {
+	RX *big.Int
+	RY *big.Int
+	S  *big.Int
+}
+
+// PublicKey returns the coordinates of the public key of key, as stored in account leaves.
+func PublicKey(key *eddsa.PrivateKey) (*big.Int, *big.Int) {
+	x, y := new(big.Int), new(big.Int)
+	key.PublicKey.A.X.BigInt(x)
+	key.PublicKey.A.Y.BigInt(y)
+	return x, y
+}
+
+// Message returns the field element signed for a state change of ProofMerkleCircuit:
+// MiMC(name, benChange, nonce), where nonce is the account nonce before the change. The nonce
+// makes every signature single-use, since the account nonce is incremented by each change.
+func Message(name, benChange, nonce *big.Int) *big.Int {
+	return hasher.MiMC.Hash(name, benChange, nonce)
+}
+
+// TransferMessage returns the field element signed by the sender of a transfer of
+// TransferCircuit: MiMC(from, to, amount, nonce), where from and to are the names of the
+// sender and receiver and nonce is the sender's nonce before the transfer.
+func TransferMessage(from, to, amount, nonce *big.Int) *big.Int {
+	return hasher.MiMC.Hash(from, to, amount, nonce)
+}
+
+// Sign signs the state change (name, benChange, nonce) with key.
+func Sign(key *eddsa.PrivateKey, name, benChange, nonce *big.Int) (*Signature, error) {
+	return signMessage(key, Message(name, benChange, nonce))
+}
+
+// SignTransfer signs the transfer of amount from from to to with key, the sender's key.
+func SignTransfer(key *eddsa.PrivateKey, from, to, amount, nonce *big.Int) (*Signature, error) {
+	return signMessage(key, TransferMessage(from, to, amount, nonce))
+}
+
+// signMessage signs the field element msg with key.
+func signMessage(key *eddsa.PrivateKey, msg *big.Int) (*Signature, error) {
+	var msgFr fr.Element
+	msgFr.SetBigInt(msg)
+	msgBytes := msgFr.Bytes()
+
+	sigBytes, err := key.Sign(msgBytes[:], hasher.MiMC.New())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	var sig eddsa.Signature
+	if _, err := sig.SetBytes(sigBytes); err != nil {
+		return nil, fmt.Errorf("failed to parse signature: %w", err)
+	}
+	res := &Signature{RX: new(big.Int), RY: new(big.Int), S: new(big.Int).SetBytes(sig.S[:])}
+	sig.R.X.BigInt(res.RX)
+	sig.R.Y.BigInt(res.RY)
+	return res, nil
+}
+
+// Verify checks sig on the state change (name, benChange, nonce) against the public key (x, y).
+func Verify(x, y *big.Int, sig *Signature, name, benChange, nonce *big.Int) bool {
+	return verifyMessage(x, y, sig, Message(name, benChange, nonce))
+}
+
+// VerifyTransfer checks sig on the transfer of amount from from to to against the public key (x, y).
+func VerifyTransfer(x, y *big.Int, sig *Signature, from, to, amount, nonce *big.Int) bool {
+	return verifyMessage(x, y, sig, TransferMessage(from, to, amount, nonce))
+}
+
+// verifyMessage checks sig on the field element msg against the public key (x, y).
+func verifyMessage(x, y *big.Int, sig *Signature, msg *big.Int) bool {
+	var pub eddsa.PublicKey
+	pub.A.X.SetBigInt(x)
+	pub.A.Y.SetBigInt(y)
+
+	var s eddsa.Signature
+	s.R.X.SetBigInt(sig.RX)
+	s.R.Y.SetBigInt(sig.RY)
+	sig.S.FillBytes(s.S[:])
+
+	var msgFr fr.Element
+	msgFr.SetBigInt(msg)
+	msgBytes := msgFr.Bytes()
+
+	ok, err := pub.Verify(s.Bytes(), msgBytes[:], hasher.MiMC.New())
+	return err == nil && ok
+}
+
+var (
+	paddingOnce        sync.Once
+	paddingX           *big.Int
+	paddingY           *big.Int
+	paddingSig         *Signature
+	paddingTransferSig *Signature
+)
+
+// Padding returns a fixed public key and its signature on the all-zero state change.
+// Signature verification cannot be switched off in the circuit, so disabled transaction
+// slots carry this valid but meaningless signature.
+func Padding() (*big.Int, *big.Int, *Signature) {
+	paddingOnce.Do(derivePadding)
+	return paddingX, paddingY, paddingSig
+}
+
+// TransferPadding is Padding for the disabled slots of TransferCircuit: the same key, signing
+// the all-zero transfer.
+func TransferPadding() (*big.Int, *big.Int, *Signature) {
+	paddingOnce.Do(derivePadding)
+	return paddingX, paddingY, paddingTransferSig
+}
+
+// derivePadding derives the padding key from a fixed seed and signs the all-zero messages.
+func derivePadding() {
+	seed := sha256.Sum256([]byte("bench-zk padding key"))
+	key, err := eddsa.GenerateKey(bytes.NewReader(seed[:]))
+	if err != nil {
+		panic(fmt.Errorf("failed to derive padding key: %w", err))
+	}
+	zero := big.NewInt(0)
+	sig, err := Sign(key, zero, zero, zero)
+	if err != nil {
+		panic(err)
+	}
+	transferSig, err := SignTransfer(key, zero, zero, zero, zero)
+	if err != nil {
+		panic(err)
+	}
+	paddingX, paddingY = PublicKey(key)
+	paddingSig = sig
+	paddingTransferSig = transferSig
+}
@@ -0,0 +1,67 @@
+// rollup/sign_test.go
+
+package rollup
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+)
+
+// newTestKey generates a fresh key.
+func newTestKey(tb testing.TB) *eddsa.PrivateKey {
+	tb.Helper()
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatalf("GenerateKey failed: %v", err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key := newTestKey(t)
+	x, y := PublicKey(key)
+
+	name, benChange, nonce := big.NewInt(4), big.NewInt(-50), big.NewInt(3)
+	sig, err := Sign(key, name, benChange, nonce)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !Verify(x, y, sig, name, benChange, nonce) {
+		t.Fatalf("signature does not verify")
+	}
+	if Verify(x, y, sig, name, big.NewInt(-500), nonce) {
+		t.Errorf("signature verifies for another amount")
+	}
+	if Verify(x, y, sig, name, benChange, big.NewInt(4)) {
+		t.Errorf("signature verifies for another nonce")
+	}
+
+	ox, oy := PublicKey(newTestKey(t))
+	if Verify(ox, oy, sig, name, benChange, nonce) {
+		t.Errorf("signature verifies under another key")
+	}
+
+	px, py, psig := Padding()
+	zero := big.NewInt(0)
+	if !Verify(px, py, psig, zero, zero, zero) {
+		t.Errorf("padding signature does not verify")
+	}
+	_, _, ptsig := TransferPadding()
+	if !VerifyTransfer(px, py, ptsig, zero, zero, zero, zero) {
+		t.Errorf("transfer padding signature does not verify")
+	}
+
+	tsig, err := SignTransfer(key, name, big.NewInt(5), big.NewInt(50), nonce)
+	if err != nil {
+		t.Fatalf("SignTransfer failed: %v", err)
+	}
+	if !VerifyTransfer(x, y, tsig, name, big.NewInt(5), big.NewInt(50), nonce) {
+		t.Errorf("transfer signature does not verify")
+	}
+	if VerifyTransfer(x, y, tsig, name, big.NewInt(6), big.NewInt(50), nonce) {
+		t.Errorf("transfer signature verifies for another receiver")
+	}
+}
@@ -0,0 +1,89 @@
+// players creates the EdDSA keys of the players of the caliper-zk benchmark and signs the
+// state changes the workloads submit on their behalf, as each player would on its own device:
+// the ZK rollup only accepts BEN changes signed by their player, and its operator holds no keys.
+//
+//	go run . -first 1000 -last 1999 -ben 100 -nonces 64 > ../player-keys.json
+//
+// For every player it prints the public key CreatePlayer registers, the signature of the
+// creation, and the signatures of exchanges of ben BEN at the nonces 1 to nonces, keyed by ID.
+// The keys themselves are not kept: the fixture holds everything the workloads submit.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/consensys/gnark-crypto/ecc/bls12-377/twistededwards/eddsa"
+
+	"github.com/weids-dev/benchains/circuits/rollup"
+)
+
+// Player is what the workloads submit for one player.
+type Player struct {
+	PubKey    string   `json:"pubKey"`    // Public key registered by CreatePlayer
+	Create    string   `json:"create"`    // Signature of CreatePlayer: rollup.Message(id, 0, 0)
+	Exchanges []string `json:"exchanges"` // Signature of an exchange of ben BEN at nonce i+1
+}
+
+func main() {
+	first := flag.Int64("first", 1000, "ID of the first player")
+	last := flag.Int64("last", 1999, "ID of the last player")
+	ben := flag.Int64("ben", 100, "BEN bought by every exchange, as ExchangeInGameCurrency takes it")
+	nonces := flag.Int("nonces", 64, "exchanges signed per player")
+	flag.Parse()
+
+	if err := run(*first, *last, *ben, *nonces); err != nil {
+		slog.Error("Failed to create the players", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(first, last, ben int64, nonces int) error {
+	if first > last {
+		return fmt.Errorf("first player %d is after the last one %d", first, last)
+	}
+
+	players := make(map[string]Player)
+	for id := first; id <= last; id++ {
+		player, err := newPlayer(id, ben, nonces)
+		if err != nil {
+			return fmt.Errorf("player %d: %w", id, err)
+		}
+		players[strconv.FormatInt(id, 10)] = player
+	}
+	slog.Info("Players ready", "players", len(players), "exchanges", nonces)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(players)
+}
+
+// newPlayer creates the key of player id and signs its creation and its exchanges.
+func newPlayer(id, ben int64, nonces int) (Player, error) {
+	key, err := eddsa.GenerateKey(rand.Reader)
+	if err != nil {
+		return Player{}, err
+	}
+	name := big.NewInt(id)
+
+	// Creating the player takes an empty slot of the rollup, which changes no BEN at nonce 0
+	create, err := rollup.Sign(key, name, big.NewInt(0), big.NewInt(0))
+	if err != nil {
+		return Player{}, err
+	}
+	player := Player{PubKey: rollup.EncodePublicKey(rollup.PublicKey(key)), Create: rollup.EncodeSignature(create)}
+	for nonce := 1; nonce <= nonces; nonce++ {
+		sig, err := rollup.Sign(key, name, big.NewInt(ben), big.NewInt(int64(nonce)))
+		if err != nil {
+			return Player{}, err
+		}
+		player.Exchanges = append(player.Exchanges, rollup.EncodeSignature(sig))
+	}
+	return player, nil
+}
@@ -1,5 +1,6 @@
 'use strict';
 
+const fs = require('fs');
 const { WorkloadModuleBase } = require('@hyperledger/caliper-core');
 
 class CreatePlayerWorkload extends WorkloadModuleBase {
@@ -11,6 +12,10 @@ class CreatePlayerWorkload extends WorkloadModuleBase {
     async initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext) {
         await super.initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext);
         this.totalWorkers = totalWorkers;
+        // Public keys and signatures of the players, written by `players` (see players/main.go):
+        // once the rollup is enabled, Layer 2 rejects players that register no key or do not
+        // sign their creation
+        this.players = JSON.parse(fs.readFileSync(roundArguments.signatures, 'utf8'));
     }
 
     async submitTransaction() {
@@ -24,7 +29,7 @@ class CreatePlayerWorkload extends WorkloadModuleBase {
             contractId: 'pasic',
             contractVersion: 'v1',
             contractFunction: 'CreatePlayer',
-            contractArguments: [id.toString()],
+            contractArguments: [id.toString(), this.players[id.toString()].pubKey, this.players[id.toString()].create],
             timeout: 60
         };
 
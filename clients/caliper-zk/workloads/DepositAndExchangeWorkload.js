@@ -1,6 +1,7 @@
 'use strict';
 
 const { WorkloadModuleBase } = require('@hyperledger/caliper-core');
+const SignedPlayers = require('./SignedPlayers');
 
 class DepositAndExchangeWorkload extends WorkloadModuleBase {
     constructor() {
@@ -11,14 +12,15 @@ class DepositAndExchangeWorkload extends WorkloadModuleBase {
     async initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext) {
         await super.initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext);
         this.workerIndex = workerIndex;
+        this.players = new SignedPlayers(workerIndex, totalWorkers, roundArguments, sutAdapter);
     }
 
     async submitTransaction() {
-        let playerID = 1000 + Math.floor(Math.random() * 1000); // Random player from 1000–1999
+        let { playerID, signature } = await this.players.pick(); // Random player of this worker
         let transactionID = this.workerIndex * 1000000 + this.txIndex; // Unique transaction ID
         this.txIndex++;
         let amountUSD = 100; // Deposit 100 USD
-        let benAmountChange = 100; // Exchange 100 USD to 100 BEN
+        let benAmountChange = 100; // Exchange 100 USD to 100 BEN, as signed by `players -ben 100`
 
         let args1 = {
             contractId: 'pasic',
@@ -32,12 +34,12 @@ class DepositAndExchangeWorkload extends WorkloadModuleBase {
             contractId: 'pasic',
             contractVersion: 'v1',
             contractFunction: 'ExchangeInGameCurrency',
-            contractArguments: [playerID.toString(), benAmountChange.toString()],
+            contractArguments: [playerID.toString(), benAmountChange.toString(), signature],
             timeout: 60
         };
 
         await this.sutAdapter.sendRequests(args1); // Deposit first
-        await this.sutAdapter.sendRequests(args2); // Then exchange
+        await this.players.submitted(playerID, await this.sutAdapter.sendRequests(args2)); // Then exchange
     }
 }
 
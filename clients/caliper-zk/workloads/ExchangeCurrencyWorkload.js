@@ -1,25 +1,31 @@
 'use strict';
 
 const { WorkloadModuleBase } = require('@hyperledger/caliper-core');
+const SignedPlayers = require('./SignedPlayers');
 
 class ExchangeCurrencyWorkload extends WorkloadModuleBase {
     constructor() {
         super();
     }
 
+    async initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext) {
+        await super.initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext);
+        this.players = new SignedPlayers(workerIndex, totalWorkers, roundArguments, sutAdapter);
+    }
+
     async submitTransaction() {
-        let playerID = 1000 + Math.floor(Math.random() * 1000); // Random player from 1000–1999
-        let benAmountChange = 100; // Exchange 100 USD to 100 BEN
+        let { playerID, signature } = await this.players.pick(); // Random player of this worker
+        let benAmountChange = 100; // Exchange 100 USD to 100 BEN, as signed by `players -ben 100`
 
         let args = {
             contractId: 'pasic',
             contractVersion: 'v1',
             contractFunction: 'ExchangeInGameCurrency',
-            contractArguments: [playerID.toString(), benAmountChange.toString()],
+            contractArguments: [playerID.toString(), benAmountChange.toString(), signature],
             timeout: 60
         };
 
-        await this.sutAdapter.sendRequests(args);
+        await this.players.submitted(playerID, await this.sutAdapter.sendRequests(args));
     }
 }
 
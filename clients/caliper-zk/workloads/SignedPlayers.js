@@ -0,0 +1,56 @@
+'use strict';
+
+const fs = require('fs');
+
+// SignedPlayers hands out the players of one worker with the signatures of their next exchange.
+// Once the ZK rollup is enabled, every BEN change must be signed by its player at the player's
+// nonce; the signatures are written by `players` (see players/main.go) and the nonces tracked
+// here, so each worker only exchanges for its own players.
+class SignedPlayers {
+    constructor(workerIndex, totalWorkers, roundArguments, sutAdapter) {
+        this.sutAdapter = sutAdapter;
+        this.signatures = JSON.parse(fs.readFileSync(roundArguments.signatures, 'utf8'));
+        let perWorker = Math.floor(roundArguments.players / totalWorkers);
+        this.startPlayer = 1000 + workerIndex * perWorker; // Same players as RecordBankTransactionWorkload
+        this.perWorker = perWorker;
+        this.nonces = {};
+    }
+
+    // pick returns a random player of the worker and the signature of its next exchange.
+    async pick() {
+        let playerID = this.startPlayer + Math.floor(Math.random() * this.perWorker);
+        if (this.nonces[playerID] === undefined) {
+            await this.refresh(playerID);
+        }
+        let exchanges = this.signatures[playerID.toString()].exchanges;
+        let nonce = this.nonces[playerID];
+        if (nonce < 1 || nonce > exchanges.length) {
+            throw new Error(`no exchange of player ${playerID} signed at nonce ${nonce}: sign more with players -nonces`);
+        }
+        return { playerID, signature: exchanges[nonce - 1] };
+    }
+
+    // submitted records the outcome of the exchange of playerID.
+    async submitted(playerID, status) {
+        if (status.GetStatus() === 'success') {
+            this.nonces[playerID]++;
+        } else {
+            await this.refresh(playerID);
+        }
+    }
+
+    // refresh reads the nonce of playerID from Layer 2.
+    async refresh(playerID) {
+        let status = await this.sutAdapter.sendRequests({
+            contractId: 'pasic',
+            contractVersion: 'v1',
+            contractFunction: 'GetPlayer',
+            contractArguments: [playerID.toString()],
+            readOnly: true,
+            timeout: 60
+        });
+        this.nonces[playerID] = JSON.parse(status.GetResult().toString()).nonce || 0;
+    }
+}
+
+module.exports = SignedPlayers;
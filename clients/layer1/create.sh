@@ -2,6 +2,9 @@
 
 # Usage: ./create.sh RATE
 # RATE is the number of requests per second you plan to test with.
+# The targets are in Vegeta's JSON format (vegeta attack -format=json), one request per line with
+# its JSON body base64-encoded, for the REST API of applications/bench-server, which runs them on
+# Layer 1, the Plasma chain or the ZK rollup depending on its backend.
 
 RATE=$1
 if [ -z "$RATE" ]; then
@@ -9,41 +12,42 @@ if [ -z "$RATE" ]; then
   exit 1
 fi
 
+API="http://192.168.50.29:10808/v1"
+
 # Calculate the number of requests for each phase based on the rate and duration (60 seconds per phase)
 REQUESTS_PER_PHASE=$(($RATE * 60))
 
+# target PATH BODY prints one POST request of the JSON body BODY to PATH
+target() {
+  echo "{\"method\":\"POST\",\"url\":\"${API}$1\",\"header\":{\"Content-Type\":[\"application/json\"]},\"body\":\"$(echo -n "$2" | base64 -w0)\"}"
+}
+
 # Phase 1: Create players
 {
   for ((i=1; i<=REQUESTS_PER_PHASE; i++)); do
-    echo "PUT http://192.168.50.29:10808/player/user${i}"
-    echo ""
+    target /players "{\"id\":${i}}"
   done
 } > player.txt
 
-# Phase 2: Deposit transactions for each player
+# Phase 2: Deposit 3 USD for each player
 {
   for ((i=1; i<=REQUESTS_PER_PHASE; i++)); do
-    echo "PUT http://192.168.50.29:10808/bank/txID${i}/3/user${i}"
-    echo ""
+    target /deposits "{\"player\":${i},\"transactionId\":${i},\"usd\":3}"
   done
 } > bank.txt
 
-# Phase 3: Exchange for each player
+# Phase 3: Exchange them for 3 BEN (the default rate is 1.0)
 {
   for ((i=1; i<=REQUESTS_PER_PHASE; i++)); do
-    echo "PUT http://192.168.50.29:10808/exchange/txID${i}/user${i}"
-    echo ""
+    target /exchanges "{\"player\":${i},\"ben\":3}"
   done
 } > exchange.txt
 
-# Phase 4: Combined Deposit-Exchange for each player (using a different set of txIDs)
+# Phase 4: Each player transfers 1 BEN to the next one, the last to the first
 {
   for ((i=1; i<=REQUESTS_PER_PHASE; i++)); do
-    # Assuming txID starts from REQUESTS_PER_PHASE + 1 to ensure uniqueness
-    offset=$((REQUESTS_PER_PHASE + i))
-    echo "PUT http://192.168.50.29:10808/bexchange/txID${offset}/3/user${i}"
-    echo ""
+    target /transfers "{\"from\":${i},\"to\":$((i % REQUESTS_PER_PHASE + 1)),\"amount\":1}"
   done
-} > bexchange.txt
+} > transfer.txt
 
-echo "Generated files for three phases with a total of $(($REQUESTS_PER_PHASE * 4)) requests."
+echo "Generated files for four phases with a total of $(($REQUESTS_PER_PHASE * 4)) requests."
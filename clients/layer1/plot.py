@@ -6,8 +6,8 @@ import matplotlib.pyplot as plt
 import numpy as np
 
 # Define the phases and rates used for benchmarking
-phases = ['bexchange', 'exchange', 'bank']
-rates = {'bexchange': [40, 60, 80, 100, 120, 140, 160, 180, 200, 220, 240], 'exchange': [40, 80, 120, 160, 240, 320, 360, 400, 440, 480], 'bank': [40, 80, 120, 160, 240, 320, 360, 400, 440, 480]}
+phases = ['transfer', 'exchange', 'bank']
+rates = {'transfer': [40, 80, 120, 160, 200, 240, 280, 320, 360, 400, 440, 480], 'exchange': [40, 80, 120, 160, 240, 320, 360, 400, 440, 480], 'bank': [40, 80, 120, 160, 240, 320, 360, 400, 440, 480]}
 
 # Directory containing the results
 results_dir = 'results'
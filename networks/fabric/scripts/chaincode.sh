@@ -48,6 +48,9 @@ parsePeerConnectionParameters() {
 function package_chaincode() {
     cd $1
     go get github.com/weids-dev/benchains/chaincodes/wrappers
+    # vendor the dependencies, so that the package carries the shared circuits module
+    # (github.com/weids-dev/benchains/circuits), which go.mod replaces with a local directory
+    go mod vendor
     cd ../../networks/fabric/scripts/ 
     if [ ! -d "../channel-artifacts" ]; then
 	mkdir ../channel-artifacts